@@ -0,0 +1,253 @@
+// Package apikey issues and verifies the API keys of service accounts, the machine clients
+// of a service. A key carries scopes and an optional expiry; Authenticator.Auth accepts it in
+// a header and sees a token.User flagged as a service account, whose scopes
+// policy.SubjectFromUser maps to roles.
+//
+// A key is "<prefix>_<id>_<secret>". The prefix tells the keys of a service apart, in logs or
+// secret scanners; the id finds the key in the Store, which keeps only a salted hash of the
+// secret. Rotate issues a new key for the same account and scopes, while the previous one is
+// still accepted for an overlap period so clients can be redeployed.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mkfst/auth/token"
+)
+
+// Errors of the Manager and the stores
+var (
+	ErrInvalidKey = errors.New("apikey: invalid key")
+	ErrExpired    = errors.New("apikey: key expired")
+	ErrNotFound   = errors.New("apikey: not found")
+)
+
+const (
+	defaultPrefix        = "mk"
+	defaultHeaderKey     = "X-API-Key"
+	defaultTouchInterval = time.Minute
+
+	idSize     = 8  // random bytes of a key id
+	secretSize = 32 // random bytes of a key secret
+	saltSize   = 16
+
+	// KeyAttr is the user attribute with the id of the key a service account authenticated with
+	KeyAttr = "api_key"
+)
+
+// Key is an issued API key, without its secret
+type Key struct {
+	ID         string    `json:"id"`
+	Account    string    `json:"account"`        // service account authenticated by the key
+	Name       string    `json:"name,omitempty"` // description, e.g. the client using the key
+	Scopes     []string  `json:"scopes,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"` // zero for keys without expiry
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+
+	Salt string `json:"-"` // hex encoded salt of Hash
+	Hash string `json:"-"` // hex encoded sha256 of the salt and the secret
+}
+
+// Expired reports if the key is expired at the given time
+func (k Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// Store keeps the issued keys. Get and the updates of a missing key return ErrNotFound.
+type Store interface {
+	Create(ctx context.Context, k Key) error
+	Get(ctx context.Context, id string) (Key, error)
+	List(ctx context.Context, account string) ([]Key, error) // keys of account, oldest first
+	SetExpiry(ctx context.Context, id string, expiresAt time.Time) error
+	SetLastUsed(ctx context.Context, id string, at time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+// Opts is a set of parameters of Manager
+type Opts struct {
+	Store         Store         // store of the keys, required
+	Prefix        string        // prefix of the issued keys, default "mk"
+	HeaderKey     string        // header of the keys accepted by Authenticator.Auth, default "X-API-Key"
+	TouchInterval time.Duration // precision of the last-used time, saving a write per request, default 1m
+}
+
+// Manager issues, verifies, rotates and revokes API keys
+type Manager struct {
+	Opts
+	now func() time.Time
+}
+
+// NewManager makes an API key manager
+func NewManager(opts Opts) *Manager {
+	res := Manager{Opts: opts, now: time.Now}
+	if res.Prefix == "" {
+		res.Prefix = defaultPrefix
+	}
+	if res.HeaderKey == "" {
+		res.HeaderKey = defaultHeaderKey
+	}
+	if res.TouchInterval == 0 {
+		res.TouchInterval = defaultTouchInterval
+	}
+	return &res
+}
+
+// Issue makes a key of the service account with the given scopes. A ttl of 0 makes a key
+// without expiry. The returned key is the only copy of the secret, shown once to its owner.
+func (m *Manager) Issue(ctx context.Context, account, name string, scopes []string, ttl time.Duration) (string, Key, error) {
+	if account == "" {
+		return "", Key{}, errors.New("apikey: empty account")
+	}
+	for _, s := range scopes {
+		if s == "" || strings.ContainsAny(s, " \t\n") {
+			return "", Key{}, fmt.Errorf("apikey: invalid scope %q", s)
+		}
+	}
+	id, err := randomHex(idSize)
+	if err != nil {
+		return "", Key{}, err
+	}
+	secret, err := randomHex(secretSize)
+	if err != nil {
+		return "", Key{}, err
+	}
+	salt, err := randomHex(saltSize)
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	now := m.now().UTC()
+	k := Key{
+		ID:        id,
+		Account:   account,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: now,
+		Salt:      salt,
+		Hash:      hash(salt, secret),
+	}
+	if ttl > 0 {
+		k.ExpiresAt = now.Add(ttl)
+	}
+	if err = m.Store.Create(ctx, k); err != nil {
+		return "", Key{}, fmt.Errorf("apikey: can't save key: %w", err)
+	}
+	return m.Prefix + "_" + id + "_" + secret, k, nil
+}
+
+// Verify checks a key and returns it, recording its use
+func (m *Manager) Verify(ctx context.Context, key string) (Key, error) {
+	prefix, id, secret, ok := split(key)
+	if !ok || prefix != m.Prefix {
+		return Key{}, ErrInvalidKey
+	}
+	k, err := m.Store.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return Key{}, ErrInvalidKey
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("apikey: can't get key: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hash(k.Salt, secret)), []byte(k.Hash)) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+
+	now := m.now().UTC()
+	if k.Expired(now) {
+		return Key{}, ErrExpired
+	}
+	if now.Sub(k.LastUsedAt) >= m.TouchInterval {
+		// failing to record the use doesn't fail the request
+		if err = m.Store.SetLastUsed(ctx, k.ID, now); err == nil {
+			k.LastUsedAt = now
+		}
+	}
+	return k, nil
+}
+
+// Rotate issues a new key with the account, name, scopes and lifetime of the key id. The
+// rotated key expires after overlap, or when it was to expire if sooner; without overlap
+// it is revoked.
+func (m *Manager) Rotate(ctx context.Context, id string, overlap time.Duration) (string, Key, error) {
+	old, err := m.Store.Get(ctx, id)
+	if err != nil {
+		return "", Key{}, err
+	}
+	now := m.now().UTC()
+	if old.Expired(now) {
+		return "", Key{}, ErrExpired
+	}
+	var ttl time.Duration
+	if !old.ExpiresAt.IsZero() {
+		ttl = old.ExpiresAt.Sub(old.CreatedAt)
+	}
+	key, k, err := m.Issue(ctx, old.Account, old.Name, old.Scopes, ttl)
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	if overlap <= 0 {
+		err = m.Store.Delete(ctx, id)
+	} else if expires := now.Add(overlap); old.ExpiresAt.IsZero() || expires.Before(old.ExpiresAt) {
+		err = m.Store.SetExpiry(ctx, id, expires)
+	}
+	if err != nil {
+		return "", Key{}, fmt.Errorf("apikey: can't retire rotated key: %w", err)
+	}
+	return key, k, nil
+}
+
+// Revoke deletes the key id
+func (m *Manager) Revoke(ctx context.Context, id string) error {
+	return m.Store.Delete(ctx, id)
+}
+
+// List returns the keys of the service account, expired ones included
+func (m *Manager) List(ctx context.Context, account string) ([]Key, error) {
+	return m.Store.List(ctx, account)
+}
+
+// User returns the service account authenticated by the key k, with its scopes
+func (m *Manager) User(k Key) token.User {
+	u := token.User{ID: "sa_" + k.Account, Name: k.Account}
+	u.SetServiceAccount(true)
+	u.SetSliceAttr(token.ScopesAttr, k.Scopes)
+	u.SetStrAttr(KeyAttr, k.ID)
+	return u
+}
+
+// split parses "<prefix>_<id>_<secret>", the prefix may contain underscores
+func split(key string) (prefix, id, secret string, ok bool) {
+	i := strings.LastIndexByte(key, '_')
+	if i < 0 {
+		return "", "", "", false
+	}
+	prefix, secret = key[:i], key[i+1:]
+	if i = strings.LastIndexByte(prefix, '_'); i < 0 {
+		return "", "", "", false
+	}
+	prefix, id = prefix[:i], prefix[i+1:]
+	return prefix, id, secret, id != "" && secret != ""
+}
+
+func hash(salt, secret string) string {
+	h := sha256.Sum256([]byte(salt + secret))
+	return hex.EncodeToString(h[:])
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("apikey: can't make random value: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
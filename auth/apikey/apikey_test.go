@@ -0,0 +1,119 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	mkfstdb "mkfst/db"
+)
+
+func newManager(t *testing.T) *Manager {
+	t.Helper()
+	raw, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "keys.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	store, err := NewSQLStore(&mkfstdb.Connection{Conn: raw, Config: mkfstdb.ConnectionInfo{Type: "SQLITE"}}, SQLOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(Opts{Store: store, Prefix: "mk_test"})
+}
+
+func TestIssueVerify(t *testing.T) {
+	ctx := context.Background()
+	m := newManager(t)
+
+	key, k, err := m.Issue(ctx, "billing-worker", "nightly export", []string{"billing", "reports"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "mk_test_"+k.ID+"_") || k.Hash == key[strings.LastIndex(key, "_")+1:] {
+		t.Errorf("unexpected key %q for %+v", key, k)
+	}
+
+	got, err := m.Verify(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Account != "billing-worker" || strings.Join(got.Scopes, ",") != "billing,reports" || got.LastUsedAt.IsZero() {
+		t.Errorf("unexpected verified key: %+v", got)
+	}
+	if stored, _ := m.Store.Get(ctx, k.ID); !stored.LastUsedAt.Equal(got.LastUsedAt) {
+		t.Errorf("last use not recorded: %+v", stored)
+	}
+
+	u := m.User(got)
+	if !u.IsServiceAccount() || u.ID != "sa_billing-worker" || len(u.SliceAttr("scopes")) != 2 || u.StrAttr(KeyAttr) != k.ID {
+		t.Errorf("unexpected user: %+v", u)
+	}
+
+	wrong := key[:len(key)-1] + "0"
+	if wrong == key {
+		wrong = key[:len(key)-1] + "1"
+	}
+	for _, bad := range []string{"", "mk_test", "other_" + k.ID + "_secret", wrong, "mk_test_unknown_secret"} {
+		if _, err := m.Verify(ctx, bad); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected an invalid key, got %v", bad, err)
+		}
+	}
+
+	m.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := m.Verify(ctx, key); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected an expired key, got %v", err)
+	}
+}
+
+func TestRotateRevoke(t *testing.T) {
+	ctx := context.Background()
+	m := newManager(t)
+
+	oldKey, old, err := m.Issue(ctx, "deployer", "", []string{"deploy"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, k, err := m.Rotate(ctx, old.ID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.ID == old.ID || k.Account != "deployer" || !k.ExpiresAt.IsZero() {
+		t.Errorf("unexpected rotated key: %+v", k)
+	}
+
+	// both keys are accepted during the overlap, then only the new one
+	for _, key := range []string{oldKey, newKey} {
+		if _, err := m.Verify(ctx, key); err != nil {
+			t.Errorf("key rejected during the overlap: %v", err)
+		}
+	}
+	m.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := m.Verify(ctx, oldKey); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected the rotated key to expire, got %v", err)
+	}
+	if _, err := m.Verify(ctx, newKey); err != nil {
+		t.Errorf("new key rejected: %v", err)
+	}
+
+	list, err := m.List(ctx, "deployer")
+	if err != nil || len(list) != 2 || list[0].ID != old.ID || list[1].ID != k.ID {
+		t.Fatalf("unexpected keys: %+v %v", list, err)
+	}
+
+	if err := m.Revoke(ctx, k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Verify(ctx, newKey); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected the revoked key to be rejected, got %v", err)
+	}
+	if err := m.Revoke(ctx, k.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mkfst/db"
+)
+
+// SQLOpts configures NewSQLStore
+type SQLOpts struct {
+	// TablePrefix is prepended to the keys table name. Default "mkfst_".
+	TablePrefix string
+}
+
+type sqlDialect int
+
+const (
+	sqlDialectSQLite sqlDialect = iota
+	sqlDialectPostgres
+	sqlDialectMySQL
+)
+
+func dialectFor(connType string) (sqlDialect, error) {
+	switch strings.ToUpper(connType) {
+	case "", "SQLITE":
+		return sqlDialectSQLite, nil
+	case "POSTGRESQL", "POSTGRES":
+		return sqlDialectPostgres, nil
+	case "MYSQL":
+		return sqlDialectMySQL, nil
+	default:
+		return 0, fmt.Errorf("apikey.NewSQLStore: unsupported db type %q", connType)
+	}
+}
+
+// NewSQLStore returns a Store backed by an SQL database accessed through
+// mkfst's db.Connection. Supports PostgreSQL, MySQL 5.7+, and SQLite.
+//
+// On first use, runs idempotent CREATE TABLE IF NOT EXISTS migrations.
+// Times are stored as unix nanoseconds, 0 for none, in every dialect.
+func NewSQLStore(conn *db.Connection, opts SQLOpts) (Store, error) {
+	if conn == nil || conn.Conn == nil {
+		return nil, errors.New("apikey.NewSQLStore: nil connection")
+	}
+	d, err := dialectFor(conn.Config.Type)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TablePrefix == "" {
+		opts.TablePrefix = "mkfst_"
+	}
+	s := &sqlStore{db: conn.Conn, dialect: d, opts: opts}
+	if err := s.migrate(context.Background()); err != nil {
+		return nil, fmt.Errorf("apikey.NewSQLStore: migrate: %w", err)
+	}
+	return s, nil
+}
+
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+	opts    SQLOpts
+}
+
+func (s *sqlStore) table() string { return s.opts.TablePrefix + "api_keys" }
+
+const sqlColumns = `id, account, name, scopes, salt, hash, created_at, expires_at, last_used_at`
+
+func (s *sqlStore) migrate(ctx context.Context) error {
+	t := s.table()
+	var stmt string
+	switch s.dialect {
+	case sqlDialectMySQL:
+		stmt = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id           VARCHAR(64) PRIMARY KEY,
+			account      VARCHAR(255) NOT NULL,
+			name         VARCHAR(255) NOT NULL,
+			scopes       TEXT NOT NULL,
+			salt         VARCHAR(64) NOT NULL,
+			hash         VARCHAR(64) NOT NULL,
+			created_at   BIGINT NOT NULL,
+			expires_at   BIGINT NOT NULL,
+			last_used_at BIGINT NOT NULL,
+			INDEX %s_account (account)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, t, t)
+	default: // SQLite, Postgres
+		stmt = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id           VARCHAR(64) PRIMARY KEY,
+			account      VARCHAR(255) NOT NULL,
+			name         VARCHAR(255) NOT NULL,
+			scopes       TEXT NOT NULL,
+			salt         VARCHAR(64) NOT NULL,
+			hash         VARCHAR(64) NOT NULL,
+			created_at   BIGINT NOT NULL,
+			expires_at   BIGINT NOT NULL,
+			last_used_at BIGINT NOT NULL
+		)`, t)
+	}
+	if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("create table: %w", err)
+	}
+	if s.dialect != sqlDialectMySQL {
+		idx := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_account ON %s (account)`, t, t)
+		if _, err := s.db.ExecContext(ctx, idx); err != nil {
+			return fmt.Errorf("create index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) Create(ctx context.Context, k Key) error {
+	q := s.rebind(`INSERT INTO ` + s.table() + ` (` + sqlColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	_, err := s.db.ExecContext(ctx, q, k.ID, k.Account, k.Name, strings.Join(k.Scopes, " "), k.Salt, k.Hash,
+		encodeTime(k.CreatedAt), encodeTime(k.ExpiresAt), encodeTime(k.LastUsedAt))
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) Get(ctx context.Context, id string) (Key, error) {
+	q := s.rebind(`SELECT ` + sqlColumns + ` FROM ` + s.table() + ` WHERE id = ?`)
+	k, err := scanKey(s.db.QueryRowContext(ctx, q, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Key{}, ErrNotFound
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("get: %w", err)
+	}
+	return k, nil
+}
+
+func (s *sqlStore) List(ctx context.Context, account string) ([]Key, error) {
+	q := s.rebind(`SELECT ` + sqlColumns + ` FROM ` + s.table() + ` WHERE account = ? ORDER BY created_at, id`)
+	rows, err := s.db.QueryContext(ctx, q, account)
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
+	defer rows.Close()
+
+	var res []Key
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list: %w", err)
+		}
+		res = append(res, k)
+	}
+	return res, rows.Err()
+}
+
+func (s *sqlStore) SetExpiry(ctx context.Context, id string, expiresAt time.Time) error {
+	return s.update(ctx, "expires_at", id, expiresAt)
+}
+
+func (s *sqlStore) SetLastUsed(ctx context.Context, id string, at time.Time) error {
+	return s.update(ctx, "last_used_at", id, at)
+}
+
+func (s *sqlStore) update(ctx context.Context, column, id string, t time.Time) error {
+	q := s.rebind(`UPDATE ` + s.table() + ` SET ` + column + ` = ? WHERE id = ?`)
+	res, err := s.db.ExecContext(ctx, q, encodeTime(t), id)
+	if err != nil {
+		return fmt.Errorf("update %s: %w", column, err)
+	}
+	// MySQL reports the changed rows only, a missing key is told apart by Get
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if _, err = s.Get(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) Delete(ctx context.Context, id string) error {
+	q := s.rebind(`DELETE FROM ` + s.table() + ` WHERE id = ?`)
+	res, err := s.db.ExecContext(ctx, q, id)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// === helpers ===
+
+// scanKey reads a row of sqlColumns
+func scanKey(row interface{ Scan(...interface{}) error }) (Key, error) {
+	var k Key
+	var scopes string
+	var created, expires, lastUsed int64
+	err := row.Scan(&k.ID, &k.Account, &k.Name, &scopes, &k.Salt, &k.Hash, &created, &expires, &lastUsed)
+	if err != nil {
+		return Key{}, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	k.CreatedAt, k.ExpiresAt, k.LastUsedAt = decodeTime(created), decodeTime(expires), decodeTime(lastUsed)
+	return k, nil
+}
+
+// rebind converts ?-style placeholders to PG's $1, $2 form.
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != sqlDialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query))
+	idx := 1
+	for _, r := range query {
+		if r == '?' {
+			fmt.Fprintf(&b, "$%d", idx)
+			idx++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func encodeTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func decodeTime(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v).UTC()
+}
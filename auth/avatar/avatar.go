@@ -1,5 +1,5 @@
 // Package avatar implements avatart proxy for oauth and
-// defines store interface and implements local (fs), gridfs (mongo) and boltdb stores.
+// defines store interface and implements local (fs), gridfs (mongo), boltdb, s3, sql and cache stores.
 package avatar
 
 import (
@@ -106,8 +106,9 @@ func (p *Proxy) Handler(
 	db *sql.DB,
 ) (any, error) {
 
-	if ctx.Request.Method != "GET" {
+	if ctx.Request.Method != "GET" && ctx.Request.Method != "HEAD" {
 		ctx.AbortWithStatus(http.StatusMethodNotAllowed)
+		return nil, nil
 	}
 	elems := strings.Split(ctx.Request.URL.Path, "/")
 	avatarID := elems[len(elems)-1]
@@ -126,11 +127,12 @@ func (p *Proxy) Handler(
 	ctx.Header("Cache-Control", "max-age=604800")
 
 	if match := ctx.Request.Header.Get("If-None-Match"); match != "" {
-		etag = strings.TrimPrefix(etag, `"`)
-		etag = strings.TrimSuffix(etag, `"`)
-		if match == etag {
-			ctx.Status(http.StatusNotModified)
-			return nil, nil
+		for _, m := range strings.Split(match, ",") { // list of quoted, maybe weak, etags
+			m = strings.TrimPrefix(strings.TrimSpace(m), "W/")
+			if m == etag || m == strings.Trim(etag, `"`) || m == "*" {
+				ctx.Status(http.StatusNotModified)
+				return nil, nil
+			}
 		}
 	}
 
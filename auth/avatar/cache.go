@@ -0,0 +1,201 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rds "github.com/redis/go-redis/v9"
+
+	"mkfst/providers/cache"
+)
+
+// Cache implements avatar store with a providers/cache backend, i.e. Redis shared by the replicas.
+// Each avatar is an image key and a sha1 key, and an index key lists the avatars for List. The
+// index is updated by read-modify-write, so concurrent puts of new avatars by several processes
+// can drop some of them from List, not from the store.
+type Cache struct {
+	cache   cache.Cache
+	prefix  string
+	timeout time.Duration
+	owned   bool // the cache was made by NewStore, closed with the store
+	lock    sync.Mutex
+}
+
+// NewCache makes avatar store keeping the avatars in the cache under the key prefix
+func NewCache(c cache.Cache, prefix string) *Cache {
+	return &Cache{cache: c, prefix: prefix, timeout: 5 * time.Second}
+}
+
+// newCacheFromURI makes cache avatar store from redis://host:6379/0?prefix=avatars: or memory://
+func newCacheFromURI(uri string) (*Cache, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	prefix := q.Get("prefix")
+	if prefix == "" {
+		prefix = "avatars:"
+	}
+	q.Del("prefix")
+	u.RawQuery = q.Encode()
+
+	if u.Scheme == "memory" {
+		c := NewCache(cache.NewMemoryCache(cache.MemoryOpts{}), prefix)
+		c.owned = true
+		return c, nil
+	}
+	opts, err := rds.ParseURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+	client := rds.NewClient(opts)
+	rc, err := cache.NewRedisCache(cache.RedisOpts{Client: client})
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	c := NewCache(&closingCache{Cache: rc, client: client}, prefix)
+	c.owned = true
+	return c, nil
+}
+
+// closingCache closes the redis client of the cache made by newCacheFromURI
+type closingCache struct {
+	cache.Cache
+	client *rds.Client
+}
+
+func (c *closingCache) Close() error {
+	err := c.Cache.Close()
+	if e := c.client.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (c *Cache) imageKey(avatarID string) string { return c.prefix + "img:" + avatarID }
+func (c *Cache) hashKey(avatarID string) string  { return c.prefix + "hash:" + avatarID }
+func (c *Cache) indexKey() string                { return c.prefix + "index" }
+
+// Put avatar to the cache, key by avatarID. Also calculates sha1 of the image for ID func
+func (c *Cache) Put(userID string, reader io.Reader) (avatar string, err error) {
+	if reader == nil {
+		return "", fmt.Errorf("empty reader")
+	}
+	avatarID := encodeID(userID) + imgSfx
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("can't read avatar %s: %w", avatarID, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if err = c.cache.Set(ctx, c.imageKey(avatarID), data, 0); err != nil {
+		return "", fmt.Errorf("can't put avatar %s: %w", avatarID, err)
+	}
+	if err = c.cache.Set(ctx, c.hashKey(avatarID), []byte(hash(data, avatarID)), 0); err != nil {
+		return "", fmt.Errorf("can't put avatar hash %s: %w", avatarID, err)
+	}
+	err = c.updateIndex(ctx, func(ids map[string]bool) { ids[avatarID] = true })
+	return avatarID, err
+}
+
+// Get avatar reader for avatar id.image
+func (c *Cache) Get(avatarID string) (reader io.ReadCloser, size int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	data, ok, err := c.cache.Get(ctx, c.imageKey(avatarID))
+	if err != nil {
+		return nil, 0, fmt.Errorf("can't load avatar %s: %w", avatarID, err)
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("can't load avatar %s", avatarID)
+	}
+	return io.NopCloser(bytes.NewReader(data)), len(data), nil
+}
+
+// ID returns a fingerprint of the avatar content.
+func (c *Cache) ID(avatarID string) (id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	data, ok, err := c.cache.Get(ctx, c.hashKey(avatarID))
+	if err != nil || !ok { // failed to get ID, use encoded avatarID
+		log.Printf("[DEBUG] can't get avatar info '%s', %v", avatarID, err)
+		return encodeID(avatarID)
+	}
+	return string(data)
+}
+
+// Remove avatar from the cache
+func (c *Cache) Remove(avatarID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if _, ok, err := c.cache.Get(ctx, c.hashKey(avatarID)); err != nil || !ok {
+		return fmt.Errorf("avatar key not found, %s", avatarID)
+	}
+	for _, key := range []string{c.imageKey(avatarID), c.hashKey(avatarID)} {
+		if err := c.cache.Delete(ctx, key); err != nil {
+			return fmt.Errorf("can't delete avatar %s: %w", avatarID, err)
+		}
+	}
+	return c.updateIndex(ctx, func(ids map[string]bool) { delete(ids, avatarID) })
+}
+
+// List all avatars (ids) of the index
+// note: id includes .image suffix
+func (c *Cache) List() (ids []string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	data, _, err := c.cache.Get(ctx, c.indexKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list: %w", err)
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// updateIndex changes the set of avatar ids of the index
+func (c *Cache) updateIndex(ctx context.Context, fn func(ids map[string]bool)) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	data, _, err := c.cache.Get(ctx, c.indexKey())
+	if err != nil {
+		return fmt.Errorf("can't load avatars index: %w", err)
+	}
+	set := map[string]bool{}
+	for _, id := range strings.Fields(string(data)) {
+		set[id] = true
+	}
+	fn(set)
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if err = c.cache.Set(ctx, c.indexKey(), []byte(strings.Join(ids, "\n")), 0); err != nil {
+		return fmt.Errorf("can't save avatars index: %w", err)
+	}
+	return nil
+}
+
+// Close closes the cache made by NewStore, the one passed to NewCache belongs to the caller
+func (c *Cache) Close() error {
+	if !c.owned {
+		return nil
+	}
+	if err := c.cache.Close(); err != nil {
+		return fmt.Errorf("failed to close avatars cache: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) String() string {
+	return fmt.Sprintf("cache, prefix=%s", c.prefix)
+}
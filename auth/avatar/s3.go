@@ -0,0 +1,254 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+
+	mkfstaws "mkfst/providers/aws"
+)
+
+// S3Opts configures NewS3
+type S3Opts struct {
+	Bucket    string        // bucket name, required
+	Prefix    string        // key prefix of the avatars, i.e. "avatars/"
+	Config    awssdk.Config // region and credentials, see providers/aws. Nil credentials send anonymous requests
+	Endpoint  string        // S3-compatible endpoint, i.e. MinIO. Default https://s3.<region>.amazonaws.com
+	PathStyle bool          // address the bucket in the path instead of the host, usual for S3-compatible stores
+	Timeout   time.Duration // timeout of a request, default 30s
+}
+
+// S3 implements avatar store with an S3-compatible object storage. Avatars are objects
+// under the prefix, and their ETag is the ID.
+type S3 struct {
+	opts   S3Opts
+	client *http.Client
+	signer *v4.Signer
+}
+
+// NewS3 makes S3 avatar store
+func NewS3(opts S3Opts) (*S3, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("no bucket for s3 avatar store")
+	}
+	if opts.Endpoint == "" {
+		if opts.Config.Region == "" {
+			return nil, fmt.Errorf("no region or endpoint for s3 avatar store")
+		}
+		opts.Endpoint = "https://s3." + opts.Config.Region + ".amazonaws.com"
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	if opts.Config.Region == "" {
+		opts.Config.Region = "us-east-1"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	client := opts.Config.HTTPClient
+	httpClient, ok := client.(*http.Client)
+	if !ok || httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &S3{opts: opts, client: httpClient, signer: v4.NewSigner()}, nil
+}
+
+// newS3FromURI makes S3 avatar store from s3://bucket/prefix?region=us-east-1&endpoint=http://localhost:9000.
+// Credentials are resolved by providers/aws, and a custom endpoint uses path-style addressing.
+func newS3FromURI(uri string) (*S3, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	endpoint := q.Get("endpoint")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cfg, err := mkfstaws.Resolve(ctx, mkfstaws.Opts{Region: q.Get("region")})
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	pathStyle := endpoint != ""
+	if v := q.Get("path_style"); v != "" {
+		pathStyle, _ = strconv.ParseBool(v)
+	}
+	return NewS3(S3Opts{Bucket: u.Host, Prefix: prefix, Config: cfg, Endpoint: endpoint, PathStyle: pathStyle})
+}
+
+// Put avatar to the bucket, key by avatarID
+func (s *S3) Put(userID string, reader io.Reader) (avatar string, err error) {
+	if reader == nil {
+		return "", fmt.Errorf("empty reader")
+	}
+	avatarID := encodeID(userID) + imgSfx
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("can't read avatar %s: %w", avatarID, err)
+	}
+	resp, err := s.do(http.MethodPut, s.opts.Prefix+avatarID, nil, data)
+	if err != nil {
+		return "", fmt.Errorf("can't put avatar %s: %w", avatarID, err)
+	}
+	_ = resp.Body.Close()
+	return avatarID, nil
+}
+
+// Get avatar reader for avatar id.image
+func (s *S3) Get(avatarID string) (reader io.ReadCloser, size int, err error) {
+	resp, err := s.do(http.MethodGet, s.opts.Prefix+avatarID, nil, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("can't load avatar %s: %w", avatarID, err)
+	}
+	return resp.Body, int(resp.ContentLength), nil
+}
+
+// ID returns a fingerprint of the avatar content, the ETag of the object
+func (s *S3) ID(avatarID string) (id string) {
+	resp, err := s.do(http.MethodHead, s.opts.Prefix+avatarID, nil, nil)
+	if err != nil {
+		log.Printf("[DEBUG] can't get avatar info '%s', %s", avatarID, err)
+		return encodeID(avatarID)
+	}
+	_ = resp.Body.Close()
+	if etag := strings.Trim(resp.Header.Get("ETag"), `"`); etag != "" {
+		return etag
+	}
+	return encodeID(avatarID)
+}
+
+// Remove avatar object
+func (s *S3) Remove(avatarID string) error {
+	resp, err := s.do(http.MethodDelete, s.opts.Prefix+avatarID, nil, nil)
+	if err != nil {
+		return fmt.Errorf("can't delete avatar %s: %w", avatarID, err)
+	}
+	return resp.Body.Close()
+}
+
+// List all avatars (ids) under the prefix
+// note: id includes .image suffix
+func (s *S3) List() (ids []string, err error) {
+	var listing struct {
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+	q := url.Values{"list-type": {"2"}, "prefix": {s.opts.Prefix}}
+	for {
+		resp, err := s.do(http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, fmt.Errorf("can't list avatars: %w", err)
+		}
+		listing.Contents, listing.IsTruncated, listing.NextContinuationToken = nil, false, ""
+		err = xml.NewDecoder(resp.Body).Decode(&listing)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("can't decode avatars list: %w", err)
+		}
+		for _, c := range listing.Contents {
+			if id := strings.TrimPrefix(c.Key, s.opts.Prefix); strings.HasSuffix(id, imgSfx) && !strings.Contains(id, "/") {
+				ids = append(ids, id)
+			}
+		}
+		if !listing.IsTruncated || listing.NextContinuationToken == "" {
+			return ids, nil
+		}
+		q.Set("continuation-token", listing.NextContinuationToken)
+	}
+}
+
+// Close S3 does nothing but satisfies interface
+func (s *S3) Close() error {
+	return nil
+}
+
+func (s *S3) String() string {
+	return fmt.Sprintf("s3, bucket=%s, prefix=%s, endpoint=%s", s.opts.Bucket, s.opts.Prefix, s.opts.Endpoint)
+}
+
+// do sends a signed request for the key, or the bucket if key is empty, and fails on a non-2xx status.
+// The caller has to close the body of the response.
+func (s *S3) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+
+	u, err := url.Parse(s.opts.Endpoint)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	if s.opts.PathStyle {
+		u.Path += "/" + s.opts.Bucket + "/" + key
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20") // the signature needs %20 for spaces
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if method == http.MethodPut {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	if s.opts.Config.Credentials != nil {
+		creds, err := s.opts.Config.Credentials.Retrieve(ctx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("can't get credentials: %w", err)
+		}
+		err = s.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "s3", s.opts.Config.Region, time.Now())
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("can't sign request: %w", err)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelReadCloser cancels the context of the request on close of the response body
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,296 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/md5" //nolint gosec
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/gin-gonic/gin"
+	_ "modernc.org/sqlite"
+
+	mkfstdb "mkfst/db"
+	"mkfst/providers/cache"
+)
+
+// fakeS3 is an S3-compatible stand-in keeping the objects of one bucket in memory, path-style
+type fakeS3 struct {
+	*httptest.Server
+	t       *testing.T
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	f := &fakeS3{t: t, objects: map[string][]byte{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeS3) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok && r.URL.Path != "/bucket" {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if key == "" && r.Method == http.MethodGet { // ListObjectsV2, two keys a page
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("continuation-token") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		type content struct{ Key string }
+		res := struct {
+			XMLName               xml.Name `xml:"ListBucketResult"`
+			Contents              []content
+			IsTruncated           bool
+			NextContinuationToken string `xml:",omitempty"`
+		}{}
+		for i, k := range keys {
+			if i == 2 {
+				res.IsTruncated, res.NextContinuationToken = true, keys[1]
+				break
+			}
+			res.Contents = append(res.Contents, content{Key: k})
+		}
+		_ = xml.NewEncoder(w).Encode(res)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		sum := md5.Sum(data) //nolint gosec
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newTestS3(t *testing.T) *S3 {
+	f := newFakeS3(t)
+	s, err := NewS3(S3Opts{
+		Bucket:    "bucket",
+		Prefix:    "avatars/",
+		Endpoint:  f.URL,
+		PathStyle: true,
+		Config:    awssdk.Config{Region: "us-east-1", Credentials: credentials.NewStaticCredentialsProvider("key", "secret", "")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestSQL(t *testing.T) *SQL {
+	raw, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "avatars.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	s, err := NewSQL(&mkfstdb.Connection{Conn: raw, Config: mkfstdb.ConnectionInfo{Type: "SQLITE"}}, SQLOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testStore checks the operations of an empty store
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	ids := map[string]string{}
+	for _, user := range []string{"user1", "user2", "user3"} {
+		id, err := s.Put(user, strings.NewReader("image of "+user))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reValidAvatarID.MatchString(id) {
+			t.Errorf("invalid avatar id %s", id)
+		}
+		ids[user] = id
+	}
+
+	r, size, err := s.Get(ids["user2"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "image of user2" || size != len(data) {
+		t.Errorf("unexpected avatar %q, size %d", data, size)
+	}
+
+	id := s.ID(ids["user1"])
+	if id == "" || id == s.ID(ids["user2"]) {
+		t.Errorf("expected distinct content ids, got %s", id)
+	}
+	if _, err = s.Put("user1", strings.NewReader("new image")); err != nil {
+		t.Fatal(err)
+	}
+	if s.ID(ids["user1"]) == id {
+		t.Error("content id should change with the content")
+	}
+
+	list, err := s.List()
+	sort.Strings(list)
+	exp := []string{ids["user1"], ids["user2"], ids["user3"]}
+	sort.Strings(exp)
+	if err != nil || strings.Join(list, ",") != strings.Join(exp, ",") {
+		t.Errorf("unexpected list %v, %v", list, err)
+	}
+
+	if err = s.Remove(ids["user3"]); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.Get(ids["user3"]); err == nil {
+		t.Error("expected an error for a removed avatar")
+	}
+	if list, _ = s.List(); len(list) != 2 {
+		t.Errorf("unexpected list after remove %v", list)
+	}
+	if s.String() == "" {
+		t.Error("empty description")
+	}
+}
+
+func TestStores(t *testing.T) {
+	tbl := []struct {
+		name string
+		make func(t *testing.T) Store
+	}{
+		{"s3", func(t *testing.T) Store { return newTestS3(t) }},
+		{"sql", func(t *testing.T) Store { return newTestSQL(t) }},
+		{"cache", func(t *testing.T) Store { return NewCache(cache.NewMemoryCache(cache.MemoryOpts{}), "ava:") }},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.make(t)
+			defer s.Close()
+			testStore(t, s)
+		})
+	}
+}
+
+func TestNewStoreSchemes(t *testing.T) {
+	s, err := NewStore("memory://?prefix=test:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.(*Cache); !ok || s.String() != "cache, prefix=test:" {
+		t.Errorf("unexpected store %s", s)
+	}
+	if _, err = NewStore("ftp://host/avatars"); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+
+	s, err = NewStore("sqlite://" + filepath.Join(t.TempDir(), "avatars.db") + "?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, s)
+
+	// the drivers of postgres and mysql are not imported: an error, not an exit
+	for _, uri := range []string{"postgres://bob@localhost/avatars", "mysql://bob@localhost/avatars"} {
+		if _, err = NewStore(uri); err == nil {
+			t.Errorf("%s: expected an error", uri)
+		}
+	}
+}
+
+func TestSQLDSN(t *testing.T) {
+	t.Setenv("DB_HOST", "app-db")
+	t.Setenv("DB_PASSWORD", "app-secret")
+	tbl := []struct {
+		uri, driver, dsn string
+	}{
+		{"postgres://bob:pa%40ss@db:5432/avatars?sslmode=verify-full", "pgx", "postgres://bob:pa%40ss@db:5432/avatars?sslmode=verify-full"},
+		{"mysql://bob:pa%40ss@db:3306/avatars?tls=true&parseTime=true", "mysql", "bob:pa@ss@tcp(db:3306)/avatars?tls=true&parseTime=true"},
+		{"mysql://db/avatars", "mysql", "tcp(db)/avatars"},
+		{"sqlite:///var/lib/avatars.db?_pragma=busy_timeout(5000)", "sqlite", "/var/lib/avatars.db?_pragma=busy_timeout(5000)"},
+	}
+	for _, tt := range tbl {
+		driver, dsn, _, err := sqlDSN(tt.uri)
+		if err != nil || driver != tt.driver || dsn != tt.dsn {
+			t.Errorf("%s: got %s %s %v, expected %s %s", tt.uri, driver, dsn, err, tt.driver, tt.dsn)
+		}
+	}
+}
+
+func TestMigrateToS3(t *testing.T) {
+	src := newTestSQL(t)
+	for _, user := range []string{"user1", "user2", "user3"} {
+		if _, err := src.Put(user, strings.NewReader("image of "+user)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := newTestS3(t)
+	n, err := Migrate(dst, src)
+	if err != nil || n != 3 {
+		t.Fatalf("unexpected migrate: %d, %v", n, err)
+	}
+	list, err := dst.List() // listed in pages of two
+	if err != nil || len(list) != 3 {
+		t.Errorf("unexpected list %v, %v", list, err)
+	}
+}
+
+func TestProxyHandlerCaching(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	p := &Proxy{Store: newTestSQL(t), RoutePath: "/avatar"}
+	id, err := p.Store.Put("user1", bytes.NewReader([]byte("image")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.GET("/avatar/:id", func(c *gin.Context) { _, _ = p.Handler(c, nil) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/avatar/"+id, nil))
+	etag := w.Header().Get("Etag")
+	if w.Code != http.StatusOK || w.Body.String() != "image" || etag != `"`+p.Store.ID(id)+`"` ||
+		w.Header().Get("Cache-Control") != "max-age=604800" {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+
+	req := httptest.NewRequest("GET", "/avatar/"+id, nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected 304 for the etag, got %d %s", w.Code, w.Body)
+	}
+}
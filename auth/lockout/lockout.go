@@ -0,0 +1,350 @@
+// Package lockout protects the login flows of the auth service from brute force. It counts the
+// failed attempts per user and per client address and locks them out for exponentially growing
+// periods, throttles the verification messages sent to an address, and can require a
+// proof-of-work or CAPTCHA challenge before a login is checked.
+//
+// The attempts are kept in a providers/cache Cache, shared by the replicas of the service with
+// the Redis or SQL backend. Updates are not atomic across replicas, so concurrent failures on
+// different replicas may be undercounted by a few.
+package lockout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mkfst/providers/cache"
+)
+
+// Types of the audit events
+const (
+	EventLoginFailed     = "login_failed"           // wrong credentials
+	EventLockedOut       = "locked_out"             // attempt rejected by a lockout
+	EventChallengeFailed = "challenge_failed"       // required challenge missing or wrong
+	EventThrottled       = "verification_throttled" // verification message rejected by the cooldown
+)
+
+const (
+	defaultPrefix       = "lockout:"
+	defaultUserAttempts = 5
+	defaultIPAttempts   = 20
+	defaultWindow       = 15 * time.Minute
+	defaultBaseLockout  = time.Minute
+	defaultMaxLockout   = time.Hour
+	defaultSendCooldown = time.Minute
+	defaultMinResponse  = 300 * time.Millisecond
+)
+
+// Event is an audit event of the login flows
+type Event struct {
+	Type      string
+	Provider  string
+	User      string // user name or address of the attempt, empty if unknown
+	IP        string
+	Time      time.Time
+	LockedFor time.Duration // lockout started or still running, 0 for none
+}
+
+// Challenge verifies a proof-of-work or CAPTCHA solution carried by a login request
+type Challenge interface {
+	Verify(r *http.Request) (ok bool, err error)
+}
+
+// ChallengeFunc type is an adapter to allow the use of ordinary functions as Challenge
+type ChallengeFunc func(r *http.Request) (ok bool, err error)
+
+// Verify calls f(r)
+func (f ChallengeFunc) Verify(r *http.Request) (ok bool, err error) {
+	return f(r)
+}
+
+// Opts is a set of parameters of Guard
+type Opts struct {
+	Cache  cache.Cache // store of the attempts, required
+	Prefix string      // prefix of the keys, default "lockout:"
+
+	UserAttempts int           // failures of a user before a lockout, default 5
+	IPAttempts   int           // failures of a client address before a lockout, default 20
+	Window       time.Duration // failures are forgotten after a quiet window, default 15m
+	BaseLockout  time.Duration // first lockout, doubled by each further failure, default 1m
+	MaxLockout   time.Duration // longest lockout, default 1h
+
+	SendCooldown time.Duration // time between verification messages to an address, default 1m
+	MinResponse  time.Duration // rejected attempts are answered no sooner, default 300ms
+
+	Challenge      Challenge // optional proof-of-work or CAPTCHA check
+	ChallengeAfter int       // failures of the user or address before Challenge is required, 0 for always
+
+	AuditFn  func(e Event)                // optional audit of the failed and rejected attempts
+	ClientIP func(r *http.Request) string // client address of a request, default the host of RemoteAddr
+}
+
+// Guard tracks the attempts of the login flows
+type Guard struct {
+	Opts
+	now  func() time.Time
+	lock sync.Mutex // serializes the updates of the attempts in this process
+}
+
+// record is the state of a user or address
+type record struct {
+	Failures    int       `json:"failures"`
+	Last        time.Time `json:"last"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// New makes a lockout guard
+func New(opts Opts) *Guard {
+	res := Guard{Opts: opts, now: time.Now}
+	if res.Prefix == "" {
+		res.Prefix = defaultPrefix
+	}
+	if res.UserAttempts == 0 {
+		res.UserAttempts = defaultUserAttempts
+	}
+	if res.IPAttempts == 0 {
+		res.IPAttempts = defaultIPAttempts
+	}
+	if res.Window == 0 {
+		res.Window = defaultWindow
+	}
+	if res.BaseLockout == 0 {
+		res.BaseLockout = defaultBaseLockout
+	}
+	if res.MaxLockout == 0 {
+		res.MaxLockout = defaultMaxLockout
+	}
+	if res.SendCooldown == 0 {
+		res.SendCooldown = defaultSendCooldown
+	}
+	if res.MinResponse == 0 {
+		res.MinResponse = defaultMinResponse
+	}
+	if res.ClientIP == nil {
+		res.ClientIP = remoteIP
+	}
+	return &res
+}
+
+// Check returns the remaining lockout of the user or the client address, 0 if neither is locked out
+func (g *Guard) Check(ctx context.Context, provider, user, ip string) (time.Duration, error) {
+	var res time.Duration
+	for _, key := range g.keys(provider, user, ip) {
+		rec, err := g.get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if wait := rec.LockedUntil.Sub(g.now()); wait > res {
+			res = wait
+		}
+	}
+	return res, nil
+}
+
+// Fail records a failed attempt of the user from the client address. It returns the lockout
+// started by this failure, 0 for none.
+func (g *Guard) Fail(ctx context.Context, provider, user, ip string) (time.Duration, error) {
+	var res time.Duration
+	if user != "" {
+		lock, err := g.fail(ctx, g.userKey(provider, user), g.UserAttempts)
+		if err != nil {
+			return 0, err
+		}
+		res = lock
+	}
+	if ip != "" {
+		lock, err := g.fail(ctx, g.ipKey(ip), g.IPAttempts)
+		if err != nil {
+			return 0, err
+		}
+		if lock > res {
+			res = lock
+		}
+	}
+	return res, nil
+}
+
+// Reset forgets the failures of a user after a successful login. The failures of the client
+// address are kept, a successful login doesn't clear an address trying many users.
+func (g *Guard) Reset(ctx context.Context, provider, user string) error {
+	if err := g.Cache.Delete(ctx, g.userKey(provider, user)); err != nil {
+		return fmt.Errorf("lockout: can't reset attempts: %w", err)
+	}
+	return nil
+}
+
+// Cooldown reports how long to wait before another verification message can be sent to the
+// address. If none, the message is recorded as sent, and counted as an attempt of the client
+// address to limit the messages sent by a client to many addresses.
+func (g *Guard) Cooldown(ctx context.Context, provider, address, ip string) (time.Duration, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	key := g.Prefix + "sent:" + provider + ":" + normalize(address)
+	data, ok, err := g.Cache.Get(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("lockout: can't get cooldown: %w", err)
+	}
+	now := g.now()
+	if ok {
+		var sent time.Time
+		if err = sent.UnmarshalText(data); err == nil {
+			if wait := sent.Add(g.SendCooldown).Sub(now); wait > 0 {
+				return wait, nil
+			}
+		}
+	}
+	data, err = now.MarshalText()
+	if err != nil {
+		return 0, fmt.Errorf("lockout: can't encode cooldown: %w", err)
+	}
+	if err = g.Cache.Set(ctx, key, data, g.SendCooldown); err != nil {
+		return 0, fmt.Errorf("lockout: can't set cooldown: %w", err)
+	}
+	if ip != "" {
+		if _, err = g.failLocked(ctx, g.ipKey(ip), g.IPAttempts); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// NeedsChallenge reports if an attempt of the user from the client address must carry a solved Challenge
+func (g *Guard) NeedsChallenge(ctx context.Context, provider, user, ip string) (bool, error) {
+	if g.Challenge == nil {
+		return false, nil
+	}
+	if g.ChallengeAfter == 0 {
+		return true, nil
+	}
+	for _, key := range g.keys(provider, user, ip) {
+		rec, err := g.get(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		if rec.Failures >= g.ChallengeAfter {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Audit emits an event to AuditFn, setting its time if missing
+func (g *Guard) Audit(e Event) {
+	if g.AuditFn == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = g.now()
+	}
+	g.AuditFn(e)
+}
+
+// Delay waits until MinResponse passed since start, so the rejected attempts take the same time
+// whether the user exists or not
+func (g *Guard) Delay(ctx context.Context, start time.Time) {
+	wait := start.Add(g.MinResponse).Sub(g.now())
+	if wait <= 0 {
+		return
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// fail counts a failure of the key, locking it out from max failures on
+func (g *Guard) fail(ctx context.Context, key string, max int) (time.Duration, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.failLocked(ctx, key, max)
+}
+
+func (g *Guard) failLocked(ctx context.Context, key string, max int) (time.Duration, error) {
+	rec, err := g.get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	now := g.now()
+	if now.Sub(rec.Last) > g.Window && !now.Before(rec.LockedUntil) {
+		rec = record{}
+	}
+	rec.Failures++
+	rec.Last = now
+
+	var lock time.Duration
+	if rec.Failures >= max {
+		lock = g.MaxLockout
+		if shift := rec.Failures - max; shift < 32 {
+			if d := g.BaseLockout << shift; d > 0 && d < g.MaxLockout {
+				lock = d
+			}
+		}
+		rec.LockedUntil = now.Add(lock)
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return 0, fmt.Errorf("lockout: can't encode attempts: %w", err)
+	}
+	if err = g.Cache.Set(ctx, key, data, lock+g.Window); err != nil {
+		return 0, fmt.Errorf("lockout: can't save attempts: %w", err)
+	}
+	return lock, nil
+}
+
+// get returns the record of the key, with the failures older than Window forgotten
+func (g *Guard) get(ctx context.Context, key string) (record, error) {
+	data, ok, err := g.Cache.Get(ctx, key)
+	if err != nil {
+		return record{}, fmt.Errorf("lockout: can't get attempts: %w", err)
+	}
+	if !ok {
+		return record{}, nil
+	}
+	var rec record
+	if err = json.Unmarshal(data, &rec); err != nil {
+		return record{}, fmt.Errorf("lockout: can't decode attempts: %w", err)
+	}
+	if now := g.now(); now.Sub(rec.Last) > g.Window && !now.Before(rec.LockedUntil) {
+		return record{}, nil
+	}
+	return rec, nil
+}
+
+// keys returns the keys of the non-empty user and address
+func (g *Guard) keys(provider, user, ip string) []string {
+	res := make([]string, 0, 2)
+	if user != "" {
+		res = append(res, g.userKey(provider, user))
+	}
+	if ip != "" {
+		res = append(res, g.ipKey(ip))
+	}
+	return res
+}
+
+func (g *Guard) userKey(provider, user string) string {
+	return g.Prefix + "user:" + provider + ":" + normalize(user)
+}
+
+func (g *Guard) ipKey(ip string) string { return g.Prefix + "ip:" + ip }
+
+// normalize makes the variants of a user name count as one
+func normalize(s string) string { return strings.ToLower(strings.TrimSpace(s)) }
+
+// remoteIP returns the host of RemoteAddr
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
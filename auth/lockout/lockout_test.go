@@ -0,0 +1,115 @@
+package lockout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mkfst/providers/cache"
+)
+
+func newGuard(t *testing.T, opts Opts) (*Guard, *time.Time) {
+	t.Helper()
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	t.Cleanup(func() { _ = c.Close() })
+	opts.Cache = c
+	g := New(opts)
+	now := time.Now()
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestGuardLockout(t *testing.T) {
+	g, now := newGuard(t, Opts{UserAttempts: 3, IPAttempts: 100})
+	ctx := t.Context()
+
+	for i := 0; i < 2; i++ {
+		if lock, err := g.Fail(ctx, "local", "bob", "10.0.0.1"); err != nil || lock != 0 {
+			t.Fatalf("failure %d: unexpected lockout %v, %v", i, lock, err)
+		}
+	}
+	lock, err := g.Fail(ctx, "local", "Bob ", "10.0.0.2")
+	if err != nil || lock != time.Minute {
+		t.Fatalf("expected 1m lockout on the 3rd failure, got %v, %v", lock, err)
+	}
+	if wait, _ := g.Check(ctx, "local", "bob", "10.0.0.3"); wait != time.Minute {
+		t.Errorf("expected bob locked out for 1m, got %v", wait)
+	}
+	if wait, _ := g.Check(ctx, "local", "alice", "10.0.0.1"); wait != 0 {
+		t.Errorf("alice should not be locked out, got %v", wait)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if wait, _ := g.Check(ctx, "local", "bob", ""); wait != 0 {
+		t.Errorf("lockout should have ended, got %v", wait)
+	}
+	if lock, _ = g.Fail(ctx, "local", "bob", ""); lock != 2*time.Minute {
+		t.Errorf("expected the lockout doubled to 2m, got %v", lock)
+	}
+
+	*now = now.Add(time.Hour)
+	if lock, _ = g.Fail(ctx, "local", "bob", ""); lock != 0 {
+		t.Errorf("failures should be forgotten after the window, got lockout %v", lock)
+	}
+	if err = g.Reset(ctx, "local", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if need, _ := g.NeedsChallenge(ctx, "local", "bob", ""); need {
+		t.Error("no challenge configured")
+	}
+}
+
+func TestGuardMaxLockout(t *testing.T) {
+	g, _ := newGuard(t, Opts{UserAttempts: 1, MaxLockout: 5 * time.Minute})
+	var lock time.Duration
+	for i := 0; i < 40; i++ {
+		lock, _ = g.Fail(t.Context(), "local", "bob", "")
+	}
+	if lock != 5*time.Minute {
+		t.Errorf("expected the lockout capped to 5m, got %v", lock)
+	}
+}
+
+func TestGuardCooldown(t *testing.T) {
+	g, now := newGuard(t, Opts{IPAttempts: 2})
+	ctx := t.Context()
+
+	if wait, err := g.Cooldown(ctx, "email", "bob@example.com", "10.0.0.1"); err != nil || wait != 0 {
+		t.Fatalf("first confirmation should be sent, got %v, %v", wait, err)
+	}
+	if wait, _ := g.Cooldown(ctx, "email", "BOB@example.com", "10.0.0.2"); wait != time.Minute {
+		t.Errorf("expected 1m cooldown, got %v", wait)
+	}
+	*now = now.Add(61 * time.Second)
+	if wait, _ := g.Cooldown(ctx, "email", "bob@example.com", "10.0.0.1"); wait != 0 {
+		t.Errorf("cooldown should have ended, got %v", wait)
+	}
+	if wait, _ := g.Check(ctx, "email", "", "10.0.0.1"); wait == 0 {
+		t.Error("address sending many confirmations should be locked out")
+	}
+}
+
+func TestGuardChallenge(t *testing.T) {
+	g, _ := newGuard(t, Opts{
+		ChallengeAfter: 2,
+		Challenge: ChallengeFunc(func(r *http.Request) (bool, error) {
+			return r.Header.Get("X-Captcha") == "solved", nil
+		}),
+	})
+	ctx := t.Context()
+
+	if need, _ := g.NeedsChallenge(ctx, "local", "bob", "10.0.0.1"); need {
+		t.Error("challenge should not be needed before failures")
+	}
+	_, _ = g.Fail(ctx, "local", "bob", "10.0.0.1")
+	_, _ = g.Fail(ctx, "local", "bob", "10.0.0.2")
+	if need, _ := g.NeedsChallenge(ctx, "local", "bob", "10.0.0.3"); !need {
+		t.Error("challenge should be needed after 2 failures of the user")
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Captcha", "solved")
+	if ok, err := g.Challenge.Verify(r); !ok || err != nil {
+		t.Errorf("challenge should pass, got %v, %v", ok, err)
+	}
+}
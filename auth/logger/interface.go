@@ -1,8 +1,14 @@
 // Package logger defines interface for logging. Implementation should be passed by user.
-// Also provides NoOp (do-nothing) and Std (redirect to std log) predefined loggers.
+// Also provides NoOp (do-nothing) and Std (redirect to std log) predefined loggers, and Slog adapting log/slog.
 package logger
 
-import "log"
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
 
 // L defined logger interface used everywhere in the package
 type L interface {
@@ -20,3 +26,26 @@ var NoOp = Func(func(format string, args ...interface{}) {})
 
 // Std logger sends to std default logger directly
 var Std = Func(func(format string, args ...interface{}) { log.Printf(format, args...) })
+
+// Slog returns the logger sending to l, at the level of the [DEBUG], [INFO], [WARN] or [ERROR]
+// prefix of the message, Info without one. The prefix is removed.
+func Slog(l *slog.Logger) L {
+	return Func(func(format string, args ...interface{}) {
+		level := slog.LevelInfo
+		msg := fmt.Sprintf(format, args...)
+		for prefix, lvl := range slogLevels {
+			if rest, ok := strings.CutPrefix(msg, prefix); ok {
+				level, msg = lvl, strings.TrimSpace(rest)
+				break
+			}
+		}
+		l.Log(context.Background(), level, msg)
+	})
+}
+
+var slogLevels = map[string]slog.Level{
+	"[DEBUG]": slog.LevelDebug,
+	"[INFO]":  slog.LevelInfo,
+	"[WARN]":  slog.LevelWarn,
+	"[ERROR]": slog.LevelError,
+}
@@ -0,0 +1,194 @@
+// Package mfa adds second factors to the users of the auth service: TOTP (RFC 6238) with
+// single-use recovery codes, and WebAuthn credentials such as security keys and passkeys.
+//
+// A user logs in with a first factor as usual, then steps up by verifying a second factor;
+// the JWT of a stepped-up user carries "mfa" in its amr claim (RFC 8176), which
+// Authenticator.RequireMFA checks. The credentials of the users are kept in a Store; CacheStore
+// keeps them in a providers/cache Cache, or any other storage can implement Store.
+//
+// WebAuthn attestation is requested as "none": the authenticator's model is not verified,
+// only the proof of possession of the registered key.
+package mfa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mkfst/auth/token"
+	"mkfst/providers/cache"
+)
+
+// Errors of the Manager
+var (
+	ErrNotEnrolled   = errors.New("mfa: factor not enrolled")
+	ErrEnrolled      = errors.New("mfa: factor already enrolled")
+	ErrInvalidCode   = errors.New("mfa: invalid code")
+	ErrNoChallenge   = errors.New("mfa: no pending challenge")
+	ErrInvalidClient = errors.New("mfa: invalid authenticator response")
+)
+
+const (
+	defaultPrefix        = "mfa:"
+	defaultPeriod        = 30 * time.Second
+	defaultSkew          = 1
+	defaultRecoveryCodes = 10
+	defaultChallengeTTL  = 5 * time.Minute
+)
+
+// Credentials are the second factors of a user
+type Credentials struct {
+	TOTP          *TOTP                `json:"totp,omitempty"`
+	WebAuthn      []WebAuthnCredential `json:"webauthn,omitempty"`
+	RecoveryCodes []string             `json:"recovery_codes,omitempty"` // hashes of the unused codes
+	Challenge     *Challenge           `json:"challenge,omitempty"`      // pending WebAuthn ceremony
+}
+
+// Enrolled reports if the user has a second factor
+func (c Credentials) Enrolled() bool {
+	return (c.TOTP != nil && c.TOTP.Confirmed) || len(c.WebAuthn) > 0
+}
+
+// Methods lists the enrolled factors, "totp", "webauthn" and "recovery"
+func (c Credentials) Methods() []string {
+	res := []string{}
+	if c.TOTP != nil && c.TOTP.Confirmed {
+		res = append(res, "totp")
+	}
+	if len(c.WebAuthn) > 0 {
+		res = append(res, "webauthn")
+	}
+	if len(c.RecoveryCodes) > 0 {
+		res = append(res, "recovery")
+	}
+	return res
+}
+
+// Challenge is the challenge of a pending WebAuthn ceremony
+type Challenge struct {
+	Value   string    `json:"value"` // base64url encoded
+	Type    string    `json:"type"`  // "webauthn.create" or "webauthn.get"
+	Expires time.Time `json:"expires"`
+}
+
+// Store keeps the credentials of the users. Get returns empty Credentials for a user without any.
+type Store interface {
+	Get(ctx context.Context, userID string) (Credentials, error)
+	Set(ctx context.Context, userID string, c Credentials) error
+}
+
+// CacheStore is a Store keeping the credentials in a cache. The credentials never expire, so
+// the cache must not evict them: use the Redis or SQL backend, or a memory cache in tests.
+type CacheStore struct {
+	Cache  cache.Cache
+	Prefix string // prefix of the keys, default "mfa:"
+}
+
+// NewCacheStore makes a store keeping the credentials in c
+func NewCacheStore(c cache.Cache) *CacheStore {
+	return &CacheStore{Cache: c, Prefix: defaultPrefix}
+}
+
+// Get implements Store
+func (s *CacheStore) Get(ctx context.Context, userID string) (Credentials, error) {
+	data, ok, err := s.Cache.Get(ctx, s.Prefix+userID)
+	if err != nil || !ok {
+		return Credentials{}, err
+	}
+	var c Credentials
+	if err = json.Unmarshal(data, &c); err != nil {
+		return Credentials{}, fmt.Errorf("mfa: can't decode credentials: %w", err)
+	}
+	return c, nil
+}
+
+// Set implements Store
+func (s *CacheStore) Set(ctx context.Context, userID string, c Credentials) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("mfa: can't encode credentials: %w", err)
+	}
+	return s.Cache.Set(ctx, s.Prefix+userID, data, 0)
+}
+
+// Opts is a set of parameters of Manager
+type Opts struct {
+	Store  Store  // store of the credentials, required
+	Issuer string // name of the service, shown by the authenticator apps and WebAuthn prompts
+
+	// TOTP
+	Period        time.Duration // lifetime of a code, default 30s
+	Skew          int           // codes of adjacent periods accepted for clock drift, default 1
+	RecoveryCodes int           // number of recovery codes, default 10
+
+	// WebAuthn
+	RPID                    string        // relying party id, the domain of the site, required for WebAuthn
+	Origins                 []string      // origins of the ceremonies, default "https://" + RPID
+	ChallengeTTL            time.Duration // time to complete a ceremony, default 5m
+	RequireUserVerification bool          // require a PIN or biometric check by the authenticator
+}
+
+// Manager enrolls and verifies the second factors of the users
+type Manager struct {
+	Opts
+	now  func() time.Time
+	lock sync.Mutex // serializes the updates of the credentials in this process
+}
+
+// NewManager makes an MFA manager
+func NewManager(opts Opts) *Manager {
+	res := Manager{Opts: opts, now: time.Now}
+	if res.Period == 0 {
+		res.Period = defaultPeriod
+	}
+	if res.Skew == 0 {
+		res.Skew = defaultSkew
+	}
+	if res.RecoveryCodes == 0 {
+		res.RecoveryCodes = defaultRecoveryCodes
+	}
+	if res.ChallengeTTL == 0 {
+		res.ChallengeTTL = defaultChallengeTTL
+	}
+	if len(res.Origins) == 0 && res.RPID != "" {
+		res.Origins = []string{"https://" + res.RPID}
+	}
+	return &res
+}
+
+// Credentials returns the second factors of the user
+func (m *Manager) Credentials(ctx context.Context, userID string) (Credentials, error) {
+	return m.Store.Get(ctx, userID)
+}
+
+// StepUp adds the method and "mfa" to the amr claim
+func StepUp(claims token.Claims, method string) token.Claims {
+	amr := make([]string, 0, len(claims.AMR)+2)
+	for _, m := range claims.AMR {
+		if m != method && m != token.AMRMultiFactor {
+			amr = append(amr, m)
+		}
+	}
+	claims.AMR = append(amr, method, token.AMRMultiFactor)
+	return claims
+}
+
+// update applies fn to the credentials of the user and saves them if fn succeeds
+func (m *Manager) update(ctx context.Context, userID string, fn func(c *Credentials) error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	c, err := m.Store.Get(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("mfa: can't get credentials: %w", err)
+	}
+	if err = fn(&c); err != nil {
+		return err
+	}
+	if err = m.Store.Set(ctx, userID, c); err != nil {
+		return fmt.Errorf("mfa: can't save credentials: %w", err)
+	}
+	return nil
+}
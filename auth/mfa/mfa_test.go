@@ -0,0 +1,236 @@
+package mfa
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go/codec"
+
+	"mkfst/auth/token"
+	"mkfst/providers/cache"
+)
+
+func newManager(t *testing.T) *Manager {
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	t.Cleanup(func() { _ = c.Close() })
+	return NewManager(Opts{Store: NewCacheStore(c), Issuer: "Acme", RPID: "example.com"})
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors of SHA1, truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	for ts, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		if got, err := TOTPCode(secret, time.Unix(ts, 0), 30*time.Second); err != nil || got != want {
+			t.Errorf("%d: expected %s, got %s %v", ts, want, got, err)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	ctx := context.Background()
+	m := newManager(t)
+	user := token.User{ID: "dev_user", Name: "user", Email: "user@example.com"}
+
+	secret, uri, err := m.EnrollTOTP(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(uri)
+	if u.Scheme != "otpauth" || u.Path != "/Acme:user@example.com" || u.Query().Get("secret") != secret || u.Query().Get("issuer") != "Acme" {
+		t.Errorf("unexpected uri %s", uri)
+	}
+	code := func(at time.Time) string {
+		c, _ := TOTPCode(secret, at, 30*time.Second)
+		return c
+	}
+
+	if err = m.VerifyTOTP(ctx, user.ID, code(time.Now())); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("expected unconfirmed TOTP to be rejected, got %v", err)
+	}
+	if _, err = m.ConfirmTOTP(ctx, user.ID, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected invalid code, got %v", err)
+	}
+	recovery, err := m.ConfirmTOTP(ctx, user.ID, code(time.Now()))
+	if err != nil || len(recovery) != 10 {
+		t.Fatalf("unexpected confirmation: %v %v", recovery, err)
+	}
+	if _, _, err = m.EnrollTOTP(ctx, user); !errors.Is(err, ErrEnrolled) {
+		t.Errorf("expected enrolled TOTP to be kept, got %v", err)
+	}
+
+	// a code is accepted once, the code of the next period too for clock drift
+	if err = m.VerifyTOTP(ctx, user.ID, code(time.Now())); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected replayed code to be rejected, got %v", err)
+	}
+	if err = m.VerifyTOTP(ctx, user.ID, code(time.Now().Add(30*time.Second))); err != nil {
+		t.Errorf("expected code of the next period to be accepted, got %v", err)
+	}
+
+	if err = m.VerifyRecoveryCode(ctx, user.ID, recovery[0]); err != nil {
+		t.Error(err)
+	}
+	if err = m.VerifyRecoveryCode(ctx, user.ID, recovery[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected used recovery code to be rejected, got %v", err)
+	}
+	c, _ := m.Credentials(ctx, user.ID)
+	if len(c.RecoveryCodes) != 9 || !c.Enrolled() || len(c.Methods()) != 2 {
+		t.Errorf("unexpected credentials: %+v", c)
+	}
+
+	if err = m.DisableTOTP(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = m.Credentials(ctx, user.ID); c.Enrolled() || len(c.RecoveryCodes) != 0 {
+		t.Errorf("factors left after disabling TOTP: %+v", c)
+	}
+}
+
+// authenticator is a fake security key of example.com
+type authenticator struct {
+	t     *testing.T
+	key   *ecdsa.PrivateKey
+	id    []byte
+	count uint32
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{t: t, key: key, id: []byte("credential-1")}
+}
+
+func (a *authenticator) cbor(v interface{}) []byte {
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, &codec.CborHandle{}).Encode(v); err != nil {
+		a.t.Fatal(err)
+	}
+	return out
+}
+
+func (a *authenticator) authData(rpID string, attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpHash[:]...)
+	flags := byte(flagUserPresent)
+	if attested {
+		flags |= flagAttested
+	}
+	data = append(data, flags)
+	a.count++
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	if attested {
+		data = append(data, make([]byte, 16)...) // aaguid
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		x, y := make([]byte, 32), make([]byte, 32)
+		a.key.X.FillBytes(x)
+		a.key.Y.FillBytes(y)
+		data = append(data, a.cbor(map[int]interface{}{1: 2, 3: COSEAlgES256, -1: 1, -2: x, -3: y})...)
+	}
+	return data
+}
+
+func (a *authenticator) clientData(typ, challenge, origin string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": origin})
+	return data
+}
+
+func (a *authenticator) create(opts CreationOptions, origin string) RegistrationResponse {
+	var resp RegistrationResponse
+	resp.ID, resp.RawID, resp.Type = b64(a.id), b64(a.id), "public-key"
+	resp.Response.ClientDataJSON = b64(a.clientData("webauthn.create", opts.Challenge, origin))
+	resp.Response.AttestationObject = b64(a.cbor(map[string]interface{}{
+		"fmt": "none", "attStmt": map[string]interface{}{}, "authData": a.authData(opts.RP.ID, true),
+	}))
+	return resp
+}
+
+func (a *authenticator) get(opts RequestOptions, origin string) AssertionResponse {
+	var resp AssertionResponse
+	resp.ID, resp.RawID, resp.Type = b64(a.id), b64(a.id), "public-key"
+	clientData := a.clientData("webauthn.get", opts.Challenge, origin)
+	authData := a.authData(opts.RPID, false)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	resp.Response.ClientDataJSON = b64(clientData)
+	resp.Response.AuthenticatorData = b64(authData)
+	resp.Response.Signature = b64(sig)
+	return resp
+}
+
+func TestWebAuthn(t *testing.T) {
+	ctx := context.Background()
+	m := newManager(t)
+	user := token.User{ID: "dev_user", Name: "user"}
+	key := newAuthenticator(t)
+
+	opts, err := m.BeginRegistration(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.FinishRegistration(ctx, user.ID, "key", key.create(opts, "https://evil.com")); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("expected another origin to be rejected, got %v", err)
+	}
+	cred, err := m.FinishRegistration(ctx, user.ID, "key", key.create(opts, "https://example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Name != "key" || cred.Algorithm != COSEAlgES256 || cred.SignCount != 2 {
+		t.Errorf("unexpected credential: %+v", cred)
+	}
+	if _, err = m.FinishRegistration(ctx, user.ID, "key", key.create(opts, "https://example.com")); !errors.Is(err, ErrNoChallenge) {
+		t.Errorf("expected the challenge to be used up, got %v", err)
+	}
+
+	login, err := m.BeginLogin(ctx, user.ID)
+	if err != nil || len(login.AllowCredentials) != 1 || login.AllowCredentials[0].ID != b64(key.id) {
+		t.Fatalf("unexpected login options: %+v %v", login, err)
+	}
+	forged := key.get(login, "https://example.com")
+	forged.Response.Signature = b64([]byte("forged"))
+	if err = m.FinishLogin(ctx, user.ID, forged); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("expected a bad signature to be rejected, got %v", err)
+	}
+	if err = m.FinishLogin(ctx, user.ID, key.get(login, "https://example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a replayed assertion has a used challenge, and a cloned key a stale counter
+	login, _ = m.BeginLogin(ctx, user.ID)
+	key.count = 1
+	if err = m.FinishLogin(ctx, user.ID, key.get(login, "https://example.com")); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("expected a stale counter to be rejected, got %v", err)
+	}
+
+	if err = m.RemoveWebAuthn(ctx, user.ID, key.id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.BeginLogin(ctx, user.ID); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("expected no credentials, got %v", err)
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	claims := StepUp(token.Claims{AMR: []string{"pwd"}}, token.AMROTP)
+	claims = StepUp(claims, token.AMROTP)
+	if !claims.HasMFA() || len(claims.AMR) != 3 {
+		t.Errorf("unexpected amr: %v", claims.AMR)
+	}
+	if (token.Claims{}).HasMFA() {
+		t.Error("claims without amr have MFA")
+	}
+}
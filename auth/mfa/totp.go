@@ -0,0 +1,206 @@
+package mfa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // HMAC-SHA1 is the TOTP algorithm supported by every authenticator app
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"mkfst/auth/token"
+)
+
+const totpDigits = 6
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP is the time-based one-time password factor of a user
+type TOTP struct {
+	Secret    string    `json:"secret"`    // base32 encoded key shared with the authenticator app
+	Confirmed bool      `json:"confirmed"` // a code was verified after enrollment
+	LastStep  int64     `json:"last_step"` // time step of the last accepted code, codes are single use
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TOTPCode returns the code of secret at the given time, for periods of the given length
+func TOTPCode(secret string, t time.Time, period time.Duration) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("mfa: invalid TOTP secret: %w", err)
+	}
+	return hotp(key, t.Unix()/int64(period/time.Second)), nil
+}
+
+// hotp is the HMAC-based one-time password of RFC 4226
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// EnrollTOTP makes a TOTP secret for the user and returns it with its otpauth:// URI, shown as a
+// QR code to be scanned by an authenticator app. The factor is enabled by ConfirmTOTP.
+func (m *Manager) EnrollTOTP(ctx context.Context, user token.User) (secret, uri string, err error) {
+	key := make([]byte, 20)
+	if _, err = rand.Read(key); err != nil {
+		return "", "", fmt.Errorf("mfa: can't make TOTP secret: %w", err)
+	}
+	secret = totpEncoding.EncodeToString(key)
+
+	err = m.update(ctx, user.ID, func(c *Credentials) error {
+		if c.TOTP != nil && c.TOTP.Confirmed {
+			return ErrEnrolled
+		}
+		c.TOTP = &TOTP{Secret: secret, CreatedAt: m.now().UTC()}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	account := user.Name
+	if user.Email != "" {
+		account = user.Email
+	}
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(int64(m.Period/time.Second)))
+	label := account
+	if m.Issuer != "" {
+		q.Set("issuer", m.Issuer)
+		label = m.Issuer + ":" + account
+	}
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: q.Encode()}
+	return secret, u.String(), nil
+}
+
+// ConfirmTOTP enables the enrolled TOTP factor with a first code of the app. It returns new
+// recovery codes if the user had none, shown once to the user.
+func (m *Manager) ConfirmTOTP(ctx context.Context, userID, code string) (recovery []string, err error) {
+	err = m.update(ctx, userID, func(c *Credentials) error {
+		if c.TOTP == nil {
+			return ErrNotEnrolled
+		}
+		if c.TOTP.Confirmed {
+			return ErrEnrolled
+		}
+		if err := m.checkTOTP(c.TOTP, code); err != nil {
+			return err
+		}
+		c.TOTP.Confirmed = true
+		if len(c.RecoveryCodes) == 0 {
+			if recovery, c.RecoveryCodes, err = m.makeRecoveryCodes(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return recovery, err
+}
+
+// VerifyTOTP checks a code of the enabled TOTP factor of the user. A code is accepted once.
+func (m *Manager) VerifyTOTP(ctx context.Context, userID, code string) error {
+	return m.update(ctx, userID, func(c *Credentials) error {
+		if c.TOTP == nil || !c.TOTP.Confirmed {
+			return ErrNotEnrolled
+		}
+		return m.checkTOTP(c.TOTP, code)
+	})
+}
+
+// DisableTOTP removes the TOTP factor of the user
+func (m *Manager) DisableTOTP(ctx context.Context, userID string) error {
+	return m.update(ctx, userID, func(c *Credentials) error {
+		if c.TOTP == nil {
+			return ErrNotEnrolled
+		}
+		c.TOTP = nil
+		if !c.Enrolled() {
+			c.RecoveryCodes = nil
+		}
+		return nil
+	})
+}
+
+// checkTOTP accepts a code of the current period, or of Skew adjacent ones, newer than the last one
+func (m *Manager) checkTOTP(t *TOTP, code string) error {
+	key, err := totpEncoding.DecodeString(t.Secret)
+	if err != nil {
+		return fmt.Errorf("mfa: invalid TOTP secret: %w", err)
+	}
+	code = strings.TrimSpace(code)
+	current := m.now().Unix() / int64(m.Period/time.Second)
+	for step := current - int64(m.Skew); step <= current+int64(m.Skew); step++ {
+		if step <= t.LastStep {
+			continue // replayed code
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			t.LastStep = step
+			return nil
+		}
+	}
+	return ErrInvalidCode
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of an enrolled user
+func (m *Manager) RegenerateRecoveryCodes(ctx context.Context, userID string) (recovery []string, err error) {
+	err = m.update(ctx, userID, func(c *Credentials) error {
+		if !c.Enrolled() {
+			return ErrNotEnrolled
+		}
+		recovery, c.RecoveryCodes, err = m.makeRecoveryCodes()
+		return err
+	})
+	return recovery, err
+}
+
+// VerifyRecoveryCode checks and uses up a recovery code of the user
+func (m *Manager) VerifyRecoveryCode(ctx context.Context, userID, code string) error {
+	h := hashRecoveryCode(code)
+	return m.update(ctx, userID, func(c *Credentials) error {
+		for i, rc := range c.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(rc), []byte(h)) == 1 {
+				c.RecoveryCodes = append(c.RecoveryCodes[:i:i], c.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+		return ErrInvalidCode
+	})
+}
+
+// makeRecoveryCodes returns new codes like "1a2b3c-4d5e6f" and their hashes
+func (m *Manager) makeRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < m.RecoveryCodes; i++ {
+		b := make([]byte, 6)
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("mfa: can't make recovery code: %w", err)
+		}
+		code := hex.EncodeToString(b)
+		code = code[:6] + "-" + code[6:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a code, ignoring its case and dashes
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
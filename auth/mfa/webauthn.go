@@ -0,0 +1,464 @@
+package mfa
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ugorji/go/codec"
+
+	"mkfst/auth/token"
+)
+
+// COSE algorithms of the supported credentials
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+// flags of the authenticator data
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// WebAuthnCredential is a registered security key or passkey of a user
+type WebAuthnCredential struct {
+	ID         []byte    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	PublicKey  []byte    `json:"public_key"` // PKIX, ASN.1 DER form
+	Algorithm  int       `json:"alg"`        // COSE algorithm
+	SignCount  uint32    `json:"sign_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+// CredentialDescriptor identifies a credential in the options of a ceremony
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"` // base64url encoded
+}
+
+// CreationOptions are the options of navigator.credentials.create, in the JSON form of
+// PublicKeyCredential.parseCreationOptionsFromJSON: binary values are base64url encoded.
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"` // milliseconds
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are the options of navigator.credentials.get, in the JSON form of
+// PublicKeyCredential.parseRequestOptionsFromJSON
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"` // milliseconds
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the credential made by navigator.credentials.create, in the form of
+// PublicKeyCredential.toJSON
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the assertion made by navigator.credentials.get, in the form of
+// PublicKeyCredential.toJSON
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// BeginRegistration starts the registration of a credential of the user
+func (m *Manager) BeginRegistration(ctx context.Context, user token.User) (CreationOptions, error) {
+	var res CreationOptions
+	if m.RPID == "" {
+		return res, errors.New("mfa: WebAuthn relying party id not set")
+	}
+	handle := sha256.Sum256([]byte(user.ID))
+	res.RP.ID, res.RP.Name = m.RPID, m.Issuer
+	if res.RP.Name == "" {
+		res.RP.Name = m.RPID
+	}
+	res.User.ID = b64(handle[:])
+	res.User.Name, res.User.DisplayName = user.Name, user.Name
+	if user.Email != "" {
+		res.User.Name = user.Email
+	}
+	for _, alg := range []int{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256} {
+		res.PubKeyCredParams = append(res.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{Type: "public-key", Alg: alg})
+	}
+	res.Timeout = m.ChallengeTTL.Milliseconds()
+	res.AuthenticatorSelection.ResidentKey = "preferred"
+	res.AuthenticatorSelection.UserVerification = m.userVerification()
+	res.Attestation = "none"
+	res.ExcludeCredentials = []CredentialDescriptor{}
+
+	err := m.update(ctx, user.ID, func(c *Credentials) (err error) {
+		for _, cred := range c.WebAuthn {
+			res.ExcludeCredentials = append(res.ExcludeCredentials, CredentialDescriptor{Type: "public-key", ID: b64(cred.ID)})
+		}
+		res.Challenge, err = m.newChallenge(c, "webauthn.create")
+		return err
+	})
+	return res, err
+}
+
+// FinishRegistration verifies the credential made for the pending registration and adds it,
+// with the given name, to the credentials of the user
+func (m *Manager) FinishRegistration(ctx context.Context, userID, name string, resp RegistrationResponse) (WebAuthnCredential, error) {
+	var res WebAuthnCredential
+	err := m.update(ctx, userID, func(c *Credentials) error {
+		if err := m.checkClientData(c, resp.Response.ClientDataJSON, "webauthn.create"); err != nil {
+			return err
+		}
+		attObj, err := unb64(resp.Response.AttestationObject)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+		var att struct {
+			Fmt      string `codec:"fmt"`
+			AuthData []byte `codec:"authData"`
+		}
+		if err = codec.NewDecoderBytes(attObj, &codec.CborHandle{}).Decode(&att); err != nil {
+			return fmt.Errorf("%w: bad attestation object: %v", ErrInvalidClient, err)
+		}
+		ad, err := m.parseAuthData(att.AuthData)
+		if err != nil {
+			return err
+		}
+		if ad.flags&flagAttested == 0 || len(ad.credID) == 0 {
+			return fmt.Errorf("%w: no attested credential", ErrInvalidClient)
+		}
+		for _, cred := range c.WebAuthn {
+			if bytes.Equal(cred.ID, ad.credID) {
+				return fmt.Errorf("%w: credential already registered", ErrEnrolled)
+			}
+		}
+		pub, alg, err := parseCOSEKey(ad.credKey)
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+
+		res = WebAuthnCredential{
+			ID:        ad.credID,
+			Name:      name,
+			PublicKey: der,
+			Algorithm: alg,
+			SignCount: ad.signCount,
+			CreatedAt: m.now().UTC(),
+		}
+		c.WebAuthn = append(c.WebAuthn, res)
+		c.Challenge = nil
+		return nil
+	})
+	return res, err
+}
+
+// BeginLogin starts the verification of a registered credential of the user
+func (m *Manager) BeginLogin(ctx context.Context, userID string) (RequestOptions, error) {
+	res := RequestOptions{
+		Timeout:          m.ChallengeTTL.Milliseconds(),
+		RPID:             m.RPID,
+		UserVerification: m.userVerification(),
+	}
+	err := m.update(ctx, userID, func(c *Credentials) (err error) {
+		if len(c.WebAuthn) == 0 {
+			return ErrNotEnrolled
+		}
+		for _, cred := range c.WebAuthn {
+			res.AllowCredentials = append(res.AllowCredentials, CredentialDescriptor{Type: "public-key", ID: b64(cred.ID)})
+		}
+		res.Challenge, err = m.newChallenge(c, "webauthn.get")
+		return err
+	})
+	return res, err
+}
+
+// FinishLogin verifies the assertion of the pending login with a credential of the user
+func (m *Manager) FinishLogin(ctx context.Context, userID string, resp AssertionResponse) error {
+	return m.update(ctx, userID, func(c *Credentials) error {
+		if err := m.checkClientData(c, resp.Response.ClientDataJSON, "webauthn.get"); err != nil {
+			return err
+		}
+		id, err := unb64(resp.RawID)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+		var cred *WebAuthnCredential
+		for i := range c.WebAuthn {
+			if bytes.Equal(c.WebAuthn[i].ID, id) {
+				cred = &c.WebAuthn[i]
+			}
+		}
+		if cred == nil {
+			return fmt.Errorf("%w: unknown credential", ErrInvalidClient)
+		}
+
+		rawAuthData, err := unb64(resp.Response.AuthenticatorData)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+		ad, err := m.parseAuthData(rawAuthData)
+		if err != nil {
+			return err
+		}
+		clientData, _ := unb64(resp.Response.ClientDataJSON) // checked by checkClientData
+		sig, err := unb64(resp.Response.Signature)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+		clientHash := sha256.Sum256(clientData)
+		if err = verifySignature(cred, append(rawAuthData[:len(rawAuthData):len(rawAuthData)], clientHash[:]...), sig); err != nil {
+			return err
+		}
+
+		// a counter not increasing tells a cloned authenticator, synced passkeys keep it at 0
+		if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+			return fmt.Errorf("%w: signature counter did not increase", ErrInvalidClient)
+		}
+		cred.SignCount = ad.signCount
+		cred.LastUsedAt = m.now().UTC()
+		c.Challenge = nil
+		return nil
+	})
+}
+
+// RemoveWebAuthn removes a registered credential of the user
+func (m *Manager) RemoveWebAuthn(ctx context.Context, userID string, id []byte) error {
+	return m.update(ctx, userID, func(c *Credentials) error {
+		for i, cred := range c.WebAuthn {
+			if bytes.Equal(cred.ID, id) {
+				c.WebAuthn = append(c.WebAuthn[:i:i], c.WebAuthn[i+1:]...)
+				if !c.Enrolled() {
+					c.RecoveryCodes = nil
+				}
+				return nil
+			}
+		}
+		return ErrNotEnrolled
+	})
+}
+
+func (m *Manager) userVerification() string {
+	if m.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// newChallenge makes the challenge of a ceremony and keeps it in the credentials
+func (m *Manager) newChallenge(c *Credentials, typ string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("mfa: can't make challenge: %w", err)
+	}
+	c.Challenge = &Challenge{Value: b64(b), Type: typ, Expires: m.now().Add(m.ChallengeTTL)}
+	return c.Challenge.Value, nil
+}
+
+// checkClientData checks the client data of a ceremony of the pending challenge
+func (m *Manager) checkClientData(c *Credentials, data, typ string) error {
+	if c.Challenge == nil || c.Challenge.Type != typ || !m.now().Before(c.Challenge.Expires) {
+		return ErrNoChallenge
+	}
+	raw, err := unb64(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidClient, err)
+	}
+	var cd struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err = json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("%w: bad client data: %v", ErrInvalidClient, err)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("%w: client data of %q", ErrInvalidClient, cd.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(cd.Challenge, "=")), []byte(c.Challenge.Value)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidClient)
+	}
+	for _, o := range m.Origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: origin %q not allowed", ErrInvalidClient, cd.Origin)
+}
+
+// authData is the parsed authenticator data of a ceremony
+type authData struct {
+	flags     byte
+	signCount uint32
+	credID    []byte // attested credential, at registration
+	credKey   []byte // COSE key of the credential, followed by the extensions if any
+}
+
+// parseAuthData parses authenticator data and checks its relying party and flags
+func (m *Manager) parseAuthData(data []byte) (authData, error) {
+	var res authData
+	if len(data) < 37 {
+		return res, fmt.Errorf("%w: short authenticator data", ErrInvalidClient)
+	}
+	rpHash := sha256.Sum256([]byte(m.RPID))
+	if subtle.ConstantTimeCompare(data[:32], rpHash[:]) != 1 {
+		return res, fmt.Errorf("%w: relying party mismatch", ErrInvalidClient)
+	}
+	res.flags = data[32]
+	res.signCount = binary.BigEndian.Uint32(data[33:37])
+	if res.flags&flagUserPresent == 0 {
+		return res, fmt.Errorf("%w: user not present", ErrInvalidClient)
+	}
+	if m.RequireUserVerification && res.flags&flagUserVerified == 0 {
+		return res, fmt.Errorf("%w: user not verified", ErrInvalidClient)
+	}
+	if res.flags&flagAttested != 0 {
+		rest := data[37:]
+		if len(rest) < 18 { // aaguid and length of the credential id
+			return res, fmt.Errorf("%w: short attested credential data", ErrInvalidClient)
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < 18+n {
+			return res, fmt.Errorf("%w: short credential id", ErrInvalidClient)
+		}
+		res.credID, res.credKey = rest[18:18+n], rest[18+n:]
+	}
+	return res, nil
+}
+
+// parseCOSEKey parses the public key of a credential, of RFC 9053
+func parseCOSEKey(data []byte) (crypto.PublicKey, int, error) {
+	h := &codec.CborHandle{}
+	h.SignedInteger = true
+	var key map[int]interface{}
+	if err := codec.NewDecoderBytes(data, h).Decode(&key); err != nil {
+		return nil, 0, fmt.Errorf("%w: bad credential key: %v", ErrInvalidClient, err)
+	}
+	num := func(label int) int64 {
+		v, _ := key[label].(int64)
+		return v
+	}
+	bin := func(label int) []byte {
+		v, _ := key[label].([]byte)
+		return v
+	}
+
+	const (
+		ktyOKP, ktyEC2, ktyRSA = 1, 2, 3
+		crvP256, crvEd25519    = 1, 6
+	)
+	alg := int(num(3))
+	switch {
+	case num(1) == ktyEC2 && alg == COSEAlgES256 && num(-1) == crvP256:
+		x, y := bin(-2), bin(-3)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("%w: bad EC2 key", ErrInvalidClient)
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: bad EC2 key: %v", ErrInvalidClient, err)
+		}
+		return pub, alg, nil
+	case num(1) == ktyOKP && alg == COSEAlgEdDSA && num(-1) == crvEd25519:
+		x := bin(-2)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("%w: bad OKP key", ErrInvalidClient)
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case num(1) == ktyRSA && alg == COSEAlgRS256:
+		n, e := bin(-1), bin(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("%w: bad RSA key", ErrInvalidClient)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported key type %d with algorithm %d", ErrInvalidClient, num(1), alg)
+}
+
+// verifySignature checks the signature of msg by the credential
+func verifySignature(cred *WebAuthnCredential, msg, sig []byte) error {
+	pub, err := x509.ParsePKIXPublicKey(cred.PublicKey)
+	if err != nil {
+		return fmt.Errorf("mfa: bad stored key: %w", err)
+	}
+	digest := sha256.Sum256(msg)
+	ok := false
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = cred.Algorithm == COSEAlgES256 && ecdsa.VerifyASN1(k, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = cred.Algorithm == COSEAlgEdDSA && ed25519.Verify(k, msg, sig)
+	case *rsa.PublicKey:
+		ok = cred.Algorithm == COSEAlgRS256 && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+	if !ok {
+		return fmt.Errorf("%w: bad signature", ErrInvalidClient)
+	}
+	return nil
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// unb64 decodes base64url, with or without padding
+func unb64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/go-pkgz/rest"
 	"github.com/golang-jwt/jwt"
 
+	"mkfst/auth/lockout"
 	"mkfst/auth/logger"
 	"mkfst/auth/token"
 )
@@ -30,6 +31,7 @@ type DirectHandler struct {
 	Issuer       string
 	AvatarSaver  AvatarSaver
 	UserIDFunc   UserIDFunc
+	Lockout      *lockout.Guard // optional brute-force protection
 }
 
 // CredChecker defines interface to check credentials
@@ -75,7 +77,11 @@ func (p DirectHandler) Name() string { return p.ProviderName }
 //	  "passwd": "xyz",
 //	  "aud": "bar",
 //	}
+//
+// With Lockout set, the failed logins lock out the user and the client address, and are answered
+// after the same minimal time whether the user exists or not.
 func (p DirectHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	creds, err := p.getCredentials(w, r)
 	if err != nil {
 		rest.SendErrorJSON(w, r, p.L, http.StatusBadRequest, err, "failed to parse credentials")
@@ -87,15 +93,26 @@ func (p DirectHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 			fmt.Errorf("no credential checker"), "no credential checker")
 		return
 	}
+	if p.Lockout != nil && !checkLockout(w, r, p.L, p.Lockout, p.ProviderName, creds.User, start) {
+		return
+	}
 	ok, err := p.CredChecker.Check(creds.User, creds.Password)
 	if err != nil {
 		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to check user credentials")
 		return
 	}
 	if !ok {
+		if p.Lockout != nil {
+			failLogin(r, p.L, p.Lockout, p.ProviderName, creds.User, start)
+		}
 		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, nil, "incorrect user or password")
 		return
 	}
+	if p.Lockout != nil {
+		if err = p.Lockout.Reset(r.Context(), p.ProviderName, creds.User); err != nil {
+			p.Logf("[WARN] %v", err)
+		}
+	}
 
 	userID := p.ProviderName + "_" + token.HashID(sha1.New(), creds.User)
 	if p.UserIDFunc != nil {
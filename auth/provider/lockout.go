@@ -0,0 +1,88 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-pkgz/rest"
+
+	"mkfst/auth/lockout"
+	"mkfst/auth/logger"
+)
+
+// checkLockout rejects an attempt of a locked out user or address, or one missing a required
+// challenge, and reports if the attempt can go on. user is empty for the attempts counted per address only.
+func checkLockout(w http.ResponseWriter, r *http.Request, l logger.L, g *lockout.Guard, provider, user string, start time.Time) bool {
+	ctx, ip := r.Context(), g.ClientIP(r)
+	wait, err := g.Check(ctx, provider, user, ip)
+	if err != nil {
+		rest.SendErrorJSON(w, r, l, http.StatusInternalServerError, err, "failed to check attempts")
+		return false
+	}
+	if wait > 0 {
+		g.Audit(lockout.Event{Type: lockout.EventLockedOut, Provider: provider, User: user, IP: ip, LockedFor: wait})
+		g.Delay(ctx, start)
+		setRetryAfter(w, wait)
+		rest.SendErrorJSON(w, r, l, http.StatusTooManyRequests, errors.New("locked out"), "too many attempts")
+		return false
+	}
+
+	need, err := g.NeedsChallenge(ctx, provider, user, ip)
+	if err != nil {
+		rest.SendErrorJSON(w, r, l, http.StatusInternalServerError, err, "failed to check attempts")
+		return false
+	}
+	if !need {
+		return true
+	}
+	ok, err := g.Challenge.Verify(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, l, http.StatusInternalServerError, err, "failed to verify challenge")
+		return false
+	}
+	if !ok {
+		g.Audit(lockout.Event{Type: lockout.EventChallengeFailed, Provider: provider, User: user, IP: ip})
+		g.Delay(ctx, start)
+		rest.SendErrorJSON(w, r, l, http.StatusForbidden, errors.New("challenge failed"), "challenge required")
+		return false
+	}
+	return true
+}
+
+// failLogin records a failed login and waits for the minimal response time of the guard
+func failLogin(r *http.Request, l logger.L, g *lockout.Guard, provider, user string, start time.Time) {
+	ctx, ip := r.Context(), g.ClientIP(r)
+	lock, err := g.Fail(ctx, provider, user, ip)
+	if err != nil {
+		l.Logf("[WARN] can't record failed login of %s: %v", user, err)
+	}
+	g.Audit(lockout.Event{Type: lockout.EventLoginFailed, Provider: provider, User: user, IP: ip, LockedFor: lock})
+	g.Delay(ctx, start)
+}
+
+// setRetryAfter sets the Retry-After header to wait, rounded up to seconds
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	secs := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+}
+
+// throttleConfirmation rejects a confirmation sent to the address too soon after the previous one,
+// and reports if it can be sent
+func throttleConfirmation(w http.ResponseWriter, r *http.Request, l logger.L, g *lockout.Guard, provider, address string) bool {
+	ip := g.ClientIP(r)
+	wait, err := g.Cooldown(r.Context(), provider, address, ip)
+	if err != nil {
+		rest.SendErrorJSON(w, r, l, http.StatusInternalServerError, err, "failed to check attempts")
+		return false
+	}
+	if wait > 0 {
+		g.Audit(lockout.Event{Type: lockout.EventThrottled, Provider: provider, User: address, IP: ip, LockedFor: wait})
+		setRetryAfter(w, wait)
+		rest.SendErrorJSON(w, r, l, http.StatusTooManyRequests, fmt.Errorf("cooldown of %s", wait), "confirmation already sent")
+		return false
+	}
+	return true
+}
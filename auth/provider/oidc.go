@@ -0,0 +1,537 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha1" //nolint
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/rest"
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+
+	"mkfst/auth/logger"
+	"mkfst/auth/token"
+	"mkfst/providers/cache"
+)
+
+const oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+// OIDCConfig is a set of parameters of an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer string   // issuer url, serving the discovery document at /.well-known/openid-configuration, required
+	Scopes []string // requested scopes, default "openid", "profile" and "email". "openid" is always requested
+	Claims OIDCClaims
+
+	RedirectURL           string // callback url registered with the issuer, default URL + auth route + "?action=callback&using=" + name
+	PostLogoutRedirectURL string // url the issuer redirects to after logout, default "from" query param of the logout request
+	UserInfo              bool   // merge the claims of the userinfo endpoint into the ones of the ID token
+
+	HTTPClient      *http.Client    // client calling the issuer, default one has a 10s timeout
+	BearerTokenHook BearerTokenHook // a way to get the tokens received from the issuer
+
+	// Cache keeps the ID tokens of the logins by JWT id, the id_token_hint of their logout, out of
+	// the JWT cookie. Default in memory; share it between the replicas.
+	Cache      cache.Cache
+	IDTokenTTL time.Duration // how long the ID tokens are kept, default 31 days as the JWT cookie
+}
+
+// OIDCClaims are the paths of the claims mapped to token.User. Paths of nested claims are
+// dot separated, i.e. "realm_access.roles" for the realm roles of Keycloak.
+type OIDCClaims struct {
+	Name       string            // default "name", falls back to "preferred_username"
+	Email      string            // default "email"
+	Picture    string            // default "picture"
+	Role       string            // string claim, or the first value of a list claim
+	Attributes map[string]string // claim path to user attribute. Lists are stored as slice attributes
+}
+
+// OIDCHandler implements login with an OpenID Connect issuer, i.e. Keycloak, Okta or Dex.
+// Endpoints are discovered on the first request. Logins use PKCE and a nonce bound to the
+// handshake token, and ID tokens are verified with the keys published by the issuer.
+type OIDCHandler struct {
+	Params
+	name   string
+	cfg    OIDCConfig
+	client *http.Client
+
+	lock      sync.Mutex
+	discovery *oidcDiscovery
+	keys      *token.RemoteKeySet
+}
+
+// oidcDiscovery is the part of the discovery document used by OIDCHandler
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// NewOIDC makes an OpenID Connect provider. The name prefixes the ids of its users and
+// can't contain "_".
+func NewOIDC(name string, p Params, cfg OIDCConfig) (*OIDCHandler, error) {
+	if name == "" || strings.Contains(name, "_") {
+		return nil, fmt.Errorf("invalid provider name %q", name)
+	}
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("no issuer for provider %s", name)
+	}
+	if p.L == nil {
+		p.L = logger.NoOp
+	}
+
+	scopes := []string{"openid"}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"profile", "email"}
+	}
+	for _, s := range cfg.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	cfg.Scopes = scopes
+
+	setDefault := func(fld *string, def string) {
+		if *fld == "" {
+			*fld = def
+		}
+	}
+	setDefault(&cfg.Claims.Name, "name")
+	setDefault(&cfg.Claims.Email, "email")
+	setDefault(&cfg.Claims.Picture, "picture")
+
+	if cfg.Cache == nil {
+		cfg.Cache = cache.NewMemoryCache(cache.MemoryOpts{})
+	}
+	if cfg.IDTokenTTL == 0 {
+		cfg.IDTokenTTL = 31 * 24 * time.Hour
+	}
+
+	h := &OIDCHandler{Params: p, name: name, cfg: cfg, client: cfg.HTTPClient}
+	if h.client == nil {
+		h.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	p.Logf("[INFO] init oidc service %s, issuer=%s", name, cfg.Issuer)
+	return h, nil
+}
+
+// Name returns provider name
+func (p *OIDCHandler) Name() string { return p.name }
+
+// LoginHandler - GET /login?from=redirect-back-url&[site|aud]=siteID&session=1&noava=1
+func (p *OIDCHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	p.Logf("[DEBUG] login with %s", p.Name())
+	d, err := p.discover()
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusServiceUnavailable, err, "failed to discover oidc issuer")
+		return
+	}
+
+	// state binds the callback to this handshake, nonce binds the ID token to it
+	var state, nonce, cid string
+	for _, fld := range []*string{&state, &nonce, &cid} {
+		if *fld, err = randToken(); err != nil {
+			rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to make oidc handshake")
+			return
+		}
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	aud := r.URL.Query().Get("site") // legacy, for back compat
+	if aud == "" {
+		aud = r.URL.Query().Get("aud")
+	}
+
+	claims := token.Claims{
+		Handshake: &token.Handshake{
+			State:    state,
+			From:     r.URL.Query().Get("from"),
+			Nonce:    nonce,
+			Verifier: verifier,
+		},
+		SessionOnly: r.URL.Query().Get("session") != "" && r.URL.Query().Get("session") != "0",
+		StandardClaims: jwt.StandardClaims{
+			Id:        cid,
+			Audience:  aud,
+			ExpiresAt: time.Now().Add(30 * time.Minute).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+		},
+		NoAva: r.URL.Query().Get("noava") == "1",
+	}
+
+	if _, err := p.JwtService.Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to set token")
+		return
+	}
+
+	conf := p.oauth2Config(d, r)
+	loginURL := conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce))
+	p.Logf("[DEBUG] login url %s, claims=%+v", loginURL, claims)
+
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
+
+// AuthHandler verifies the ID token, fills user info and redirects to "from" url.
+// This is callback url redirected locally by browser
+// GET /callback
+func (p *OIDCHandler) AuthHandler(w http.ResponseWriter, r *http.Request) {
+	if e := r.URL.Query().Get("error"); e != "" {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, fmt.Errorf("%s: %s", e, r.URL.Query().Get("error_description")), "login rejected by issuer")
+		return
+	}
+
+	oauthClaims, _, err := p.JwtService.Get(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to get token")
+		return
+	}
+
+	if oauthClaims.Handshake == nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, nil, "invalid handshake token")
+		return
+	}
+
+	retrievedState := oauthClaims.Handshake.State
+	if retrievedState == "" || retrievedState != r.URL.Query().Get("state") {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, nil, "unexpected state")
+		return
+	}
+
+	d, err := p.discover()
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusServiceUnavailable, err, "failed to discover oidc issuer")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, p.client)
+	conf := p.oauth2Config(d, r)
+	tok, err := conf.Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(oauthClaims.Handshake.Verifier))
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "exchange failed")
+		return
+	}
+
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, nil, "no id token in response")
+		return
+	}
+	idClaims, err := p.verifyIDToken(rawIDToken, oauthClaims.Handshake.Nonce, d)
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "invalid id token")
+		return
+	}
+
+	client := conf.Client(ctx, tok)
+	if p.cfg.UserInfo && d.UserInfoEndpoint != "" {
+		if err = p.mergeUserInfo(client, d.UserInfoEndpoint, idClaims); err != nil {
+			rest.SendErrorJSON(w, r, p.L, http.StatusServiceUnavailable, err, "failed to get user info")
+			return
+		}
+	}
+	p.Logf("[DEBUG] got id token claims %+v", idClaims)
+
+	u := p.mapUser(idClaims)
+	if oauthClaims.NoAva {
+		u.Picture = "" // reset picture on no avatar request
+	}
+	u, err = setAvatar(p.AvatarSaver, u, client)
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to save avatar to proxy")
+		return
+	}
+
+	cid, err := randToken()
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to make claim's id")
+		return
+	}
+	claims := token.Claims{
+		User: &u,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:   p.Issuer,
+			Id:       cid,
+			Audience: oauthClaims.Audience,
+		},
+		SessionOnly: oauthClaims.SessionOnly,
+		NoAva:       oauthClaims.NoAva,
+	}
+
+	if _, err = p.JwtService.Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to set token")
+		return
+	}
+	// the logout only misses its hint without the ID token, not worth failing the login
+	if err = p.cfg.Cache.Set(r.Context(), p.idTokenKey(cid), []byte(rawIDToken), p.cfg.IDTokenTTL); err != nil {
+		p.Logf("[WARN] can't keep the id token of %s: %v", u.ID, err)
+	}
+
+	if p.cfg.BearerTokenHook != nil {
+		p.Logf("[DEBUG] pass bearer token %s, %s", p.Name(), tok.TokenType)
+		p.cfg.BearerTokenHook(p.Name(), u, *tok)
+	}
+
+	p.Logf("[DEBUG] user info %+v", u)
+
+	// redirect to back url if presented in login query params
+	if oauthClaims.Handshake.From != "" {
+		http.Redirect(w, r, oauthClaims.Handshake.From, http.StatusTemporaryRedirect)
+		return
+	}
+	rest.RenderJSON(w, &u)
+}
+
+// LogoutHandler - GET /logout?from=redirect-back-url
+// Resets the token and, if the issuer supports RP-initiated logout, redirects to its end session endpoint
+func (p *OIDCHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, _, err := p.JwtService.Get(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "logout not allowed")
+		return
+	}
+	p.JwtService.Reset(w)
+
+	d, err := p.discover()
+	if err != nil || d.EndSessionEndpoint == "" {
+		return // local logout only
+	}
+	endSession, err := url.Parse(d.EndSessionEndpoint)
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "invalid end session endpoint")
+		return
+	}
+	q := endSession.Query()
+	q.Set("client_id", p.Cid)
+	if claims.Id != "" {
+		key := p.idTokenKey(claims.Id)
+		if idToken, ok, err := p.cfg.Cache.Get(r.Context(), key); err != nil {
+			p.Logf("[WARN] can't get the id token of %s: %v", claims.Id, err)
+		} else if ok {
+			q.Set("id_token_hint", string(idToken))
+			if err = p.cfg.Cache.Delete(r.Context(), key); err != nil {
+				p.Logf("[WARN] can't delete the id token of %s: %v", claims.Id, err)
+			}
+		}
+	}
+	redirect := p.cfg.PostLogoutRedirectURL
+	if redirect == "" {
+		redirect = r.URL.Query().Get("from")
+	}
+	if redirect != "" {
+		q.Set("post_logout_redirect_uri", redirect)
+	}
+	endSession.RawQuery = q.Encode()
+	http.Redirect(w, r, endSession.String(), http.StatusFound)
+}
+
+// idTokenKey is the cache key of the ID token of the login with given JWT id
+func (p *OIDCHandler) idTokenKey(jwtID string) string {
+	return "oidc:" + p.name + ":id_token:" + jwtID
+}
+
+// discover gets the discovery document of the issuer, cached once fetched
+func (p *OIDCHandler) discover() (*oidcDiscovery, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(p.cfg.Issuer, "/")
+	resp, err := p.client.Get(issuer + oidcDiscoveryPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't get discovery document: %w", err)
+	}
+	defer func() {
+		if e := resp.Body.Close(); e != nil {
+			p.Logf("[WARN] failed to close response body, %s", e)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't get discovery document: status %d", resp.StatusCode)
+	}
+
+	d := oidcDiscovery{}
+	if err = json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("can't decode discovery document: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document of issuer %q is for %q", p.cfg.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("incomplete discovery document of issuer %q", p.cfg.Issuer)
+	}
+
+	p.keys = token.NewRemoteKeySet(d.JWKSURI)
+	p.keys.Client = p.client
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+func (p *OIDCHandler) oauth2Config(d *oidcDiscovery, r *http.Request) oauth2.Config {
+	redirectURL := p.cfg.RedirectURL
+	if redirectURL == "" {
+		redirectURL = strings.TrimSuffix(p.URL, "/") + r.URL.Path + "?action=callback&using=" + url.QueryEscape(p.name)
+	}
+	return oauth2.Config{
+		ClientID:     p.Cid,
+		ClientSecret: p.Csecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: d.AuthorizationEndpoint, TokenURL: d.TokenEndpoint},
+		RedirectURL:  redirectURL,
+		Scopes:       p.cfg.Scopes,
+	}
+}
+
+// verifyIDToken checks the signature of the ID token with the keys of the issuer,
+// and its iss, aud, azp, exp and nonce claims
+func (p *OIDCHandler) verifyIDToken(raw, nonce string, d *oidcDiscovery) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := p.keys.Key(kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %v for key %q", t.Header["alg"], kid)
+		}
+		return key.PublicKey(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't verify id token: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !claims.VerifyAudience(p.Cid, true) {
+		return nil, fmt.Errorf("id token is not for client %q", p.Cid)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.Cid {
+		return nil, fmt.Errorf("id token is authorized for %q", azp)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if n, _ := claims["nonce"].(string); nonce == "" || n != nonce {
+		return nil, fmt.Errorf("unexpected nonce")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("no subject in id token")
+	}
+	return claims, nil
+}
+
+// mergeUserInfo adds the claims of the userinfo endpoint missing from the ID token's
+func (p *OIDCHandler) mergeUserInfo(client *http.Client, endpoint string, claims jwt.MapClaims) error {
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := resp.Body.Close(); e != nil {
+			p.Logf("[WARN] failed to close response body, %s", e)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("userinfo status %d", resp.StatusCode)
+	}
+
+	info := map[string]interface{}{}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return fmt.Errorf("can't decode user info: %w", err)
+	}
+	if info["sub"] != claims["sub"] {
+		return fmt.Errorf("user info is for another subject")
+	}
+	for k, v := range info {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return nil
+}
+
+// mapUser makes user from the claims, with the paths of OIDCClaims
+func (p *OIDCHandler) mapUser(claims jwt.MapClaims) token.User {
+	str := func(path string) string {
+		switch v := claimValue(claims, path).(type) {
+		case string:
+			return v
+		case []interface{}:
+			if len(v) > 0 {
+				return fmt.Sprintf("%v", v[0])
+			}
+		case nil:
+		default:
+			return fmt.Sprintf("%v", v)
+		}
+		return ""
+	}
+
+	sub, _ := claims["sub"].(string)
+	u := token.User{
+		// encode subject with provider name to avoid collision if same id returned by other provider
+		ID:      p.name + "_" + token.HashID(sha1.New(), sub),
+		Name:    str(p.cfg.Claims.Name),
+		Email:   str(p.cfg.Claims.Email),
+		Picture: str(p.cfg.Claims.Picture),
+	}
+	if verified, _ := claims["email_verified"].(bool); verified && u.Email != "" {
+		u.SetEmailVerified(true)
+	}
+	if u.Name == "" {
+		u.Name = str("preferred_username")
+	}
+	if u.Name == "" {
+		u.Name = "noname_" + token.HashID(sha1.New(), sub)[:4]
+	}
+	if p.cfg.Claims.Role != "" {
+		u.SetRole(str(p.cfg.Claims.Role))
+	}
+
+	for path, attr := range p.cfg.Claims.Attributes {
+		switch v := claimValue(claims, path).(type) {
+		case nil:
+		case string:
+			u.SetStrAttr(attr, v)
+		case bool:
+			u.SetBoolAttr(attr, v)
+		case []interface{}:
+			list := make([]string, 0, len(v))
+			for _, e := range v {
+				list = append(list, fmt.Sprintf("%v", e))
+			}
+			u.SetSliceAttr(attr, list)
+		default:
+			if u.Attributes == nil {
+				u.Attributes = map[string]interface{}{}
+			}
+			u.Attributes[attr] = v
+		}
+	}
+	return u
+}
+
+// claimValue returns the claim at the dot separated path, nil if not found
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	var v interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if v, ok = m[key]; !ok {
+			return nil
+		}
+	}
+	return v
+}
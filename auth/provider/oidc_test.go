@@ -0,0 +1,192 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+
+	"mkfst/auth/token"
+)
+
+// fakeIssuer is an OpenID Connect issuer answering the requests of one login at a time
+type fakeIssuer struct {
+	*httptest.Server
+	t         *testing.T
+	keys      *token.KeyRing
+	challenge string
+	nonce     string
+	badNonce  bool
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	keys, err := token.NewKeyRing(token.RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{t: t, keys: keys}
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                f.URL,
+			AuthorizationEndpoint: f.URL + "/authorize",
+			TokenEndpoint:         f.URL + "/token",
+			UserInfoEndpoint:      f.URL + "/userinfo",
+			JWKSURI:               f.URL + "/jwks",
+			EndSessionEndpoint:    f.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwks, _ := token.NewJWKS(f.keys)
+		_ = json.NewEncoder(w).Encode(jwks)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if oauth2.S256ChallengeFromVerifier(r.FormValue("code_verifier")) != f.challenge || r.FormValue("code") != "code1" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		nonce := f.nonce
+		if f.badNonce {
+			nonce = "other"
+		}
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": f.URL, "aud": "app", "sub": "user1", "nonce": nonce,
+			"exp": time.Now().Add(time.Minute).Unix(), "name": "User One",
+			"realm_access": map[string]interface{}{"roles": []string{"admin", "dev"}},
+		})
+		key, _ := f.keys.SigningKey()
+		idToken.Header["kid"] = key.ID
+		signed, err := idToken.SignedString(key.Private)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access1", "token_type": "Bearer", "id_token": signed,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access1" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"sub": "user1", "email": "one@example.com", "groups": []string{"ops"}})
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// login follows the login redirect and returns the callback request of the issuer
+func (f *fakeIssuer) login(h *OIDCHandler) *http.Request {
+	w := httptest.NewRecorder()
+	h.LoginHandler(w, httptest.NewRequest("GET", "/auth?action=login&using=keycloak&from=/home", nil))
+	if w.Code != http.StatusFound {
+		f.t.Fatalf("unexpected login response: %d %s", w.Code, w.Body)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(loc.String(), f.URL+"/authorize") {
+		f.t.Fatalf("unexpected login redirect: %v", loc)
+	}
+	q := loc.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("redirect_uri") != "http://app.example.com/auth?action=callback&using=keycloak" ||
+		!strings.Contains(q.Get("scope"), "openid") {
+		f.t.Errorf("unexpected authorization request: %v", q)
+	}
+	f.challenge, f.nonce = q.Get("code_challenge"), q.Get("nonce")
+
+	req := httptest.NewRequest("GET", "/auth?action=callback&using=keycloak&code=code1&state="+q.Get("state"), nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newFakeIssuer(t)
+	defer idp.Close()
+
+	jwtService := token.NewService(token.Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		DisableXSRF:  true,
+	})
+	h, err := NewOIDC("keycloak", Params{URL: "http://app.example.com", JwtService: jwtService, Cid: "app", Csecret: "csecret"}, OIDCConfig{
+		Issuer:   idp.URL,
+		UserInfo: true,
+		Claims:   OIDCClaims{Role: "realm_access.roles", Attributes: map[string]string{"groups": "groups"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.AuthHandler(w, idp.login(h))
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/home" {
+		t.Fatalf("unexpected callback response: %d %s", w.Code, w.Body)
+	}
+	req := httptest.NewRequest("GET", "/auth?action=user", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	claims, _, err := jwtService.Get(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := claims.User
+	if !strings.HasPrefix(u.ID, "keycloak_") || u.Name != "User One" || u.Email != "one@example.com" || u.Role != "admin" ||
+		fmt.Sprint(u.Attributes["groups"]) != "[ops]" {
+		t.Errorf("unexpected user: %+v", u)
+	}
+
+	// the ID token is kept out of the cookie and is the hint of the logout
+	for _, c := range w.Result().Cookies() {
+		if len(c.Value) > 1024 {
+			t.Errorf("unexpected size %d of cookie %s", len(c.Value), c.Name)
+		}
+	}
+	logout := func() *url.URL {
+		w := httptest.NewRecorder()
+		h.LogoutHandler(w, req)
+		loc, _ := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || loc.Path != "/logout" {
+			t.Fatalf("unexpected logout response: %d %v", w.Code, loc)
+		}
+		return loc
+	}
+	hint := logout().Query().Get("id_token_hint")
+	idClaims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(hint, idClaims); err != nil || idClaims["sub"] != "user1" {
+		t.Errorf("expected the ID token as hint of the logout, got %q, %v", hint, err)
+	}
+	if hint = logout().Query().Get("id_token_hint"); hint != "" {
+		t.Errorf("expected the ID token dropped by the logout, got %q", hint)
+	}
+
+	// the ID token must carry the nonce of the handshake
+	idp.badNonce = true
+	w = httptest.NewRecorder()
+	h.AuthHandler(w, idp.login(h))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for another nonce, got %d %s", w.Code, w.Body)
+	}
+	idp.badNonce = false
+
+	// and the callback the state of the handshake
+	req = idp.login(h)
+	req.URL.RawQuery = strings.Replace(req.URL.RawQuery, "state=", "state=x", 1)
+	w = httptest.NewRecorder()
+	h.AuthHandler(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for another state, got %d %s", w.Code, w.Body)
+	}
+
+	if _, err := NewOIDC("key_cloak", Params{}, OIDCConfig{Issuer: idp.URL}); err == nil {
+		t.Error("expected an error for a name with an underscore")
+	}
+}
@@ -0,0 +1,729 @@
+package provider
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/sha1" //nolint
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/rest"
+	"github.com/golang-jwt/jwt"
+
+	"mkfst/auth/logger"
+	"mkfst/auth/token"
+)
+
+// SAML bindings of the AuthnRequests
+const (
+	SAMLBindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	SAMLBindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+)
+
+const (
+	samlStatusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer             = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlNameIDEmail        = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	samlClockSkew          = 3 * time.Minute
+	samlHandshakeCookie    = "SAML-HANDSHAKE"
+	samlHandshakeDuration  = 30 * time.Minute
+	defaultSAMLMetadataTTL = 24 * time.Hour
+)
+
+// default attribute names of the user fields, the plain, ADFS claim and OID forms
+var (
+	samlNameAttrs = []string{"displayName", "http://schemas.microsoft.com/identity/claims/displayname",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name", "urn:oid:2.16.840.1.113730.3.1.241", "cn"}
+	samlEmailAttrs = []string{"email", "mail", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+		"urn:oid:0.9.2342.19200300.100.1.3"}
+)
+
+// SAMLConfig is a set of parameters of a SAML 2.0 service provider
+type SAMLConfig struct {
+	IdPMetadata string   // path or http(s) url of the metadata of the IdP, required unless IdP is set
+	IdP         *SAMLIdP // metadata of the IdP, loaded with ParseSAMLMetadata
+
+	EntityID string // entity id of the SP, default the url of its metadata, URL + AuthPath + "?action=metadata&using=" + name
+	ACSURL   string // assertion consumer service, default URL + AuthPath + "?action=callback&using=" + name
+	AuthPath string // path of the auth route, default "/auth"
+
+	Key         crypto.Signer     // optional RSA or ECDSA key signing the AuthnRequests
+	Certificate *x509.Certificate // certificate of Key, published in the SP metadata
+
+	Binding      string // binding of the AuthnRequests, default HTTP-Redirect if the IdP supports it
+	NameIDFormat string // requested NameID format, default any
+	Attributes   SAMLAttributes
+	TrustEmail   bool // the IdP verifies the emails of its users, they are marked verified
+
+	MetadataTTL time.Duration // reload period of the IdP metadata of an url, default 24h
+	HTTPClient  *http.Client  // client loading the IdP metadata, default one has a 10s timeout
+}
+
+// SAMLAttributes are the names of the assertion attributes mapped to token.User. Names are
+// matched against both the Name and the FriendlyName of the attributes.
+type SAMLAttributes struct {
+	Name       string            // default displayName or its ADFS and OID forms, falls back to the NameID
+	Email      string            // default email or mail or their ADFS and OID forms, falls back to an email NameID
+	Role       string            // first value of the attribute
+	Attributes map[string]string // attribute name to user attribute. Multiple values are stored as slice attributes
+}
+
+// SAMLIdP is the metadata of a SAML identity provider
+type SAMLIdP struct {
+	EntityID                string
+	SSO                     map[string]string // binding to location of the single sign-on service
+	Certificates            []*x509.Certificate
+	WantAuthnRequestsSigned bool
+}
+
+// SAMLHandler implements login with a SAML 2.0 identity provider, i.e. ADFS or Okta, as a
+// service provider. AuthnRequests are sent with the HTTP-Redirect or HTTP-POST binding and
+// responses accepted with the HTTP-POST binding. Responses or assertions must be signed by the
+// IdP, encrypted assertions are not supported.
+type SAMLHandler struct {
+	Params
+	name   string
+	cfg    SAMLConfig
+	client *http.Client
+	tokens samlTokenService
+
+	lock     sync.Mutex
+	idp      *SAMLIdP
+	loadedAt time.Time
+}
+
+// samlTokenService makes the handshake tokens kept in the handshake cookie of the SAML logins
+type samlTokenService interface {
+	TokenService
+	Token(claims token.Claims) (string, error)
+}
+
+// NewSAML makes a SAML service provider. The name prefixes the ids of its users and can't
+// contain "_".
+func NewSAML(name string, p Params, cfg SAMLConfig) (*SAMLHandler, error) {
+	if name == "" || strings.Contains(name, "_") {
+		return nil, fmt.Errorf("invalid provider name %q", name)
+	}
+	if cfg.IdP == nil && cfg.IdPMetadata == "" {
+		return nil, fmt.Errorf("no IdP metadata for provider %s", name)
+	}
+	tokens, ok := p.JwtService.(samlTokenService)
+	if !ok {
+		return nil, fmt.Errorf("token service of provider %s can't make handshake tokens", name)
+	}
+	if cfg.Key != nil {
+		if _, err := signatureMethod(cfg.Key); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Binding != "" && cfg.Binding != SAMLBindingRedirect && cfg.Binding != SAMLBindingPOST {
+		return nil, fmt.Errorf("unsupported binding %q", cfg.Binding)
+	}
+	if p.L == nil {
+		p.L = logger.NoOp
+	}
+
+	setDefault := func(fld *string, def string) {
+		if *fld == "" {
+			*fld = def
+		}
+	}
+	setDefault(&cfg.AuthPath, "/auth")
+	base := strings.TrimSuffix(p.URL, "/") + cfg.AuthPath + "?using=" + url.QueryEscape(name)
+	setDefault(&cfg.EntityID, base+"&action=metadata")
+	setDefault(&cfg.ACSURL, base+"&action=callback")
+	if cfg.MetadataTTL == 0 {
+		cfg.MetadataTTL = defaultSAMLMetadataTTL
+	}
+
+	h := &SAMLHandler{Params: p, name: name, cfg: cfg, client: cfg.HTTPClient, tokens: tokens, idp: cfg.IdP}
+	if h.client == nil {
+		h.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	p.Logf("[INFO] init saml service %s, entity id=%s", name, cfg.EntityID)
+	return h, nil
+}
+
+// Name returns provider name
+func (p *SAMLHandler) Name() string { return p.name }
+
+// LoginHandler sends an AuthnRequest to the IdP
+// GET /login?from=redirect-back-url&[site|aud]=siteID&session=1&noava=1
+func (p *SAMLHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	p.Logf("[DEBUG] login with %s", p.Name())
+	idp, err := p.loadIdP(r.Context())
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusServiceUnavailable, err, "failed to load saml idp metadata")
+		return
+	}
+	binding := p.cfg.Binding
+	if binding == "" {
+		binding = SAMLBindingRedirect
+		if idp.SSO[binding] == "" {
+			binding = SAMLBindingPOST
+		}
+	}
+	location := idp.SSO[binding]
+	if location == "" {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, fmt.Errorf("no %s service", binding), "binding not supported by idp")
+		return
+	}
+	if idp.WantAuthnRequestsSigned && p.cfg.Key == nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, errors.New("no key"), "idp requires signed requests")
+		return
+	}
+
+	requestID, err := randToken()
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to make saml request id")
+		return
+	}
+	requestID = "_" + requestID // xml ids can't start with a digit
+	if err = p.setHandshake(w, r, requestID); err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to set handshake")
+		return
+	}
+
+	authnRequest := p.authnRequest(requestID, location)
+	if binding == SAMLBindingRedirect {
+		loginURL, err := p.redirectURL(location, authnRequest)
+		if err != nil {
+			rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to make saml request")
+			return
+		}
+		p.Logf("[DEBUG] login url %s", loginURL)
+		http.Redirect(w, r, loginURL, http.StatusFound)
+		return
+	}
+
+	if p.cfg.Key != nil {
+		el, err := parseXML([]byte(authnRequest))
+		if err == nil {
+			var signed []byte
+			signed, err = signEnveloped(el, p.cfg.Key, p.cfg.Certificate)
+			authnRequest = string(signed)
+		}
+		if err != nil {
+			rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to sign saml request")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err = samlPostForm.Execute(w, struct{ Location, Request string }{
+		Location: location,
+		Request:  base64.StdEncoding.EncodeToString([]byte(authnRequest)),
+	})
+	if err != nil {
+		p.Logf("[WARN] failed to render saml request form, %s", err)
+	}
+}
+
+var samlPostForm = template.Must(template.New("saml").Parse(`<!DOCTYPE html>
+<html><body onload="document.forms[0].submit()">
+<form method="post" action="{{.Location}}"><input type="hidden" name="SAMLRequest" value="{{.Request}}">
+<noscript><button type="submit">Continue</button></noscript></form>
+</body></html>`))
+
+// AuthHandler is the assertion consumer service, verifying the response of the IdP posted by
+// the browser, filling user info and redirecting to "from" url
+// POST /callback
+func (p *SAMLHandler) AuthHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(samlHandshakeCookie)
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "no saml handshake")
+		return
+	}
+	oauthClaims, err := p.tokens.Parse(cookie.Value)
+	if err != nil || oauthClaims.Handshake == nil || oauthClaims.Handshake.State == "" {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "invalid handshake token")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: samlHandshakeCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxHTTPBodySize)
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(r.PostFormValue("SAMLResponse")), ""))
+	if err != nil || len(data) == 0 {
+		rest.SendErrorJSON(w, r, p.L, http.StatusBadRequest, err, "no saml response")
+		return
+	}
+	idp, err := p.loadIdP(r.Context())
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusServiceUnavailable, err, "failed to load saml idp metadata")
+		return
+	}
+	assertion, err := p.verifyResponse(idp, data, oauthClaims.Handshake.State, time.Now())
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "invalid saml response")
+		return
+	}
+
+	u := p.mapUser(assertion)
+	if oauthClaims.NoAva {
+		u.Picture = "" // reset picture on no avatar request
+	}
+	u, err = setAvatar(p.AvatarSaver, u, p.client)
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to save avatar to proxy")
+		return
+	}
+
+	cid, err := randToken()
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to make claim's id")
+		return
+	}
+	claims := token.Claims{
+		User: &u,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:   p.Issuer,
+			Id:       cid,
+			Audience: oauthClaims.Audience,
+		},
+		SessionOnly: oauthClaims.SessionOnly,
+		NoAva:       oauthClaims.NoAva,
+	}
+	if _, err = p.JwtService.Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to set token")
+		return
+	}
+	p.Logf("[DEBUG] user info %+v", u)
+
+	// redirect to back url if presented in login query params
+	if oauthClaims.Handshake.From != "" {
+		http.Redirect(w, r, oauthClaims.Handshake.From, http.StatusSeeOther)
+		return
+	}
+	rest.RenderJSON(w, &u)
+}
+
+// LogoutHandler - GET /logout. Logs out of the service only, the session of the IdP is kept.
+func (p *SAMLHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := p.JwtService.Get(r); err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "logout not allowed")
+		return
+	}
+	p.JwtService.Reset(w)
+}
+
+// MetadataHandler serves the SP metadata, registered with the IdP
+// GET /metadata
+func (p *SAMLHandler) MetadataHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	if _, err := w.Write(p.Metadata()); err != nil {
+		p.Logf("[WARN] failed to write saml metadata, %s", err)
+	}
+}
+
+// Metadata returns the SP metadata
+func (p *SAMLHandler) Metadata() []byte {
+	var b bytes.Buffer
+	signed := p.cfg.Key != nil
+	fmt.Fprintf(&b, `<md:EntityDescriptor xmlns:md="%s" entityID="%s">`, nsMetadata, escapeCanonicalAttr(p.cfg.EntityID))
+	fmt.Fprintf(&b, `<md:SPSSODescriptor AuthnRequestsSigned="%t" WantAssertionsSigned="true" protocolSupportEnumeration="%s">`,
+		signed, nsSAMLP)
+	if signed && p.cfg.Certificate != nil {
+		fmt.Fprintf(&b, `<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="%s"><ds:X509Data><ds:X509Certificate>%s`+
+			`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>`, nsDSig, base64.StdEncoding.EncodeToString(p.cfg.Certificate.Raw))
+	}
+	if p.cfg.NameIDFormat != "" {
+		fmt.Fprintf(&b, `<md:NameIDFormat>%s</md:NameIDFormat>`, escapeCanonicalText(p.cfg.NameIDFormat))
+	}
+	fmt.Fprintf(&b, `<md:AssertionConsumerService Binding="%s" Location="%s" index="0" isDefault="true"></md:AssertionConsumerService>`,
+		SAMLBindingPOST, escapeCanonicalAttr(p.cfg.ACSURL))
+	b.WriteString(`</md:SPSSODescriptor></md:EntityDescriptor>`)
+	return b.Bytes()
+}
+
+// setHandshake keeps the handshake of the login in a cookie sent back by the cross-site post of the
+// IdP, which the SameSite policy of the JWT cookie could drop
+func (p *SAMLHandler) setHandshake(w http.ResponseWriter, r *http.Request, requestID string) error {
+	aud := r.URL.Query().Get("site") // legacy, for back compat
+	if aud == "" {
+		aud = r.URL.Query().Get("aud")
+	}
+	cid, err := randToken()
+	if err != nil {
+		return err
+	}
+	claims := token.Claims{
+		Handshake: &token.Handshake{
+			State: requestID,
+			From:  r.URL.Query().Get("from"),
+		},
+		SessionOnly: r.URL.Query().Get("session") != "" && r.URL.Query().Get("session") != "0",
+		StandardClaims: jwt.StandardClaims{
+			Id:        cid,
+			Audience:  aud,
+			ExpiresAt: time.Now().Add(samlHandshakeDuration).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+		},
+		NoAva: r.URL.Query().Get("noava") == "1",
+	}
+	tkn, err := p.tokens.Token(claims)
+	if err != nil {
+		return err
+	}
+	secure := strings.HasPrefix(p.cfg.ACSURL, "https://")
+	sameSite := http.SameSiteLaxMode
+	if secure {
+		sameSite = http.SameSiteNoneMode // browsers accept SameSite=None on secure cookies only
+	}
+	http.SetCookie(w, &http.Cookie{Name: samlHandshakeCookie, Value: tkn, Path: "/", HttpOnly: true,
+		MaxAge: int(samlHandshakeDuration.Seconds()), Secure: secure, SameSite: sameSite})
+	return nil
+}
+
+// authnRequest makes the AuthnRequest of a login
+func (p *SAMLHandler) authnRequest(id, destination string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s"`+
+		` Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`,
+		nsSAMLP, nsSAML, id, time.Now().UTC().Format(time.RFC3339), escapeCanonicalAttr(destination),
+		escapeCanonicalAttr(p.cfg.ACSURL), SAMLBindingPOST)
+	fmt.Fprintf(&b, `<saml:Issuer>%s</saml:Issuer>`, escapeCanonicalText(p.cfg.EntityID))
+	if p.cfg.NameIDFormat != "" {
+		fmt.Fprintf(&b, `<samlp:NameIDPolicy Format="%s" AllowCreate="true"></samlp:NameIDPolicy>`, escapeCanonicalAttr(p.cfg.NameIDFormat))
+	} else {
+		b.WriteString(`<samlp:NameIDPolicy AllowCreate="true"></samlp:NameIDPolicy>`)
+	}
+	b.WriteString(`</samlp:AuthnRequest>`)
+	return b.String()
+}
+
+// redirectURL makes the url of the HTTP-Redirect binding, with the deflated request and its
+// signature in the query
+func (p *SAMLHandler) redirectURL(location, authnRequest string) (string, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err = fw.Write([]byte(authnRequest)); err != nil {
+		return "", err
+	}
+	if err = fw.Close(); err != nil {
+		return "", err
+	}
+
+	// the signature is over the query parameters as sent, in this order
+	query := "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if p.cfg.Key != nil {
+		alg, err := signatureMethod(p.cfg.Key)
+		if err != nil {
+			return "", err
+		}
+		query += "&SigAlg=" + url.QueryEscape(alg)
+		sig, err := signXML(p.cfg.Key, []byte(query))
+		if err != nil {
+			return "", err
+		}
+		query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(sig))
+	}
+	sep := "?"
+	if strings.Contains(location, "?") {
+		sep = "&"
+	}
+	return location + sep + query, nil
+}
+
+// verifyResponse checks the response to the request and returns its assertion
+func (p *SAMLHandler) verifyResponse(idp *SAMLIdP, data []byte, requestID string, now time.Time) (*xmlElement, error) {
+	resp, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.is(nsSAMLP, "Response") || resp.attr("Version") != "2.0" {
+		return nil, errors.New("not a saml 2.0 response")
+	}
+	if d := resp.attr("Destination"); d != "" && d != p.cfg.ACSURL {
+		return nil, fmt.Errorf("response sent to %q", d)
+	}
+	if resp.attr("InResponseTo") != requestID {
+		return nil, errors.New("response to another request")
+	}
+	if iss := resp.child(nsSAML, "Issuer"); iss != nil && strings.TrimSpace(iss.text()) != idp.EntityID {
+		return nil, fmt.Errorf("response issued by %q", iss.text())
+	}
+	status := resp.child(nsSAMLP, "Status").child(nsSAMLP, "StatusCode")
+	if code := status.attr("Value"); code != samlStatusSuccess {
+		if sub := status.child(nsSAMLP, "StatusCode").attr("Value"); sub != "" {
+			code += " " + sub
+		}
+		return nil, fmt.Errorf("login failed: %s", code)
+	}
+
+	signed := false
+	if resp.child(nsDSig, "Signature") != nil {
+		if err = verifySignature(resp, idp.Certificates); err != nil {
+			return nil, fmt.Errorf("response signature: %w", err)
+		}
+		signed = true
+	}
+	if len(resp.elements(nsSAML, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := resp.elements(nsSAML, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("expected one assertion, got %d", len(assertions))
+	}
+	a := assertions[0]
+	if a.child(nsDSig, "Signature") != nil {
+		if err = verifySignature(a, idp.Certificates); err != nil {
+			return nil, fmt.Errorf("assertion signature: %w", err)
+		}
+		signed = true
+	}
+	if !signed {
+		return nil, errors.New("neither response nor assertion signed")
+	}
+	if iss := strings.TrimSpace(a.child(nsSAML, "Issuer").text()); iss != idp.EntityID {
+		return nil, fmt.Errorf("assertion issued by %q", iss)
+	}
+
+	before := func(s string) bool { // s is a time before now, with the clock skew
+		t, err := time.Parse(time.RFC3339, s)
+		return err == nil && t.Before(now.Add(samlClockSkew))
+	}
+	after := func(s string) bool { // s is a time after now, with the clock skew
+		t, err := time.Parse(time.RFC3339, s)
+		return err == nil && t.After(now.Add(-samlClockSkew))
+	}
+
+	subject := a.child(nsSAML, "Subject")
+	if strings.TrimSpace(subject.child(nsSAML, "NameID").text()) == "" {
+		return nil, errors.New("no subject")
+	}
+	confirmed := false
+	for _, sc := range subject.elements(nsSAML, "SubjectConfirmation") {
+		d := sc.child(nsSAML, "SubjectConfirmationData")
+		// the login is always started by the service, so the confirmation must be for its request
+		if sc.attr("Method") == samlBearer && d.attr("Recipient") == p.cfg.ACSURL && after(d.attr("NotOnOrAfter")) &&
+			d.attr("InResponseTo") == requestID {
+			confirmed = true
+			break
+		}
+	}
+	if !confirmed {
+		return nil, errors.New("subject not confirmed for this service")
+	}
+
+	cond := a.child(nsSAML, "Conditions")
+	if cond == nil {
+		return nil, errors.New("no conditions")
+	}
+	if nb := cond.attr("NotBefore"); nb != "" && !before(nb) {
+		return nil, errors.New("assertion not valid yet")
+	}
+	if na := cond.attr("NotOnOrAfter"); na != "" && !after(na) {
+		return nil, errors.New("assertion expired")
+	}
+	for _, ar := range cond.elements(nsSAML, "AudienceRestriction") {
+		found := false
+		for _, aud := range ar.elements(nsSAML, "Audience") {
+			found = found || strings.TrimSpace(aud.text()) == p.cfg.EntityID
+		}
+		if !found {
+			return nil, errors.New("assertion for another audience")
+		}
+	}
+	if a.child(nsSAML, "AuthnStatement") == nil {
+		return nil, errors.New("no authn statement")
+	}
+	return a, nil
+}
+
+// mapUser makes user from the subject and attributes of the assertion
+func (p *SAMLHandler) mapUser(a *xmlElement) token.User {
+	nameID := a.child(nsSAML, "Subject").child(nsSAML, "NameID")
+	subject := strings.TrimSpace(nameID.text())
+
+	attrs := map[string][]string{}
+	for _, st := range a.elements(nsSAML, "AttributeStatement") {
+		for _, at := range st.elements(nsSAML, "Attribute") {
+			var values []string
+			for _, v := range at.elements(nsSAML, "AttributeValue") {
+				values = append(values, strings.TrimSpace(v.text()))
+			}
+			for _, name := range []string{at.attr("Name"), at.attr("FriendlyName")} {
+				if name != "" {
+					attrs[name] = append(attrs[name], values...)
+				}
+			}
+		}
+	}
+	first := func(names ...string) string {
+		for _, n := range names {
+			if len(attrs[n]) > 0 && attrs[n][0] != "" {
+				return attrs[n][0]
+			}
+		}
+		return ""
+	}
+	names := func(configured string, defaults []string) []string {
+		if configured != "" {
+			return []string{configured}
+		}
+		return defaults
+	}
+
+	u := token.User{
+		// encode subject with provider name to avoid collision if same id returned by other provider
+		ID:    p.name + "_" + token.HashID(sha1.New(), subject),
+		Name:  first(names(p.cfg.Attributes.Name, samlNameAttrs)...),
+		Email: first(names(p.cfg.Attributes.Email, samlEmailAttrs)...),
+	}
+	if u.Email == "" && nameID.attr("Format") == samlNameIDEmail {
+		u.Email = subject
+	}
+	if u.Name == "" {
+		u.Name = subject
+	}
+	if p.cfg.TrustEmail && u.Email != "" {
+		u.SetEmailVerified(true)
+	}
+	if p.cfg.Attributes.Role != "" {
+		u.SetRole(first(p.cfg.Attributes.Role))
+	}
+	for name, attr := range p.cfg.Attributes.Attributes {
+		switch values := attrs[name]; len(values) {
+		case 0:
+		case 1:
+			u.SetStrAttr(attr, values[0])
+		default:
+			u.SetSliceAttr(attr, values)
+		}
+	}
+	return u
+}
+
+// loadIdP returns the IdP metadata, loaded on the first request and reloaded after MetadataTTL.
+// A failed reload keeps the previous metadata.
+func (p *SAMLHandler) loadIdP(ctx context.Context) (*SAMLIdP, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.idp != nil && (p.cfg.IdPMetadata == "" || time.Since(p.loadedAt) < p.cfg.MetadataTTL) {
+		return p.idp, nil
+	}
+	idp, err := LoadSAMLMetadata(ctx, p.cfg.IdPMetadata, p.client)
+	if err != nil {
+		if p.idp != nil {
+			p.Logf("[WARN] can't reload saml metadata of %s, %s", p.name, err)
+			p.loadedAt = time.Now()
+			return p.idp, nil
+		}
+		return nil, err
+	}
+	p.idp, p.loadedAt = idp, time.Now()
+	return idp, nil
+}
+
+// LoadSAMLMetadata loads the metadata of a SAML IdP from a file or an http(s) url
+func LoadSAMLMetadata(ctx context.Context, source string, client *http.Client) (*SAMLIdP, error) {
+	if !strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "http://") {
+		data, err := os.ReadFile(source) // #nosec G304 // path of the configuration
+		if err != nil {
+			return nil, fmt.Errorf("can't read saml metadata: %w", err)
+		}
+		return ParseSAMLMetadata(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("can't make saml metadata request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't get saml metadata: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't get saml metadata: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSAMLDocument))
+	if err != nil {
+		return nil, fmt.Errorf("can't read saml metadata: %w", err)
+	}
+	return ParseSAMLMetadata(data)
+}
+
+// samlEntityDescriptor is the part of the metadata of an entity used by ParseSAMLMetadata
+type samlEntityDescriptor struct {
+	EntityID string `xml:"entityID,attr"`
+	IDP      []struct {
+		WantAuthnRequestsSigned bool `xml:"WantAuthnRequestsSigned,attr"`
+		Keys                    []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SSO []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+// ParseSAMLMetadata parses the metadata of a SAML IdP, an EntityDescriptor or the first IdP of
+// an EntitiesDescriptor. The signing certificates are trusted as is, load the metadata from a
+// trusted source.
+func ParseSAMLMetadata(data []byte) (*SAMLIdP, error) {
+	var md struct {
+		XMLName xml.Name
+		samlEntityDescriptor
+		Entities []samlEntityDescriptor `xml:"EntityDescriptor"`
+	}
+	if err := xml.Unmarshal(data, &md); err != nil {
+		return nil, fmt.Errorf("can't parse saml metadata: %w", err)
+	}
+	entities := []samlEntityDescriptor{md.samlEntityDescriptor}
+	if md.XMLName.Local == "EntitiesDescriptor" {
+		entities = md.Entities
+	}
+
+	for _, e := range entities {
+		if len(e.IDP) == 0 {
+			continue
+		}
+		d := e.IDP[0]
+		idp := &SAMLIdP{EntityID: e.EntityID, SSO: map[string]string{}, WantAuthnRequestsSigned: d.WantAuthnRequestsSigned}
+		for _, s := range d.SSO {
+			if idp.SSO[s.Binding] == "" {
+				idp.SSO[s.Binding] = s.Location
+			}
+		}
+		for _, k := range d.Keys {
+			if k.Use != "" && k.Use != "signing" {
+				continue
+			}
+			for _, c := range k.Certificates {
+				der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(c), ""))
+				if err != nil {
+					return nil, fmt.Errorf("can't decode idp certificate: %w", err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("can't parse idp certificate: %w", err)
+				}
+				idp.Certificates = append(idp.Certificates, cert)
+			}
+		}
+		if idp.EntityID == "" || len(idp.Certificates) == 0 {
+			return nil, errors.New("saml metadata without entity id or signing certificate")
+		}
+		return idp, nil
+	}
+	return nil, errors.New("no idp in saml metadata")
+}
@@ -0,0 +1,340 @@
+package provider
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"mkfst/auth/token"
+)
+
+func selfSigned(t *testing.T, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "saml"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// fakeSAMLIdP is a SAML identity provider serving its metadata and signing responses
+type fakeSAMLIdP struct {
+	*httptest.Server
+	t    *testing.T
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newFakeSAMLIdP(t *testing.T) *fakeSAMLIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSAMLIdP{t: t, key: key, cert: selfSigned(t, key)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<EntitiesDescriptor xmlns="%[1]s"><EntityDescriptor entityID="%[2]s/idp">`+
+			`<IDPSSODescriptor WantAuthnRequestsSigned="true" protocolSupportEnumeration="%[3]s">`+
+			`<KeyDescriptor use="signing"><KeyInfo xmlns="%[4]s"><X509Data><X509Certificate>%[5]s</X509Certificate></X509Data></KeyInfo></KeyDescriptor>`+
+			`<SingleSignOnService Binding="%[6]s" Location="%[2]s/sso"/><SingleSignOnService Binding="%[7]s" Location="%[2]s/sso"/>`+
+			`</IDPSSODescriptor></EntityDescriptor></EntitiesDescriptor>`,
+			nsMetadata, f.URL, nsSAMLP, nsDSig, base64.StdEncoding.EncodeToString(f.cert.Raw), SAMLBindingRedirect, SAMLBindingPOST)
+	}))
+	return f
+}
+
+// login follows the login redirect, checks the AuthnRequest and returns its id and the handshake cookies
+func (f *fakeSAMLIdP) login(h *SAMLHandler) (string, []*http.Cookie) {
+	w := httptest.NewRecorder()
+	h.LoginHandler(w, httptest.NewRequest("GET", "/auth?action=login&using=okta&from=/home", nil))
+	if w.Code != http.StatusFound {
+		f.t.Fatalf("unexpected login response: %d %s", w.Code, w.Body)
+	}
+	loc := w.Header().Get("Location")
+	if !strings.HasPrefix(loc, f.URL+"/sso?SAMLRequest=") {
+		f.t.Fatalf("unexpected login redirect: %s", loc)
+	}
+
+	// the signature is over the raw query, up to the Signature parameter
+	rawQuery := loc[strings.Index(loc, "?")+1:]
+	signedPart := rawQuery[:strings.Index(rawQuery, "&Signature=")]
+	q, _ := url.ParseQuery(rawQuery)
+	sig, _ := base64.StdEncoding.DecodeString(q.Get("Signature"))
+	if err := verifyXMLSignature(q.Get("SigAlg"), h.cfg.Certificate.PublicKey, []byte(signedPart), sig); err != nil {
+		f.t.Errorf("invalid request signature: %v", err)
+	}
+
+	deflated, _ := base64.StdEncoding.DecodeString(q.Get("SAMLRequest"))
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	req, err := parseXML(data)
+	if err != nil || !req.is(nsSAMLP, "AuthnRequest") || req.attr("AssertionConsumerServiceURL") != h.cfg.ACSURL ||
+		req.child(nsSAML, "Issuer").text() != h.cfg.EntityID {
+		f.t.Fatalf("unexpected authn request: %s, %v", data, err)
+	}
+	return req.attr("ID"), w.Result().Cookies()
+}
+
+// samlResponse are the variations of the responses of fakeSAMLIdP
+type samlResponse struct {
+	InResponseTo string
+	Confirmation string // InResponseTo of the subject confirmation, default InResponseTo, "none" for no attribute
+	Audience     string
+	NotOnOrAfter time.Time
+	Unsigned     bool
+	Tamper       bool
+}
+
+// response makes the signed response posted to the assertion consumer service
+func (f *fakeSAMLIdP) response(h *SAMLHandler, r samlResponse, cookies []*http.Cookie) *http.Request {
+	now := time.Now().UTC()
+	if r.NotOnOrAfter.IsZero() {
+		r.NotOnOrAfter = now.Add(5 * time.Minute)
+	}
+	if r.Audience == "" {
+		r.Audience = h.cfg.EntityID
+	}
+	confirmation := fmt.Sprintf(` InResponseTo="%s"`, r.InResponseTo)
+	switch r.Confirmation {
+	case "":
+	case "none":
+		confirmation = ""
+	default:
+		confirmation = fmt.Sprintf(` InResponseTo="%s"`, r.Confirmation)
+	}
+	doc := fmt.Sprintf(`<samlp:Response xmlns:samlp="%[1]s" xmlns:saml="%[2]s" ID="_r1" Version="2.0" IssueInstant="%[3]s"`+
+		` Destination="%[4]s" InResponseTo="%[5]s"><saml:Issuer>%[6]s/idp</saml:Issuer>`+
+		`<samlp:Status><samlp:StatusCode Value="%[7]s"/></samlp:Status>`+
+		`<saml:Assertion ID="_a1" Version="2.0" IssueInstant="%[3]s"><saml:Issuer>%[6]s/idp</saml:Issuer>`+
+		`<saml:Subject><saml:NameID Format="%[8]s">bob@example.com</saml:NameID>`+
+		`<saml:SubjectConfirmation Method="%[9]s"><saml:SubjectConfirmationData%[12]s Recipient="%[4]s" NotOnOrAfter="%[10]s"/>`+
+		`</saml:SubjectConfirmation></saml:Subject>`+
+		`<saml:Conditions NotBefore="%[3]s" NotOnOrAfter="%[10]s"><saml:AudienceRestriction><saml:Audience>%[11]s</saml:Audience>`+
+		`</saml:AudienceRestriction></saml:Conditions><saml:AuthnStatement AuthnInstant="%[3]s"/>`+
+		`<saml:AttributeStatement><saml:Attribute Name="urn:oid:2.16.840.1.113730.3.1.241" FriendlyName="displayName">`+
+		`<saml:AttributeValue>Bob &amp; Co</saml:AttributeValue></saml:Attribute>`+
+		`<saml:Attribute Name="groups"><saml:AttributeValue>admin</saml:AttributeValue><saml:AttributeValue>dev</saml:AttributeValue>`+
+		`</saml:Attribute></saml:AttributeStatement></saml:Assertion></samlp:Response>`,
+		nsSAMLP, nsSAML, now.Format(time.RFC3339), escapeCanonicalAttr(h.cfg.ACSURL), r.InResponseTo, f.URL, samlStatusSuccess,
+		samlNameIDEmail, samlBearer, r.NotOnOrAfter.Format(time.RFC3339), escapeCanonicalText(r.Audience), confirmation)
+
+	data := []byte(doc)
+	if !r.Unsigned {
+		resp, err := parseXML(data)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		if data, err = signEnveloped(resp.child(nsSAML, "Assertion"), f.key, f.cert); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	if r.Tamper {
+		data = bytes.Replace(data, []byte("bob@example.com"), []byte("eve@example.com"), 1)
+	}
+
+	form := url.Values{"SAMLResponse": {base64.StdEncoding.EncodeToString(data)}}
+	req := httptest.NewRequest("POST", "/auth?action=callback&using=okta", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestSAML(t *testing.T) {
+	idp := newFakeSAMLIdP(t)
+	defer idp.Close()
+
+	spKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtService := token.NewService(token.Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		DisableXSRF:  true,
+	})
+	h, err := NewSAML("okta", Params{URL: "https://app.example.com", JwtService: jwtService}, SAMLConfig{
+		IdPMetadata: idp.URL + "/metadata",
+		Key:         spKey,
+		Certificate: selfSigned(t, spKey),
+		Attributes:  SAMLAttributes{Role: "groups", Attributes: map[string]string{"groups": "groups"}},
+		TrustEmail:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.MetadataHandler(w, httptest.NewRequest("GET", "/auth?action=metadata&using=okta", nil))
+	md, err := parseXML(w.Body.Bytes())
+	sp := md.child(nsMetadata, "SPSSODescriptor")
+	if err != nil || md.attr("entityID") != "https://app.example.com/auth?using=okta&action=metadata" ||
+		sp.child(nsMetadata, "AssertionConsumerService").attr("Location") != "https://app.example.com/auth?using=okta&action=callback" ||
+		sp.child(nsMetadata, "KeyDescriptor") == nil {
+		t.Fatalf("unexpected metadata: %s, %v", w.Body, err)
+	}
+
+	id, cookies := idp.login(h)
+	w = httptest.NewRecorder()
+	h.AuthHandler(w, idp.response(h, samlResponse{InResponseTo: id}, cookies))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/home" {
+		t.Fatalf("unexpected callback response: %d %s", w.Code, w.Body)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	claims, _, err := jwtService.Get(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := claims.User
+	if !strings.HasPrefix(u.ID, "okta_") || u.Name != "Bob & Co" || u.Email != "bob@example.com" || !u.IsEmailVerified() ||
+		u.Role != "admin" || strings.Join(u.SliceAttr("groups"), ",") != "admin,dev" {
+		t.Errorf("unexpected user: %+v", u)
+	}
+
+	tbl := []struct {
+		name string
+		resp func(id string) samlResponse
+	}{
+		{"another request", func(string) samlResponse { return samlResponse{InResponseTo: "_other"} }},
+		{"subject confirmed for another request", func(id string) samlResponse {
+			return samlResponse{InResponseTo: id, Confirmation: "_other"}
+		}},
+		{"subject confirmed for no request", func(id string) samlResponse {
+			return samlResponse{InResponseTo: id, Confirmation: "none"}
+		}},
+		{"another audience", func(id string) samlResponse {
+			return samlResponse{InResponseTo: id, Audience: "https://other.example.com"}
+		}},
+		{"expired", func(id string) samlResponse {
+			return samlResponse{InResponseTo: id, NotOnOrAfter: time.Now().Add(-10 * time.Minute)}
+		}},
+		{"unsigned", func(id string) samlResponse { return samlResponse{InResponseTo: id, Unsigned: true} }},
+		{"tampered", func(id string) samlResponse { return samlResponse{InResponseTo: id, Tamper: true} }},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			id, cookies := idp.login(h)
+			w := httptest.NewRecorder()
+			h.AuthHandler(w, idp.response(h, tt.resp(id), cookies))
+			if w.Code != http.StatusForbidden {
+				t.Errorf("expected 403, got %d %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	// the response needs the handshake of the login
+	id, _ = idp.login(h)
+	w = httptest.NewRecorder()
+	h.AuthHandler(w, idp.response(h, samlResponse{InResponseTo: id}, nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without handshake, got %d %s", w.Code, w.Body)
+	}
+
+	if _, err := NewSAML("ok_ta", Params{JwtService: jwtService}, SAMLConfig{IdPMetadata: idp.URL}); err == nil {
+		t.Error("expected an error for a name with an underscore")
+	}
+}
+
+func TestSAMLPostBinding(t *testing.T) {
+	idp := newFakeSAMLIdP(t)
+	defer idp.Close()
+	spKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtService := token.NewService(token.Opts{SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil })})
+	h, err := NewSAML("okta", Params{URL: "https://app.example.com", JwtService: jwtService},
+		SAMLConfig{IdPMetadata: idp.URL, Binding: SAMLBindingPOST, Key: spKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.LoginHandler(w, httptest.NewRequest("GET", "/auth?action=login&using=okta", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `action="`+idp.URL+`/sso"`) {
+		t.Fatalf("unexpected login response: %d %s", w.Code, body)
+	}
+	value := body[strings.Index(body, `name="SAMLRequest" value="`)+len(`name="SAMLRequest" value="`):]
+	data, _ := base64.StdEncoding.DecodeString(html.UnescapeString(value[:strings.Index(value, `"`)]))
+	req, err := parseXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifySignature(req, []*x509.Certificate{{PublicKey: &spKey.PublicKey}}); err != nil {
+		t.Errorf("invalid request signature: %v, %s", err, data)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	el, err := parseXML([]byte(`<?xml version="1.0"?><a:root xmlns:b="urn:b" xmlns:a="urn:a" xmlns:u="urn:unused" z="1" b:y="2" a:x="3">` +
+		"\r\n" + `<a:child xmlns:a="urn:a">t&amp;&lt;&gt;"</a:child><c xmlns="urn:c" v='&quot;&#9;'/></a:root>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := canonicalize(el, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `<a:root xmlns:a="urn:a" xmlns:b="urn:b" z="1" a:x="3" b:y="2">` + "\n" +
+		`<a:child>t&amp;&lt;&gt;"</a:child><c xmlns="urn:c" v="&quot;&#x9;"></c></a:root>`
+	if string(res) != exp {
+		t.Errorf("unexpected canonical form:\n%s\n%s", res, exp)
+	}
+
+	if _, err = parseXML([]byte(`<!DOCTYPE r [<!ENTITY e "x">]><r>&e;</r>`)); err == nil {
+		t.Error("expected an error for a document type")
+	}
+}
+
+func TestVerifySignatureWrapping(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	el, err := parseXML([]byte(`<r ID="_1"><v>1</v></r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signEnveloped(el, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []*x509.Certificate{{PublicKey: &key.PublicKey}}
+	if el, err = parseXML(signed); err != nil || verifySignature(el, certs) != nil {
+		t.Fatalf("expected a valid signature: %v", err)
+	}
+
+	// a signature of another element is not one of this element
+	moved := bytes.Replace(signed, []byte(`ID="_1"`), []byte(`ID="_2"`), 1)
+	if el, err = parseXML(moved); err != nil || verifySignature(el, certs) == nil {
+		t.Errorf("expected an error for a reference to another id, %v", err)
+	}
+}
@@ -0,0 +1,509 @@
+package provider
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // rsa-sha1 and sha1 digests are still sent by some IdPs
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// XML namespaces and algorithms of SAML and XML signatures
+const (
+	nsSAML     = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsSAMLP    = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsMetadata = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsDSig     = "http://www.w3.org/2000/09/xmldsig#"
+	nsXML      = "http://www.w3.org/XML/1998/namespace"
+
+	algExcC14N      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped    = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algSHA1         = "http://www.w3.org/2000/09/xmldsig#sha1"
+	algSHA256       = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algRSASHA1      = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	algRSASHA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algECDSASHA256  = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	maxSAMLDocument = 1 << 20
+)
+
+// xmlElement is an element of a parsed XML document, keeping the prefixes and namespace
+// declarations needed by the canonicalization. Children are *xmlElement or string.
+type xmlElement struct {
+	Prefix   string
+	Local    string
+	Attrs    []xml.Attr // Name.Space is the prefix, "xmlns" for the namespace declarations
+	Children []interface{}
+	Parent   *xmlElement
+}
+
+// parseXML parses a document, rejecting DTDs
+func parseXML(data []byte) (*xmlElement, error) {
+	if len(data) > maxSAMLDocument {
+		return nil, errors.New("xml document too large")
+	}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *xmlElement
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't parse xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			e := &xmlElement{Prefix: t.Name.Space, Local: t.Name.Local, Attrs: append([]xml.Attr(nil), t.Attr...), Parent: cur}
+			if cur == nil {
+				if root != nil {
+					return nil, errors.New("can't parse xml: more than one root element")
+				}
+				root = e
+			} else {
+				cur.Children = append(cur.Children, e)
+			}
+			cur = e
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.Prefix || t.Name.Local != cur.Local {
+				return nil, errors.New("can't parse xml: unexpected end element")
+			}
+			cur = cur.Parent
+		case xml.CharData:
+			if cur != nil {
+				cur.Children = append(cur.Children, string(t))
+			}
+		case xml.Directive:
+			return nil, errors.New("can't parse xml: DTDs are not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, errors.New("can't parse xml: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNS returns the namespace of the prefix in the scope of the element
+func (e *xmlElement) lookupNS(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return nsXML, true
+	}
+	for el := e; el != nil; el = el.Parent {
+		for _, a := range el.Attrs {
+			if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") ||
+				(prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+				return a.Value, true
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// Space returns the namespace of the element
+func (e *xmlElement) Space() string {
+	ns, _ := e.lookupNS(e.Prefix)
+	return ns
+}
+
+// is reports if the element has the namespace and local name
+func (e *xmlElement) is(space, local string) bool {
+	return e != nil && e.Local == local && e.Space() == space
+}
+
+// child returns the first child element with the namespace and local name
+func (e *xmlElement) child(space, local string) *xmlElement {
+	for _, c := range e.elements(space, local) {
+		return c
+	}
+	return nil
+}
+
+// elements returns the child elements with the namespace and local name
+func (e *xmlElement) elements(space, local string) []*xmlElement {
+	if e == nil {
+		return nil
+	}
+	var res []*xmlElement
+	for _, c := range e.Children {
+		if el, ok := c.(*xmlElement); ok && el.is(space, local) {
+			res = append(res, el)
+		}
+	}
+	return res
+}
+
+// attr returns the value of the unqualified attribute
+func (e *xmlElement) attr(name string) string {
+	if e == nil {
+		return ""
+	}
+	for _, a := range e.Attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// text returns the concatenated text of the element and its descendants
+func (e *xmlElement) text() string {
+	if e == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range e.Children {
+		switch v := c.(type) {
+		case string:
+			b.WriteString(v)
+		case *xmlElement:
+			b.WriteString(v.text())
+		}
+	}
+	return b.String()
+}
+
+// canonicalize serializes the element with Exclusive XML Canonicalization 1.0 without comments.
+// The prefixes of inclusive are rendered like Canonical XML does, and skip is left out, for the
+// enveloped signature transform.
+func canonicalize(e *xmlElement, inclusive []string, skip *xmlElement) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeCanonical(&b, e, map[string]string{"": ""}, inclusive, skip); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeCanonical(b *bytes.Buffer, e *xmlElement, rendered map[string]string, inclusive []string, skip *xmlElement) error {
+	// namespaces visibly utilized by the element and its attributes, and the inclusive ones in scope
+	used := map[string]bool{e.Prefix: true}
+	type attr struct{ space, qname, value string }
+	var attrs []attr
+	for _, a := range e.Attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		qname, space := a.Name.Local, ""
+		if a.Name.Space != "" {
+			ns, ok := e.lookupNS(a.Name.Space)
+			if !ok {
+				return fmt.Errorf("unbound prefix %q", a.Name.Space)
+			}
+			qname, space = a.Name.Space+":"+a.Name.Local, ns
+			if a.Name.Space != "xml" {
+				used[a.Name.Space] = true
+			}
+		}
+		attrs = append(attrs, attr{space: space, qname: qname, value: a.Value})
+	}
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		if _, ok := e.lookupNS(p); ok {
+			used[p] = true
+		}
+	}
+
+	var decls []string
+	scope := make(map[string]string, len(rendered)+len(used))
+	for k, v := range rendered {
+		scope[k] = v
+	}
+	for p := range used {
+		ns, ok := e.lookupNS(p)
+		if !ok {
+			return fmt.Errorf("unbound prefix %q", p)
+		}
+		if prev, ok := rendered[p]; ok && prev == ns {
+			continue
+		}
+		scope[p] = ns
+		decls = append(decls, p)
+	}
+	sort.Strings(decls)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return localName(attrs[i].qname) < localName(attrs[j].qname)
+	})
+
+	qname := e.Local
+	if e.Prefix != "" {
+		qname = e.Prefix + ":" + e.Local
+	}
+	b.WriteString("<" + qname)
+	for _, p := range decls {
+		if p == "" {
+			b.WriteString(` xmlns="`)
+		} else {
+			b.WriteString(` xmlns:` + p + `="`)
+		}
+		b.WriteString(escapeCanonicalAttr(scope[p]) + `"`)
+	}
+	for _, a := range attrs {
+		b.WriteString(" " + a.qname + `="` + escapeCanonicalAttr(a.value) + `"`)
+	}
+	b.WriteString(">")
+	for _, c := range e.Children {
+		switch v := c.(type) {
+		case string:
+			b.WriteString(escapeCanonicalText(v))
+		case *xmlElement:
+			if v == skip {
+				continue
+			}
+			if err := writeCanonical(b, v, scope, inclusive, skip); err != nil {
+				return err
+			}
+		}
+	}
+	b.WriteString("</" + qname + ">")
+	return nil
+}
+
+func localName(qname string) string {
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
+
+var (
+	canonicalTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	canonicalAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeCanonicalText(s string) string { return canonicalTextEscaper.Replace(s) }
+func escapeCanonicalAttr(s string) string { return canonicalAttrEscaper.Replace(s) }
+
+// inclusivePrefixes returns the PrefixList of the InclusiveNamespaces child of a transform or
+// canonicalization method
+func inclusivePrefixes(method *xmlElement) []string {
+	for _, c := range method.Children {
+		if el, ok := c.(*xmlElement); ok && el.Local == "InclusiveNamespaces" && el.Space() == algExcC14N {
+			return strings.Fields(el.attr("PrefixList"))
+		}
+	}
+	return nil
+}
+
+// verifySignature checks the enveloped XML signature of the element with one of the certificates.
+// The signature must reference the element itself by its ID, so the verified content is the
+// element passed, whatever else the document holds.
+func verifySignature(e *xmlElement, certs []*x509.Certificate) error {
+	sigs := e.elements(nsDSig, "Signature")
+	if len(sigs) != 1 {
+		return fmt.Errorf("expected one signature, got %d", len(sigs))
+	}
+	sig := sigs[0]
+	signedInfo := sig.child(nsDSig, "SignedInfo")
+	if signedInfo == nil {
+		return errors.New("no SignedInfo")
+	}
+	c14nMethod := signedInfo.child(nsDSig, "CanonicalizationMethod")
+	if c14nMethod.attr("Algorithm") != algExcC14N {
+		return fmt.Errorf("unsupported canonicalization %q", c14nMethod.attr("Algorithm"))
+	}
+
+	refs := signedInfo.elements(nsDSig, "Reference")
+	if len(refs) != 1 {
+		return fmt.Errorf("expected one reference, got %d", len(refs))
+	}
+	ref := refs[0]
+	if id := e.attr("ID"); id == "" || ref.attr("URI") != "#"+id {
+		return fmt.Errorf("signature references %q, not the signed element", ref.attr("URI"))
+	}
+	var inclusive []string
+	for _, t := range ref.child(nsDSig, "Transforms").elements(nsDSig, "Transform") {
+		switch t.attr("Algorithm") {
+		case algEnveloped:
+		case algExcC14N:
+			inclusive = inclusivePrefixes(t)
+		default:
+			return fmt.Errorf("unsupported transform %q", t.attr("Algorithm"))
+		}
+	}
+	h, err := digestHash(ref.child(nsDSig, "DigestMethod").attr("Algorithm"))
+	if err != nil {
+		return err
+	}
+	data, err := canonicalize(e, inclusive, sig)
+	if err != nil {
+		return fmt.Errorf("can't canonicalize signed element: %w", err)
+	}
+	h.Write(data)
+	digest, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(ref.child(nsDSig, "DigestValue").text()), ""))
+	if err != nil || !bytes.Equal(digest, h.Sum(nil)) {
+		return errors.New("digest mismatch")
+	}
+
+	data, err = canonicalize(signedInfo, inclusivePrefixes(c14nMethod), nil)
+	if err != nil {
+		return fmt.Errorf("can't canonicalize SignedInfo: %w", err)
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(sig.child(nsDSig, "SignatureValue").text()), ""))
+	if err != nil {
+		return fmt.Errorf("can't decode signature value: %w", err)
+	}
+	method := signedInfo.child(nsDSig, "SignatureMethod").attr("Algorithm")
+	for _, cert := range certs {
+		if err = verifyXMLSignature(method, cert.PublicKey, data, value); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid signature: %w", err)
+}
+
+// digestHash returns the hash of a digest algorithm
+func digestHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case algSHA256:
+		return sha256.New(), nil
+	case algSHA1:
+		return sha1.New(), nil //nolint:gosec // see import
+	}
+	return nil, fmt.Errorf("unsupported digest %q", alg)
+}
+
+// verifyXMLSignature checks a signature of the XML signature and SAML Redirect binding algorithms
+func verifyXMLSignature(alg string, pub crypto.PublicKey, data, sig []byte) error {
+	var h crypto.Hash
+	switch alg {
+	case algRSASHA256, algECDSASHA256:
+		h = crypto.SHA256
+	case algRSASHA1:
+		h = crypto.SHA1
+	default:
+		return fmt.Errorf("unsupported signature method %q", alg)
+	}
+	hh := h.New()
+	hh.Write(data)
+	sum := hh.Sum(nil)
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if alg == algECDSASHA256 {
+			return errors.New("rsa key for an ecdsa signature")
+		}
+		return rsa.VerifyPKCS1v15(key, h, sum, sig)
+	case *ecdsa.PublicKey:
+		// XML signatures hold the r and s values concatenated
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg != algECDSASHA256 || len(sig) != 2*size {
+			return errors.New("invalid ecdsa signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, sum, r, s) {
+			return errors.New("ecdsa verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", pub)
+}
+
+// signatureMethod returns the XML signature algorithm of the key
+func signatureMethod(key crypto.Signer) (string, error) {
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		return algRSASHA256, nil
+	case *ecdsa.PublicKey:
+		return algECDSASHA256, nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", key.Public())
+}
+
+// signXML signs data with the key, in the format of the XML signature algorithm of the key
+func signXML(key crypto.Signer, data []byte) ([]byte, error) {
+	sum := sha256.Sum256(data)
+	sig, err := key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("can't sign: %w", err)
+	}
+	pub, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return sig, nil
+	}
+	// convert the ASN.1 signature of crypto.Signer to r and s concatenated
+	var rs struct{ R, S *big.Int }
+	if _, err = asn1.Unmarshal(sig, &rs); err != nil {
+		return nil, fmt.Errorf("can't decode ecdsa signature: %w", err)
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	res := make([]byte, 2*size)
+	rs.R.FillBytes(res[:size])
+	rs.S.FillBytes(res[size:])
+	return res, nil
+}
+
+// signEnveloped adds an enveloped signature of the element after its Issuer child, or as its
+// first child, and returns the canonical document
+func signEnveloped(e *xmlElement, key crypto.Signer, cert *x509.Certificate) ([]byte, error) {
+	method, err := signatureMethod(key)
+	if err != nil {
+		return nil, err
+	}
+	var keyInfo string
+	if cert != nil {
+		keyInfo = `<ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(cert.Raw) +
+			`</ds:X509Certificate></ds:X509Data></ds:KeyInfo>`
+	}
+	sig, err := parseXML([]byte(`<ds:Signature xmlns:ds="` + nsDSig + `"><ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="` + method + `"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + escapeCanonicalAttr(e.attr("ID")) + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnveloped + `"></ds:Transform>` +
+		`<ds:Transform Algorithm="` + algExcC14N + `"></ds:Transform></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + algSHA256 + `"></ds:DigestMethod><ds:DigestValue></ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo><ds:SignatureValue></ds:SignatureValue>` + keyInfo + `</ds:Signature>`))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := canonicalize(e, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	signedInfo := sig.child(nsDSig, "SignedInfo")
+	signedInfo.child(nsDSig, "Reference").child(nsDSig, "DigestValue").Children = []interface{}{
+		base64.StdEncoding.EncodeToString(digest[:]),
+	}
+
+	pos := 0
+	for i, c := range e.Children {
+		if el, ok := c.(*xmlElement); ok && el.is(nsSAML, "Issuer") {
+			pos = i + 1
+			break
+		}
+	}
+	sig.Parent = e
+	e.Children = append(e.Children[:pos:pos], append([]interface{}{sig}, e.Children[pos:]...)...)
+
+	if data, err = canonicalize(signedInfo, nil, nil); err != nil {
+		return nil, err
+	}
+	value, err := signXML(key, data)
+	if err != nil {
+		return nil, err
+	}
+	sig.child(nsDSig, "SignatureValue").Children = []interface{}{base64.StdEncoding.EncodeToString(value)}
+
+	root := e
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return canonicalize(root, nil, nil)
+}
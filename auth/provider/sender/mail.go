@@ -0,0 +1,39 @@
+package sender
+
+import (
+	"context"
+
+	"mkfst/auth/logger"
+	"mkfst/providers/mail"
+)
+
+// Mail implements sender interfaces for VerifyHandler with a mail.Mailer.
+// Send sends the text with the common subject, SendTemplate renders the templates of the
+// mailer, and with a Scheduler the messages are delivered asynchronously with retries.
+type Mail struct {
+	logger.L
+	Mailer  *mail.Mailer
+	Subject string // subject of the messages of Send
+}
+
+// NewMail makes sender with the mailer
+func NewMail(m *mail.Mailer, subject string, l logger.L) *Mail {
+	if l == nil {
+		l = logger.NoOp
+	}
+	return &Mail{L: l, Mailer: m, Subject: subject}
+}
+
+// Send email with given text
+func (m *Mail) Send(to, text string) error {
+	m.Logf("[DEBUG] send %q to %s", m.Subject, to)
+	_, err := m.Mailer.Send(context.Background(), mail.Message{To: []string{to}, Subject: m.Subject, Text: text})
+	return err
+}
+
+// SendTemplate sends email rendered by the template name in the locale
+func (m *Mail) SendTemplate(ctx context.Context, to, name, locale string, data interface{}) error {
+	m.Logf("[DEBUG] send template %s/%s to %s", name, locale, to)
+	_, err := m.Mailer.SendTemplate(ctx, to, name, locale, data)
+	return err
+}
@@ -49,6 +49,11 @@ type Provider interface {
 	LogoutHandler(w http.ResponseWriter, r *http.Request)
 }
 
+// MetadataProvider is a provider publishing its metadata, i.e. a SAML service provider
+type MetadataProvider interface {
+	MetadataHandler(w http.ResponseWriter, r *http.Request)
+}
+
 // Handler returns auth routes for given provider
 func (p Service) Handler(ctx *gin.Context) (gin.H, error) {
 
@@ -76,6 +81,17 @@ func (p Service) Handler(ctx *gin.Context) (gin.H, error) {
 		p.LogoutHandler(ctx.Writer, ctx.Request)
 		return nil, nil
 
+	case "metadata":
+		if m, ok := p.Provider.(MetadataProvider); ok {
+			m.MetadataHandler(ctx.Writer, ctx.Request)
+			return nil, nil
+		}
+		ctx.AbortWithStatus(http.StatusNotFound)
+		errMsg := "provider without metadata"
+		return gin.H{
+			"error": errMsg,
+		}, errors.New(errMsg)
+
 	default:
 		ctx.AbortWithStatus(http.StatusNotFound)
 		errMsg := "invalid action"
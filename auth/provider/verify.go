@@ -2,10 +2,12 @@ package provider
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/golang-jwt/jwt"
 
 	"mkfst/auth/avatar"
+	"mkfst/auth/lockout"
 	"mkfst/auth/logger"
 	"mkfst/auth/token"
 )
@@ -28,6 +31,11 @@ type VerifyHandler struct {
 	Sender       Sender
 	Template     string
 	UseGravatar  bool
+	Lockout      *lockout.Guard // optional cooldown of the confirmations and lockout of the client addresses
+
+	TemplateSender TemplateSender // sends the confirmations with its own templates instead of Sender
+	TemplateName   string         // name of the template of TemplateSender, default "verify"
+	URL            string         // login url of the provider, i.e. https://example.com/auth?action=login&using=email, makes the Link of the messages
 }
 
 // Sender defines interface to send emails
@@ -43,6 +51,12 @@ func (f SenderFunc) Send(address, text string) error {
 	return f(address, text)
 }
 
+// TemplateSender defines interface to send templated emails, i.e. sender.Mail.
+// The locale is the one of the request, from the locale query param or Accept-Language.
+type TemplateSender interface {
+	SendTemplate(ctx context.Context, address, name, locale string, data interface{}) error
+}
+
 // VerifTokenService defines interface accessing tokens
 type VerifTokenService interface {
 	Token(claims token.Claims) (string, error)
@@ -79,6 +93,10 @@ func (e VerifyHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if confClaims.Handshake == nil || confClaims.Handshake.State != "" { // not a confirmation
+		rest.SendErrorJSON(w, r, e.L, http.StatusBadRequest, fmt.Errorf("no handshake"), "invalid handshake token")
+		return
+	}
 	elems := strings.Split(confClaims.Handshake.ID, "::")
 	if len(elems) != 2 {
 		rest.SendErrorJSON(w, r, e.L, http.StatusBadRequest, fmt.Errorf("%s", confClaims.Handshake.ID), "invalid handshake token")
@@ -91,6 +109,11 @@ func (e VerifyHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		Name: user,
 		ID:   e.ProviderName + "_" + token.HashID(sha1.New(), address),
 	}
+	// the address is verified by the confirmation
+	if strings.Contains(address, "@") {
+		u.Email = address
+		u.SetEmailVerified(true)
+	}
 	// try to get gravatar for email
 	if e.UseGravatar && strings.Contains(address, "@") { // TODO: better email check to avoid silly hits to gravatar api
 		if picURL, e := avatar.GetGravatarURL(address); e == nil {
@@ -123,7 +146,7 @@ func (e VerifyHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, e.L, http.StatusInternalServerError, err, "failed to set token")
 		return
 	}
-	if confClaims.Handshake != nil && confClaims.Handshake.From != "" {
+	if confClaims.Handshake.From != "" {
 		http.Redirect(w, r, confClaims.Handshake.From, http.StatusTemporaryRedirect)
 		return
 	}
@@ -139,10 +162,19 @@ func (e VerifyHandler) sendConfirmation(w http.ResponseWriter, r *http.Request)
 		rest.SendErrorJSON(w, r, e.L, http.StatusBadRequest, fmt.Errorf("wrong request"), "can't get user and address")
 		return
 	}
+	if e.Lockout != nil {
+		if !checkLockout(w, r, e.L, e.Lockout, e.ProviderName, "", time.Now()) {
+			return
+		}
+		if !throttleConfirmation(w, r, e.L, e.Lockout, e.ProviderName, address) {
+			return
+		}
+	}
 
 	claims := token.Claims{
 		Handshake: &token.Handshake{
 			State: "",
+			From:  r.URL.Query().Get("from"),
 			ID:    user + "::" + address,
 		},
 		SessionOnly: r.URL.Query().Get("session") != "" && r.URL.Query().Get("session") != "0",
@@ -160,27 +192,55 @@ func (e VerifyHandler) sendConfirmation(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	tmpl := msgTemplate
-	if e.Template != "" {
-		tmpl = e.Template
-	}
-	emailTmpl, err := template.New("confirm").Parse(tmpl)
-	if err != nil {
-		rest.SendErrorJSON(w, r, e.L, http.StatusInternalServerError, err, "can't parse confirmation template")
-		return
-	}
-
 	tmplData := struct {
 		User    string
 		Address string
 		Token   string
 		Site    string
+		Link    string // magic link logging in with the token, empty without URL
 	}{
 		User:    trim(user),
 		Address: trim(address),
 		Token:   tkn,
 		Site:    site,
 	}
+	if e.URL != "" {
+		link, err := url.Parse(e.URL)
+		if err != nil {
+			rest.SendErrorJSON(w, r, e.L, http.StatusInternalServerError, err, "invalid login url")
+			return
+		}
+		q := link.Query()
+		q.Set("token", tkn)
+		if claims.SessionOnly {
+			q.Set("sess", "1")
+		}
+		link.RawQuery = q.Encode()
+		tmplData.Link = link.String()
+	}
+
+	if e.TemplateSender != nil {
+		name := e.TemplateName
+		if name == "" {
+			name = "verify"
+		}
+		if err = e.TemplateSender.SendTemplate(r.Context(), address, name, RequestLocale(r), tmplData); err != nil {
+			rest.SendErrorJSON(w, r, e.L, http.StatusInternalServerError, err, "failed to send confirmation")
+			return
+		}
+		rest.RenderJSON(w, rest.JSON{"user": user, "address": address})
+		return
+	}
+
+	tmpl := msgTemplate
+	if e.Template != "" {
+		tmpl = e.Template
+	}
+	emailTmpl, err := template.New("confirm").Parse(tmpl)
+	if err != nil {
+		rest.SendErrorJSON(w, r, e.L, http.StatusInternalServerError, err, "can't parse confirmation template")
+		return
+	}
 	buf := bytes.Buffer{}
 	if err = emailTmpl.Execute(&buf, tmplData); err != nil {
 		rest.SendErrorJSON(w, r, e.L, http.StatusInternalServerError, err, "can't execute confirmation template")
@@ -209,6 +269,26 @@ Confirmation for {{.User}} {{.Address}}, site {{.Site}}
 Token: {{.Token}}
 `
 
+// RequestLocale returns the locale of the locale query param, or else the first of Accept-Language,
+// i.e. "fr-CA" of "fr-CA,fr;q=0.9". Anything but a language tag is ignored.
+func RequestLocale(r *http.Request) string {
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale, _, _ = strings.Cut(r.Header.Get("Accept-Language"), ",")
+		locale, _, _ = strings.Cut(locale, ";")
+		locale = strings.TrimSpace(locale)
+	}
+	if len(locale) > 16 {
+		return ""
+	}
+	for _, c := range locale {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return ""
+		}
+	}
+	return locale
+}
+
 func trim(inp string) string {
 	res := strings.ReplaceAll(inp, "\n", "")
 	res = strings.TrimSpace(res)
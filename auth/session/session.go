@@ -0,0 +1,453 @@
+// Package session keeps the sessions of logged-in users: opaque refresh tokens rotated on every
+// use with reuse detection, revocation of access tokens, and the listing of active sessions.
+//
+// Sessions are stored in a providers/cache Cache. The Redis and SQL backends share them between
+// the replicas of a service, so a logout or a ban on one replica is seen by all of them; the
+// memory backend keeps them in the process only.
+//
+// A refresh token is "<session id>.<secret>" and only the hash of the secret is stored. Each
+// refresh issues a new secret; presenting a rotated one again means the token leaked, and the
+// session is revoked. The previous secret is still accepted for a short grace period, without
+// rotation, so concurrent refreshes of a client don't revoke its session. A secret is rotated
+// once: concurrent refreshes with it claim the rotation with the atomic Add of the cache, and
+// the ones losing the claim are answered like refreshes in the grace period.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mkfst/auth/token"
+	"mkfst/providers/cache"
+)
+
+// Errors of Refresh
+var (
+	ErrInvalidToken = errors.New("session: invalid refresh token")
+	ErrTokenReused  = errors.New("session: refresh token reused, session revoked")
+	ErrNotFound     = errors.New("session: not found")
+)
+
+const (
+	defaultPrefix     = "auth:"
+	defaultDuration   = time.Hour * 24 * 31
+	defaultReuseGrace = time.Second * 10
+	defaultCookieName = "JWT-REFRESH"
+	defaultHeaderKey  = "X-JWT-Refresh"
+
+	maxPrevious = 10 // rotated secrets kept to detect their reuse
+)
+
+// Session is a login of a user, on one device
+type Session struct {
+	ID          string     `json:"id"`
+	User        token.User `json:"user"`
+	Audience    string     `json:"aud,omitempty"`
+	UserAgent   string     `json:"user_agent,omitempty"`
+	IP          string     `json:"ip,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RefreshedAt time.Time  `json:"refreshed_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
+
+// record is the stored session, with the hashes of its refresh tokens
+type record struct {
+	Session
+	Hash      string    `json:"hash"`
+	Previous  []string  `json:"previous,omitempty"` // newest first
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// Opts is a set of parameters of Manager
+type Opts struct {
+	Cache         cache.Cache   // store of the sessions, required
+	Prefix        string        // prefix of the keys in Cache, default "auth:"
+	Duration      time.Duration // lifetime of a session since its last refresh, default 31 days
+	TokenLifetime time.Duration // longest lifetime of an access token, refreshes included, default Duration
+	ReuseGrace    time.Duration // period the previous refresh token is still accepted, default 10s
+
+	// transport of the refresh token
+	CookieName    string        // default "JWT-REFRESH"
+	CookieDomain  string        // default empty
+	HeaderKey     string        // default "X-JWT-Refresh"
+	SecureCookies bool          // makes the cookie secure
+	SameSite      http.SameSite // SameSite attribute of the cookie
+	SendHeader    bool          // if enabled send the token as a header instead of cookie
+}
+
+// Manager creates, refreshes and revokes sessions. It implements token.Revocations.
+type Manager struct {
+	Opts
+	lock sync.Mutex // serializes the updates of the user indexes, and the rotations with a cache without Add, of this process
+}
+
+// NewManager makes a session manager
+func NewManager(opts Opts) *Manager {
+	res := Manager{Opts: opts}
+	setDefault := func(fld *string, def string) {
+		if *fld == "" {
+			*fld = def
+		}
+	}
+	setDefault(&res.Prefix, defaultPrefix)
+	setDefault(&res.CookieName, defaultCookieName)
+	setDefault(&res.HeaderKey, defaultHeaderKey)
+	if res.Duration == 0 {
+		res.Duration = defaultDuration
+	}
+	if res.TokenLifetime == 0 {
+		res.TokenLifetime = res.Duration
+	}
+	if res.ReuseGrace == 0 {
+		res.ReuseGrace = defaultReuseGrace
+	}
+	return &res
+}
+
+// Create starts a session for the user of claims and returns its refresh token.
+// The user agent and address of r, if not nil, describe the device.
+func (m *Manager) Create(ctx context.Context, claims token.Claims, r *http.Request) (string, Session, error) {
+	if claims.User == nil {
+		return "", Session{}, fmt.Errorf("session: no user in claims")
+	}
+	id, err := random(20)
+	if err != nil {
+		return "", Session{}, err
+	}
+	now := time.Now()
+	rec := record{Session: Session{
+		ID:          id,
+		User:        *claims.User,
+		Audience:    claims.Audience,
+		CreatedAt:   now,
+		RefreshedAt: now,
+		ExpiresAt:   now.Add(m.Duration),
+	}}
+	if r != nil {
+		rec.UserAgent = r.UserAgent()
+		if rec.IP, _, err = net.SplitHostPort(r.RemoteAddr); err != nil {
+			rec.IP = r.RemoteAddr
+		}
+	}
+
+	refresh, err := m.rotate(&rec)
+	if err != nil {
+		return "", Session{}, err
+	}
+	if err = m.put(ctx, &rec); err != nil {
+		return "", Session{}, err
+	}
+	if err = m.index(ctx, rec.User.ID, func(ids []string) []string { return append(ids, id) }); err != nil {
+		return "", Session{}, err
+	}
+	return refresh, rec.Session, nil
+}
+
+// Refresh checks the refresh token and returns the next one with the session it refreshes, extending
+// its lifetime. Within the grace period after a rotation the previous token returns the session and
+// an empty token, the client keeping the one it got from the rotation. The reuse of an older token
+// revokes the session and returns ErrTokenReused.
+func (m *Manager) Refresh(ctx context.Context, refreshToken string) (string, Session, error) {
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || id == "" || secret == "" {
+		return "", Session{}, ErrInvalidToken
+	}
+	rec, err := m.get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return "", Session{}, ErrInvalidToken
+	}
+	if err != nil {
+		return "", Session{}, err
+	}
+	if revoked, err := m.userRevoked(ctx, rec.User.ID, rec.CreatedAt); err != nil || revoked {
+		if err == nil {
+			err = ErrInvalidToken
+		}
+		return "", Session{}, err
+	}
+
+	now := time.Now()
+	hash := hashSecret(secret)
+	switch {
+	case equal(hash, rec.Hash):
+	case len(rec.Previous) > 0 && equal(hash, rec.Previous[0]) && now.Sub(rec.RotatedAt) < m.ReuseGrace:
+		return "", rec.Session, nil
+	default:
+		for _, h := range rec.Previous {
+			if equal(hash, h) {
+				if err := m.Revoke(ctx, id); err != nil {
+					return "", Session{}, err
+				}
+				return "", Session{}, ErrTokenReused
+			}
+		}
+		return "", Session{}, ErrInvalidToken
+	}
+
+	// a concurrent refresh with the same token rotates it, this one keeps the token like in the grace period
+	if claimed, err := m.claimRotation(ctx, id, rec.Hash); err != nil || !claimed {
+		return "", rec.Session, err
+	}
+	next, err := m.rotate(rec)
+	if err != nil {
+		return "", Session{}, err
+	}
+	rec.RefreshedAt, rec.ExpiresAt = now, now.Add(m.Duration)
+	if err = m.put(ctx, rec); err != nil {
+		return "", Session{}, err
+	}
+	return next, rec.Session, nil
+}
+
+// Session returns the session with given id
+func (m *Manager) Session(ctx context.Context, id string) (Session, error) {
+	rec, err := m.get(ctx, id)
+	if err != nil {
+		return Session{}, err
+	}
+	return rec.Session, nil
+}
+
+// Sessions returns the active sessions of the user, oldest first
+func (m *Manager) Sessions(ctx context.Context, userID string) ([]Session, error) {
+	var res []Session
+	err := m.index(ctx, userID, func(ids []string) []string {
+		active := ids[:0]
+		for _, id := range ids {
+			rec, err := m.get(ctx, id)
+			if err != nil {
+				continue // expired or revoked
+			}
+			res = append(res, rec.Session)
+			active = append(active, id)
+		}
+		return active
+	})
+	return res, err
+}
+
+// Revoke ends the session: its refresh token and access tokens are rejected
+func (m *Manager) Revoke(ctx context.Context, id string) error {
+	if err := m.Cache.Delete(ctx, m.Prefix+"session:"+id); err != nil {
+		return fmt.Errorf("session: can't revoke %s: %w", id, err)
+	}
+	return nil
+}
+
+// RevokeUser ends all the sessions of the user, logging it out of all devices, and rejects the
+// access tokens issued to the user until now, including the ones without session if they have iat.
+// The iat of the tokens being in seconds, those issued without session in the second of the
+// revocation are rejected too; the sessions are compared with the time of their creation.
+func (m *Manager) RevokeUser(ctx context.Context, userID string) error {
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	ttl := m.TokenLifetime // sessions and tokens issued until now are rejected as long as they may live
+	if m.Duration > ttl {
+		ttl = m.Duration
+	}
+	if err := m.Cache.Set(ctx, m.Prefix+"revoked-user:"+userID, []byte(now), ttl); err != nil {
+		return fmt.Errorf("session: can't revoke user %s: %w", userID, err)
+	}
+	return m.index(ctx, userID, func(ids []string) []string {
+		for _, id := range ids {
+			_ = m.Cache.Delete(ctx, m.Prefix+"session:"+id) // revoked by the user entry anyway
+		}
+		return nil
+	})
+}
+
+// RevokeToken rejects the access token of claims until it expires
+func (m *Manager) RevokeToken(ctx context.Context, claims token.Claims) error {
+	if claims.Id == "" {
+		return fmt.Errorf("session: no id in claims")
+	}
+	ttl := m.TokenLifetime
+	if claims.ExpiresAt != 0 {
+		ttl = time.Until(time.Unix(claims.ExpiresAt, 0)) + m.TokenLifetime // expired tokens may be refreshed
+	}
+	if err := m.Cache.Set(ctx, m.Prefix+"revoked:"+claims.Id, []byte{1}, ttl); err != nil {
+		return fmt.Errorf("session: can't revoke token %s: %w", claims.Id, err)
+	}
+	return nil
+}
+
+// IsRevoked checks if the access token was revoked, itself, with its session or with its user
+func (m *Manager) IsRevoked(ctx context.Context, claims token.Claims) (bool, error) {
+	if claims.Id != "" {
+		_, found, err := m.Cache.Get(ctx, m.Prefix+"revoked:"+claims.Id)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	if claims.SessionID != "" { // revoked with its session, or its user since the session was created
+		rec, err := m.get(ctx, claims.SessionID)
+		if errors.Is(err, ErrNotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return m.userRevoked(ctx, rec.User.ID, rec.CreatedAt)
+	}
+	if claims.User != nil && claims.IssuedAt != 0 {
+		return m.userRevoked(ctx, claims.User.ID, time.Unix(claims.IssuedAt, 0))
+	}
+	return false, nil
+}
+
+// SetToken sends the refresh token in a cookie, or a header with SendHeader
+func (m *Manager) SetToken(w http.ResponseWriter, refreshToken string) {
+	if m.SendHeader {
+		w.Header().Set(m.HeaderKey, refreshToken)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: m.CookieName, Value: refreshToken, HttpOnly: true, Path: "/", Domain: m.CookieDomain,
+		MaxAge: int(m.Duration.Seconds()), Secure: m.SecureCookies, SameSite: m.SameSite})
+}
+
+// Token gets the refresh token of the request, from the header or the cookie
+func (m *Manager) Token(r *http.Request) string {
+	if h := r.Header.Get(m.HeaderKey); h != "" {
+		return h
+	}
+	if c, err := r.Cookie(m.CookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// Reset removes the refresh token cookie
+func (m *Manager) Reset(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: m.CookieName, Value: "", HttpOnly: true, Path: "/", Domain: m.CookieDomain,
+		MaxAge: -1, Expires: time.Unix(0, 0), Secure: m.SecureCookies, SameSite: m.SameSite})
+}
+
+// rotate sets a new secret on the record and returns its refresh token
+func (m *Manager) rotate(rec *record) (string, error) {
+	secret, err := random(32)
+	if err != nil {
+		return "", err
+	}
+	if rec.Hash != "" {
+		rec.Previous = append([]string{rec.Hash}, rec.Previous...)
+		if len(rec.Previous) > maxPrevious {
+			rec.Previous = rec.Previous[:maxPrevious]
+		}
+	}
+	rec.Hash, rec.RotatedAt = hashSecret(secret), time.Now()
+	return rec.ID + "." + secret, nil
+}
+
+// claimRotation reports if the secret of hash is rotated by this refresh, false if a concurrent
+// one claimed it first
+func (m *Manager) claimRotation(ctx context.Context, id, hash string) (bool, error) {
+	key := m.Prefix + "rotation:" + id + ":" + hash
+	if a, ok := m.Cache.(cache.Adder); ok {
+		claimed, err := a.Add(ctx, key, []byte{1}, m.ReuseGrace)
+		if err != nil {
+			return false, fmt.Errorf("session: can't rotate %s: %w", id, err)
+		}
+		return claimed, nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, found, err := m.Cache.Get(ctx, key); err != nil || found {
+		return false, err
+	}
+	return true, m.Cache.Set(ctx, key, []byte{1}, m.ReuseGrace)
+}
+
+func (m *Manager) get(ctx context.Context, id string) (*record, error) {
+	b, found, err := m.Cache.Get(ctx, m.Prefix+"session:"+id)
+	if err != nil {
+		return nil, fmt.Errorf("session: can't get %s: %w", id, err)
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	rec := record{}
+	if err = json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("session: can't decode %s: %w", id, err)
+	}
+	return &rec, nil
+}
+
+func (m *Manager) put(ctx context.Context, rec *record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("session: can't encode %s: %w", rec.ID, err)
+	}
+	if err = m.Cache.Set(ctx, m.Prefix+"session:"+rec.ID, b, time.Until(rec.ExpiresAt)); err != nil {
+		return fmt.Errorf("session: can't store %s: %w", rec.ID, err)
+	}
+	return nil
+}
+
+// index updates the ids of the sessions of the user with fn. Updates of concurrent replicas
+// may be lost, which RevokeUser covers by revoking the sessions created before it.
+func (m *Manager) index(ctx context.Context, userID string, fn func([]string) []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := m.Prefix + "user:" + userID
+	var ids []string
+	b, found, err := m.Cache.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("session: can't get sessions of %s: %w", userID, err)
+	}
+	if found {
+		if err = json.Unmarshal(b, &ids); err != nil {
+			return fmt.Errorf("session: can't decode sessions of %s: %w", userID, err)
+		}
+	}
+
+	if ids = fn(ids); len(ids) == 0 {
+		return m.Cache.Delete(ctx, key)
+	}
+	if b, err = json.Marshal(ids); err != nil {
+		return err
+	}
+	return m.Cache.Set(ctx, key, b, m.Duration)
+}
+
+// userRevoked checks if the user was revoked at or after the time
+func (m *Manager) userRevoked(ctx context.Context, userID string, at time.Time) (bool, error) {
+	b, found, err := m.Cache.Get(ctx, m.Prefix+"revoked-user:"+userID)
+	if err != nil || !found {
+		return false, err
+	}
+	revokedAt, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("session: invalid revocation of user %s: %w", userID, err)
+	}
+	return at.UnixNano() <= revokedAt, nil
+}
+
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("session: can't get random: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
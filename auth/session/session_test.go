@@ -0,0 +1,222 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"mkfst/auth/token"
+	"mkfst/providers/cache"
+)
+
+func newManager(t *testing.T) *Manager {
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	t.Cleanup(func() { _ = c.Close() })
+	return NewManager(Opts{Cache: c, ReuseGrace: 50 * time.Millisecond})
+}
+
+func userClaims(id string) token.Claims {
+	return token.Claims{User: &token.User{ID: id, Name: id}, StandardClaims: jwt.StandardClaims{Audience: "web"}}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	m := newManager(t)
+
+	req := httptest.NewRequest("POST", "/auth", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	first, s, err := m.Create(ctx, userClaims("dev_user"), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserAgent != "test-agent" || s.IP == "" || s.Audience != "web" {
+		t.Errorf("unexpected session: %+v", s)
+	}
+
+	second, refreshed, err := m.Refresh(ctx, first)
+	if err != nil || second == "" || second == first || refreshed.ID != s.ID {
+		t.Fatalf("unexpected refresh: %q %+v %v", second, refreshed, err)
+	}
+	// a concurrent refresh with the previous token is accepted, without rotation
+	if next, _, err := m.Refresh(ctx, first); err != nil || next != "" {
+		t.Errorf("unexpected refresh within the grace period: %q %v", next, err)
+	}
+	third, _, err := m.Refresh(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reusing a rotated token revokes the session
+	if _, _, err := m.Refresh(ctx, first); !errors.Is(err, ErrTokenReused) {
+		t.Errorf("expected reuse detection, got %v", err)
+	}
+	if _, _, err := m.Refresh(ctx, third); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the session to be revoked, got %v", err)
+	}
+	if revoked, err := m.IsRevoked(ctx, token.Claims{SessionID: s.ID}); err != nil || !revoked {
+		t.Errorf("access token of the revoked session not revoked: %v", err)
+	}
+
+	for _, tkn := range []string{"", "nodot", s.ID + ".wrong", "unknown.secret"} {
+		if _, _, err := m.Refresh(ctx, tkn); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%q: expected an invalid token, got %v", tkn, err)
+		}
+	}
+}
+
+// slowCache delays the reads, so concurrent refreshes all read the session before one stores it
+type slowCache struct {
+	cache.Cache
+}
+
+func (c slowCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	time.Sleep(20 * time.Millisecond)
+	return c.Cache.Get(ctx, key)
+}
+
+// slowAdder is a slowCache with the Add of its cache
+type slowAdder struct {
+	slowCache
+}
+
+func (c slowAdder) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.Cache.(cache.Adder).Add(ctx, key, value, ttl)
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	mem := cache.NewMemoryCache(cache.MemoryOpts{})
+	t.Cleanup(func() { _ = mem.Close() })
+	for name, c := range map[string]cache.Cache{"add": slowAdder{slowCache{mem}}, "no add": slowCache{mem}} {
+		t.Run(name, func(t *testing.T) { testConcurrentRefresh(t, c) })
+	}
+}
+
+func testConcurrentRefresh(t *testing.T, c cache.Cache) {
+	ctx := context.Background()
+	m := NewManager(Opts{Cache: c, ReuseGrace: time.Minute})
+
+	current, s, err := m.Create(ctx, userClaims("dev_user"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the tabs of a client refresh the same token at once: one rotates it, the others keep it
+	var wg sync.WaitGroup
+	next := make([]string, 10)
+	errs := make([]error, len(next))
+	for i := range next {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			next[i], _, errs[i] = m.Refresh(ctx, current)
+		}(i)
+	}
+	wg.Wait()
+	var rotated []string
+	for i, tkn := range next {
+		if errs[i] != nil {
+			t.Fatalf("unexpected error: %v", errs[i])
+		}
+		if tkn != "" {
+			rotated = append(rotated, tkn)
+		}
+	}
+	if len(rotated) != 1 {
+		t.Fatalf("expected one rotation, got %d", len(rotated))
+	}
+	if _, refreshed, err := m.Refresh(ctx, rotated[0]); err != nil || refreshed.ID != s.ID {
+		t.Errorf("the rotated token is not recorded: %v", err)
+	}
+}
+
+func TestRevocations(t *testing.T) {
+	ctx := context.Background()
+	m := newManager(t)
+
+	_, s1, _ := m.Create(ctx, userClaims("dev_user"), nil)
+	refresh2, s2, _ := m.Create(ctx, userClaims("dev_user"), nil)
+	_, _, _ = m.Create(ctx, userClaims("dev_other"), nil)
+
+	list, err := m.Sessions(ctx, "dev_user")
+	if err != nil || len(list) != 2 || list[0].ID != s1.ID || list[1].ID != s2.ID {
+		t.Fatalf("unexpected sessions: %+v %v", list, err)
+	}
+
+	if err := m.Revoke(ctx, s1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = m.Sessions(ctx, "dev_user"); len(list) != 1 {
+		t.Errorf("revoked session still listed: %+v", list)
+	}
+
+	// a token without session is revoked by its id
+	claims := userClaims("dev_other")
+	claims.Id, claims.ExpiresAt = "jti1", time.Now().Add(time.Minute).Unix()
+	if revoked, _ := m.IsRevoked(ctx, claims); revoked {
+		t.Error("token revoked before RevokeToken")
+	}
+	if err := m.RevokeToken(ctx, claims); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := m.IsRevoked(ctx, claims); !revoked {
+		t.Error("token not revoked")
+	}
+
+	// logging out of all devices revokes the sessions and the tokens issued until then
+	claims = userClaims("dev_user")
+	claims.IssuedAt = time.Now().Unix()
+	if err := m.RevokeUser(ctx, "dev_user"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = m.Sessions(ctx, "dev_user"); len(list) != 0 {
+		t.Errorf("sessions left after RevokeUser: %+v", list)
+	}
+	if _, _, err := m.Refresh(ctx, refresh2); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected an invalid token, got %v", err)
+	}
+	if revoked, _ := m.IsRevoked(ctx, claims); !revoked {
+		t.Error("token of the user not revoked")
+	}
+	if list, _ = m.Sessions(ctx, "dev_other"); len(list) != 1 {
+		t.Errorf("sessions of another user revoked: %+v", list)
+	}
+
+	// logging in again, in the second of the revocation
+	refresh3, s3, err := m.Create(ctx, userClaims("dev_user"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims.SessionID = s3.ID
+	if revoked, err := m.IsRevoked(ctx, claims); err != nil || revoked {
+		t.Errorf("token of the new session revoked: %v", err)
+	}
+	if _, _, err := m.Refresh(ctx, refresh3); err != nil {
+		t.Errorf("new session revoked: %v", err)
+	}
+}
+
+func TestTokenTransport(t *testing.T) {
+	m := newManager(t)
+	w := httptest.NewRecorder()
+	m.SetToken(w, "sid.secret")
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	if got := m.Token(req); got != "sid.secret" {
+		t.Errorf("unexpected token from cookie: %q", got)
+	}
+
+	m.SendHeader = true
+	w = httptest.NewRecorder()
+	m.SetToken(w, "sid.other")
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-JWT-Refresh", w.Header().Get("X-JWT-Refresh"))
+	if got := m.Token(req); got != "sid.other" {
+		t.Errorf("unexpected token from header: %q", got)
+	}
+}
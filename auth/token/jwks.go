@@ -0,0 +1,303 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// JWK is the JSON Web Key (RFC 7517) of a public key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // curve of EC and OKP keys
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as published at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// JWK returns the JSON Web Key of the public key
+func (k *Key) JWK() (JWK, error) {
+	res := JWK{Use: "sig", Kid: k.ID, Alg: k.Algorithm}
+	switch pub := k.PublicKey().(type) {
+	case *rsa.PublicKey:
+		res.Kty = "RSA"
+		res.N = b64.EncodeToString(pub.N.Bytes())
+		res.E = b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return JWK{}, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+		res.Kty, res.Crv = "EC", "P-256"
+		res.X = b64.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+		res.Y = b64.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		res.Kty, res.Crv = "OKP", "Ed25519"
+		res.X = b64.EncodeToString(pub)
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+	return res, nil
+}
+
+// Key returns the verifying key of the JSON Web Key. The algorithm
+// defaults to the one of the key type if the JWK has none.
+func (j JWK) Key() (*Key, error) {
+	res := &Key{ID: j.Kid, Algorithm: j.Alg}
+	switch j.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", j.Kid, err)
+		}
+		e, err := b64.DecodeString(j.E)
+		if err != nil || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent of key %q", j.Kid)
+		}
+		res.Public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if res.Algorithm == "" {
+			res.Algorithm = RS256
+		}
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q of key %q", j.Crv, j.Kid)
+		}
+		x, errX := b64.DecodeString(j.X)
+		y, errY := b64.DecodeString(j.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid coordinates of key %q", j.Kid)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("invalid point of key %q", j.Kid)
+		}
+		res.Public = pub
+		if res.Algorithm == "" {
+			res.Algorithm = ES256
+		}
+	case "OKP":
+		x, err := b64.DecodeString(j.X)
+		if j.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key %q", j.Kid)
+		}
+		res.Public = ed25519.PublicKey(x)
+		if res.Algorithm == "" {
+			res.Algorithm = EdDSA
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %q of key %q", j.Kty, j.Kid)
+	}
+	if _, err := res.method(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Thumbprint returns the JWK thumbprint (RFC 7638), used as id of generated keys
+func (j JWK) Thumbprint() (string, error) {
+	var members map[string]string
+	switch j.Kty {
+	case "RSA":
+		members = map[string]string{"e": j.E, "kty": j.Kty, "n": j.N}
+	case "EC":
+		members = map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X, "y": j.Y}
+	case "OKP":
+		members = map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+	b, err := json.Marshal(members) // maps are marshaled with sorted keys
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return b64.EncodeToString(sum[:]), nil
+}
+
+// NewJWKS makes the JSON Web Key Set of the keys verifying tokens
+func NewJWKS(ks KeySet) (JWKS, error) {
+	keys, err := ks.Keys()
+	if err != nil {
+		return JWKS{}, fmt.Errorf("can't get keys: %w", err)
+	}
+	res := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, k := range keys {
+		jwk, err := k.JWK()
+		if err != nil {
+			return JWKS{}, err
+		}
+		res.Keys = append(res.Keys, jwk)
+	}
+	return res, nil
+}
+
+const (
+	defaultJWKSRefresh    = time.Hour
+	defaultJWKSMinRefresh = time.Minute
+)
+
+// RemoteKeySet is a KeySet verifying the tokens of another service with the keys it publishes
+// at a JWKS URL. Keys are cached and fetched again once the refresh interval is over, or when a
+// token has an unknown kid, as after a rotation, at most once per minimal refresh interval.
+// Concurrent fetches are merged into one, made without holding the lock of the cached keys.
+// It can't sign tokens.
+type RemoteKeySet struct {
+	URL             string
+	Client          *http.Client  // default client with a 10s timeout
+	RefreshInterval time.Duration // default 1h
+	MinRefresh      time.Duration // default 1m, also limits the fetches of unknown kids
+
+	group     singleflight.Group
+	lock      sync.Mutex // guards the fields below, never held while fetching
+	keys      []*Key
+	fetched   time.Time
+	attempted time.Time
+}
+
+// NewRemoteKeySet makes a key set of the keys published at url
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:             url,
+		Client:          &http.Client{Timeout: 10 * time.Second},
+		RefreshInterval: defaultJWKSRefresh,
+		MinRefresh:      defaultJWKSMinRefresh,
+	}
+}
+
+// SigningKey always fails, remote keys verify tokens only
+func (r *RemoteKeySet) SigningKey() (*Key, error) {
+	return nil, fmt.Errorf("remote keys of %s can't sign tokens", r.URL)
+}
+
+// Key returns the key with given id, fetching the keys if it is unknown
+func (r *RemoteKeySet) Key(kid string) (*Key, error) {
+	err := r.refresh(false)
+	if k := r.find(kid); k != nil {
+		return k, nil
+	}
+	if err == nil {
+		err = r.refresh(true)
+	}
+	if k := r.find(kid); k != nil {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unknown key %q: %w", kid, err)
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// Keys returns the cached keys, fetched again if stale
+func (r *RemoteKeySet) Keys() ([]*Key, error) {
+	err := r.refresh(false)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err != nil && len(r.keys) == 0 {
+		return nil, err
+	}
+	return append([]*Key(nil), r.keys...), nil
+}
+
+func (r *RemoteKeySet) find(kid string) *Key {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, k := range r.keys {
+		if k.ID == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// refresh fetches the keys if they are stale, or if force is set and the last attempt is
+// older than the minimal refresh interval. Callers arriving during a fetch wait for it and
+// share its result. Cached keys are kept if the fetch fails.
+func (r *RemoteKeySet) refresh(force bool) error {
+	interval, minInterval := r.RefreshInterval, r.MinRefresh
+	if interval == 0 {
+		interval = defaultJWKSRefresh
+	}
+	if minInterval == 0 {
+		minInterval = defaultJWKSMinRefresh
+	}
+	r.lock.Lock()
+	stale := time.Since(r.fetched) >= interval
+	r.lock.Unlock()
+	if !force && !stale {
+		return nil
+	}
+
+	_, err, _ := r.group.Do("keys", func() (any, error) {
+		r.lock.Lock()
+		now := time.Now()
+		if now.Sub(r.attempted) < minInterval || (!force && now.Sub(r.fetched) < interval) {
+			r.lock.Unlock()
+			return nil, nil
+		}
+		r.attempted = now
+		r.lock.Unlock()
+
+		keys, err := r.fetch()
+		if err != nil {
+			return nil, err
+		}
+		r.lock.Lock()
+		r.keys, r.fetched = keys, now
+		r.lock.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+func (r *RemoteKeySet) fetch() ([]*Key, error) {
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Get(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't fetch keys from %s: status %d", r.URL, resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("can't decode keys from %s: %w", r.URL, err)
+	}
+	keys := make([]*Key, 0, len(jwks.Keys))
+	for _, j := range jwks.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		k, err := j.Key()
+		if err != nil {
+			continue // skip keys of unsupported types, others may still verify tokens
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
@@ -2,6 +2,7 @@
 package token
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -24,6 +25,25 @@ type Claims struct {
 	SessionOnly bool       `json:"sess_only,omitempty"`
 	Handshake   *Handshake `json:"handshake,omitempty"` // used for oauth handshake
 	NoAva       bool       `json:"no-ava,omitempty"`    // disable avatar, always use identicon
+	SessionID   string     `json:"sid,omitempty"`       // session refreshing the token, see auth/session
+	AMR         []string   `json:"amr,omitempty"`       // authentication methods of the user, see auth/mfa
+}
+
+// Authentication methods of the amr claim, RFC 8176
+const (
+	AMROTP         = "otp" // one-time password, e.g. TOTP or a recovery code
+	AMRHardwareKey = "hwk" // proof of possession of a key, e.g. WebAuthn
+	AMRMultiFactor = "mfa" // a second factor was verified
+)
+
+// HasMFA reports if the user of the claims verified a second factor
+func (c Claims) HasMFA() bool {
+	for _, m := range c.AMR {
+		if m == AMRMultiFactor {
+			return true
+		}
+	}
+	return false
 }
 
 // Handshake used for oauth handshake
@@ -31,6 +51,9 @@ type Handshake struct {
 	State string `json:"state,omitempty"`
 	From  string `json:"from,omitempty"`
 	ID    string `json:"id,omitempty"`
+
+	Nonce    string `json:"nonce,omitempty"`    // nonce of the OpenID Connect ID token
+	Verifier string `json:"verifier,omitempty"` // PKCE code verifier
 }
 
 const (
@@ -52,6 +75,7 @@ const (
 // Opts holds constructor params
 type Opts struct {
 	SecretReader   Secret
+	KeySet         KeySet // asymmetric keys signing tokens instead of SecretReader, verifying tokens with a kid
 	ClaimsUpd      ClaimsUpdater
 	SecureCookies  bool
 	TokenDuration  time.Duration
@@ -70,6 +94,7 @@ type Opts struct {
 	AudSecrets      bool          // uses different secret for differed auds. important: adds pre-parsing of unverified token
 	SendJWTHeader   bool          // if enabled send JWT as a header instead of cookie
 	SameSite        http.SameSite // define a cookie attribute making it impossible for the browser to send this cookie cross-site
+	Revocations     Revocations   // optional check of revoked tokens, i.e. after a logout on another replica
 }
 
 // NewService makes JWT service
@@ -111,9 +136,7 @@ func (j *Service) Token(claims Claims) (string, error) {
 		claims = j.ClaimsUpd.Update(claims)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	if j.SecretReader == nil {
+	if j.SecretReader == nil && j.KeySet == nil {
 		return "", fmt.Errorf("secret reader not defined")
 	}
 
@@ -121,6 +144,11 @@ func (j *Service) Token(claims Claims) (string, error) {
 		return "", fmt.Errorf("aud rejected: %w", err)
 	}
 
+	if j.KeySet != nil {
+		return j.signedToken(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	secret, err := j.SecretReader.Get(claims.Audience) // get secret via consumer defined SecretReader
 	if err != nil {
 		return "", fmt.Errorf("can't get secret: %w", err)
@@ -133,33 +161,45 @@ func (j *Service) Token(claims Claims) (string, error) {
 	return tokenString, nil
 }
 
+// signedToken makes token signed with the signing key of KeySet, identified by the kid header
+func (j *Service) signedToken(claims Claims) (string, error) {
+	key, err := j.KeySet.SigningKey()
+	if err != nil {
+		return "", fmt.Errorf("can't get signing key: %w", err)
+	}
+	method, err := key.method()
+	if err != nil {
+		return "", fmt.Errorf("can't sign token: %w", err)
+	}
+	if key.Private == nil {
+		return "", fmt.Errorf("can't sign token: key %q has no private key", key.ID)
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.Private)
+	if err != nil {
+		return "", fmt.Errorf("can't sign token: %w", err)
+	}
+	return tokenString, nil
+}
+
 // Parse token string and verify. Not checking for expiration
 func (j *Service) Parse(tokenString string) (Claims, error) {
 	parser := jwt.Parser{SkipClaimsValidation: true} // allow parsing of expired tokens
 
-	if j.SecretReader == nil {
+	if j.SecretReader == nil && j.KeySet == nil {
 		return Claims{}, fmt.Errorf("secret reader not defined")
 	}
 
-	aud := "ignore"
-	if j.AudSecrets {
-		var err error
-		aud, err = j.aud(tokenString)
-		if err != nil {
-			return Claims{}, fmt.Errorf("can't retrieve audience from the token")
-		}
-	}
-
-	secret, err := j.SecretReader.Get(aud)
-	if err != nil {
-		return Claims{}, fmt.Errorf("can't get secret: %w", err)
-	}
-
 	token, err := parser.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok && j.SecretReader != nil {
+			return j.secret(tokenString)
+		}
+		if j.KeySet != nil {
+			return j.verifyingKey(token)
 		}
-		return []byte(secret), nil
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 	})
 	if err != nil {
 		return Claims{}, fmt.Errorf("can't parse token: %w", err)
@@ -176,6 +216,41 @@ func (j *Service) Parse(tokenString string) (Claims, error) {
 	return *claims, j.validate(claims)
 }
 
+// secret returns the HMAC secret verifying the token
+func (j *Service) secret(tokenString string) (interface{}, error) {
+	aud := "ignore"
+	if j.AudSecrets {
+		var err error
+		aud, err = j.aud(tokenString)
+		if err != nil {
+			return nil, fmt.Errorf("can't retrieve audience from the token")
+		}
+	}
+
+	secret, err := j.SecretReader.Get(aud)
+	if err != nil {
+		return nil, fmt.Errorf("can't get secret: %w", err)
+	}
+	return []byte(secret), nil
+}
+
+// verifyingKey returns the public key of KeySet with the kid of the token,
+// rejecting tokens signed with another algorithm than the one of the key
+func (j *Service) verifyingKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("no kid in token signed with %v", token.Header["alg"])
+	}
+	key, err := j.KeySet.Key(kid)
+	if err != nil {
+		return nil, fmt.Errorf("can't get key: %w", err)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], kid)
+	}
+	return key.PublicKey(), nil
+}
+
 // aud pre-parse token and extracts aud from the claim
 // important! this step ignores token verification, should not be used for any validations
 func (j *Service) aud(tokenString string) (string, error) {
@@ -293,6 +368,16 @@ func (j *Service) Get(r *http.Request) (Claims, string, error) {
 		return Claims{}, "", fmt.Errorf("token expired")
 	}
 
+	if j.Revocations != nil {
+		revoked, err := j.Revocations.IsRevoked(r.Context(), claims)
+		if err != nil {
+			return Claims{}, "", fmt.Errorf("can't check revocation: %w", err)
+		}
+		if revoked {
+			return Claims{}, "", fmt.Errorf("token revoked")
+		}
+	}
+
 	if j.DisableXSRF {
 		return claims, tokenString, nil
 	}
@@ -391,6 +476,11 @@ func (f ValidatorFunc) Validate(token string, claims Claims) bool {
 	return f(token, claims)
 }
 
+// Revocations defines interface checking if a valid token was revoked
+type Revocations interface {
+	IsRevoked(ctx context.Context, claims Claims) (bool, error)
+}
+
 // Audience defines interface returning list of allowed audiences
 type Audience interface {
 	Get() ([]string, error)
@@ -0,0 +1,212 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// algorithms of the asymmetric keys signing tokens
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Key is an asymmetric key signing or verifying tokens
+type Key struct {
+	ID        string           // kid header of the tokens signed with the key
+	Algorithm string           // RS256, ES256 or EdDSA
+	Private   crypto.Signer    // signing key, nil for keys verifying tokens only
+	Public    crypto.PublicKey // verifying key, public part of Private if not set
+	Expires   time.Time        // end of the verification with a retired key, zero if the key is not retired
+}
+
+// GenerateKey makes a key for the algorithm, with its thumbprint as id
+func GenerateKey(alg string) (*Key, error) {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+	switch alg {
+	case RS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't generate %s key: %w", alg, err)
+	}
+
+	k := &Key{Algorithm: alg, Private: priv}
+	jwk, err := k.JWK()
+	if err != nil {
+		return nil, err
+	}
+	if k.ID, err = jwk.Thumbprint(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// PublicKey returns the verifying key
+func (k *Key) PublicKey() crypto.PublicKey {
+	if k.Public == nil && k.Private != nil {
+		return k.Private.Public()
+	}
+	return k.Public
+}
+
+// method returns the signing method of the key, checking the key fits its algorithm
+func (k *Key) method() (jwt.SigningMethod, error) {
+	var ok bool
+	switch pub := k.PublicKey().(type) {
+	case *rsa.PublicKey:
+		ok = k.Algorithm == RS256
+	case *ecdsa.PublicKey:
+		ok = k.Algorithm == ES256 && pub.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		ok = k.Algorithm == EdDSA
+	}
+	if !ok {
+		return nil, fmt.Errorf("key %q is not a %s key", k.ID, k.Algorithm)
+	}
+	return jwt.GetSigningMethod(k.Algorithm), nil
+}
+
+// expired checks if a retired key stopped verifying tokens
+func (k *Key) expired(now time.Time) bool {
+	return !k.Expires.IsZero() && now.After(k.Expires)
+}
+
+// KeySet defines interface returning asymmetric keys to sign and verify tokens
+type KeySet interface {
+	SigningKey() (*Key, error)    // current key signing new tokens
+	Key(kid string) (*Key, error) // key verifying the tokens with given kid
+	Keys() ([]*Key, error)        // keys verifying tokens, published as JWKS
+}
+
+// KeyRing is a KeySet signing with its newest key. Rotation retires the signing key,
+// which keeps verifying the tokens it signed for the retention period.
+type KeyRing struct {
+	alg    string
+	retain time.Duration
+
+	lock sync.RWMutex
+	keys []*Key // newest last
+}
+
+// NewKeyRing makes a key ring with a generated key for alg. The retention should cover the
+// lifetime of the tokens, including the cookie duration as expired cookie tokens are refreshed.
+func NewKeyRing(alg string, retain time.Duration) (*KeyRing, error) {
+	r := &KeyRing{alg: alg, retain: retain}
+	if _, err := r.Rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Add makes k the signing key, retiring the current one. Replicas of a service
+// sharing keys load them with Add instead of generating their own.
+func (r *KeyRing) Add(k *Key) error {
+	if k.Private == nil {
+		return fmt.Errorf("key %q has no private key", k.ID)
+	}
+	if _, err := k.method(); err != nil {
+		return err
+	}
+	if k.ID == "" {
+		return fmt.Errorf("key has no id")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	keys := r.keys[:0]
+	for _, old := range r.keys {
+		if old.ID == k.ID || old.expired(now) {
+			continue
+		}
+		if old.Expires.IsZero() {
+			retired := *old
+			retired.Expires = now.Add(r.retain)
+			old = &retired
+		}
+		keys = append(keys, old)
+	}
+	r.keys = append(keys, k)
+	return nil
+}
+
+// Rotate generates a new signing key and retires the current one
+func (r *KeyRing) Rotate() (*Key, error) {
+	k, err := GenerateKey(r.alg)
+	if err != nil {
+		return nil, err
+	}
+	return k, r.Add(k)
+}
+
+// RotateEvery rotates the keys at every interval until ctx is done
+func (r *KeyRing) RotateEvery(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := r.Rotate(); err != nil {
+				return fmt.Errorf("can't rotate keys: %w", err)
+			}
+		}
+	}
+}
+
+// SigningKey returns the newest key
+func (r *KeyRing) SigningKey() (*Key, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.keys) == 0 {
+		return nil, fmt.Errorf("no signing key")
+	}
+	return r.keys[len(r.keys)-1], nil
+}
+
+// Key returns the key with given id, unless it is expired
+func (r *KeyRing) Key(kid string) (*Key, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, k := range r.keys {
+		if k.ID == kid && !k.expired(time.Now()) {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// Keys returns the signing key and the retired keys not expired yet
+func (r *KeyRing) Keys() ([]*Key, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	res := make([]*Key, 0, len(r.keys))
+	for _, k := range r.keys {
+		if !k.expired(time.Now()) {
+			res = append(res, k)
+		}
+	}
+	return res, nil
+}
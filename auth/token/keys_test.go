@@ -0,0 +1,250 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func testClaims() Claims {
+	return Claims{
+		StandardClaims: jwt.StandardClaims{Id: "id1", Audience: "test", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		User:           &User{ID: "dev_user", Name: "user"},
+	}
+}
+
+func TestKeyRing(t *testing.T) {
+	for _, alg := range []string{RS256, ES256, EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			ring, err := NewKeyRing(alg, 50*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc := NewService(Opts{KeySet: ring})
+
+			old, err := svc.Token(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			oldKey, _ := ring.SigningKey()
+			if tkn, _, _ := new(jwt.Parser).ParseUnverified(old, &Claims{}); tkn.Header["kid"] != oldKey.ID || tkn.Header["alg"] != alg {
+				t.Errorf("unexpected header: %v", tkn.Header)
+			}
+			if c, err := svc.Parse(old); err != nil || c.User.ID != "dev_user" {
+				t.Fatalf("can't parse token: %v", err)
+			}
+
+			newKey, err := ring.Rotate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tkn, err := svc.Token(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.Parse(tkn); err != nil {
+				t.Errorf("can't parse token of the new key: %v", err)
+			}
+			if _, err := svc.Parse(old); err != nil {
+				t.Errorf("can't parse token of the retired key: %v", err)
+			}
+			jwks, err := NewJWKS(ring)
+			if err != nil || len(jwks.Keys) != 2 || jwks.Keys[1].Kid != newKey.ID {
+				t.Errorf("unexpected keys: %+v %v", jwks, err)
+			}
+
+			time.Sleep(60 * time.Millisecond)
+			if _, err := svc.Parse(old); err == nil {
+				t.Error("expected an error for the token of an expired key")
+			}
+			if keys, _ := ring.Keys(); len(keys) != 1 {
+				t.Errorf("expired key still published: %d keys", len(keys))
+			}
+		})
+	}
+}
+
+func TestKeySetRejectsOtherSignatures(t *testing.T) {
+	ring, err := NewKeyRing(ES256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(Opts{KeySet: ring})
+
+	// HMAC tokens are rejected without SecretReader
+	hmac := NewService(Opts{SecretReader: SecretFunc(func(string) (string, error) { return "secret", nil })})
+	tkn, err := hmac.Token(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Parse(tkn); err == nil {
+		t.Error("expected an error for a HMAC token")
+	}
+	// and accepted along with the key set's tokens with it, for migrations
+	svc.SecretReader = hmac.SecretReader
+	if _, err := svc.Parse(tkn); err != nil {
+		t.Errorf("can't parse HMAC token: %v", err)
+	}
+
+	// the algorithm must be the one of the key
+	other, _ := GenerateKey(EdDSA)
+	current, _ := ring.SigningKey()
+	other.ID = current.ID
+	forged := NewService(Opts{KeySet: staticKeys{other}})
+	if tkn, err = forged.Token(testClaims()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Parse(tkn); err == nil || !strings.Contains(err.Error(), "unexpected signing method") {
+		t.Errorf("expected an error for a token of another algorithm, got %v", err)
+	}
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	ring, err := NewKeyRing(RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		jwks, err := NewJWKS(ring)
+		if err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	defer srv.Close()
+
+	issuer := NewService(Opts{KeySet: ring})
+	remote := NewRemoteKeySet(srv.URL)
+	remote.MinRefresh = time.Millisecond
+	verifier := NewService(Opts{KeySet: remote})
+
+	tkn, err := issuer.Token(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.Parse(tkn); err != nil {
+			t.Fatalf("can't verify token: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("keys fetched %d times, want once", n)
+	}
+
+	// a rotated key is fetched on its first token
+	if _, err := ring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if tkn, err = issuer.Token(testClaims()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Parse(tkn); err != nil {
+		t.Errorf("can't verify token of the rotated key: %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("keys fetched %d times, want twice", n)
+	}
+
+	if _, err := verifier.Token(testClaims()); err == nil {
+		t.Error("expected an error signing with remote keys")
+	}
+}
+
+func TestRemoteKeySetConcurrent(t *testing.T) {
+	ring, err := NewKeyRing(RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	var block atomic.Value // channel the handler waits on
+	unblocked := make(chan struct{})
+	close(unblocked)
+	block.Store(unblocked)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-block.Load().(chan struct{})
+		time.Sleep(20 * time.Millisecond)
+		jwks, err := NewJWKS(ring)
+		if err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	defer srv.Close()
+	signing, err := ring.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent requests of a cold key set share one fetch
+	remote := NewRemoteKeySet(srv.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := remote.Key(signing.ID); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("keys fetched %d times, want once", n)
+	}
+
+	// unknown kids are fetched at most once per MinRefresh
+	for i := 0; i < 10; i++ {
+		if _, err := remote.Key("unknown"); err == nil {
+			t.Error("expected an error for an unknown kid")
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("keys fetched %d times for unknown kids, want once", n)
+	}
+
+	// the cached keys are served while a fetch is blocked
+	remote.MinRefresh = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	release := make(chan struct{})
+	block.Store(release)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = remote.Key("unknown")
+	}()
+	for atomic.LoadInt32(&fetches) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	keys := make(chan int, 1)
+	go func() {
+		list, _ := remote.Keys()
+		keys <- len(list)
+	}()
+	select {
+	case n := <-keys:
+		if n != 1 {
+			t.Errorf("expected the cached key, got %d keys", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("Keys blocked by the fetch of an unknown kid")
+	}
+	close(release)
+	<-done
+}
+
+type staticKeys []*Key
+
+func (s staticKeys) SigningKey() (*Key, error)    { return s[0], nil }
+func (s staticKeys) Key(kid string) (*Key, error) { return s[0], nil }
+func (s staticKeys) Keys() ([]*Key, error)        { return s, nil }
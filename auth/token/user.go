@@ -15,8 +15,13 @@ var reValidSha = regexp.MustCompile("^[a-fA-F0-9]{40}$")
 var reValidCrc64 = regexp.MustCompile("^[a-fA-F0-9]{16}$")
 
 const (
-	adminAttr          = "admin"       // predefined attribute key for bool isAdmin status
-	paidSubscriberAttr = "is_paid_sub" // predefined attribute key for bool paid subscriptions status
+	adminAttr          = "admin"           // predefined attribute key for bool isAdmin status
+	paidSubscriberAttr = "is_paid_sub"     // predefined attribute key for bool paid subscriptions status
+	serviceAccountAttr = "service_account" // predefined attribute key for bool service account status
+	emailVerifiedAttr  = "email_verified"  // predefined attribute key for bool verified email status
+
+	// ScopesAttr is the predefined attribute key for the slice of scopes of a service account
+	ScopesAttr = "scopes"
 )
 
 // User is the basic part of oauth data provided by service
@@ -88,13 +93,43 @@ func (u *User) IsPaidSub() bool {
 	return u.BoolAttr(paidSubscriberAttr)
 }
 
-// SliceAttr gets slice attribute
+// SetServiceAccount is a shortcut to set "service_account" attribute
+func (u *User) SetServiceAccount(val bool) {
+	u.SetBoolAttr(serviceAccountAttr, val)
+}
+
+// IsServiceAccount is a shortcut to get "service_account" attribute
+func (u *User) IsServiceAccount() bool {
+	return u.BoolAttr(serviceAccountAttr)
+}
+
+// SetEmailVerified is a shortcut to set "email_verified" attribute, for the emails verified by the provider
+func (u *User) SetEmailVerified(val bool) {
+	u.SetBoolAttr(emailVerifiedAttr, val)
+}
+
+// IsEmailVerified is a shortcut to get "email_verified" attribute
+func (u *User) IsEmailVerified() bool {
+	return u.BoolAttr(emailVerifiedAttr)
+}
+
+// SliceAttr gets slice attribute, also the one decoded from a token as a list of strings
 func (u *User) SliceAttr(key string) []string {
-	r, ok := u.Attributes[key].([]string)
-	if !ok {
-		return []string{}
+	switch r := u.Attributes[key].(type) {
+	case []string:
+		return r
+	case []interface{}:
+		res := make([]string, 0, len(r))
+		for _, v := range r {
+			s, ok := v.(string)
+			if !ok {
+				return []string{}
+			}
+			res = append(res, s)
+		}
+		return res
 	}
-	return r
+	return []string{}
 }
 
 // SetSliceAttr sets slice attribute for given key
@@ -158,6 +193,17 @@ func SetUserInfo(r *http.Request, user User) *http.Request {
 	return r.WithContext(ctx)
 }
 
+// GetAMR returns the authentication methods of the token of the request, set by the auth middlewares
+func GetAMR(r *http.Request) []string {
+	amr, _ := r.Context().Value(contextKey("amr")).([]string)
+	return amr
+}
+
+// SetAMR sets the authentication methods of the token into request context
+func SetAMR(r *http.Request, amr []string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKey("amr"), amr))
+}
+
 // SetRole sets user role for RBAC
 func (u *User) SetRole(role string) {
 	u.Role = role
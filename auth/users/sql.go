@@ -0,0 +1,315 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mkfst/db"
+)
+
+// SQLOpts configures NewSQLStore
+type SQLOpts struct {
+	// TablePrefix is prepended to the accounts and identities table names. Default "mkfst_".
+	TablePrefix string
+}
+
+type sqlDialect int
+
+const (
+	sqlDialectSQLite sqlDialect = iota
+	sqlDialectPostgres
+	sqlDialectMySQL
+)
+
+func dialectFor(connType string) (sqlDialect, error) {
+	switch strings.ToUpper(connType) {
+	case "", "SQLITE":
+		return sqlDialectSQLite, nil
+	case "POSTGRESQL", "POSTGRES":
+		return sqlDialectPostgres, nil
+	case "MYSQL":
+		return sqlDialectMySQL, nil
+	default:
+		return 0, fmt.Errorf("users.NewSQLStore: unsupported db type %q", connType)
+	}
+}
+
+// NewSQLStore returns a Store backed by an SQL database accessed through
+// mkfst's db.Connection. Supports PostgreSQL, MySQL 5.7+, and SQLite.
+//
+// On first use, runs idempotent CREATE TABLE IF NOT EXISTS migrations.
+// Times are stored as unix nanoseconds, roles as a space separated list
+// and attributes as JSON.
+func NewSQLStore(conn *db.Connection, opts SQLOpts) (Store, error) {
+	if conn == nil || conn.Conn == nil {
+		return nil, errors.New("users.NewSQLStore: nil connection")
+	}
+	d, err := dialectFor(conn.Config.Type)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TablePrefix == "" {
+		opts.TablePrefix = "mkfst_"
+	}
+	s := &sqlStore{db: conn.Conn, dialect: d, opts: opts}
+	if err := s.migrate(context.Background()); err != nil {
+		return nil, fmt.Errorf("users.NewSQLStore: migrate: %w", err)
+	}
+	return s, nil
+}
+
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+	opts    SQLOpts
+}
+
+func (s *sqlStore) accounts() string   { return s.opts.TablePrefix + "accounts" }
+func (s *sqlStore) identities() string { return s.opts.TablePrefix + "identities" }
+
+const (
+	accountColumns  = `id, name, email, email_key, email_verified, picture, role, roles, attrs, created_at, updated_at`
+	identityColumns = `id, provider, account_id, name, email, email_verified, created_at, last_login`
+)
+
+func (s *sqlStore) migrate(ctx context.Context) error {
+	a, i := s.accounts(), s.identities()
+	accountsIdx, identitiesIdx, opts := "", "", ""
+	if s.dialect == sqlDialectMySQL {
+		accountsIdx = fmt.Sprintf(",\n\t\t\tINDEX %s_email_key (email_key)", a)
+		identitiesIdx = fmt.Sprintf(",\n\t\t\tINDEX %s_account_id (account_id)", i)
+		opts = " ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	}
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id             VARCHAR(64) PRIMARY KEY,
+			name           VARCHAR(255) NOT NULL,
+			email          VARCHAR(255) NOT NULL,
+			email_key      VARCHAR(255) NOT NULL,
+			email_verified SMALLINT NOT NULL,
+			picture        TEXT NOT NULL,
+			role           VARCHAR(255) NOT NULL,
+			roles          TEXT NOT NULL,
+			attrs          TEXT NOT NULL,
+			created_at     BIGINT NOT NULL,
+			updated_at     BIGINT NOT NULL%s
+		)%s`, a, accountsIdx, opts),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id             VARCHAR(255) PRIMARY KEY,
+			provider       VARCHAR(64) NOT NULL,
+			account_id     VARCHAR(64) NOT NULL,
+			name           VARCHAR(255) NOT NULL,
+			email          VARCHAR(255) NOT NULL,
+			email_verified SMALLINT NOT NULL,
+			created_at     BIGINT NOT NULL,
+			last_login     BIGINT NOT NULL%s
+		)%s`, i, identitiesIdx, opts),
+	}
+	if s.dialect != sqlDialectMySQL {
+		stmts = append(stmts,
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_email_key ON %s (email_key)`, a, a),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_account_id ON %s (account_id)`, i, i))
+	}
+	for _, stmt := range stmts {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) Account(ctx context.Context, id string) (Account, error) {
+	q := s.rebind(`SELECT ` + accountColumns + ` FROM ` + s.accounts() + ` WHERE id = ?`)
+	return s.getAccount(ctx, q, id)
+}
+
+func (s *sqlStore) AccountByEmail(ctx context.Context, email string) (Account, error) {
+	q := s.rebind(`SELECT ` + accountColumns + ` FROM ` + s.accounts() +
+		` WHERE email_key = ? AND email_verified = 1 ORDER BY created_at, id`)
+	return s.getAccount(ctx, q, strings.ToLower(email))
+}
+
+func (s *sqlStore) getAccount(ctx context.Context, q string, arg string) (Account, error) {
+	a, err := scanAccount(s.db.QueryRowContext(ctx, q, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrNotFound
+	}
+	if err != nil {
+		return Account{}, fmt.Errorf("get account: %w", err)
+	}
+	return a, nil
+}
+
+func (s *sqlStore) SaveAccount(ctx context.Context, a Account) error {
+	attrs, err := json.Marshal(a.Attributes)
+	if err != nil {
+		return fmt.Errorf("encode attributes: %w", err)
+	}
+	q := s.upsert(s.accounts(), accountColumns)
+	_, err = s.db.ExecContext(ctx, q, a.ID, a.Name, a.Email, strings.ToLower(a.Email), encodeBool(a.EmailVerified),
+		a.Picture, a.Role, strings.Join(a.Roles, " "), string(attrs), encodeTime(a.CreatedAt), encodeTime(a.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("save account: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteAccount(ctx context.Context, id string) error {
+	return s.delete(ctx, s.accounts(), id)
+}
+
+func (s *sqlStore) Identity(ctx context.Context, id string) (Identity, error) {
+	q := s.rebind(`SELECT ` + identityColumns + ` FROM ` + s.identities() + ` WHERE id = ?`)
+	i, err := scanIdentity(s.db.QueryRowContext(ctx, q, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, ErrNotFound
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("get identity: %w", err)
+	}
+	return i, nil
+}
+
+func (s *sqlStore) Identities(ctx context.Context, accountID string) ([]Identity, error) {
+	q := s.rebind(`SELECT ` + identityColumns + ` FROM ` + s.identities() + ` WHERE account_id = ? ORDER BY created_at, id`)
+	rows, err := s.db.QueryContext(ctx, q, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("list identities: %w", err)
+	}
+	defer rows.Close()
+
+	var res []Identity
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list identities: %w", err)
+		}
+		res = append(res, i)
+	}
+	return res, rows.Err()
+}
+
+func (s *sqlStore) SaveIdentity(ctx context.Context, i Identity) error {
+	q := s.upsert(s.identities(), identityColumns)
+	_, err := s.db.ExecContext(ctx, q, i.ID, i.Provider, i.AccountID, i.Name, i.Email, encodeBool(i.EmailVerified),
+		encodeTime(i.CreatedAt), encodeTime(i.LastLogin))
+	if err != nil {
+		return fmt.Errorf("save identity: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteIdentity(ctx context.Context, id string) error {
+	return s.delete(ctx, s.identities(), id)
+}
+
+func (s *sqlStore) delete(ctx context.Context, table, id string) error {
+	q := s.rebind(`DELETE FROM ` + table + ` WHERE id = ?`)
+	res, err := s.db.ExecContext(ctx, q, id)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// === helpers ===
+
+// upsert returns the statement inserting or replacing a row of the columns, keyed by id
+func (s *sqlStore) upsert(table, columns string) string {
+	cols := strings.Split(columns, ", ")
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+	sets := make([]string, 0, len(cols)-1)
+	for _, c := range cols[1:] {
+		switch s.dialect {
+		case sqlDialectMySQL:
+			sets = append(sets, c+" = VALUES("+c+")")
+		default: // SQLite, Postgres
+			sets = append(sets, c+" = excluded."+c)
+		}
+	}
+	q := `INSERT INTO ` + table + ` (` + columns + `) VALUES (` + marks + `)`
+	if s.dialect == sqlDialectMySQL {
+		return q + ` ON DUPLICATE KEY UPDATE ` + strings.Join(sets, ", ")
+	}
+	return s.rebind(q + ` ON CONFLICT (id) DO UPDATE SET ` + strings.Join(sets, ", "))
+}
+
+// scanAccount reads a row of accountColumns
+func scanAccount(row interface{ Scan(...interface{}) error }) (Account, error) {
+	var a Account
+	var emailKey, roles, attrs string
+	var verified int
+	var created, updated int64
+	err := row.Scan(&a.ID, &a.Name, &a.Email, &emailKey, &verified, &a.Picture, &a.Role, &roles, &attrs, &created, &updated)
+	if err != nil {
+		return Account{}, err
+	}
+	if err = json.Unmarshal([]byte(attrs), &a.Attributes); err != nil {
+		return Account{}, fmt.Errorf("decode attributes: %w", err)
+	}
+	a.EmailVerified, a.Roles = verified == 1, strings.Fields(roles)
+	a.CreatedAt, a.UpdatedAt = decodeTime(created), decodeTime(updated)
+	return a, nil
+}
+
+// scanIdentity reads a row of identityColumns
+func scanIdentity(row interface{ Scan(...interface{}) error }) (Identity, error) {
+	var i Identity
+	var verified int
+	var created, lastLogin int64
+	err := row.Scan(&i.ID, &i.Provider, &i.AccountID, &i.Name, &i.Email, &verified, &created, &lastLogin)
+	if err != nil {
+		return Identity{}, err
+	}
+	i.EmailVerified = verified == 1
+	i.CreatedAt, i.LastLogin = decodeTime(created), decodeTime(lastLogin)
+	return i, nil
+}
+
+// rebind converts ?-style placeholders to PG's $1, $2 form.
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != sqlDialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query))
+	idx := 1
+	for _, r := range query {
+		if r == '?' {
+			fmt.Fprintf(&b, "$%d", idx)
+			idx++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func encodeBool(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func encodeTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func decodeTime(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v).UTC()
+}
@@ -0,0 +1,395 @@
+// Package users keeps the users of the auth service and links the identities of the login
+// providers to them. Each provider makes its own user id, like "github_<hash>"; the Manager
+// records it as an identity of a canonical account with an id like "acct_<random>", and the
+// tokens of every linked identity carry the account id.
+//
+// A new identity is linked to an existing account when both have the same verified email and
+// Opts.LinkVerifiedEmail is set, or explicitly: a logged in user makes a link code with
+// StartLink, logs in with another provider and redeems the code with Link.
+//
+// The accounts carry the profile, role, roles and attributes of the users, added to their
+// tokens by Update, the token.ClaimsUpdater of the Manager.
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"mkfst/auth/logger"
+	"mkfst/auth/token"
+	"mkfst/providers/cache"
+)
+
+// Errors of the Manager and the stores
+var (
+	ErrNotFound     = errors.New("users: not found")
+	ErrInvalidCode  = errors.New("users: invalid or expired link code")
+	ErrLinked       = errors.New("users: identity already linked to the account")
+	ErrLastIdentity = errors.New("users: can't unlink the last identity of an account")
+	ErrNoLinking    = errors.New("users: explicit linking not enabled")
+)
+
+const (
+	// IdentityAttr is the user attribute keeping the identity of the login, i.e. "github_<hash>"
+	IdentityAttr = "identity"
+
+	// RolesAttr is the user attribute keeping the roles of the account, read by policy.SubjectFromUser
+	RolesAttr = "roles"
+
+	accountPrefix   = "acct_"
+	defaultLinkTTL  = 10 * time.Minute
+	linkCachePrefix = "users:link:"
+)
+
+// Account is the canonical user, with the identities of its logins
+type Account struct {
+	ID            string                 `json:"id"`
+	Name          string                 `json:"name"`
+	Email         string                 `json:"email,omitempty"`
+	EmailVerified bool                   `json:"email_verified,omitempty"`
+	Picture       string                 `json:"picture,omitempty"`
+	Role          string                 `json:"role,omitempty"`
+	Roles         []string               `json:"roles,omitempty"`
+	Attributes    map[string]interface{} `json:"attrs,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+}
+
+// Identity is the user of a login provider, linked to an account
+type Identity struct {
+	ID            string    `json:"id"`       // user id made by the provider, i.e. "github_<hash>"
+	Provider      string    `json:"provider"` // name of the provider, the prefix of ID
+	AccountID     string    `json:"account_id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email,omitempty"`
+	EmailVerified bool      `json:"email_verified,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastLogin     time.Time `json:"last_login"`
+}
+
+// Store keeps the accounts and identities. Get methods return ErrNotFound for missing ones.
+type Store interface {
+	Account(ctx context.Context, id string) (Account, error)
+	// AccountByEmail returns the account with the verified email, compared case-insensitively
+	AccountByEmail(ctx context.Context, email string) (Account, error)
+	SaveAccount(ctx context.Context, a Account) error
+	DeleteAccount(ctx context.Context, id string) error
+
+	Identity(ctx context.Context, id string) (Identity, error)
+	Identities(ctx context.Context, accountID string) ([]Identity, error)
+	SaveIdentity(ctx context.Context, i Identity) error
+	DeleteIdentity(ctx context.Context, id string) error
+}
+
+// Opts is a set of parameters of Manager
+type Opts struct {
+	Store Store // store of the accounts, required
+
+	// LinkVerifiedEmail links a new identity with a verified email to the account with the same
+	// verified email. Enable it only with providers verifying the emails they report.
+	LinkVerifiedEmail bool
+
+	Cache   cache.Cache   // keeps the codes of the explicit linking, required for it
+	LinkTTL time.Duration // lifetime of a link code, default 10m
+
+	L logger.L // logger of the failures of Update, default no logging
+}
+
+// Manager upserts the users on login and links their identities
+type Manager struct {
+	Opts
+	now  func() time.Time
+	lock sync.Mutex // serializes the logins and links in this process
+}
+
+// NewManager makes a users manager
+func NewManager(opts Opts) *Manager {
+	res := Manager{Opts: opts, now: time.Now}
+	if res.LinkTTL == 0 {
+		res.LinkTTL = defaultLinkTTL
+	}
+	if res.L == nil {
+		res.L = logger.NoOp
+	}
+	return &res
+}
+
+// Login records the login of a provider user and returns the user of its account. A new
+// identity is linked to the account with its verified email, if enabled, or to a new account.
+func (m *Manager) Login(ctx context.Context, u token.User) (token.User, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now().UTC()
+	ident, err := m.Store.Identity(ctx, u.ID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		provider, _, _ := strings.Cut(u.ID, "_")
+		ident = Identity{ID: u.ID, Provider: provider, CreatedAt: now}
+	case err != nil:
+		return token.User{}, fmt.Errorf("users: can't get identity: %w", err)
+	}
+	ident.Name, ident.Email, ident.EmailVerified, ident.LastLogin = u.Name, u.Email, u.IsEmailVerified(), now
+
+	var acc Account
+	if ident.AccountID != "" {
+		if acc, err = m.Store.Account(ctx, ident.AccountID); err != nil && !errors.Is(err, ErrNotFound) {
+			return token.User{}, fmt.Errorf("users: can't get account: %w", err)
+		}
+	}
+	if acc.ID == "" && m.LinkVerifiedEmail && ident.EmailVerified && ident.Email != "" {
+		if acc, err = m.Store.AccountByEmail(ctx, ident.Email); err != nil && !errors.Is(err, ErrNotFound) {
+			return token.User{}, fmt.Errorf("users: can't get account: %w", err)
+		}
+	}
+	if acc.ID == "" {
+		if acc, err = m.newAccount(now); err != nil {
+			return token.User{}, err
+		}
+	}
+
+	// fill the profile of the account from its logins, the stored values win
+	changed := acc.UpdatedAt.IsZero()
+	setDefault := func(fld *string, val string) {
+		if *fld == "" && val != "" {
+			*fld, changed = val, true
+		}
+	}
+	setDefault(&acc.Name, u.Name)
+	setDefault(&acc.Picture, u.Picture)
+	setDefault(&acc.Email, u.Email)
+	if ident.EmailVerified && strings.EqualFold(acc.Email, ident.Email) && !acc.EmailVerified {
+		acc.EmailVerified, changed = true, true
+	}
+	if changed {
+		acc.UpdatedAt = now
+		if err = m.Store.SaveAccount(ctx, acc); err != nil {
+			return token.User{}, fmt.Errorf("users: can't save account: %w", err)
+		}
+	}
+
+	ident.AccountID = acc.ID
+	if err = m.Store.SaveIdentity(ctx, ident); err != nil {
+		return token.User{}, fmt.Errorf("users: can't save identity: %w", err)
+	}
+	return acc.apply(u, ident.ID), nil
+}
+
+// Update implements token.ClaimsUpdater, setting the user of the claims to the one of its account
+// with the stored profile, roles and attributes. Claims of an unknown identity are recorded as a
+// login, and the ones of an account deleted by a link move to the account of their identity.
+func (m *Manager) Update(claims token.Claims) token.Claims {
+	if claims.User == nil || claims.Handshake != nil {
+		return claims
+	}
+	ctx := context.Background()
+	acc, err := m.Store.Account(ctx, claims.User.ID)
+	if err == nil {
+		u := acc.apply(*claims.User, claims.User.StrAttr(IdentityAttr))
+		claims.User = &u
+		return claims
+	}
+	if !errors.Is(err, ErrNotFound) {
+		m.L.Logf("[WARN] can't get account of %s: %v", claims.User.ID, err)
+		return claims
+	}
+
+	u := *claims.User
+	if strings.HasPrefix(u.ID, accountPrefix) {
+		if u.ID = u.StrAttr(IdentityAttr); u.ID == "" {
+			m.L.Logf("[WARN] account %s not found", claims.User.ID)
+			return claims
+		}
+	}
+	if u, err = m.Login(ctx, u); err != nil {
+		m.L.Logf("[WARN] can't record login of %s: %v", claims.User.ID, err)
+		return claims
+	}
+	claims.User = &u
+	return claims
+}
+
+// Account returns an account
+func (m *Manager) Account(ctx context.Context, id string) (Account, error) {
+	return m.Store.Account(ctx, id)
+}
+
+// UpdateAccount applies fn to the account and saves it if fn succeeds, i.e. to set its roles
+func (m *Manager) UpdateAccount(ctx context.Context, id string, fn func(a *Account) error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	acc, err := m.Store.Account(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = fn(&acc); err != nil {
+		return err
+	}
+	acc.ID, acc.UpdatedAt = id, m.now().UTC()
+	if err = m.Store.SaveAccount(ctx, acc); err != nil {
+		return fmt.Errorf("users: can't save account: %w", err)
+	}
+	return nil
+}
+
+// Identities lists the identities linked to the account
+func (m *Manager) Identities(ctx context.Context, accountID string) ([]Identity, error) {
+	return m.Store.Identities(ctx, accountID)
+}
+
+// StartLink makes a single use code linking another identity to the account, redeemed by Link
+// after a login with the other provider. The code grants access to the account, show it to
+// its user only.
+func (m *Manager) StartLink(ctx context.Context, accountID string) (string, error) {
+	if m.Cache == nil {
+		return "", ErrNoLinking
+	}
+	if _, err := m.Store.Account(ctx, accountID); err != nil {
+		return "", err
+	}
+	code, err := randID()
+	if err != nil {
+		return "", err
+	}
+	if err = m.Cache.Set(ctx, linkCachePrefix+code, []byte(accountID), m.LinkTTL); err != nil {
+		return "", fmt.Errorf("users: can't save link code: %w", err)
+	}
+	return code, nil
+}
+
+// Link moves the identity to the account of the link code. The previous account of the identity
+// is deleted if no identity is left, its profile is not merged.
+func (m *Manager) Link(ctx context.Context, code, identityID string) (Account, error) {
+	if m.Cache == nil {
+		return Account{}, ErrNoLinking
+	}
+	data, ok, err := m.Cache.Get(ctx, linkCachePrefix+code)
+	if err != nil {
+		return Account{}, fmt.Errorf("users: can't get link code: %w", err)
+	}
+	if !ok || code == "" {
+		return Account{}, ErrInvalidCode
+	}
+	if err = m.Cache.Delete(ctx, linkCachePrefix+code); err != nil {
+		return Account{}, fmt.Errorf("users: can't delete link code: %w", err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	acc, err := m.Store.Account(ctx, string(data))
+	if err != nil {
+		return Account{}, err
+	}
+	ident, err := m.Store.Identity(ctx, identityID)
+	if err != nil {
+		return Account{}, err
+	}
+	if ident.AccountID == acc.ID {
+		return Account{}, ErrLinked
+	}
+	prev := ident.AccountID
+	ident.AccountID = acc.ID
+	if err = m.Store.SaveIdentity(ctx, ident); err != nil {
+		return Account{}, fmt.Errorf("users: can't save identity: %w", err)
+	}
+	if err = m.deleteIfOrphan(ctx, prev); err != nil {
+		return Account{}, err
+	}
+	return acc, nil
+}
+
+// Unlink removes an identity of the account. The last identity can't be removed, its next login
+// would make a new account.
+func (m *Manager) Unlink(ctx context.Context, accountID, identityID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	list, err := m.Store.Identities(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("users: can't list identities: %w", err)
+	}
+	found := false
+	for _, ident := range list {
+		found = found || ident.ID == identityID
+	}
+	if !found {
+		return ErrNotFound
+	}
+	if len(list) == 1 {
+		return ErrLastIdentity
+	}
+	return m.Store.DeleteIdentity(ctx, identityID)
+}
+
+// deleteIfOrphan deletes the account if it has no identity left
+func (m *Manager) deleteIfOrphan(ctx context.Context, accountID string) error {
+	if accountID == "" {
+		return nil
+	}
+	list, err := m.Store.Identities(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("users: can't list identities: %w", err)
+	}
+	if len(list) > 0 {
+		return nil
+	}
+	if err = m.Store.DeleteAccount(ctx, accountID); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("users: can't delete account: %w", err)
+	}
+	return nil
+}
+
+// newAccount makes an account with a random id
+func (m *Manager) newAccount(now time.Time) (Account, error) {
+	id, err := randID()
+	if err != nil {
+		return Account{}, err
+	}
+	return Account{ID: accountPrefix + id, CreatedAt: now}, nil
+}
+
+// apply sets the account on the user of a login of the identity
+func (a Account) apply(u token.User, identityID string) token.User {
+	u.ID = a.ID
+	if a.Name != "" {
+		u.Name = a.Name
+	}
+	if a.Picture != "" {
+		u.Picture = a.Picture
+	}
+	if a.Email != "" {
+		u.Email = a.Email
+		u.SetEmailVerified(a.EmailVerified)
+	}
+	if a.Role != "" {
+		u.SetRole(a.Role)
+	}
+	attrs := make(map[string]interface{}, len(u.Attributes)+len(a.Attributes)+2)
+	for k, v := range u.Attributes {
+		attrs[k] = v
+	}
+	for k, v := range a.Attributes {
+		attrs[k] = v
+	}
+	u.Attributes = attrs
+	if len(a.Roles) > 0 {
+		u.SetSliceAttr(RolesAttr, a.Roles)
+	}
+	if identityID != "" {
+		u.SetStrAttr(IdentityAttr, identityID)
+	}
+	return u
+}
+
+func randID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("users: can't make id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,129 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"mkfst/auth/token"
+	mkfstdb "mkfst/db"
+	"mkfst/providers/cache"
+)
+
+func newManager(t *testing.T, opts Opts) *Manager {
+	t.Helper()
+	raw, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "users.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	store, err := NewSQLStore(&mkfstdb.Connection{Conn: raw, Config: mkfstdb.ConnectionInfo{Type: "SQLITE"}}, SQLOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	t.Cleanup(func() { _ = c.Close() })
+	opts.Store, opts.Cache = store, c
+	return NewManager(opts)
+}
+
+func verified(id, name, email string) token.User {
+	u := token.User{ID: id, Name: name, Email: email}
+	u.SetEmailVerified(true)
+	return u
+}
+
+func TestLoginAndVerifiedEmailLinking(t *testing.T) {
+	m := newManager(t, Opts{LinkVerifiedEmail: true})
+	ctx := t.Context()
+
+	gh, err := m.Login(ctx, verified("github_1", "Bob", "bob@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(gh.ID, "acct_") || gh.StrAttr(IdentityAttr) != "github_1" || gh.Name != "Bob" {
+		t.Fatalf("unexpected user: %+v", gh)
+	}
+	again, err := m.Login(ctx, token.User{ID: "github_1", Name: "Robert"})
+	if err != nil || again.ID != gh.ID || again.Name != "Bob" {
+		t.Errorf("expected the same account with the stored name, got %+v, %v", again, err)
+	}
+
+	google, err := m.Login(ctx, verified("google_2", "Bob G", "BOB@example.com"))
+	if err != nil || google.ID != gh.ID {
+		t.Errorf("expected the verified email linked to %s, got %+v, %v", gh.ID, google, err)
+	}
+	unverified, err := m.Login(ctx, token.User{ID: "local_3", Name: "bob", Email: "bob@example.com"})
+	if err != nil || unverified.ID == gh.ID {
+		t.Errorf("unverified email should not be linked, got %+v, %v", unverified, err)
+	}
+
+	list, err := m.Identities(ctx, gh.ID)
+	if err != nil || len(list) != 2 || list[0].Provider != "github" || list[1].ID != "google_2" {
+		t.Errorf("unexpected identities: %+v, %v", list, err)
+	}
+}
+
+func TestExplicitLinking(t *testing.T) {
+	m := newManager(t, Opts{})
+	ctx := t.Context()
+
+	gh, _ := m.Login(ctx, verified("github_1", "Bob", "bob@example.com"))
+	other, _ := m.Login(ctx, verified("google_2", "Bob", "bob@example.com"))
+	if other.ID == gh.ID {
+		t.Fatal("verified email linking is disabled")
+	}
+
+	code, err := m.StartLink(ctx, gh.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Link(ctx, code, "google_2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Link(ctx, code, "google_2"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("link code should be single use, got %v", err)
+	}
+	if _, err = m.Account(ctx, other.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("account without identities should be deleted, got %v", err)
+	}
+	if u, _ := m.Login(ctx, token.User{ID: "google_2"}); u.ID != gh.ID {
+		t.Errorf("expected google login in %s, got %s", gh.ID, u.ID)
+	}
+
+	if err = m.Unlink(ctx, gh.ID, "google_2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Unlink(ctx, gh.ID, "github_1"); !errors.Is(err, ErrLastIdentity) {
+		t.Errorf("expected ErrLastIdentity, got %v", err)
+	}
+}
+
+func TestUpdateClaims(t *testing.T) {
+	m := newManager(t, Opts{})
+	ctx := t.Context()
+
+	u, _ := m.Login(ctx, token.User{ID: "github_1", Name: "Bob"})
+	err := m.UpdateAccount(ctx, u.ID, func(a *Account) error {
+		a.Role, a.Roles = "staff", []string{"billing", "support"}
+		a.Attributes = map[string]interface{}{"admin": true}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := m.Update(token.Claims{User: &u})
+	if claims.User.Role != "staff" || !claims.User.IsAdmin() || strings.Join(claims.User.SliceAttr(RolesAttr), ",") != "billing,support" {
+		t.Errorf("claims not enriched: %+v", claims.User)
+	}
+
+	fresh := m.Update(token.Claims{User: &token.User{ID: "gitlab_2", Name: "Alice"}})
+	if !strings.HasPrefix(fresh.User.ID, "acct_") || fresh.User.StrAttr(IdentityAttr) != "gitlab_2" {
+		t.Errorf("unknown identity should be recorded, got %+v", fresh.User)
+	}
+}
@@ -10,6 +10,10 @@
 //	mkfst module add NAME[@VERSION] [--registry URL]
 //	mkfst stack apply [--config mkfst.yaml]
 //	mkfst stack list
+//	mkfst sdk go|ts [--spec FILE|URL] [--out FILE] [--package NAME]
+//	mkfst openapi export [--pkg PKG] [--url URL] [--out FILE]
+//	mkfst openapi diff BASE HEAD [--format text|json]
+//	mkfst openapi markdown [--spec FILE|URL] [--out FILE]
 //
 // The CLI is intentionally compact for v1; richer output formatting,
 // JSON-mode (--json), and watch-mode (--watch) are follow-ups.
@@ -67,6 +71,25 @@ func main() {
 		default:
 			fatal("unknown module subcommand: " + os.Args[2])
 		}
+	case "sdk":
+		if len(os.Args) < 3 {
+			fatal("usage: mkfst sdk <go|ts> [--spec FILE|URL] [--out FILE]")
+		}
+		cmdSDK(os.Args[2], os.Args[3:])
+	case "openapi":
+		if len(os.Args) < 3 {
+			fatal("usage: mkfst openapi <export|diff|markdown>")
+		}
+		switch os.Args[2] {
+		case "export":
+			cmdOpenAPIExport(os.Args[3:])
+		case "diff":
+			cmdOpenAPIDiff(os.Args[3:])
+		case "markdown":
+			cmdOpenAPIMarkdown(os.Args[3:])
+		default:
+			fatal("unknown openapi subcommand: " + os.Args[2])
+		}
 	case "-h", "--help", "help":
 		usage()
 	default:
@@ -90,6 +113,15 @@ Author commands (run from the client):
   mkfst submit  FILE.ts  [--server URL] [--name NAME]
   mkfst run     NAME     [--server URL]
   mkfst inspect ID       [--server URL]
+
+API client generation:
+  mkfst sdk go  [--spec FILE|URL] [--out FILE] [--package NAME]
+  mkfst sdk ts  [--spec FILE|URL] [--out FILE]
+
+API specification:
+  mkfst openapi export [--pkg PKG] [--url URL] [--out openapi.json]
+  mkfst openapi diff   BASE HEAD [--format text|json] [--fail-on-breaking=false]
+  mkfst openapi markdown [--spec FILE|URL] [--out API.md]
 `)
 }
 
@@ -203,6 +235,13 @@ func cmdStackList(args []string)   { runStackList(args) }
 func cmdModuleAdd(args []string)   { runModuleAdd(args) }
 func cmdModuleList(args []string)  { runModuleList(args) }
 
+// === generator subcommands ===
+
+func cmdSDK(lang string, args []string) { runSDK(lang, args) }
+func cmdOpenAPIExport(args []string)    { runOpenAPIExport(args) }
+func cmdOpenAPIDiff(args []string)      { runOpenAPIDiff(args) }
+func cmdOpenAPIMarkdown(args []string)  { runOpenAPIMarkdown(args) }
+
 // === HTTP client builder (with mTLS support) ===
 
 func buildHTTPClient(certPath, keyPath, caCertPath string) *http.Client {
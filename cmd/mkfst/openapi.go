@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"mkfst/fizz/openapi"
+	"mkfst/fizz/ui"
+	"mkfst/service"
+)
+
+// runOpenAPIExport is invoked by `mkfst openapi export`. The
+// application package is run with service.ExportEnv set, so
+// Service.Run writes the specification instead of listening,
+// without a database. With --url, the specification is fetched
+// from a running service instead.
+func runOpenAPIExport(args []string) {
+	fs := flag.NewFlagSet("openapi export", flag.ExitOnError)
+	pkg := fs.String("pkg", ".", "application package, run with go run")
+	url := fs.String("url", "", "URL of the specification of a running service, instead of --pkg")
+	out := fs.String("out", "openapi.json", "output file, in the format told by its extension (.json, .yaml, .yml)")
+	fs.Parse(args)
+
+	if *url != "" {
+		resp, err := http.Get(*url)
+		if err != nil {
+			fatal("openapi export: " + err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			fatal(fmt.Sprintf("openapi export: get %s: %s", *url, resp.Status))
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			fatal("openapi export: " + err.Error())
+		}
+		if b, err = convertSpec(b, filepath.Ext(*out)); err != nil {
+			fatal("openapi export: " + err.Error())
+		}
+		if err := os.WriteFile(*out, b, 0o644); err != nil {
+			fatal("openapi export: " + err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "wrote %s\n", *out)
+		return
+	}
+
+	path, err := filepath.Abs(*out)
+	if err != nil {
+		fatal("openapi export: " + err.Error())
+	}
+	cmd := exec.Command("go", "run", *pkg)
+	cmd.Env = append(os.Environ(), service.ExportEnv+"="+path, "APP_SKIP_DB=true")
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fatal("openapi export: " + err.Error())
+	}
+	if _, err := os.Stat(path); err != nil {
+		fatal("openapi export: the application did not export its specification; does it call Service.Run?")
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", *out)
+}
+
+// convertSpec converts the specification b, in JSON
+// or YAML, to the format told by the extension ext.
+func convertSpec(b []byte, ext string) ([]byte, error) {
+	b, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		return yaml.JSONToYAML(b)
+	default:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, b, "", "  "); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+}
+
+// runOpenAPIDiff is invoked by `mkfst openapi diff BASE HEAD`. It
+// prints the changes between the specifications, and exits with
+// status 1 if any is breaking, unless --fail-on-breaking=false.
+func runOpenAPIDiff(args []string) {
+	fs := flag.NewFlagSet("openapi diff", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	failOnBreaking := fs.Bool("fail-on-breaking", true, "exit with status 1 when a change is breaking")
+	// Accept the flags after the specifications too.
+	var specs []string
+	for rest := args; ; rest = fs.Args()[1:] {
+		fs.Parse(rest)
+		if fs.NArg() == 0 {
+			break
+		}
+		specs = append(specs, fs.Arg(0))
+	}
+	if len(specs) != 2 {
+		fatal("usage: mkfst openapi diff BASE HEAD [--format text|json]")
+	}
+	base, err := loadSpec(specs[0])
+	if err != nil {
+		fatal("openapi diff: " + err.Error())
+	}
+	head, err := loadSpec(specs[1])
+	if err != nil {
+		fatal("openapi diff: " + err.Error())
+	}
+	changes := openapi.Diff(base, head)
+	breaking := len(changes.Breaking()) != 0
+
+	switch *format {
+	case "json":
+		if changes == nil {
+			changes = openapi.Changes{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Breaking bool            `json:"breaking"`
+			Changes  openapi.Changes `json:"changes"`
+		}{breaking, changes})
+	case "text":
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		fmt.Fprintf(os.Stderr, "%d changes, %d breaking\n", len(changes), len(changes.Breaking()))
+	default:
+		fatal("openapi diff: unknown format " + *format)
+	}
+	if breaking && *failOnBreaking {
+		os.Exit(1)
+	}
+}
+
+// runOpenAPIMarkdown is invoked by `mkfst openapi markdown`. It
+// writes the static Markdown reference of a specification.
+func runOpenAPIMarkdown(args []string) {
+	fs := flag.NewFlagSet("openapi markdown", flag.ExitOnError)
+	spec := fs.String("spec", "openapi.json", "OpenAPI specification file or URL (JSON or YAML)")
+	out := fs.String("out", "", "output file (defaults to stdout)")
+	fs.Parse(args)
+
+	api, err := loadSpec(*spec)
+	if err != nil {
+		fatal("openapi markdown: " + err.Error())
+	}
+	md := []byte(ui.Markdown(api) + "\n")
+	if *out == "" {
+		os.Stdout.Write(md)
+		return
+	}
+	if err := os.WriteFile(*out, md, 0o644); err != nil {
+		fatal("openapi markdown: " + err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", *out)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"mkfst/fizz/openapi"
+	"mkfst/sdkgen"
+)
+
+// runSDK is invoked by `mkfst sdk <go|ts>`. The specification is
+// read from a file, or fetched from a running service when --spec
+// is a URL (such as http://localhost:8080/openapi.json).
+func runSDK(lang string, args []string) {
+	fs := flag.NewFlagSet("sdk "+lang, flag.ExitOnError)
+	spec := fs.String("spec", "openapi.json", "OpenAPI specification file or URL (JSON or YAML)")
+	out := fs.String("out", "", "output file (defaults to stdout)")
+	pkg := fs.String("package", "client", "name of the generated Go package")
+	internal := fs.Bool("internal", false, "include the operations marked x-internal")
+	fs.Parse(args)
+
+	api, err := loadSpec(*spec)
+	if err != nil {
+		fatal("sdk: " + err.Error())
+	}
+	opts := sdkgen.Options{Package: *pkg, IncludeInternal: *internal}
+
+	var src []byte
+	switch lang {
+	case "go":
+		src, err = sdkgen.Go(api, opts)
+	case "ts", "typescript":
+		src, err = sdkgen.TypeScript(api, opts)
+	default:
+		fatal("usage: mkfst sdk <go|ts> [--spec FILE|URL] [--out FILE]")
+	}
+	if err != nil {
+		fatal("sdk: " + err.Error())
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		fatal("sdk: " + err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", *out)
+}
+
+func loadSpec(location string) (*openapi.OpenAPI, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		resp, err := http.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("get %s: %s", location, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+	return sdkgen.Load(r)
+}
@@ -6,6 +6,7 @@ import (
 	db "mkfst/db"
 	"os"
 	"strconv"
+	"strings"
 
 	"mkfst/fizz/openapi"
 )
@@ -17,6 +18,22 @@ type Config struct {
 	UseHTTPS bool
 	Database db.ConnectionInfo
 	Spec     openapi.Info
+	// OpenAPIVersion selects the version of the served
+	// specification, "3.0" (the default) or "3.1".
+	OpenAPIVersion string
+	// DocsPath is the path of the documentation
+	// page. Default value is "/api/docs".
+	DocsPath string
+	// DocsUI selects the renderer of the documentation
+	// page, "swagger" (the default), "redoc" or "scalar".
+	DocsUI string
+	// DocsAssetsURL is the base URL of a mirror of the
+	// renderer assets, required by the renderers whose
+	// bundles are not embedded, see fizz/ui.
+	DocsAssetsURL string
+	// DisableDocs disables the documentation page
+	// and the Markdown reference.
+	DisableDocs bool
 }
 
 func (config *Config) getConfigHost(opts Config) *Config {
@@ -84,6 +101,76 @@ func (config *Config) getConfigSkipDB(opts Config) *Config {
 	return config
 }
 
+func (config *Config) getConfigOpenAPIVersion(opts Config) *Config {
+	if value, ok := os.LookupEnv("APP_OPENAPI_VERSION"); ok {
+		config.OpenAPIVersion = value
+	}
+
+	if opts.OpenAPIVersion != "" {
+		config.OpenAPIVersion = opts.OpenAPIVersion
+	}
+
+	return config
+}
+
+func (config *Config) getConfigDocs(opts Config) *Config {
+	if value, ok := os.LookupEnv("APP_DOCS_PATH"); ok {
+		config.DocsPath = value
+	}
+
+	if opts.DocsPath != "" {
+		config.DocsPath = opts.DocsPath
+	} else if config.DocsPath == "" {
+		config.DocsPath = "/api/docs"
+	}
+
+	if value, ok := os.LookupEnv("APP_DOCS_UI"); ok {
+		config.DocsUI = value
+	}
+
+	if opts.DocsUI != "" {
+		config.DocsUI = opts.DocsUI
+	}
+
+	if value, ok := os.LookupEnv("APP_DOCS_ASSETS_URL"); ok {
+		config.DocsAssetsURL = value
+	}
+
+	if opts.DocsAssetsURL != "" {
+		config.DocsAssetsURL = opts.DocsAssetsURL
+	}
+
+	if value, ok := os.LookupEnv("APP_DISABLE_DOCS"); ok {
+		disableDocs, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.DisableDocs = disableDocs
+	}
+
+	if opts.DisableDocs {
+		config.DisableDocs = opts.DisableDocs
+	}
+
+	return config
+}
+
+// Validate checks the settings read from the environment or the
+// options, returning an error for the first invalid one.
+func (config Config) Validate() error {
+	switch v := config.OpenAPIVersion; {
+	case v == "", v == "3.0", v == "3.1", strings.HasPrefix(v, "3.0."), strings.HasPrefix(v, "3.1."):
+	default:
+		return fmt.Errorf("config: unsupported OpenAPI version %q (APP_OPENAPI_VERSION), expected 3.0 or 3.1", v)
+	}
+	switch config.DocsUI {
+	case "", "swagger", "redoc", "scalar":
+	default:
+		return fmt.Errorf("config: unknown docs renderer %q (APP_DOCS_UI), expected swagger, redoc or scalar", config.DocsUI)
+	}
+	return nil
+}
+
 func (config *Config) ToAddress() string {
 	return fmt.Sprintf("%s:%d", config.Host, config.Port)
 }
@@ -103,6 +190,10 @@ func Create(opts Config) Config {
 		opts,
 	).getConfigSkipDB(
 		opts,
+	).getConfigOpenAPIVersion(
+		opts,
+	).getConfigDocs(
+		opts,
 	)
 
 	return config
@@ -2,7 +2,8 @@
 //
 // Demonstrates:
 //   - cache.NewMemoryCache for ephemeral key-value storage
-//   - A tiny middleware that caches GET responses for 30 seconds
+//   - middleware/httpcache caching GET responses for 30 seconds, with
+//     ETag/304 handling and tag invalidation
 //   - A handler that performs an "expensive" computation and benefits
 //     from the cache on repeated requests
 //
@@ -14,16 +15,13 @@
 //
 //	curl -i http://localhost:8081/expensive/42
 //	curl -i http://localhost:8081/expensive/42      # served from cache
-//	curl -i http://localhost:8081/expensive/42 -H "Cache-Bypass: 1"
+//	curl -i http://localhost:8081/expensive/42 -H "Cache-Control: no-cache"
+//	curl -X POST http://localhost:8081/expensive/42/refresh
 //	curl -X POST http://localhost:8081/cache/clear
 package main
 
 import (
-	"bytes"
 	"database/sql"
-	"net/http"
-	"strconv"
-	"strings"
 	"sync/atomic"
 	"time"
 
@@ -32,6 +30,7 @@ import (
 	"mkfst/config"
 	"mkfst/fizz"
 	"mkfst/fizz/openapi"
+	"mkfst/middleware/httpcache"
 	"mkfst/providers/cache"
 	"mkfst/service"
 )
@@ -41,10 +40,10 @@ import (
 var computed atomic.Uint64
 
 type Result struct {
-	N        int    `json:"n"`
-	Square   int    `json:"square"`
+	N          int    `json:"n"`
+	Square     int    `json:"square"`
 	ComputedAt string `json:"computed_at"`
-	Calls    uint64 `json:"calls_so_far"`
+	Calls      uint64 `json:"calls_so_far"`
 }
 
 func main() {
@@ -60,35 +59,22 @@ func main() {
 		},
 	})
 
-	// Response-cache middleware. Caches 200 OK responses to GETs
-	// for 30s, keyed by URI. Honors Cache-Bypass: 1 to skip.
-	// Middleware uses the tonic-style signature
-	// func(*gin.Context, *sql.DB) (any, error).
-	svc.Middleware(func(g *gin.Context, _ *sql.DB) (any, error) {
-		if g.Request.Method != http.MethodGet || g.GetHeader("Cache-Bypass") != "" {
-			return nil, nil
-		}
-		key := "resp:" + g.Request.URL.RequestURI()
-		if body, ok, _ := c.Get(g.Request.Context(), key); ok {
-			g.Header("X-Cache", "HIT")
-			g.Data(http.StatusOK, "application/json", body)
-			g.Abort()
-			return nil, nil
-		}
-		rec := &recordingWriter{ResponseWriter: g.Writer, body: &bytes.Buffer{}}
-		g.Writer = rec
-		g.Next()
-		if rec.code == http.StatusOK || rec.code == 0 {
-			_ = c.Set(g.Request.Context(), key, rec.body.Bytes(), 30*time.Second)
-			g.Header("X-Cache", "MISS")
-		}
-		return nil, nil
-	})
+	// Response-cache middleware. Caches the 200 OK responses of the
+	// GET routes declaring a TTL with httpcache.Cached; a request
+	// with Cache-Control: no-cache recomputes the response.
+	responses, err := httpcache.New(httpcache.Opts{Cache: c})
+	if err != nil {
+		panic(err)
+	}
+	svc.Middleware(responses)
 
 	// Expensive handler: simulates 200ms of work; cache hides it on
 	// subsequent calls.
 	svc.Route("GET", "/expensive/:n", 200,
-		[]fizz.OperationOption{fizz.Summary("Compute n^2 (slowly)")},
+		[]fizz.OperationOption{
+			fizz.Summary("Compute n^2 (slowly)"),
+			httpcache.Cached(httpcache.RouteOpts{TTL: 30 * time.Second, Tags: []string{"n:{n}"}}),
+		},
 		func(g *gin.Context, _ *sql.DB, in *struct {
 			N int `path:"n" validate:"min=0,max=10000"`
 		}) (Result, error) {
@@ -103,37 +89,21 @@ func main() {
 		},
 	)
 
+	// Invalidates the cached result of n.
+	svc.Route("POST", "/expensive/:n/refresh", 200,
+		[]fizz.OperationOption{httpcache.Invalidates("n:{n}")},
+		func(g *gin.Context, _ *sql.DB) (struct{ Refreshed string }, error) {
+			return struct{ Refreshed string }{Refreshed: g.Param("n")}, nil
+		},
+	)
+
 	// Operator-flush endpoint.
 	svc.Route("POST", "/cache/clear", 200, nil,
-		func(g *gin.Context, _ *sql.DB) (struct{ Cleared int }, error) {
-			n, err := c.DeletePrefix(g.Request.Context(), "resp:")
-			return struct{ Cleared int }{Cleared: n}, err
+		func(g *gin.Context, _ *sql.DB) (struct{ Cleared bool }, error) {
+			err := responses.Purge(g.Request.Context())
+			return struct{ Cleared bool }{Cleared: err == nil}, err
 		},
 	)
 
 	svc.Run()
 }
-
-// recordingWriter captures the response body for caching.
-type recordingWriter struct {
-	gin.ResponseWriter
-	body *bytes.Buffer
-	code int
-}
-
-func (w *recordingWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
-	return w.ResponseWriter.Write(b)
-}
-func (w *recordingWriter) WriteHeader(code int) {
-	w.code = code
-	w.ResponseWriter.WriteHeader(code)
-}
-func (w *recordingWriter) WriteString(s string) (int, error) {
-	w.body.WriteString(s)
-	return w.ResponseWriter.WriteString(s)
-}
-
-// keep imports tidy (strconv/strings are useful for richer caching keys)
-var _ = strconv.Itoa
-var _ = strings.Contains
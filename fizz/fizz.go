@@ -16,6 +16,7 @@ import (
 	"mkfst/tonic"
 
 	"github.com/gin-gonic/gin"
+	validator "github.com/go-playground/validator/v10"
 )
 
 const ctxOpenAPIOperation = "_ctx_openapi_operation"
@@ -101,6 +102,49 @@ func (f *Fizz) Generator() *openapi.Generator {
 	return f.gen
 }
 
+// RegisterDiscriminator declares the concrete types of an interface
+// type, given as a nil pointer such as (*Shape)(nil). The values of
+// the property named property tell the concrete type, as in mapping,
+// whose values are instances of the types, such as Circle{}. The
+// request bodies are decoded into the concrete types, and the
+// specification describes the interface as a oneOf with a
+// discriminator. It must be called before the routes using the
+// interface are added.
+func (f *Fizz) RegisterDiscriminator(iface interface{}, property string, mapping map[string]interface{}) error {
+	it := reflect.TypeOf(iface)
+	if it == nil || it.Kind() != reflect.Ptr || it.Elem().Kind() != reflect.Interface {
+		return errors.New("iface must be a nil pointer to an interface type")
+	}
+	types := make(map[string]reflect.Type, len(mapping))
+	for value, v := range mapping {
+		types[value] = reflect.TypeOf(v)
+	}
+	if err := tonic.RegisterDiscriminator(it.Elem(), property, types); err != nil {
+		return err
+	}
+	return f.gen.RegisterDiscriminator(it.Elem(), property, types)
+}
+
+// RegisterValidation registers the custom validation fn of the
+// validator tag, and the function documenting the tag in the
+// specification, such as:
+//
+//	f.RegisterValidation("currency", isCurrency, func(string, reflect.Type) *openapi.Schema {
+//		return &openapi.Schema{Pattern: "^[A-Z]{3}$"}
+//	})
+//
+// The tag is not documented if schema is nil. It must be called
+// before the routes using the tag are added.
+func (f *Fizz) RegisterValidation(tag string, fn validator.Func, schema openapi.ValidationSchemaFunc) error {
+	if err := tonic.RegisterValidation(tag, fn); err != nil {
+		return err
+	}
+	if schema != nil {
+		f.gen.RegisterValidation(tag, schema)
+	}
+	return nil
+}
+
 // Errors returns the errors that may have occurred
 // during the spec generation.
 func (f *Fizz) Errors() []error {
@@ -403,6 +447,69 @@ func XInternal() func(*openapi.OperationInfo) {
 	}
 }
 
+// Callback adds a callback to the operation: a request the API sends
+// to the URL the runtime expression evaluates to, such as
+// {$request.body#/callbackUrl}. The request is described by the type
+// of model, like the input of a handler, and the options describe it
+// and the response expected from the consumer.
+func Callback(name, expression, method string, model interface{}, infos ...OperationOption) func(*openapi.OperationInfo) {
+	return func(o *openapi.OperationInfo) {
+		cbi := &openapi.OperationInfo{}
+		for _, info := range infos {
+			info(cbi)
+		}
+		o.Callbacks = append(o.Callbacks, &openapi.OperationCallback{
+			Name:       name,
+			Expression: expression,
+			Method:     method,
+			Model:      model,
+			Info:       cbi,
+		})
+	}
+}
+
+// Link adds a link to the response of the operation with the given
+// status code, or the default one if empty, to the operation with the
+// given ID. The parameters of the linked operation are mapped to values
+// or runtime expressions, such as $response.body#/id.
+func Link(statusCode, name, operationID string, parameters map[string]interface{}, desc string) func(*openapi.OperationInfo) {
+	return func(o *openapi.OperationInfo) {
+		o.Links = append(o.Links, &openapi.ResponseLink{
+			Code:        statusCode,
+			Name:        name,
+			OperationID: operationID,
+			Parameters:  parameters,
+			Description: desc,
+		})
+	}
+}
+
+// Webhook documents a top-level webhook of the API, a request it
+// sends to its consumers out of any operation. The request is
+// described by the type of model, like the input of a handler, and
+// the options describe it and the response expected from the
+// consumer. The webhooks are listed under x-webhooks in OpenAPI 3.0.
+func (f *Fizz) Webhook(name, method string, model interface{}, infos []OperationOption) *Fizz {
+	oi := &openapi.OperationInfo{}
+	for _, info := range infos {
+		info(oi)
+	}
+	if oi.ID == "" {
+		oi.ID = name
+	}
+	if oi.StatusCode == 0 {
+		oi.StatusCode = http.StatusOK
+	}
+	var in reflect.Type
+	if model != nil {
+		in = reflect.TypeOf(model)
+	}
+	if _, err := f.gen.AddWebhook(name, strings.ToUpper(method), "", in, nil, oi); err != nil {
+		panic(fmt.Sprintf("error while generating OpenAPI spec on webhook %s: %s", name, err))
+	}
+	return f
+}
+
 // OperationFromContext returns the OpenAPI operation from
 // the given Gin context or an error if none is found.
 func OperationFromContext(ctx context.Context) (*openapi.Operation, error) {
@@ -0,0 +1,70 @@
+package fizz
+
+import (
+	"context"
+	"net/http"
+
+	"mkfst/fizz/openapi"
+)
+
+// OperationDocumenter is implemented by the middleware changing the
+// responses of the operations they guard, such as a rate limiter
+// adding headers and a 429 response, to document them.
+type OperationDocumenter interface {
+	// OperationOptions returns the options documenting the changes,
+	// applied after the options of the operation: they should not
+	// override those, see DefaultResponse and DefaultHeader.
+	OperationOptions() []OperationOption
+}
+
+// DefaultResponse adds a response to the operation unless its options
+// documented one with the same status code. Use it after the options
+// of the operation.
+func DefaultResponse(statusCode, desc string, model interface{}, headers []*openapi.ResponseHeader) func(*openapi.OperationInfo) {
+	return func(o *openapi.OperationInfo) {
+		for _, r := range o.Responses {
+			if r != nil && r.Code == statusCode {
+				return
+			}
+		}
+		Response(statusCode, desc, model, headers, nil)(o)
+	}
+}
+
+// DefaultHeader adds a header to the operation unless its options
+// documented one with the same name. Use it after the options of the
+// operation.
+func DefaultHeader(name, desc string, model interface{}) func(*openapi.OperationInfo) {
+	return func(o *openapi.OperationInfo) {
+		for _, h := range o.Headers {
+			if h != nil && http.CanonicalHeaderKey(h.Name) == http.CanonicalHeaderKey(name) {
+				return
+			}
+		}
+		Header(name, desc, model)(o)
+	}
+}
+
+// WithValue sets the value of key in the settings of the operation,
+// read at runtime by middleware with OperationValue. Use unexported
+// key types, as with context.WithValue.
+func WithValue(key, value interface{}) func(*openapi.OperationInfo) {
+	return func(o *openapi.OperationInfo) {
+		if o.Values == nil {
+			o.Values = make(map[interface{}]interface{})
+		}
+		o.Values[key] = value
+	}
+}
+
+// OperationValue returns the value of key in the settings of the
+// operation of the request. The operation is set on the context by
+// the handler of the route: middleware read it after calling Next.
+func OperationValue(ctx context.Context, key interface{}) (interface{}, bool) {
+	op, err := OperationFromContext(ctx)
+	if err != nil {
+		return nil, false
+	}
+	v, ok := op.Values[key]
+	return v, ok
+}
@@ -0,0 +1,78 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type cbSubscribeIn struct {
+	CallbackURL string `json:"callbackUrl" validate:"required"`
+}
+
+type cbSubscription struct {
+	ID string `json:"id"`
+}
+
+type cbEvent struct {
+	Kind string `json:"kind" enum:"created,deleted"`
+	At   string `json:"at"`
+}
+
+func TestCallbacksAndLinks(t *testing.T) {
+	g := newTestGenerator(t)
+
+	info := &OperationInfo{
+		ID:         "subscribe",
+		StatusCode: 201,
+		Callbacks: []*OperationCallback{{
+			Name:       "event",
+			Expression: "{$request.body#/callbackUrl}",
+			Model:      cbEvent{},
+			Info:       &OperationInfo{Summary: "Event notification", StatusCode: 204},
+		}},
+		Links: []*ResponseLink{{
+			Name:        "GetSubscription",
+			OperationID: "getSubscription",
+			Parameters:  map[string]interface{}{"id": "$response.body#/id"},
+		}},
+	}
+	op, err := g.AddOperation("/subscriptions", "POST", "", reflect.TypeOf(cbSubscribeIn{}), reflect.TypeOf(cbSubscription{}), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := op.Callbacks["event"]["{$request.body#/callbackUrl}"]
+	if item == nil || item.POST == nil {
+		t.Fatalf("missing callback: %+v", op.Callbacks)
+	}
+	cb := item.POST
+	if cb.ID != "subscribeEventCallback" || cb.Summary != "Event notification" || cb.Responses["204"] == nil {
+		t.Errorf("unexpected callback operation: %+v", cb)
+	}
+	body := cb.RequestBody.Content["application/json"]
+	if s := g.api.resolveSchema(body.Schema); s == nil || s.Properties["kind"] == nil {
+		t.Errorf("callback body not derived from its model: %+v", body.Schema)
+	}
+	link := op.Responses["201"].Links["GetSubscription"]
+	if link == nil || link.OperationID != "getSubscription" || link.Parameters["id"] != "$response.body#/id" {
+		t.Errorf("unexpected link: %+v", link)
+	}
+
+	// Callback schemas are converted for OpenAPI 3.1 too.
+	if err := g.SetOpenAPIVersion("3.1"); err != nil {
+		t.Fatal(err)
+	}
+	spec := marshalFlat(t, g.API())
+	if !strings.Contains(spec, `"callbacks":{"event":{"{$request.body#/callbackUrl}":{"post":`) {
+		t.Errorf("callbacks missing from the 3.1 specification: %s", spec)
+	}
+
+	for _, info := range []*OperationInfo{
+		{ID: "badLink", StatusCode: 200, Links: []*ResponseLink{{Code: "404", Name: "x", OperationID: "y"}}},
+		{ID: "badCallback", StatusCode: 200, Callbacks: []*OperationCallback{{Name: "x"}}},
+	} {
+		if _, err := g.AddOperation("/"+info.ID, "GET", "", nil, nil, info); err == nil {
+			t.Errorf("%s: expected an error", info.ID)
+		}
+	}
+}
@@ -0,0 +1,602 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Operation returns the operation registered for the given
+// method and path, or nil if none exists. The path may use
+// either the Gin syntax (/users/:id) or the OpenAPI syntax
+// (/users/{id}).
+func (api *OpenAPI) Operation(method, path string) *Operation {
+	item, ok := api.Paths[rewritePath(path)]
+	if !ok || item == nil {
+		return nil
+	}
+	return item.Operation(method)
+}
+
+// Operation returns the operation of the path item
+// for the given method, or nil if none is set.
+func (item *PathItem) Operation(method string) *Operation {
+	switch strings.ToUpper(method) {
+	case "GET":
+		return item.GET
+	case "PUT":
+		return item.PUT
+	case "POST":
+		return item.POST
+	case "PATCH":
+		return item.PATCH
+	case "HEAD":
+		return item.HEAD
+	case "OPTIONS":
+		return item.OPTIONS
+	case "TRACE":
+		return item.TRACE
+	case "DELETE":
+		return item.DELETE
+	}
+	return nil
+}
+
+// ResponseFor returns the response documented by the operation
+// for the given status code. An exact code takes precedence over
+// a range (2XX), which takes precedence over the default response.
+func (op *Operation) ResponseFor(status int) *Response {
+	code := strconv.Itoa(status)
+	for _, k := range []string{code, code[:1] + "XX", "default"} {
+		if r, ok := op.Responses[k]; ok && r != nil && r.Response != nil {
+			return r.Response
+		}
+	}
+	return nil
+}
+
+// ValidateResponse checks a response returned by the operation at
+// method and path against the specification. Successful status codes
+// must be documented by the operation; other codes are only checked
+// when documented. A JSON body is checked against the schema of the
+// documented media type.
+func (api *OpenAPI) ValidateResponse(method, path string, status int, mediaType string, body []byte) error {
+	op := api.Operation(method, path)
+	if op == nil {
+		return fmt.Errorf("operation %s %s is not documented", method, path)
+	}
+	resp := op.ResponseFor(status)
+	if resp == nil {
+		if status >= 200 && status < 300 {
+			return fmt.Errorf("status %d of operation %s %s is not documented", status, method, path)
+		}
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 || len(resp.Content) == 0 {
+		return nil
+	}
+	mt, ok := resp.Content[mediaType]
+	if !ok {
+		mt, ok = resp.Content[anyMediaType]
+	}
+	if !ok || mt == nil || mt.MediaType == nil || mt.Schema == nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Errorf("response body is not valid JSON: %s", err)
+	}
+	return api.ValidateValue(mt.Schema, v)
+}
+
+// ValidateRequest checks a request sent to the operation at method
+// and path against the specification. The parameters must be
+// documented by the operation, and their values conform to their
+// schemas. A body must be sent with a documented media type, and a
+// JSON body conform to its schema. It returns nil or a ValueErrors
+// whose paths are prefixed by the location of the parameters, such
+// as query.limit, or $ for the body.
+func (api *OpenAPI) ValidateRequest(method, path string, r *http.Request, body []byte) error {
+	op := api.Operation(method, path)
+	if op == nil {
+		return fmt.Errorf("operation %s %s is not documented", method, path)
+	}
+	var errs ValueErrors
+
+	params := pathParams(rewritePath(path), r.URL.Path)
+	query := r.URL.Query()
+	known := make(map[string]bool, len(op.Parameters))
+
+	for _, por := range op.Parameters {
+		p := por.Parameter
+		if p == nil {
+			continue
+		}
+		var values []string
+		switch p.In {
+		case "path":
+			if v, ok := params[p.Name]; ok {
+				values = []string{v}
+			}
+		case "query":
+			known[p.Name] = true
+			values = query[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		case "cookie":
+			if c, err := r.Cookie(p.Name); err == nil {
+				values = []string{c.Value}
+			}
+		}
+		loc := p.In + "." + p.Name
+		if len(values) == 0 {
+			if p.Required {
+				errs.add(loc, "missing required %s parameter", p.In)
+			}
+			continue
+		}
+		if p.AllowEmptyValue && len(values) == 1 && values[0] == "" {
+			continue
+		}
+		api.validateValue(p.Schema, api.paramValue(p, values), loc, &errs)
+	}
+	for name := range query {
+		if !known[name] {
+			errs.add("query."+name, "unknown query parameter")
+		}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody != nil && op.RequestBody.Required {
+			errs.add("$", "missing required request body")
+		}
+		return errs.orNil()
+	}
+	if op.RequestBody == nil || len(op.RequestBody.Content) == 0 {
+		errs.add("$", "operation accepts no request body")
+		return errs.orNil()
+	}
+	ct := r.Header.Get("Content-Type")
+	mt, _, _ := mime.ParseMediaType(ct)
+
+	media, ok := op.RequestBody.Content[mt]
+	if !ok {
+		media, ok = op.RequestBody.Content[anyMediaType]
+	}
+	if !ok {
+		types := make([]string, 0, len(op.RequestBody.Content))
+		for k := range op.RequestBody.Content {
+			types = append(types, k)
+		}
+		sort.Strings(types)
+		errs.add("header.Content-Type", "unsupported media type %q, expected %s", ct, strings.Join(types, " or "))
+		return errs.orNil()
+	}
+	if media == nil || media.Schema == nil || !isJSONMediaType(mt) {
+		return errs.orNil()
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		errs.add("$", "request body is not valid JSON: %s", err)
+		return errs.orNil()
+	}
+	api.validateValue(media.Schema, v, "$", &errs)
+
+	return errs.orNil()
+}
+
+// paramValue converts the values of the parameter p to the
+// type of its schema, as if they were decoded from JSON. The
+// values that can't be converted are left as strings for the
+// validation to report them.
+func (api *OpenAPI) paramValue(p *Parameter, values []string) interface{} {
+	var schema *Schema
+	if p.Schema != nil {
+		schema = api.resolveSchema(p.Schema)
+	}
+	if schema == nil {
+		return values[0]
+	}
+	if !contains(schemaTypes(schema), "array") {
+		return scalarValue(schema, values[0])
+	}
+	if len(values) == 1 && !p.Explode {
+		values = strings.Split(values[0], ",")
+	}
+	var items *Schema
+	if schema.Items != nil {
+		items = api.resolveSchema(schema.Items)
+	}
+	arr := make([]interface{}, len(values))
+	for i, v := range values {
+		arr[i] = scalarValue(items, v)
+	}
+	return arr
+}
+
+func scalarValue(schema *Schema, s string) interface{} {
+	if schema == nil {
+		return s
+	}
+	for _, t := range schemaTypes(schema) {
+		switch t {
+		case "integer", "number":
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b
+			}
+		case "string":
+			return s
+		}
+	}
+	return s
+}
+
+// pathParams returns the values of the parameters of the
+// path template tmpl, in the OpenAPI syntax, found in path.
+func pathParams(tmpl, path string) map[string]string {
+	ts := strings.Split(strings.Trim(tmpl, "/"), "/")
+	ps := strings.Split(strings.Trim(path, "/"), "/")
+
+	params := make(map[string]string)
+	for i, t := range ts {
+		if i >= len(ps) {
+			break
+		}
+		if strings.HasPrefix(t, "*") {
+			// A Gin catch-all parameter takes the rest of the path.
+			params[t[1:]] = "/" + strings.Join(ps[i:], "/")
+			break
+		}
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if v, err := url.PathUnescape(ps[i]); err == nil {
+				params[t[1:len(t)-1]] = v
+			}
+		}
+	}
+	return params
+}
+
+// isJSONMediaType returns whether mt, a media type without
+// parameters, designates JSON content.
+func isJSONMediaType(mt string) bool {
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// ValidateValue checks that v, a value decoded from JSON into an
+// empty interface, conforms to the schema s. References are resolved
+// against the components of the specification. It returns nil or a
+// ValueErrors listing every violation found.
+func (api *OpenAPI) ValidateValue(s *SchemaOrRef, v interface{}) error {
+	var errs ValueErrors
+	api.validateValue(s, v, "$", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (api *OpenAPI) validateValue(s *SchemaOrRef, v interface{}, path string, errs *ValueErrors) {
+	if s == nil {
+		return
+	}
+	schema := api.resolveSchema(s)
+	if schema == nil {
+		if s.Reference != nil {
+			errs.add(path, "unresolvable schema reference %s", s.Reference.Ref)
+		}
+		return
+	}
+	for _, sub := range schema.AllOf {
+		api.validateValue(sub, v, path, errs)
+	}
+	if len(schema.AnyOf) != 0 && api.countValid(schema.AnyOf, v) == 0 {
+		errs.add(path, "value does not match any of the anyOf schemas")
+	}
+	if len(schema.OneOf) != 0 {
+		if schema.Discriminator != nil {
+			api.validateDiscriminated(schema, v, path, errs)
+		} else if n := api.countValid(schema.OneOf, v); n != 1 {
+			errs.add(path, "value matches %d of the oneOf schemas, expected exactly one", n)
+		}
+	}
+	if schema.Const != nil && !enumContains([]interface{}{schema.Const}, v) {
+		errs.add(path, "value %v is not the constant %v", v, schema.Const)
+	}
+	types := schemaTypes(schema)
+
+	if v == nil {
+		if len(types) != 0 && !contains(types, "null") {
+			errs.add(path, "null is not allowed, expected %s", strings.Join(types, " or "))
+		}
+		return
+	}
+	if len(schema.Enum) != 0 && !enumContains(schema.Enum, v) {
+		errs.add(path, "value %v is not one of %v", v, schema.Enum)
+	}
+	var typ string
+	if len(types) != 0 {
+		if typ = matchType(types, v); typ == "" {
+			errs.add(path, "expected %s, got %s", strings.Join(types, " or "), jsonKind(v))
+			return
+		}
+	}
+	switch typ {
+	case "":
+		// No type constraint, the schema accepts any value.
+	case "object":
+		api.validateObject(schema, v.(map[string]interface{}), path, errs)
+	case "array":
+		api.validateArray(schema, v.([]interface{}), path, errs)
+	case "string":
+		validateString(schema, v.(string), path, errs)
+	case "integer", "number":
+		validateNumber(schema, v.(float64), path, errs)
+	}
+}
+
+// validateDiscriminated validates v against the schema of the
+// oneOf of schema designated by the value of its discriminator.
+func (api *OpenAPI) validateDiscriminated(schema *Schema, v interface{}, path string, errs *ValueErrors) {
+	d := schema.Discriminator
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		errs.add(path, "expected object, got %s", jsonKind(v))
+		return
+	}
+	value, ok := obj[d.PropertyName].(string)
+	if !ok {
+		errs.add(path, "missing discriminator property %q", d.PropertyName)
+		return
+	}
+	ref, ok := d.Mapping[value]
+	if !ok {
+		// Without mapping, the value is the name of the schema.
+		ref = componentsSchemaPath + value
+	}
+	for _, s := range schema.OneOf {
+		if s.Reference != nil && s.Reference.Ref == ref {
+			api.validateValue(s, v, path, errs)
+			return
+		}
+	}
+	errs.add(path, "unknown %s %q", d.PropertyName, value)
+}
+
+// countValid returns the number of schemas of ss v conforms to.
+func (api *OpenAPI) countValid(ss []*SchemaOrRef, v interface{}) int {
+	var n int
+	for _, s := range ss {
+		var errs ValueErrors
+		if api.validateValue(s, v, "$", &errs); len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// schemaTypes returns the types allowed by the schema,
+// including null for the OpenAPI 3.0 nullable schemas.
+func schemaTypes(schema *Schema) []string {
+	if len(schema.Types) != 0 {
+		return schema.Types
+	}
+	if schema.Type == "" {
+		return nil
+	}
+	if schema.Nullable {
+		return []string{schema.Type, "null"}
+	}
+	return []string{schema.Type}
+}
+
+// matchType returns the type of types matching v, a non-null
+// value decoded from JSON, or an empty string.
+func matchType(types []string, v interface{}) string {
+	for _, t := range types {
+		switch t {
+		case "object", "array", "string", "boolean", "number":
+			if jsonKind(v) == t {
+				return t
+			}
+		case "integer":
+			if n, ok := v.(float64); ok && n == math.Trunc(n) {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (api *OpenAPI) validateObject(schema *Schema, obj map[string]interface{}, path string, errs *ValueErrors) {
+	for _, name := range schema.Required {
+		if _, ok := obj[name]; !ok {
+			errs.add(path, "missing required property %q", name)
+		}
+	}
+	if schema.MinProperties != 0 && len(obj) < schema.MinProperties {
+		errs.add(path, "expected at least %d properties, got %d", schema.MinProperties, len(obj))
+	}
+	if schema.MaxProperties != 0 && len(obj) > schema.MaxProperties {
+		errs.add(path, "expected at most %d properties, got %d", schema.MaxProperties, len(obj))
+	}
+	for name, val := range obj {
+		if ps, ok := schema.Properties[name]; ok {
+			api.validateValue(ps, val, path+"."+name, errs)
+			continue
+		}
+		if schema.AdditionalProperties != nil {
+			api.validateValue(schema.AdditionalProperties, val, path+"."+name, errs)
+		}
+	}
+}
+
+func (api *OpenAPI) validateArray(schema *Schema, arr []interface{}, path string, errs *ValueErrors) {
+	if schema.MinItems != 0 && len(arr) < schema.MinItems {
+		errs.add(path, "expected at least %d items, got %d", schema.MinItems, len(arr))
+	}
+	if schema.MaxItems != 0 && len(arr) > schema.MaxItems {
+		errs.add(path, "expected at most %d items, got %d", schema.MaxItems, len(arr))
+	}
+	if schema.UniqueItems {
+		seen := make(map[string]struct{}, len(arr))
+		for _, item := range arr {
+			b, _ := json.Marshal(item)
+			if _, ok := seen[string(b)]; ok {
+				errs.add(path, "items are not unique")
+				break
+			}
+			seen[string(b)] = struct{}{}
+		}
+	}
+	for i, item := range arr {
+		api.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+	}
+}
+
+func validateString(schema *Schema, str, path string, errs *ValueErrors) {
+	l := utf8.RuneCountInString(str)
+	if schema.MinLength != 0 && l < schema.MinLength {
+		errs.add(path, "expected at least %d characters, got %d", schema.MinLength, l)
+	}
+	if schema.MaxLength != 0 && l > schema.MaxLength {
+		errs.add(path, "expected at most %d characters, got %d", schema.MaxLength, l)
+	}
+	if schema.Pattern != "" {
+		re, err := regexp.Compile(schema.Pattern)
+		if err == nil && !re.MatchString(str) {
+			errs.add(path, "value %q does not match pattern %s", str, schema.Pattern)
+		}
+	}
+	switch schema.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			errs.add(path, "value %q is not a valid date-time", str)
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", str); err != nil {
+			errs.add(path, "value %q is not a valid date", str)
+		}
+	case "uuid":
+		if !uuidRe.MatchString(str) {
+			errs.add(path, "value %q is not a valid uuid", str)
+		}
+	}
+}
+
+func validateNumber(schema *Schema, n float64, path string, errs *ValueErrors) {
+	if min := schema.Minimum; min != nil {
+		if n < *min || (schema.ExclusiveMinimum && n == *min) {
+			errs.add(path, "value %v is below the minimum %v", n, *min)
+		}
+	}
+	if min := schema.exclusiveMinimum; min != nil && n <= *min {
+		errs.add(path, "value %v is not above %v", n, *min)
+	}
+	if max := schema.Maximum; max != nil {
+		if n > *max || (schema.ExclusiveMaximum && n == *max) {
+			errs.add(path, "value %v is above the maximum %v", n, *max)
+		}
+	}
+	if max := schema.exclusiveMaximum; max != nil && n >= *max {
+		errs.add(path, "value %v is not below %v", n, *max)
+	}
+	if m := schema.MultipleOf; m != nil && *m != 0 {
+		if r := math.Mod(n, *m); r != 0 {
+			errs.add(path, "value %v is not a multiple of %v", n, *m)
+		}
+	}
+}
+
+// resolveSchema returns either the inlined schema
+// in s or the one referenced in the components, nil
+// if none, or if the references form a cycle.
+func (api *OpenAPI) resolveSchema(s *SchemaOrRef) *Schema {
+	seen := make(map[string]bool)
+	for s != nil && s.Reference != nil {
+		if seen[s.Reference.Ref] {
+			return nil
+		}
+		seen[s.Reference.Ref] = true
+
+		name := strings.TrimPrefix(s.Reference.Ref, componentsSchemaPath)
+		if name == s.Reference.Ref || api.Components == nil {
+			return nil
+		}
+		s = api.Components.Schemas[name]
+	}
+	if s == nil {
+		return nil
+	}
+	return s.Schema
+}
+
+// enumContains returns whether v, a value decoded from JSON, is equal
+// to one of the enum values, which may hold any Go type. Both sides are
+// compared in their JSON representation.
+func enumContains(enum []interface{}, v interface{}) bool {
+	vb, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	for _, e := range enum {
+		eb, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(vb, eb) {
+			return true
+		}
+		// Numbers decoded from JSON are float64, while enum
+		// values keep their Go type. Compare them numerically.
+		if vf, ok := v.(float64); ok {
+			if ef, err := strconv.ParseFloat(string(eb), 64); err == nil && ef == vf {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonKind returns the JSON name of the kind of v.
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
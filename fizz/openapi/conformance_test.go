@@ -0,0 +1,21 @@
+package openapi
+
+import "testing"
+
+func TestValidateValueSparseSchemas(t *testing.T) {
+	api := &OpenAPI{Components: &Components{Schemas: map[string]*SchemaOrRef{
+		"A":    {Reference: &Reference{Ref: "#/components/schemas/B"}},
+		"B":    {Reference: &Reference{Ref: "#/components/schemas/A"}},
+		"Self": {Reference: &Reference{Ref: "#/components/schemas/Self"}},
+	}}}
+
+	// neither a schema nor a reference: any value
+	if err := api.ValidateValue(&SchemaOrRef{}, 1.0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"A", "Self", "Missing"} {
+		if err := api.ValidateValue(&SchemaOrRef{Reference: &Reference{Ref: "#/components/schemas/" + name}}, 1.0); err == nil {
+			t.Errorf("%s: expected an unresolvable reference", name)
+		}
+	}
+}
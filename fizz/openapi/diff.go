@@ -0,0 +1,571 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a difference between two versions of a specification.
+type Change struct {
+	// Breaking tells whether the change may
+	// break the clients of the former version.
+	Breaking bool `json:"breaking"`
+
+	// Kind classifies the change, such as
+	// operation-removed or property-required.
+	Kind string `json:"kind"`
+
+	// Operation is the method and path of the
+	// operation concerned, such as GET /pets/{id}.
+	Operation string `json:"operation,omitempty"`
+
+	// Location locates the change within the operation: a
+	// parameter (query.limit), or a value within the request
+	// or a response body (response 200 $.items[].name).
+	Location string `json:"location,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// String returns the change on a single line.
+func (c *Change) String() string {
+	var b strings.Builder
+	if c.Breaking {
+		b.WriteString("BREAKING ")
+	}
+	if c.Operation != "" {
+		b.WriteString(c.Operation + " ")
+	}
+	if c.Location != "" {
+		b.WriteString(c.Location + ": ")
+	}
+	b.WriteString(c.Message)
+
+	return b.String()
+}
+
+// Changes is a list of changes between two versions of a specification.
+type Changes []*Change
+
+// Breaking returns the breaking changes of the list.
+func (cs Changes) Breaking() Changes {
+	var bc Changes
+	for _, c := range cs {
+		if c.Breaking {
+			bc = append(bc, c)
+		}
+	}
+	return bc
+}
+
+// Diff compares the specification head to the former version base and
+// returns the changes of their operations. A change is breaking when a
+// client of base may fail against head: an operation or a documented
+// success response is removed, the requests accept fewer values (a new
+// required parameter or property, a narrower type, fewer enum values,
+// tighter bounds), or the responses return values base didn't document
+// (a removed or optional property, a wider type, more enum values).
+//
+// The operations are matched by method and path, regardless of the
+// names of their path parameters.
+func Diff(base, head *OpenAPI) Changes {
+	d := &differ{base: base, head: head}
+
+	baseOps, headOps := operationsByKey(base), operationsByKey(head)
+	for key, bo := range baseOps {
+		ho, ok := headOps[key]
+		if !ok {
+			d.add(true, "operation-removed", bo.name, "", "operation removed")
+			continue
+		}
+		d.operation(bo, ho)
+	}
+	for key, ho := range headOps {
+		if _, ok := baseOps[key]; !ok {
+			d.add(false, "operation-added", ho.name, "", "operation added")
+		}
+	}
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		ci, cj := d.changes[i], d.changes[j]
+		if ci.Operation != cj.Operation {
+			return ci.Operation < cj.Operation
+		}
+		if ci.Location != cj.Location {
+			return ci.Location < cj.Location
+		}
+		return ci.Kind < cj.Kind
+	})
+	return d.changes
+}
+
+type keyedOperation struct {
+	name   string // method and path
+	path   string
+	params []string // names of the path parameters
+	op     *Operation
+}
+
+// operationsByKey returns the operations of api by method
+// and path, with the names of the path parameters erased.
+func operationsByKey(api *OpenAPI) map[string]*keyedOperation {
+	ops := make(map[string]*keyedOperation)
+	for path, item := range api.Paths {
+		if item == nil {
+			continue
+		}
+		var params []string
+		for _, m := range paramsInPathRe.FindAllStringSubmatch(path, -1) {
+			params = append(params, m[1])
+		}
+		for _, method := range []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH", "TRACE"} {
+			op := item.Operation(method)
+			if op == nil {
+				continue
+			}
+			ops[method+" "+paramsInPathRe.ReplaceAllString(path, "{}")] = &keyedOperation{
+				name:   method + " " + path,
+				path:   path,
+				params: params,
+				op:     op,
+			}
+		}
+	}
+	return ops
+}
+
+type differ struct {
+	base, head *OpenAPI
+	changes    Changes
+
+	// Operation and location of the schemas being compared.
+	opName, loc string
+	// req tells whether the compared schemas
+	// describe requests or responses.
+	req bool
+	// seen holds the pairs of compared references,
+	// which break the recursion in the schemas.
+	seen map[[2]string]bool
+}
+
+func (d *differ) add(breaking bool, kind, op, loc, format string, a ...interface{}) {
+	d.changes = append(d.changes, &Change{
+		Breaking:  breaking,
+		Kind:      kind,
+		Operation: op,
+		Location:  loc,
+		Message:   fmt.Sprintf(format, a...),
+	})
+}
+
+func (d *differ) operation(bo, ho *keyedOperation) {
+	name := ho.name
+	if !bo.op.Deprecated && ho.op.Deprecated {
+		d.add(false, "operation-deprecated", name, "", "operation deprecated")
+	}
+	// Path parameters are matched by position.
+	rename := make(map[string]string, len(bo.params))
+	for i, p := range bo.params {
+		if i < len(ho.params) {
+			rename[p] = ho.params[i]
+		}
+	}
+	paramKey := func(p *Parameter, renamed bool) string {
+		n := p.Name
+		if p.In == "path" && renamed {
+			n = rename[n]
+		}
+		if p.In == "header" {
+			n = strings.ToLower(n)
+		}
+		return p.In + "." + n
+	}
+	baseParams, headParams := map[string]*Parameter{}, map[string]*Parameter{}
+	for _, p := range bo.op.Parameters {
+		if p != nil && p.Parameter != nil {
+			baseParams[paramKey(p.Parameter, true)] = p.Parameter
+		}
+	}
+	for _, p := range ho.op.Parameters {
+		if p != nil && p.Parameter != nil {
+			headParams[paramKey(p.Parameter, false)] = p.Parameter
+		}
+	}
+	for key, bp := range baseParams {
+		loc := bp.In + "." + bp.Name
+		hp, ok := headParams[key]
+		if !ok {
+			d.add(true, "parameter-removed", name, loc, "parameter removed")
+			continue
+		}
+		loc = hp.In + "." + hp.Name
+		if !bp.Required && hp.Required {
+			d.add(true, "parameter-required", name, loc, "parameter became required")
+		}
+		if bp.Required && !hp.Required {
+			d.add(false, "parameter-optional", name, loc, "parameter became optional")
+		}
+		d.compare(name, loc, true, bp.Schema, hp.Schema)
+	}
+	for key, hp := range headParams {
+		if _, ok := baseParams[key]; !ok {
+			loc := hp.In + "." + hp.Name
+			if hp.Required {
+				d.add(true, "parameter-added", name, loc, "required parameter added")
+			} else {
+				d.add(false, "parameter-added", name, loc, "optional parameter added")
+			}
+		}
+	}
+	d.requestBody(name, bo.op.RequestBody, ho.op.RequestBody)
+	d.responses(name, bo.op.Responses, ho.op.Responses)
+}
+
+func (d *differ) requestBody(name string, bb, hb *RequestBody) {
+	const loc = "request"
+	switch {
+	case bb == nil && hb == nil:
+		return
+	case bb == nil:
+		d.add(hb.Required, "request-body-added", name, loc, "request body added")
+		return
+	case hb == nil:
+		d.add(true, "request-body-removed", name, loc, "request body removed")
+		return
+	}
+	if !bb.Required && hb.Required {
+		d.add(true, "request-body-required", name, loc, "request body became required")
+	}
+	for mt, bm := range bb.Content {
+		hm, ok := hb.Content[mt]
+		if !ok {
+			if _, ok = hb.Content[anyMediaType]; !ok {
+				d.add(true, "media-type-removed", name, loc, "media type %s removed", mt)
+				continue
+			}
+			hm = hb.Content[anyMediaType]
+		}
+		if bm != nil && hm != nil {
+			d.compare(name, loc+" $", true, bm.Schema, hm.Schema)
+		}
+	}
+	for mt := range hb.Content {
+		if _, ok := bb.Content[mt]; !ok {
+			d.add(false, "media-type-added", name, loc, "media type %s added", mt)
+		}
+	}
+}
+
+func (d *differ) responses(name string, brs, hrs Responses) {
+	for code, br := range brs {
+		if br == nil || br.Response == nil {
+			continue
+		}
+		loc := "response " + code
+		hr, ok := hrs[code]
+		if !ok || hr == nil || hr.Response == nil {
+			success := strings.HasPrefix(code, "2") || code == "default"
+			d.add(success, "response-removed", name, loc, "response removed")
+			continue
+		}
+		for mt, bm := range br.Content {
+			hm, ok := hr.Content[mt]
+			if !ok {
+				d.add(true, "media-type-removed", name, loc, "media type %s removed", mt)
+				continue
+			}
+			if bm != nil && bm.MediaType != nil && hm != nil && hm.MediaType != nil {
+				d.compare(name, loc+" $", false, bm.Schema, hm.Schema)
+			}
+		}
+		for mt := range hr.Content {
+			if _, ok := br.Content[mt]; !ok {
+				d.add(false, "media-type-added", name, loc, "media type %s added", mt)
+			}
+		}
+	}
+	for code, hr := range hrs {
+		if _, ok := brs[code]; !ok && hr != nil {
+			d.add(false, "response-added", name, "response "+code, "response added")
+		}
+	}
+}
+
+// compare adds the changes between the schemas of a request
+// value, if req is true, or of a response value.
+func (d *differ) compare(name, loc string, req bool, bs, hs *SchemaOrRef) {
+	d.opName, d.req = name, req
+	d.seen = make(map[[2]string]bool)
+	d.schema(loc, bs, hs)
+}
+
+// breaking returns whether a change is breaking, given whether
+// it restricts (or else relaxes) the values of the schema: more
+// restrictive requests and more permissive responses are.
+func (d *differ) breaking(restricts bool) bool {
+	return restricts == d.req
+}
+
+func (d *differ) schema(loc string, bsor, hsor *SchemaOrRef) {
+	if bsor == nil || hsor == nil {
+		return
+	}
+	if bsor.Reference != nil && hsor.Reference != nil {
+		pair := [2]string{bsor.Reference.Ref, hsor.Reference.Ref}
+		if d.seen[pair] {
+			return
+		}
+		d.seen[pair] = true
+	}
+	bs, hs := d.base.resolveSchema(bsor), d.head.resolveSchema(hsor)
+	if bs == nil || hs == nil {
+		return
+	}
+	d.types(loc, bs, hs, bsor.nullable, hsor.nullable)
+	d.enum(loc, bs, hs)
+	d.constraints(loc, bs, hs)
+	d.variants(loc, "oneOf", bs.OneOf, hs.OneOf)
+	d.variants(loc, "anyOf", bs.AnyOf, hs.AnyOf)
+
+	d.schema(loc+"[]", bs.Items, hs.Items)
+	d.schema(loc+".*", bs.AdditionalProperties, hs.AdditionalProperties)
+
+	for prop, bp := range bs.Properties {
+		ploc := loc + "." + prop
+		hp, ok := hs.Properties[prop]
+		if !ok {
+			d.add(d.breaking(false), "property-removed", d.opName, ploc, "property removed")
+			continue
+		}
+		breq, hreq := contains(bs.Required, prop), contains(hs.Required, prop)
+		if !breq && hreq {
+			d.add(d.breaking(true), "property-required", d.opName, ploc, "property became required")
+		}
+		if breq && !hreq {
+			d.add(d.breaking(false), "property-optional", d.opName, ploc, "property became optional")
+		}
+		d.schema(ploc, bp, hp)
+	}
+	for prop := range hs.Properties {
+		if _, ok := bs.Properties[prop]; ok {
+			continue
+		}
+		ploc := loc + "." + prop
+		if contains(hs.Required, prop) {
+			d.add(d.breaking(true), "property-added", d.opName, ploc, "required property added")
+		} else {
+			d.add(false, "property-added", d.opName, ploc, "optional property added")
+		}
+	}
+}
+
+// types compares the types allowed by the schemas,
+// null included, an empty list allowing any type.
+func (d *differ) types(loc string, bs, hs *Schema, bnull, hnull bool) {
+	bt, ht := schemaTypes(bs), schemaTypes(hs)
+	if len(bt) != 0 && (bnull || bs.Nullable) && !contains(bt, "null") {
+		bt = append(append([]string(nil), bt...), "null")
+	}
+	if len(ht) != 0 && (hnull || hs.Nullable) && !contains(ht, "null") {
+		ht = append(append([]string(nil), ht...), "null")
+	}
+	// covers returns whether the types ts allow the type t.
+	covers := func(ts []string, t string) bool {
+		return len(ts) == 0 || contains(ts, t) || (t == "integer" && contains(ts, "number"))
+	}
+	var removed, added []string
+	if len(bt) == 0 && len(ht) != 0 {
+		removed = []string{"any"}
+	}
+	for _, t := range bt {
+		if !covers(ht, t) {
+			removed = append(removed, t)
+		}
+	}
+	if len(ht) == 0 && len(bt) != 0 {
+		added = []string{"any"}
+	}
+	for _, t := range ht {
+		if !covers(bt, t) {
+			added = append(added, t)
+		}
+	}
+	if len(removed) == 0 && len(added) == 0 {
+		return
+	}
+	msg := fmt.Sprintf("type changed from %s to %s", typeList(bt), typeList(ht))
+	d.add(
+		(len(removed) != 0 && d.breaking(true)) || (len(added) != 0 && d.breaking(false)),
+		"type-changed", d.opName, loc, "%s", msg,
+	)
+}
+
+func typeList(ts []string) string {
+	if len(ts) == 0 {
+		return "any"
+	}
+	return strings.Join(ts, " or ")
+}
+
+func (d *differ) enum(loc string, bs, hs *Schema) {
+	be, he := enumValues(bs), enumValues(hs)
+	if be == nil && he == nil {
+		return
+	}
+	if be == nil {
+		d.add(d.breaking(true), "enum-added", d.opName, loc, "values restricted to %s", strings.Join(he, ", "))
+		return
+	}
+	if he == nil {
+		d.add(d.breaking(false), "enum-removed", d.opName, loc, "values no longer restricted")
+		return
+	}
+	for _, v := range be {
+		if !contains(he, v) {
+			d.add(d.breaking(true), "enum-value-removed", d.opName, loc, "value %s removed", v)
+		}
+	}
+	for _, v := range he {
+		if !contains(be, v) {
+			d.add(d.breaking(false), "enum-value-added", d.opName, loc, "value %s added", v)
+		}
+	}
+}
+
+// enumValues returns the JSON representation of
+// the values allowed by the enum or const of s.
+func enumValues(s *Schema) []string {
+	values := s.Enum
+	if s.Const != nil {
+		values = []interface{}{s.Const}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	vs := make([]string, 0, len(values))
+	for _, v := range values {
+		b, _ := json.Marshal(v)
+		vs = append(vs, string(b))
+	}
+	return vs
+}
+
+func (d *differ) constraints(loc string, bs, hs *Schema) {
+	// upper compares an upper bound, zero meaning none.
+	upper := func(kind string, b, h int) {
+		switch {
+		case b == h:
+		case h != 0 && (b == 0 || h < b):
+			d.add(d.breaking(true), kind, d.opName, loc, "%s lowered to %d", strings.TrimSuffix(kind, "-changed"), h)
+		default:
+			d.add(d.breaking(false), kind, d.opName, loc, "%s raised from %d", strings.TrimSuffix(kind, "-changed"), b)
+		}
+	}
+	// lower compares a lower bound, zero meaning none.
+	lower := func(kind string, b, h int) {
+		switch {
+		case b == h:
+		case h > b:
+			d.add(d.breaking(true), kind, d.opName, loc, "%s raised to %d", strings.TrimSuffix(kind, "-changed"), h)
+		default:
+			d.add(d.breaking(false), kind, d.opName, loc, "%s lowered to %d", strings.TrimSuffix(kind, "-changed"), h)
+		}
+	}
+	upper("maxLength-changed", bs.MaxLength, hs.MaxLength)
+	lower("minLength-changed", bs.MinLength, hs.MinLength)
+	d.bounds(loc, bs, hs)
+	upper("maxItems-changed", bs.MaxItems, hs.MaxItems)
+	lower("minItems-changed", bs.MinItems, hs.MinItems)
+
+	if bs.Pattern != hs.Pattern {
+		// Patterns can't be compared, a new one restricts the
+		// requests and a former one documented the responses.
+		breaking := (d.req && hs.Pattern != "") || (!d.req && bs.Pattern != "")
+		d.add(breaking, "pattern-changed", d.opName, loc, "pattern changed from %q to %q", bs.Pattern, hs.Pattern)
+	}
+	if bs.Format != hs.Format {
+		breaking := (d.req && hs.Format != "" && !widens(bs.Format, hs.Format)) ||
+			(!d.req && bs.Format != "" && !widens(hs.Format, bs.Format))
+		d.add(breaking, "format-changed", d.opName, loc, "format changed from %q to %q", bs.Format, hs.Format)
+	}
+}
+
+// bounds compares the numeric bounds of the schemas, whose
+// exclusive flags are compared as a change of the bound.
+func (d *differ) bounds(loc string, bs, hs *Schema) {
+	type bound struct {
+		v         *float64
+		exclusive bool
+	}
+	// tighter returns whether a restricts the values more than b.
+	tighter := func(a, b bound, upper bool) bool {
+		switch {
+		case a.v == nil:
+			return false
+		case b.v == nil:
+			return true
+		case *a.v == *b.v:
+			return a.exclusive && !b.exclusive
+		case upper:
+			return *a.v < *b.v
+		default:
+			return *a.v > *b.v
+		}
+	}
+	str := func(b bound) string {
+		if b.v == nil {
+			return "none"
+		}
+		s := strconv.FormatFloat(*b.v, 'f', -1, 64)
+		if b.exclusive {
+			s += " (exclusive)"
+		}
+		return s
+	}
+	for _, c := range []struct {
+		kind  string
+		b, h  bound
+		upper bool
+	}{
+		{"maximum-changed", bound{bs.Maximum, bs.ExclusiveMaximum}, bound{hs.Maximum, hs.ExclusiveMaximum}, true},
+		{"minimum-changed", bound{bs.Minimum, bs.ExclusiveMinimum}, bound{hs.Minimum, hs.ExclusiveMinimum}, false},
+	} {
+		if str(c.b) == str(c.h) {
+			continue
+		}
+		name := strings.TrimSuffix(c.kind, "-changed")
+		d.add(d.breaking(tighter(c.h, c.b, c.upper)), c.kind, d.opName, loc, "%s changed from %s to %s", name, str(c.b), str(c.h))
+	}
+}
+
+// widens returns whether the numeric format to
+// allows every value of the numeric format from.
+func widens(from, to string) bool {
+	return (from == "int32" && to == "int64") || (from == "float" && to == "double")
+}
+
+// variants compares the referenced schemas of a oneOf or anyOf.
+func (d *differ) variants(loc, keyword string, bv, hv []*SchemaOrRef) {
+	refs := func(ss []*SchemaOrRef) []string {
+		var rs []string
+		for _, s := range ss {
+			if s != nil && s.Reference != nil {
+				rs = append(rs, strings.TrimPrefix(s.Reference.Ref, componentsSchemaPath))
+			}
+		}
+		return rs
+	}
+	br, hr := refs(bv), refs(hv)
+	for _, r := range br {
+		if !contains(hr, r) {
+			d.add(d.breaking(true), keyword+"-variant-removed", d.opName, loc, "%s variant %s removed", keyword, r)
+		}
+	}
+	for _, r := range hr {
+		if !contains(br, r) {
+			d.add(d.breaking(false), keyword+"-variant-added", d.opName, loc, "%s variant %s added", keyword, r)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+type diffPetV1 struct {
+	ID   int64  `json:"id" validate:"required"`
+	Name string `json:"name" validate:"required"`
+	Kind string `json:"kind" enum:"cat,dog"`
+}
+
+type diffPetV2 struct {
+	ID   int64  `json:"id" validate:"required"`
+	Kind string `json:"kind" enum:"cat,dog,bird"`
+}
+
+type diffCreateV1 struct {
+	Name string `json:"name" validate:"required"`
+	Age  int    `json:"age"`
+}
+
+type diffCreateV2 struct {
+	Name  string `json:"name" validate:"required"`
+	Age   int64  `json:"age"`
+	Owner string `json:"owner" validate:"required"`
+}
+
+type diffOperation struct {
+	path, method string
+	in, out      interface{}
+}
+
+func newDiffSpec(t *testing.T, ops []diffOperation) *OpenAPI {
+	g := newTestGenerator(t)
+	for _, op := range ops {
+		var in reflect.Type
+		if op.in != nil {
+			in = reflect.TypeOf(op.in)
+		}
+		info := &OperationInfo{ID: op.method + op.path, StatusCode: 200}
+		if _, err := g.AddOperation(op.path, op.method, "", in, reflect.TypeOf(op.out), info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g.API()
+}
+
+func TestDiff(t *testing.T) {
+	base := newDiffSpec(t, []diffOperation{
+		{"/shop/:id", "GET", struct {
+			ID    int64 `path:"id"`
+			Limit int   `query:"limit"`
+		}{}, diffPetV1{}},
+		{"/shop", "POST", diffCreateV1{}, diffPetV1{}},
+		{"/shop/:id", "DELETE", struct {
+			ID int64 `path:"id"`
+		}{}, diffPetV1{}},
+	})
+	head := newDiffSpec(t, []diffOperation{
+		{"/shop/:petId", "GET", struct {
+			ID    int64  `path:"petId"`
+			Limit int    `query:"limit" validate:"required"`
+			Sort  string `query:"sort"`
+		}{}, diffPetV2{}},
+		{"/shop", "POST", diffCreateV2{}, diffPetV2{}},
+		{"/owners", "GET", nil, diffPetV2{}},
+	})
+
+	type key struct{ kind, operation, location string }
+	expected := map[key]bool{
+		{"operation-removed", "DELETE /shop/{id}", ""}:                   true,
+		{"operation-added", "GET /owners", ""}:                           false,
+		{"parameter-required", "GET /shop/{petId}", "query.limit"}:       true,
+		{"parameter-added", "GET /shop/{petId}", "query.sort"}:           false,
+		{"property-removed", "GET /shop/{petId}", "response 200 $.name"}: true,
+		{"enum-value-added", "GET /shop/{petId}", "response 200 $.kind"}: true,
+		{"property-removed", "POST /shop", "response 200 $.name"}:        true,
+		{"enum-value-added", "POST /shop", "response 200 $.kind"}:        true,
+		{"property-added", "POST /shop", "request $.owner"}:              true,
+		{"format-changed", "POST /shop", "request $.age"}:                false,
+	}
+	changes := Diff(base, head)
+	for _, c := range changes {
+		k := key{c.Kind, c.Operation, c.Location}
+		breaking, ok := expected[k]
+		if !ok {
+			t.Errorf("unexpected change: %s", c)
+			continue
+		}
+		if breaking != c.Breaking {
+			t.Errorf("%s: expected breaking to be %t", c, breaking)
+		}
+		delete(expected, k)
+	}
+	for k := range expected {
+		t.Errorf("missing change: %+v", k)
+	}
+	if n := len(changes.Breaking()); n != 7 {
+		t.Errorf("expected 7 breaking changes, got %d", n)
+	}
+	if changes := Diff(base, base); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func TestDiffSchemas(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		req      bool
+		base     *Schema
+		head     *Schema
+		kind     string
+		breaking bool
+	}{
+		{"request type widened", true, &Schema{Type: "integer"}, &Schema{Type: "number"}, "type-changed", false},
+		{"response type widened", false, &Schema{Type: "integer"}, &Schema{Type: "number"}, "type-changed", true},
+		{"request nullable removed", true, &Schema{Type: "string", Nullable: true}, &Schema{Type: "string"}, "type-changed", true},
+		{"response nullable added", false, &Schema{Type: "string"}, &Schema{Type: "string", Nullable: true}, "type-changed", true},
+		{"request enum value removed", true, &Schema{Type: "string", Enum: []interface{}{"a", "b"}}, &Schema{Type: "string", Enum: []interface{}{"a"}}, "enum-value-removed", true},
+		{"response enum value removed", false, &Schema{Type: "string", Enum: []interface{}{"a", "b"}}, &Schema{Type: "string", Enum: []interface{}{"a"}}, "enum-value-removed", false},
+		{"request max length lowered", true, &Schema{Type: "string", MaxLength: 10}, &Schema{Type: "string", MaxLength: 5}, "maxLength-changed", true},
+		{"response max length lowered", false, &Schema{Type: "string", MaxLength: 10}, &Schema{Type: "string", MaxLength: 5}, "maxLength-changed", false},
+		{"request minimum lowered", true, &Schema{Type: "integer", Minimum: Float(1)}, &Schema{Type: "integer"}, "minimum-changed", false},
+		{"request zero minimum added", true, &Schema{Type: "integer"}, &Schema{Type: "integer", Minimum: Float(0)}, "minimum-changed", true},
+		{"request maximum made exclusive", true, &Schema{Type: "number", Maximum: Float(1.5)}, &Schema{Type: "number", Maximum: Float(1.5), ExclusiveMaximum: true}, "maximum-changed", true},
+		{"response maximum made exclusive", false, &Schema{Type: "number", Maximum: Float(1.5)}, &Schema{Type: "number", Maximum: Float(1.5), ExclusiveMaximum: true}, "maximum-changed", false},
+		{"request items narrowed", true, &Schema{Type: "array", Items: &SchemaOrRef{Schema: &Schema{Type: "number"}}}, &Schema{Type: "array", Items: &SchemaOrRef{Schema: &Schema{Type: "integer"}}}, "type-changed", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &differ{base: &OpenAPI{}, head: &OpenAPI{}}
+			d.compare("GET /", "$", tc.req, &SchemaOrRef{Schema: tc.base}, &SchemaOrRef{Schema: tc.head})
+			if len(d.changes) != 1 {
+				t.Fatalf("expected a single change, got %v", d.changes)
+			}
+			if c := d.changes[0]; c.Kind != tc.kind || c.Breaking != tc.breaking {
+				t.Errorf("unexpected change: %s (%s)", c, c.Kind)
+			}
+		})
+	}
+}
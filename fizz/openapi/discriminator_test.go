@@ -0,0 +1,91 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type dsShape interface{ isShape() }
+
+type dsCircle struct {
+	Kind   string  `json:"kind"`
+	Radius float64 `json:"radius" validate:"required"`
+}
+
+func (dsCircle) isShape() {}
+
+type dsSquare struct {
+	Kind string  `json:"kind"`
+	Side float64 `json:"side" validate:"required"`
+}
+
+func (*dsSquare) isShape() {}
+
+type dsDrawing struct {
+	Shapes []dsShape `json:"shapes"`
+}
+
+func TestDiscriminator(t *testing.T) {
+	g := newTestGenerator(t)
+
+	iface := reflect.TypeOf((*dsShape)(nil)).Elem()
+	if err := g.RegisterDiscriminator(iface, "kind", map[string]reflect.Type{
+		"circle": reflect.TypeOf(dsCircle{}),
+		"square": reflect.TypeOf(&dsSquare{}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.RegisterDiscriminator(iface, "kind", map[string]reflect.Type{
+		"pet": reflect.TypeOf(jsPet{}),
+	}); err == nil {
+		t.Error("expected an error for a type not implementing the interface")
+	}
+	if _, err := g.AddOperation("/drawings", "POST", "", reflect.TypeOf(dsDrawing{}), nil, &OperationInfo{ID: "createDrawing", StatusCode: 201}); err != nil {
+		t.Fatal(err)
+	}
+	api := g.API()
+	s := marshalFlat(t, api)
+	for _, want := range []string{
+		`"OpenapiDsShape":{"oneOf":[{"$ref":"#/components/schemas/OpenapiDsCircle"},{"$ref":"#/components/schemas/OpenapiDsSquare"}]`,
+		`"discriminator":{"propertyName":"kind","mapping":{"circle":"#/components/schemas/OpenapiDsCircle","square":"#/components/schemas/OpenapiDsSquare"}}`,
+		`"items":{"$ref":"#/components/schemas/OpenapiDsShape"}`,
+		`"kind":{"type":"string","enum":["circle"]}`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("specification lacks %s: %s", want, s)
+		}
+	}
+	if req := api.Components.Schemas["OpenapiDsSquare"].Schema.Required; !contains(req, "kind") {
+		t.Errorf("discriminator property is not required: %v", req)
+	}
+
+	shape := &SchemaOrRef{Reference: &Reference{Ref: componentsSchemaPath + "OpenapiDsShape"}}
+	for _, tc := range []struct {
+		v    map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"kind": "circle", "radius": 1.0}, ""},
+		{map[string]interface{}{"kind": "square", "side": 1.0}, ""},
+		{map[string]interface{}{"kind": "square", "radius": 1.0}, "side"},
+		{map[string]interface{}{"kind": "hexagon"}, `unknown kind "hexagon"`},
+		{map[string]interface{}{"radius": 1.0}, `missing discriminator property "kind"`},
+	} {
+		err := api.ValidateValue(shape, tc.v)
+		if tc.want == "" && err != nil {
+			t.Errorf("%v: unexpected error %v", tc.v, err)
+		}
+		if tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%v: expected error %q, got %v", tc.v, tc.want, err)
+		}
+	}
+
+	// The mapping is rewritten along with the references.
+	doc, err := g.JSONSchema(reflect.TypeOf(dsDrawing{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := marshalFlat(t, doc); !strings.Contains(s, `"circle":"#/$defs/OpenapiDsCircle"`) || len(doc.Defs) != 3 {
+		t.Errorf("unexpected JSON schema: %s", s)
+	}
+}
@@ -3,6 +3,7 @@ package openapi
 import (
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 // FieldError is the error returned when an
@@ -32,3 +33,42 @@ type TypeError struct {
 func (te *TypeError) Error() string {
 	return fmt.Sprintf("%s: type=%s, kind=%s", te.Message, te.Type, te.Type.Kind())
 }
+
+// ValueError is the error returned when a value does
+// not conform to a schema of the specification.
+type ValueError struct {
+	// Path locates the offending value using a
+	// JSONPath-like notation, such as $.items[2].name.
+	Path    string
+	Message string
+}
+
+// Error implements the builtin error interface for ValueError.
+func (ve *ValueError) Error() string {
+	return fmt.Sprintf("%s: %s", ve.Path, ve.Message)
+}
+
+// ValueErrors is the list of violations found
+// while validating a value against a schema.
+type ValueErrors []*ValueError
+
+// Error implements the builtin error interface for ValueErrors.
+func (ve ValueErrors) Error() string {
+	msgs := make([]string, 0, len(ve))
+	for _, e := range ve {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (ve *ValueErrors) add(path, format string, a ...interface{}) {
+	*ve = append(*ve, &ValueError{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+// orNil returns ve as an error, or nil if it is empty.
+func (ve ValueErrors) orNil() error {
+	if len(ve) == 0 {
+		return nil
+	}
+	return ve
+}
@@ -11,11 +11,13 @@ import (
 	"strings"
 
 	"github.com/gofrs/uuid"
-	"github.com/loopfz/gadgeto/tonic"
+
+	"mkfst/tonic"
 )
 
 const (
 	version              = "3.0.1"
+	version31            = "3.1.0"
 	anyMediaType         = "*/*"
 	formatTag            = "format"
 	deprecatedTag        = "deprecated"
@@ -42,6 +44,8 @@ type Generator struct {
 	schemaTypes   map[reflect.Type]struct{}
 	typeNames     map[reflect.Type]string
 	dataTypes     map[reflect.Type]*OverridedDataType
+	polymorphics  map[reflect.Type]*polymorphic
+	validators    map[string]ValidationSchemaFunc
 	operationsIDS map[string]struct{}
 	errors        []error
 	fullNames     bool
@@ -71,6 +75,7 @@ func NewGenerator(conf *SpecGenConfig) (*Generator, error) {
 		schemaTypes:   make(map[reflect.Type]struct{}),
 		typeNames:     make(map[reflect.Type]string),
 		dataTypes:     make(map[reflect.Type]*OverridedDataType),
+		polymorphics:  make(map[reflect.Type]*polymorphic),
 		operationsIDS: make(map[string]struct{}),
 		fullNames:     true,
 		sortParams:    true,
@@ -116,9 +121,48 @@ func (g *Generator) SetSecuritySchemes(security map[string]*SecuritySchemeOrRef)
 	g.api.Components.SecuritySchemes = security
 }
 
+// AddSecuritySchemes adds security schemes to the ones that can
+// be used inside the operations of the specification, replacing
+// the schemes of the same name.
+func (g *Generator) AddSecuritySchemes(security map[string]*SecuritySchemeOrRef) {
+	if g.api.Components.SecuritySchemes == nil {
+		g.api.Components.SecuritySchemes = make(map[string]*SecuritySchemeOrRef, len(security))
+	}
+	for name, s := range security {
+		g.api.Components.SecuritySchemes[name] = s
+	}
+}
+
+// SetOpenAPIVersion sets the version of the specification
+// returned by API, either 3.0 (the default) or 3.1. The patch
+// version may be omitted.
+func (g *Generator) SetOpenAPIVersion(v string) error {
+	switch {
+	case v == "3.0":
+		v = version
+	case v == "3.1":
+		v = version31
+	case strings.HasPrefix(v, "3.0."), strings.HasPrefix(v, "3.1."):
+	default:
+		return fmt.Errorf("unsupported OpenAPI version %s", v)
+	}
+	g.api.OpenAPI = v
+
+	return nil
+}
+
 // API returns a copy of the internal OpenAPI object.
+// The specification is generated for OpenAPI 3.0, and
+// converted when another version was set.
 func (g *Generator) API() *OpenAPI {
 	cpy := *g.api
+	if strings.HasPrefix(cpy.OpenAPI, "3.1.") {
+		return cpy.to31()
+	}
+	// OpenAPI 3.0 has no webhooks, tools like
+	// Redoc read them from an extension.
+	cpy.XWebhooks, cpy.Webhooks = cpy.Webhooks, nil
+
 	return &cpy
 }
 
@@ -191,6 +235,52 @@ func (g *Generator) OverrideDataType(t reflect.Type, typ, format string) error {
 	return nil
 }
 
+// polymorphic describes the concrete types of an
+// interface registered with RegisterDiscriminator.
+type polymorphic struct {
+	property string
+	mapping  map[string]reflect.Type
+}
+
+// RegisterDiscriminator declares the concrete types of the
+// interface type iface. The values of that type are described
+// as a oneOf of the schemas of the concrete types, which must
+// be named structs, with a discriminator: the value of the
+// property named property tells the type, as in mapping.
+func (g *Generator) RegisterDiscriminator(iface reflect.Type, property string, mapping map[string]reflect.Type) error {
+	if iface == nil || iface.Kind() != reflect.Interface {
+		return errors.New("discriminated type is not an interface")
+	}
+	if property == "" {
+		return errors.New("discriminator property is empty")
+	}
+	if len(mapping) == 0 {
+		return errors.New("discriminator mapping is empty")
+	}
+	p := &polymorphic{
+		property: property,
+		mapping:  make(map[string]reflect.Type, len(mapping)),
+	}
+	for value, t := range mapping {
+		if t == nil {
+			return fmt.Errorf("nil type mapped to %s", value)
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || g.typeName(t) == "" {
+			return fmt.Errorf("type %s mapped to %s is not a named struct", t, value)
+		}
+		if !t.Implements(iface) && !reflect.PtrTo(t).Implements(iface) {
+			return fmt.Errorf("type %s mapped to %s does not implement %s", t, value, iface)
+		}
+		p.mapping[value] = t
+	}
+	g.polymorphics[iface] = p
+
+	return nil
+}
+
 func (g *Generator) datatype(t reflect.Type) DataType {
 	if dt, ok := g.dataTypes[t]; ok {
 		return dt
@@ -235,11 +325,52 @@ func (g *Generator) AddTag(name, desc string) {
 // using the method and path of the route and the tonic
 // handler informations.
 func (g *Generator) AddOperation(path, method, tag string, in, out reflect.Type, info *OperationInfo) (*Operation, error) {
+	path = rewritePath(path)
+
+	// If a PathItem does not exists for this
+	// path, create a new one.
+	item, ok := g.api.Paths[path]
+	if !ok {
+		item = new(PathItem)
+		g.api.Paths[path] = item
+	}
+	op, err := g.newOperation(path, method, tag, in, out, info)
+	if err != nil {
+		return nil, err
+	}
+	setOperationBymethod(item, op, method)
+
+	return op, nil
+}
+
+// AddWebhook adds a webhook to the specification, a request
+// the API sends to its consumers, identified by name. The
+// input type describes the request sent, and the output type
+// and informations the response expected from the consumer.
+func (g *Generator) AddWebhook(name, method, tag string, in, out reflect.Type, info *OperationInfo) (*Operation, error) {
+	if g.api.Webhooks == nil {
+		g.api.Webhooks = make(map[string]*PathItem)
+	}
+	item, ok := g.api.Webhooks[name]
+	if !ok {
+		item = new(PathItem)
+		g.api.Webhooks[name] = item
+	}
+	op, err := g.newOperation("", method, tag, in, out, info)
+	if err != nil {
+		return nil, err
+	}
+	setOperationBymethod(item, op, method)
+
+	return op, nil
+}
+
+// newOperation builds the operation of the given
+// path from the handler input and output types.
+func (g *Generator) newOperation(path, method, tag string, in, out reflect.Type, info *OperationInfo) (*Operation, error) {
 	op := &Operation{
 		ID: uuid.Must(uuid.NewV4()).String(),
 	}
-	path = rewritePath(path)
-
 	if info != nil {
 		// Ensure that the provided operation ID is unique.
 		if _, ok := g.operationsIDS[info.ID]; ok {
@@ -247,15 +378,8 @@ func (g *Generator) AddOperation(path, method, tag string, in, out reflect.Type,
 		}
 		g.operationsIDS[info.ID] = struct{}{}
 	}
-	// If a PathItem does not exists for this
-	// path, create a new one.
-	item, ok := g.api.Paths[path]
-	if !ok {
-		item = new(PathItem)
-		g.api.Paths[path] = item
-	}
-	// Create a new operation and set it
-	// to the according method of the PathItem.
+	// Create a new operation from the
+	// provided informations.
 	if info != nil {
 		op.ID = info.ID
 		op.Summary = info.Summary
@@ -265,6 +389,7 @@ func (g *Generator) AddOperation(path, method, tag string, in, out reflect.Type,
 		op.XCodeSamples = info.XCodeSamples
 		op.Security = info.Security
 		op.XInternal = info.XInternal
+		op.Values = info.Values
 	}
 	if tag != "" {
 		op.Tags = append(op.Tags, tag)
@@ -288,8 +413,22 @@ func (g *Generator) AddOperation(path, method, tag string, in, out reflect.Type,
 	}
 	// Generate the default response from the tonic
 	// handler return type. If the handler has no output
-	// type, the response won't have a schema.
-	if err := g.setOperationResponse(op, out, strconv.Itoa(info.StatusCode), tonic.MediaType(), info.StatusDescription, info.Headers, nil, nil); err != nil {
+	// type, the response won't have a schema. An output
+	// type embedding tonic.Responses has a response per
+	// variant instead, the first being the default.
+	defaultCode := strconv.Itoa(info.StatusCode)
+	if tonic.IsResponses(out) {
+		variants, err := tonic.ResponseVariants(out)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range variants {
+			if err := g.setOperationResponse(op, v.Type, strconv.Itoa(v.StatusCode), tonic.MediaType(), v.Description, variantHeaders(v, info.Headers), nil, nil); err != nil {
+				return nil, err
+			}
+		}
+		defaultCode = strconv.Itoa(variants[0].StatusCode)
+	} else if err := g.setOperationResponse(op, out, defaultCode, tonic.MediaType(), info.StatusDescription, info.Headers, nil, nil); err != nil {
 		return nil, err
 	}
 	// Generate additional responses from the operation
@@ -309,11 +448,115 @@ func (g *Generator) AddOperation(path, method, tag string, in, out reflect.Type,
 			}
 		}
 	}
-	setOperationBymethod(item, op, method)
-
+	for _, cb := range info.Callbacks {
+		if cb != nil {
+			if err := g.setOperationCallback(op, cb); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, link := range info.Links {
+		if link != nil {
+			if err := setResponseLink(op, link, defaultCode); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return op, nil
 }
 
+// variantHeaders returns the headers of the response variant v,
+// described by the headers of the operation with the same name,
+// if any, and else as strings.
+func variantHeaders(v tonic.ResponseVariant, documented []*ResponseHeader) []*ResponseHeader {
+	headers := make([]*ResponseHeader, 0, len(v.Headers))
+	for _, name := range v.Headers {
+		h := &ResponseHeader{Name: name}
+		for _, d := range documented {
+			if d != nil && http.CanonicalHeaderKey(d.Name) == http.CanonicalHeaderKey(name) {
+				h = d
+				break
+			}
+		}
+		headers = append(headers, h)
+	}
+	return headers
+}
+
+// setOperationCallback adds the callback cb to the operation,
+// its request described by the type of the model, like the
+// input of an operation.
+func (g *Generator) setOperationCallback(op *Operation, cb *OperationCallback) error {
+	if cb.Name == "" || cb.Expression == "" {
+		return errors.New("callback name and expression are required")
+	}
+	info := &OperationInfo{}
+	if cb.Info != nil {
+		cpy := *cb.Info
+		info = &cpy
+	}
+	if info.ID == "" {
+		info.ID = op.ID + strings.Title(cb.Name) + "Callback"
+	}
+	if info.StatusCode == 0 {
+		info.StatusCode = http.StatusOK
+	}
+	method := strings.ToUpper(cb.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	var in reflect.Type
+	if cb.Model != nil {
+		in = reflect.TypeOf(cb.Model)
+	}
+	cbop, err := g.newOperation("", method, "", in, nil, info)
+	if err != nil {
+		return fmt.Errorf("callback %s: %s", cb.Name, err)
+	}
+	if op.Callbacks == nil {
+		op.Callbacks = make(map[string]Callback)
+	}
+	callback, ok := op.Callbacks[cb.Name]
+	if !ok {
+		callback = make(Callback)
+		op.Callbacks[cb.Name] = callback
+	}
+	item, ok := callback[cb.Expression]
+	if !ok {
+		item = new(PathItem)
+		callback[cb.Expression] = item
+	}
+	setOperationBymethod(item, cbop, method)
+
+	return nil
+}
+
+// setResponseLink adds the link l to the response of the
+// operation it designates, the default one if unset.
+func setResponseLink(op *Operation, l *ResponseLink, defaultCode string) error {
+	code := l.Code
+	if code == "" {
+		code = defaultCode
+	}
+	r, ok := op.Responses[code]
+	if !ok || r.Response == nil {
+		return fmt.Errorf("link %s: no response with code %s", l.Name, code)
+	}
+	if l.Name == "" || l.OperationID == "" {
+		return fmt.Errorf("link name and operation ID are required")
+	}
+	if r.Links == nil {
+		r.Links = make(map[string]*LinkOrRef)
+	}
+	r.Links[l.Name] = &LinkOrRef{Link: &Link{
+		OperationID: l.OperationID,
+		Parameters:  l.Parameters,
+		RequestBody: l.RequestBody,
+		Description: l.Description,
+	}}
+	return nil
+}
+
 // rewritePath converts a Gin operation path that use
 // colons and asterisks to declare path parameters, to
 // an OpenAPI representation that use curly braces.
@@ -527,8 +770,12 @@ func (g *Generator) buildParamsRecursive(op *Operation, t, parent reflect.Type,
 						TypeName: g.typeName(parent),
 						Type:     parent,
 					})
-				} else if err := g.buildParamsRecursive(op, sft, parent, allowBody); err != nil {
-					return err
+				} else {
+					first := len(op.Parameters)
+					if err := g.buildParamsRecursive(op, sft, parent, allowBody); err != nil {
+						return err
+					}
+					g.documentEmbeddedParams(op.Parameters[first:], sf)
 				}
 			}
 			continue
@@ -543,6 +790,28 @@ func (g *Generator) buildParamsRecursive(op *Operation, t, parent reflect.Type,
 	return nil
 }
 
+// documentEmbeddedParams lets the type of the embedded
+// field sf adjust the parameters it declared, if it
+// implements the ParametersDocumenter interface.
+func (g *Generator) documentEmbeddedParams(params []*ParameterOrRef, sf reflect.StructField) {
+	t := sf.Type
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	if !t.Implements(tofParamDoc) {
+		return
+	}
+	pd := reflect.New(t.Elem()).Interface().(ParametersDocumenter)
+
+	ps := make([]*Parameter, 0, len(params))
+	for _, p := range params {
+		if p.Parameter != nil {
+			ps = append(ps, p.Parameter)
+		}
+	}
+	pd.DocumentParameters(sf.Tag, ps)
+}
+
 func (g *Generator) paramyByName(p1, p2 *ParameterOrRef) bool {
 	return g.resolveParameter(p1).Name < g.resolveParameter(p2).Name
 }
@@ -881,6 +1150,9 @@ func (g *Generator) newSchemaFromType(t reflect.Type) *SchemaOrRef {
 			nullable = i.Nullable()
 		}
 	}
+	if p, ok := g.polymorphics[t]; ok {
+		return g.newSchemaFromPolymorphic(t, p)
+	}
 	dt := g.datatype(t)
 
 	if dt == TypeUnsupported {
@@ -895,7 +1167,11 @@ func (g *Generator) newSchemaFromType(t reflect.Type) *SchemaOrRef {
 		case reflect.Slice, reflect.Array, reflect.Map:
 			return g.buildSchemaRecursive(t)
 		case reflect.Struct:
-			return g.newSchemaFromStruct(t)
+			sor := g.newSchemaFromStruct(t)
+			if sor != nil && sor.Reference != nil {
+				sor.nullable = nullable
+			}
+			return sor
 		}
 	}
 	if dt == TypeAny {
@@ -917,6 +1193,9 @@ func (g *Generator) newSchemaFromType(t reflect.Type) *SchemaOrRef {
 // buildSchemaRecursive recursively decomposes the complex
 // type t into subsequent schemas.
 func (g *Generator) buildSchemaRecursive(t reflect.Type) *SchemaOrRef {
+	if p, ok := g.polymorphics[t]; ok {
+		return g.newSchemaFromPolymorphic(t, p)
+	}
 	schema := &Schema{}
 
 	switch t.Kind() {
@@ -1003,6 +1282,78 @@ func (g *Generator) newSchemaFromStruct(t reflect.Type) *SchemaOrRef {
 	return sor
 }
 
+// newSchemaFromPolymorphic returns the schema of the interface
+// type t registered with RegisterDiscriminator: a oneOf of the
+// schemas of its concrete types, with a discriminator.
+func (g *Generator) newSchemaFromPolymorphic(t reflect.Type, p *polymorphic) *SchemaOrRef {
+	name := g.typeName(t)
+	if name != "" {
+		if _, ok := g.schemaTypes[t]; ok {
+			return &SchemaOrRef{Reference: &Reference{
+				Ref: componentsSchemaPath + name,
+			}}
+		}
+		g.schemaTypes[t] = struct{}{}
+	}
+	schema := &Schema{
+		Discriminator: &Discriminator{
+			PropertyName: p.property,
+			Mapping:      make(map[string]string, len(p.mapping)),
+		},
+	}
+	values := make([]string, 0, len(p.mapping))
+	for v := range p.mapping {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	for _, v := range values {
+		sor := g.newSchemaFromStruct(p.mapping[v])
+		if sor == nil || sor.Reference == nil {
+			continue
+		}
+		schema.Discriminator.Mapping[v] = sor.Reference.Ref
+
+		// A type may be mapped to several values.
+		var seen bool
+		for _, o := range schema.OneOf {
+			seen = seen || o.Reference.Ref == sor.Reference.Ref
+		}
+		if !seen {
+			schema.OneOf = append(schema.OneOf, sor)
+		}
+		// Document the values of the property
+		// in the schema of the concrete type.
+		cs := g.resolveSchema(sor)
+		if cs == nil {
+			continue
+		}
+		ps, ok := cs.Properties[p.property]
+		if !ok || ps.Schema == nil {
+			g.error(&TypeError{
+				Message: fmt.Sprintf("discriminated type has no property %s", p.property),
+				Type:    p.mapping[v],
+			})
+			continue
+		}
+		ps.Schema.Enum = append(ps.Schema.Enum, v)
+
+		if !contains(cs.Required, p.property) {
+			cs.Required = append(cs.Required, p.property)
+		}
+	}
+	sor := &SchemaOrRef{Schema: schema}
+
+	if name != "" {
+		g.api.Components.Schemas[name] = sor
+
+		return &SchemaOrRef{Reference: &Reference{
+			Ref: componentsSchemaPath + name,
+		}}
+	}
+	return sor
+}
+
 // flattenStructSchema recursively flatten the embedded
 // fields of the struct type t to the given schema.
 func (g *Generator) flattenStructSchema(t, parent reflect.Type, schema *Schema) *Schema {
@@ -1166,69 +1517,6 @@ func (g *Generator) typeName(t reflect.Type) string {
 	return strings.Title(pkg) + strings.Title(typ)
 }
 
-// updateSchemaValidation fills the fields of the schema
-// related to the JSON Schema Validation RFC based on the
-// content of the validator tag.
-// see https://godoc.org/gopkg.in/go-playground/validator.v8
-func (g *Generator) updateSchemaValidation(schema *Schema, sf reflect.StructField) *Schema {
-	ts := sf.Tag.Get(g.config.ValidatorTag)
-	if ts == "" {
-		return schema
-	}
-	ft := sf.Type
-	if sf.Type.Kind() == reflect.Ptr {
-		ft = sf.Type.Elem()
-	}
-	tags := strings.Split(ts, ",")
-
-	for _, t := range tags {
-		if t == "dive" || t == "keys" {
-			break
-		}
-		if t == "email" {
-			schema.Format = "email"
-			break
-		}
-		// Tags can be joined together with an OR operator.
-		parts := strings.Split(t, "|")
-
-		for _, p := range parts {
-			var k, v string
-			// Split k/v pair using separator.
-			sepIdx := strings.Index(p, "=")
-			if sepIdx == -1 {
-				k = p
-			} else {
-				k = p[:sepIdx]
-				v = p[sepIdx+1:]
-			}
-			// Handle validators with value.
-			switch k {
-			case "len", "max", "min", "eq", "gt", "gte", "lt", "lte":
-				n, err := strconv.Atoi(v)
-				if err != nil {
-					continue
-				}
-				switch k {
-				case "len":
-					setSchemaLen(schema, n, ft)
-				case "max", "lte":
-					setSchemaMax(schema, n, ft)
-				case "min", "gte":
-					setSchemaMin(schema, n, ft)
-				case "lt":
-					setSchemaMax(schema, n-1, ft)
-				case "gt":
-					setSchemaMin(schema, n+1, ft)
-				case "eq":
-					setSchemaEq(schema, n, ft)
-				}
-			}
-		}
-	}
-	return schema
-}
-
 func (g *Generator) error(err error) {
 	g.errors = append(g.errors, err)
 }
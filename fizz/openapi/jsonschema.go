@@ -0,0 +1,385 @@
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// JSONSchemaDialect is the URI of the JSON Schema 2020-12
+// dialect, used by OpenAPI 3.1 and the standalone schemas.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+const defsPath = "#/$defs/"
+
+// to31 returns a copy of the OpenAPI 3.0 specification
+// api converted to OpenAPI 3.1. The schemas are rewritten
+// for JSON Schema 2020-12: nullable becomes a null type,
+// example becomes examples, single value enums become
+// const, and the references to nullable schemas become
+// an anyOf with the null type.
+func (api OpenAPI) to31() *OpenAPI {
+	c := &converter{refPrefix: componentsSchemaPath}
+
+	if api.Paths != nil {
+		paths := make(Paths, len(api.Paths))
+		for k, pi := range api.Paths {
+			paths[k] = c.pathItem(pi)
+		}
+		api.Paths = paths
+	}
+	if api.Webhooks != nil {
+		hooks := make(map[string]*PathItem, len(api.Webhooks))
+		for k, pi := range api.Webhooks {
+			hooks[k] = c.pathItem(pi)
+		}
+		api.Webhooks = hooks
+	}
+	if api.Components != nil {
+		comps := *api.Components
+		comps.Schemas = c.schemaMap(comps.Schemas)
+
+		if comps.Parameters != nil {
+			comps.Parameters = make(map[string]*ParameterOrRef, len(api.Components.Parameters))
+			for k, p := range api.Components.Parameters {
+				comps.Parameters[k] = c.parameter(p)
+			}
+		}
+		comps.Headers = c.headers(comps.Headers)
+
+		if comps.Responses != nil {
+			comps.Responses = make(map[string]*ResponseOrRef, len(api.Components.Responses))
+			for k, r := range api.Components.Responses {
+				comps.Responses[k] = c.response(r)
+			}
+		}
+		api.Components = &comps
+	}
+	return &api
+}
+
+// JSONSchema returns the schema of the component name as a
+// standalone JSON Schema 2020-12 document. The components it
+// references, directly or not, are copied to its $defs.
+func (api *OpenAPI) JSONSchema(name string) (*Schema, error) {
+	if api.Components == nil || api.Components.Schemas[name] == nil {
+		return nil, fmt.Errorf("unknown schema %s", name)
+	}
+	return api.jsonSchema(api.Components.Schemas[name])
+}
+
+// JSONSchema returns the schema of the type t as a standalone
+// JSON Schema 2020-12 document. The type is added to the
+// components of the specification if it is named.
+func (g *Generator) JSONSchema(t reflect.Type) (*Schema, error) {
+	n := len(g.errors)
+	sor := g.newSchemaFromType(t)
+	if len(g.errors) > n {
+		return nil, g.errors[n]
+	}
+	if sor == nil {
+		return nil, errors.New("type has no schema")
+	}
+	if sor.Reference != nil {
+		return g.api.JSONSchema(strings.TrimPrefix(sor.Reference.Ref, componentsSchemaPath))
+	}
+	return g.api.jsonSchema(sor)
+}
+
+func (api *OpenAPI) jsonSchema(root *SchemaOrRef) (*Schema, error) {
+	c := &converter{refPrefix: defsPath}
+
+	// Collect the components referenced by
+	// the schema, directly or transitively.
+	var (
+		names = map[string]bool{}
+		err   error
+		visit func(*SchemaOrRef)
+	)
+	visit = func(sor *SchemaOrRef) {
+		walkSchema(sor, func(ref string) {
+			name := strings.TrimPrefix(ref, componentsSchemaPath)
+			if name == ref {
+				err = fmt.Errorf("unsupported reference %s", ref)
+				return
+			}
+			if names[name] {
+				return
+			}
+			names[name] = true
+
+			if api.Components == nil || api.Components.Schemas[name] == nil {
+				err = fmt.Errorf("unresolvable reference %s", ref)
+				return
+			}
+			visit(api.Components.Schemas[name])
+		})
+	}
+	if root.Reference != nil {
+		// A component aliasing another one.
+		s := api.resolveSchema(root)
+		if s == nil {
+			return nil, fmt.Errorf("unresolvable reference %s", root.Reference.Ref)
+		}
+		root = &SchemaOrRef{Schema: s}
+	}
+	visit(root)
+	if err != nil {
+		return nil, err
+	}
+	doc := c.schema(root).Schema
+	doc.Dialect = JSONSchemaDialect
+
+	if len(names) != 0 {
+		doc.Defs = make(map[string]*SchemaOrRef, len(names))
+		for name := range names {
+			doc.Defs[name] = c.schema(api.Components.Schemas[name])
+		}
+	}
+	return doc, nil
+}
+
+// walkSchema calls fn with the references found in the schema.
+func walkSchema(sor *SchemaOrRef, fn func(ref string)) {
+	if sor == nil {
+		return
+	}
+	if sor.Reference != nil {
+		fn(sor.Reference.Ref)
+		return
+	}
+	s := sor.Schema
+	if s == nil {
+		return
+	}
+	for _, ss := range [][]*SchemaOrRef{s.AllOf, s.OneOf, s.AnyOf} {
+		for _, sub := range ss {
+			walkSchema(sub, fn)
+		}
+	}
+	walkSchema(s.Items, fn)
+	walkSchema(s.AdditionalProperties, fn)
+
+	for _, p := range s.Properties {
+		walkSchema(p, fn)
+	}
+	for _, d := range s.Defs {
+		walkSchema(d, fn)
+	}
+}
+
+// converter copies the parts of a specification holding
+// schemas, converting the schemas to JSON Schema 2020-12.
+type converter struct {
+	// refPrefix replaces the components
+	// prefix of the schema references.
+	refPrefix string
+}
+
+func (c *converter) schema(sor *SchemaOrRef) *SchemaOrRef {
+	if sor == nil {
+		return nil
+	}
+	if sor.Schema == nil {
+		if sor.Reference == nil {
+			return sor
+		}
+		ref := &SchemaOrRef{Reference: &Reference{
+			Ref: c.refPrefix + strings.TrimPrefix(sor.Reference.Ref, componentsSchemaPath),
+		}}
+		if sor.nullable {
+			return &SchemaOrRef{Schema: &Schema{
+				AnyOf: []*SchemaOrRef{ref, {Schema: &Schema{Type: "null"}}},
+			}}
+		}
+		return ref
+	}
+	s := *sor.Schema
+
+	if s.Nullable {
+		s.Nullable = false
+
+		switch {
+		case len(s.Types) != 0:
+			if !contains(s.Types, "null") {
+				s.Types = append(append([]string(nil), s.Types...), "null")
+			}
+		case s.Type != "":
+			s.Types = []string{s.Type, "null"}
+		}
+		// A schema without type already accepts null.
+
+		if len(s.Enum) != 0 {
+			s.Enum = append(append([]interface{}(nil), s.Enum...), nil)
+		}
+	}
+	if s.Example != nil {
+		s.Examples = append([]interface{}{s.Example}, s.Examples...)
+		s.Example = nil
+	}
+	// The exclusive bounds are numbers in OpenAPI 3.1.
+	if s.ExclusiveMinimum && s.Minimum != nil {
+		s.exclusiveMinimum, s.Minimum, s.ExclusiveMinimum = s.Minimum, nil, false
+	}
+	if s.ExclusiveMaximum && s.Maximum != nil {
+		s.exclusiveMaximum, s.Maximum, s.ExclusiveMaximum = s.Maximum, nil, false
+	}
+	// OpenAPI 3.0 has no const, a single
+	// value enum is used instead.
+	if len(s.Enum) == 1 && s.Const == nil {
+		s.Const, s.Enum = s.Enum[0], nil
+	}
+	if s.Discriminator != nil {
+		d := *s.Discriminator
+		d.Mapping = make(map[string]string, len(s.Discriminator.Mapping))
+		for k, ref := range s.Discriminator.Mapping {
+			d.Mapping[k] = c.refPrefix + strings.TrimPrefix(ref, componentsSchemaPath)
+		}
+		s.Discriminator = &d
+	}
+	s.AllOf = c.schemas(s.AllOf)
+	s.OneOf = c.schemas(s.OneOf)
+	s.AnyOf = c.schemas(s.AnyOf)
+	s.Items = c.schema(s.Items)
+	s.AdditionalProperties = c.schema(s.AdditionalProperties)
+	s.Properties = c.schemaMap(s.Properties)
+	s.Defs = c.schemaMap(s.Defs)
+
+	return &SchemaOrRef{Schema: &s}
+}
+
+func (c *converter) schemas(ss []*SchemaOrRef) []*SchemaOrRef {
+	if ss == nil {
+		return nil
+	}
+	cpy := make([]*SchemaOrRef, len(ss))
+	for i, s := range ss {
+		cpy[i] = c.schema(s)
+	}
+	return cpy
+}
+
+func (c *converter) schemaMap(m map[string]*SchemaOrRef) map[string]*SchemaOrRef {
+	if m == nil {
+		return nil
+	}
+	cpy := make(map[string]*SchemaOrRef, len(m))
+	for k, s := range m {
+		cpy[k] = c.schema(s)
+	}
+	return cpy
+}
+
+func (c *converter) pathItem(pi *PathItem) *PathItem {
+	if pi == nil {
+		return nil
+	}
+	cpy := *pi
+	for _, op := range []**Operation{&cpy.GET, &cpy.PUT, &cpy.POST, &cpy.DELETE, &cpy.OPTIONS, &cpy.HEAD, &cpy.PATCH, &cpy.TRACE} {
+		*op = c.operation(*op)
+	}
+	cpy.Parameters = c.parameters(pi.Parameters)
+
+	return &cpy
+}
+
+func (c *converter) operation(op *Operation) *Operation {
+	if op == nil {
+		return nil
+	}
+	cpy := *op
+	cpy.Parameters = c.parameters(op.Parameters)
+
+	if op.RequestBody != nil {
+		rb := *op.RequestBody
+		rb.Content = make(map[string]*MediaType, len(op.RequestBody.Content))
+		for k, mt := range op.RequestBody.Content {
+			rb.Content[k] = c.mediaType(mt)
+		}
+		cpy.RequestBody = &rb
+	}
+	if op.Responses != nil {
+		cpy.Responses = make(Responses, len(op.Responses))
+		for k, r := range op.Responses {
+			cpy.Responses[k] = c.response(r)
+		}
+	}
+	if op.Callbacks != nil {
+		cpy.Callbacks = make(map[string]Callback, len(op.Callbacks))
+		for k, cb := range op.Callbacks {
+			cbc := make(Callback, len(cb))
+			for expr, pi := range cb {
+				cbc[expr] = c.pathItem(pi)
+			}
+			cpy.Callbacks[k] = cbc
+		}
+	}
+	return &cpy
+}
+
+func (c *converter) parameters(ps []*ParameterOrRef) []*ParameterOrRef {
+	if ps == nil {
+		return nil
+	}
+	cpy := make([]*ParameterOrRef, len(ps))
+	for i, p := range ps {
+		cpy[i] = c.parameter(p)
+	}
+	return cpy
+}
+
+func (c *converter) parameter(p *ParameterOrRef) *ParameterOrRef {
+	if p == nil || p.Parameter == nil {
+		return p
+	}
+	cpy := *p.Parameter
+	cpy.Schema = c.schema(p.Schema)
+
+	return &ParameterOrRef{Parameter: &cpy}
+}
+
+func (c *converter) response(r *ResponseOrRef) *ResponseOrRef {
+	if r == nil || r.Response == nil {
+		return r
+	}
+	cpy := *r.Response
+	cpy.Headers = c.headers(r.Headers)
+
+	if r.Content != nil {
+		cpy.Content = make(map[string]*MediaTypeOrRef, len(r.Content))
+		for k, mt := range r.Content {
+			if mt != nil && mt.MediaType != nil {
+				mt = &MediaTypeOrRef{MediaType: c.mediaType(mt.MediaType)}
+			}
+			cpy.Content[k] = mt
+		}
+	}
+	return &ResponseOrRef{Response: &cpy}
+}
+
+func (c *converter) headers(hs map[string]*HeaderOrRef) map[string]*HeaderOrRef {
+	if hs == nil {
+		return nil
+	}
+	cpy := make(map[string]*HeaderOrRef, len(hs))
+	for k, h := range hs {
+		if h != nil && h.Header != nil {
+			hc := *h.Header
+			hc.Schema = c.schema(h.Schema)
+			h = &HeaderOrRef{Header: &hc}
+		}
+		cpy[k] = h
+	}
+	return cpy
+}
+
+func (c *converter) mediaType(mt *MediaType) *MediaType {
+	if mt == nil {
+		return nil
+	}
+	cpy := *mt
+	cpy.Schema = c.schema(mt.Schema)
+
+	return &cpy
+}
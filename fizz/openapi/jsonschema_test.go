@@ -0,0 +1,146 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type jsOwner struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type jsPet struct {
+	ID       int64    `json:"id" validate:"required"`
+	Nickname *string  `json:"nickname" example:"Rex"`
+	Kind     string   `json:"kind" enum:"pet"`
+	Owner    *jsOwner `json:"owner"`
+	Parent   *jsPet   `json:"parent"`
+}
+
+type jsPetEvent struct {
+	Pet *jsPet `json:"pet"`
+}
+
+func newTestGenerator(t *testing.T) *Generator {
+	g, err := NewGenerator(&SpecGenConfig{
+		ValidatorTag:      "validate",
+		PathLocationTag:   "path",
+		QueryLocationTag:  "query",
+		HeaderLocationTag: "header",
+		EnumTag:           "enum",
+		DefaultTag:        "default",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &OperationInfo{StatusCode: 200}
+	if _, err := g.AddOperation("/pets/:id", "GET", "", reflect.TypeOf(struct {
+		ID int64 `path:"id"`
+	}{}), reflect.TypeOf(jsPet{}), info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.AddWebhook("petCreated", "POST", "", reflect.TypeOf(jsPetEvent{}), nil, &OperationInfo{ID: "petCreated", StatusCode: 204}); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func marshalFlat(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestOpenAPI31(t *testing.T) {
+	g := newTestGenerator(t)
+
+	v30 := marshalFlat(t, g.API())
+	for _, want := range []string{
+		`"openapi":"3.0.1"`,
+		`"nullable":true`,
+		`"example":"Rex"`,
+		`"x-webhooks":{"petCreated"`,
+	} {
+		if !strings.Contains(v30, want) {
+			t.Errorf("3.0 specification lacks %s", want)
+		}
+	}
+	if err := g.SetOpenAPIVersion("3.1"); err != nil {
+		t.Fatal(err)
+	}
+	api := g.API()
+	v31 := marshalFlat(t, api)
+	for _, want := range []string{
+		`"openapi":"3.1.0"`,
+		`"type":["string","null"]`,
+		`"examples":["Rex"]`,
+		`"const":"pet"`,
+		`"owner":{"anyOf":[{"$ref":"#/components/schemas/OpenapiJsOwner"},{"type":"null"}]}`,
+		`"webhooks":{"petCreated"`,
+	} {
+		if !strings.Contains(v31, want) {
+			t.Errorf("3.1 specification lacks %s", want)
+		}
+	}
+	if strings.Contains(v31, `"nullable"`) || strings.Contains(v31, `"example"`) {
+		t.Error("3.1 specification has OpenAPI 3.0 keywords")
+	}
+	// The internal specification is left untouched.
+	if v30again := marshalFlat(t, g.api); strings.Contains(v30again, `"examples"`) {
+		t.Error("conversion altered the generator's specification")
+	}
+
+	pet := &SchemaOrRef{Reference: &Reference{Ref: componentsSchemaPath + "OpenapiJsPet"}}
+	if err := api.ValidateValue(pet, map[string]interface{}{"id": 1.0, "kind": "pet", "owner": nil, "nickname": nil}); err != nil {
+		t.Errorf("valid value rejected: %v", err)
+	}
+	if err := api.ValidateValue(pet, map[string]interface{}{"id": 1.0, "kind": "cat"}); err == nil {
+		t.Error("const not enforced")
+	}
+
+	// Decoding restores the OpenAPI 3.0 view of the schemas.
+	var decoded OpenAPI
+	if err := json.Unmarshal([]byte(v31), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	ps := decoded.Components.Schemas["OpenapiJsPet"].Properties
+	if nn := ps["nickname"].Schema; nn.Type != "string" || !nn.Nullable {
+		t.Errorf("nickname decoded as %+v", nn)
+	}
+	if o := ps["owner"]; o.Reference == nil || !o.nullable {
+		t.Errorf("owner decoded as %+v", o)
+	}
+	if again := marshalFlat(t, decoded.to31()); again != v31 {
+		t.Errorf("round trip changed the specification:\n%s\n%s", v31, again)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	g := newTestGenerator(t)
+
+	doc, err := g.JSONSchema(reflect.TypeOf(jsPetEvent{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := marshalFlat(t, doc)
+	for _, want := range []string{
+		`"$schema":"https://json-schema.org/draft/2020-12/schema"`,
+		`"$ref":"#/$defs/OpenapiJsPet"`,
+		`"$ref":"#/$defs/OpenapiJsOwner"`,
+		`"$defs":{`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("JSON schema lacks %s: %s", want, s)
+		}
+	}
+	if len(doc.Defs) != 2 || strings.Contains(s, "#/components/") {
+		t.Errorf("unexpected definitions: %s", s)
+	}
+	if _, err := g.API().JSONSchema("Unknown"); err == nil {
+		t.Error("expected an error for an unknown schema")
+	}
+}
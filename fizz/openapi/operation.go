@@ -12,9 +12,48 @@ type OperationInfo struct {
 	Deprecated        bool
 	InputModel        interface{}
 	Responses         []*OperationResponse
+	Callbacks         []*OperationCallback
+	Links             []*ResponseLink
 	Security          []*SecurityRequirement
 	XCodeSamples      []*XCodeSample
 	XInternal         bool
+	// Values are settings of the operation read by middleware
+	// at runtime, not part of the specification.
+	Values map[interface{}]interface{}
+}
+
+// OperationCallback represents a request the API sends
+// to a URL given by a runtime expression, usually read
+// from the request of the operation.
+type OperationCallback struct {
+	// Name identifies the callback in the operation.
+	Name string
+	// Expression is the runtime expression of the
+	// URL, such as {$request.body#/callbackUrl}.
+	Expression string
+	Method     string
+	// Model is the type of the request sent.
+	Model interface{}
+	// Info describes the request sent, and the
+	// response expected from the consumer.
+	Info *OperationInfo
+}
+
+// ResponseLink represents a link from a response
+// of an operation to another operation.
+type ResponseLink struct {
+	// Code is the code of the response, or
+	// the default status code of the operation
+	// if empty.
+	Code        string
+	Name        string
+	OperationID string
+	// Parameters maps the parameters of the linked
+	// operation to values or runtime expressions,
+	// such as $response.body#/id.
+	Parameters  map[string]interface{}
+	RequestBody interface{}
+	Description string
 }
 
 // ResponseHeader represents a single header that
@@ -0,0 +1,71 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+
+	"mkfst/tonic"
+)
+
+type rsOrder struct {
+	ID string `json:"id"`
+}
+
+type rsJob struct {
+	ID string `json:"id"`
+}
+
+type rsCreateOrder struct {
+	tonic.Responses
+	Created  *rsOrder  `status:"201" description:"The order was created" headers:"Location"`
+	Accepted *rsJob    `status:"202"`
+	Existing *struct{} `status:"303" headers:"location"`
+}
+
+func TestResponseVariants(t *testing.T) {
+	g := newTestGenerator(t)
+
+	info := &OperationInfo{
+		ID:         "createOrder",
+		StatusCode: 200,
+		Headers:    []*ResponseHeader{{Name: "Location", Description: "URL of the order"}},
+		Responses:  []*OperationResponse{{Code: "409", Description: "Conflict"}},
+		Links:      []*ResponseLink{{Name: "GetOrder", OperationID: "getOrder"}},
+	}
+	op, err := g.AddOperation("/orders", "POST", "", nil, reflect.TypeOf(rsCreateOrder{}), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := op.Responses["200"]; ok {
+		t.Error("unexpected response for the status code of the route")
+	}
+	for code, schema := range map[string]bool{"201": true, "202": true, "303": false, "409": false} {
+		r := op.Responses[code]
+		if r == nil {
+			t.Errorf("missing response %s", code)
+			continue
+		}
+		if got := len(r.Content) != 0; got != schema {
+			t.Errorf("response %s: has content %v, want %v", code, got, schema)
+		}
+	}
+	created := op.Responses["201"]
+	if created.Description != "The order was created" || op.Responses["202"].Description != "Accepted" {
+		t.Errorf("unexpected descriptions: %q %q", created.Description, op.Responses["202"].Description)
+	}
+	if h := created.Headers["Location"]; h == nil || h.Description != "URL of the order" {
+		t.Errorf("unexpected Location header: %+v", created.Headers)
+	}
+	if h := op.Responses["303"].Headers["Location"]; h == nil || h.Description != "URL of the order" {
+		t.Errorf("unexpected documented header: %+v", op.Responses["303"].Headers)
+	}
+	// Links without a status code go to the first variant.
+	if created.Links["GetOrder"] == nil {
+		t.Errorf("link not added to the first variant: %+v", created.Links)
+	}
+
+	_, err = g.AddOperation("/invalid", "POST", "", nil, reflect.TypeOf(struct{ tonic.Responses }{}), &OperationInfo{ID: "invalid", StatusCode: 200})
+	if err == nil {
+		t.Error("expected an error for a Responses type without variants")
+	}
+}
@@ -1,18 +1,25 @@
 package openapi
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
 
 // OpenAPI represents the root document object of
 // an OpenAPI document.
 type OpenAPI struct {
-	OpenAPI    string                 `json:"openapi" yaml:"openapi"`
-	Info       *Info                  `json:"info" yaml:"info"`
-	Servers    []*Server              `json:"servers,omitempty" yaml:"servers,omitempty"`
-	Paths      Paths                  `json:"paths" yaml:"paths"`
-	Components *Components            `json:"components,omitempty" yaml:"components,omitempty"`
-	Tags       []*Tag                 `json:"tags,omitempty" yaml:"tags,omitempty"`
-	Security   []*SecurityRequirement `json:"security,omitempty" yaml:"security,omitempty"`
-	XTagGroups []*XTagGroup           `json:"x-tagGroups,omitempty" yaml:"x-tagGroups,omitempty"`
+	OpenAPI           string                 `json:"openapi" yaml:"openapi"`
+	Info              *Info                  `json:"info" yaml:"info"`
+	JSONSchemaDialect string                 `json:"jsonSchemaDialect,omitempty" yaml:"jsonSchemaDialect,omitempty"`
+	Servers           []*Server              `json:"servers,omitempty" yaml:"servers,omitempty"`
+	Paths             Paths                  `json:"paths" yaml:"paths"`
+	Webhooks          map[string]*PathItem   `json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+	Components        *Components            `json:"components,omitempty" yaml:"components,omitempty"`
+	Tags              []*Tag                 `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Security          []*SecurityRequirement `json:"security,omitempty" yaml:"security,omitempty"`
+	XTagGroups        []*XTagGroup           `json:"x-tagGroups,omitempty" yaml:"x-tagGroups,omitempty"`
+	XWebhooks         map[string]*PathItem   `json:"x-webhooks,omitempty" yaml:"x-webhooks,omitempty"`
 }
 
 // Components holds a set of reusable objects for different
@@ -136,16 +143,59 @@ type RequestBody struct {
 type SchemaOrRef struct {
 	*Schema
 	*Reference
+
+	// nullable is set on the references to the schemas
+	// of pointer types, which OpenAPI 3.0 cannot describe.
+	nullable bool
 }
 
 // MarshalYAML implements yaml.Marshaler for SchemaOrRef.
 func (sor *SchemaOrRef) MarshalYAML() (interface{}, error) {
 	if sor.Schema != nil {
-		return sor.Schema, nil
+		return sor.Schema.MarshalYAML()
 	}
 	return sor.Reference, nil
 }
 
+// MarshalJSON implements json.Marshaler for SchemaOrRef.
+// It is required since the methods of Schema are promoted.
+func (sor *SchemaOrRef) MarshalJSON() ([]byte, error) {
+	if sor.Schema != nil {
+		return sor.Schema.MarshalJSON()
+	}
+	return json.Marshal(sor.Reference)
+}
+
+// UnmarshalJSON implements json.Unmarshaler for SchemaOrRef.
+func (sor *SchemaOrRef) UnmarshalJSON(b []byte) error {
+	var ref struct {
+		Ref *string `json:"$ref"`
+	}
+	if err := json.Unmarshal(b, &ref); err != nil {
+		return err
+	}
+	if ref.Ref != nil {
+		sor.Reference = &Reference{Ref: *ref.Ref}
+		return nil
+	}
+	sor.Schema = new(Schema)
+	if err := sor.Schema.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	// Restore the nullable references of OpenAPI 3.1,
+	// described as an anyOf with the null type.
+	if s := sor.Schema; len(s.AnyOf) == 2 && s.AnyOf[0].Reference != nil {
+		if n := s.AnyOf[1].Schema; n != nil && n.Type == "null" && len(n.Types) == 0 {
+			only := *s
+			only.AnyOf = nil
+			if reflect.DeepEqual(only, Schema{}) {
+				*sor = SchemaOrRef{Reference: s.AnyOf[0].Reference, nullable: true}
+			}
+		}
+	}
+	return nil
+}
+
 // Schema represents the definition of input and output data
 // types of the API.
 type Schema struct {
@@ -153,9 +203,10 @@ type Schema struct {
 	// definition but their definitions were adjusted to the
 	// OpenAPI Specification.
 	Type                 string                  `json:"type,omitempty" yaml:"type,omitempty"`
-	AllOf                *SchemaOrRef            `json:"allOf,omitempty" yaml:"allOf,omitempty"`
-	OneOf                *SchemaOrRef            `json:"oneOf,omitempty" yaml:"oneOf,omitempty"`
-	AnyOf                *SchemaOrRef            `json:"anyOf,omitempty" yaml:"anyOf,omitempty"`
+	AllOf                []*SchemaOrRef          `json:"allOf,omitempty" yaml:"allOf,omitempty"`
+	OneOf                []*SchemaOrRef          `json:"oneOf,omitempty" yaml:"oneOf,omitempty"`
+	AnyOf                []*SchemaOrRef          `json:"anyOf,omitempty" yaml:"anyOf,omitempty"`
+	Discriminator        *Discriminator          `json:"discriminator,omitempty" yaml:"discriminator,omitempty"`
 	Items                *SchemaOrRef            `json:"items,omitempty" yaml:"items,omitempty"`
 	Properties           map[string]*SchemaOrRef `json:"properties,omitempty" yaml:"properties,omitempty"`
 	AdditionalProperties *SchemaOrRef            `json:"additionalProperties,omitempty" yaml:"additionalProperties,omitempty"`
@@ -167,10 +218,10 @@ type Schema struct {
 	// The following properties are taken directly from the
 	// JSON Schema definition and follow the same specifications
 	Title            string        `json:"title,omitempty" yaml:"title,omitempty"`
-	MultipleOf       int           `json:"multipleOf,omitempty" yaml:"multipleOf,omitempty"`
-	Maximum          int           `json:"maximum,omitempty" yaml:"maximum,omitempty"`
+	MultipleOf       *float64      `json:"multipleOf,omitempty" yaml:"multipleOf,omitempty"`
+	Maximum          *float64      `json:"maximum,omitempty" yaml:"maximum,omitempty"`
 	ExclusiveMaximum bool          `json:"exclusiveMaximum,omitempty" yaml:"exclusiveMaximum,omitempty"`
-	Minimum          int           `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+	Minimum          *float64      `json:"minimum,omitempty" yaml:"minimum,omitempty"`
 	ExclusiveMinimum bool          `json:"exclusiveMinimum,omitempty" yaml:"exclusiveMinimum,omitempty"`
 	MaxLength        int           `json:"maxLength,omitempty" yaml:"maxLength,omitempty"`
 	MinLength        int           `json:"minLength,omitempty" yaml:"minLength,omitempty"`
@@ -184,6 +235,148 @@ type Schema struct {
 	Enum             []interface{} `json:"enum,omitempty" yaml:"enum,omitempty"`
 	Nullable         bool          `json:"nullable,omitempty" yaml:"nullable,omitempty"`
 	Deprecated       bool          `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+
+	// The following properties are only valid in OpenAPI 3.1
+	// documents, and standalone JSON Schema 2020-12 documents.
+	// Types replaces Type when the schema allows several types,
+	// such as ["string", "null"] for a nullable string.
+	Types    []string                `json:"-" yaml:"-"`
+	Const    interface{}             `json:"const,omitempty" yaml:"const,omitempty"`
+	Examples []interface{}           `json:"examples,omitempty" yaml:"examples,omitempty"`
+	Dialect  string                  `json:"$schema,omitempty" yaml:"$schema,omitempty"`
+	ID       string                  `json:"$id,omitempty" yaml:"$id,omitempty"`
+	Defs     map[string]*SchemaOrRef `json:"$defs,omitempty" yaml:"$defs,omitempty"`
+
+	// exclusiveMinimum and exclusiveMaximum replace the boolean
+	// ExclusiveMinimum and ExclusiveMaximum in OpenAPI 3.1, where
+	// they are bounds instead of modifiers of Minimum and Maximum.
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+}
+
+// Float returns a pointer to f, to set the
+// numeric constraints of a schema.
+func Float(f float64) *float64 { return &f }
+
+// MarshalJSON implements json.Marshaler for Schema,
+// writing Types as the type of the schema if set.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema
+	if !s.is31() {
+		return json.Marshal((*schema)(s))
+	}
+	cpy := schema(*s)
+	cpy.Nullable = false
+
+	var typ interface{}
+	switch {
+	case len(s.Types) != 0:
+		typ = s.Types
+	case s.Type != "":
+		typ = s.Type
+	}
+	return json.Marshal(struct {
+		*schema
+		Type             interface{} `json:"type,omitempty"`
+		ExclusiveMinimum *float64    `json:"exclusiveMinimum,omitempty"`
+		ExclusiveMaximum *float64    `json:"exclusiveMaximum,omitempty"`
+	}{&cpy, typ, s.exclusiveMinimum, s.exclusiveMaximum})
+}
+
+// is31 returns whether the schema uses keywords
+// written differently in OpenAPI 3.0 and 3.1.
+func (s *Schema) is31() bool {
+	return len(s.Types) != 0 || s.exclusiveMinimum != nil || s.exclusiveMaximum != nil
+}
+
+// MarshalYAML implements yaml.Marshaler for Schema.
+func (s *Schema) MarshalYAML() (interface{}, error) {
+	type schema Schema
+	if !s.is31() {
+		return (*schema)(s), nil
+	}
+	// The YAML encoders do not let an outer field
+	// override an inlined one, go through JSON.
+	b, err := s.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler for Schema. A type
+// given as a list is stored in Types; if it holds a single type
+// besides null, it is also stored in Type, with Nullable set,
+// which is how OpenAPI 3.0 describes the same schema. Likewise,
+// numeric exclusive bounds are stored in Minimum and Maximum,
+// with ExclusiveMinimum and ExclusiveMaximum set.
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	type schema Schema
+	v := struct {
+		*schema
+		Type             json.RawMessage `json:"type"`
+		ExclusiveMinimum json.RawMessage `json:"exclusiveMinimum"`
+		ExclusiveMaximum json.RawMessage `json:"exclusiveMaximum"`
+	}{schema: (*schema)(s)}
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if err := unmarshalExclusive(v.ExclusiveMinimum, &s.ExclusiveMinimum, &s.Minimum); err != nil {
+		return err
+	}
+	if err := unmarshalExclusive(v.ExclusiveMaximum, &s.ExclusiveMaximum, &s.Maximum); err != nil {
+		return err
+	}
+	if len(v.Type) == 0 {
+		return nil
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(v.Type), []byte("[")) {
+		return json.Unmarshal(v.Type, &s.Type)
+	}
+	if err := json.Unmarshal(v.Type, &s.Types); err != nil {
+		return err
+	}
+	var others []string
+	for _, t := range s.Types {
+		if t == "null" {
+			s.Nullable = true
+		} else {
+			others = append(others, t)
+		}
+	}
+	if len(others) == 1 {
+		s.Type = others[0]
+	}
+	return nil
+}
+
+// unmarshalExclusive decodes an exclusive bound b, a boolean in
+// OpenAPI 3.0 and a number in OpenAPI 3.1, into exclusive and bound.
+func unmarshalExclusive(b json.RawMessage, exclusive *bool, bound **float64) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, exclusive); err == nil {
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*exclusive, *bound = true, &f
+	return nil
+}
+
+// Discriminator tells which of the schemas of a oneOf
+// describes a value, using the value of one of its properties.
+type Discriminator struct {
+	PropertyName string            `json:"propertyName" yaml:"propertyName"`
+	Mapping      map[string]string `json:"mapping,omitempty" yaml:"mapping,omitempty"`
 }
 
 // Operation describes an API operation on a path.
@@ -195,27 +388,32 @@ type Operation struct {
 	Parameters   []*ParameterOrRef      `json:"parameters,omitempty" yaml:"parameters,omitempty"`
 	RequestBody  *RequestBody           `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
 	Responses    Responses              `json:"responses,omitempty" yaml:"responses,omitempty"`
+	Callbacks    map[string]Callback    `json:"callbacks,omitempty" yaml:"callbacks,omitempty"`
 	Deprecated   bool                   `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
 	Servers      []*Server              `json:"servers,omitempty" yaml:"servers,omitempty"`
 	Security     []*SecurityRequirement `json:"security" yaml:"security"`
 	XCodeSamples []*XCodeSample         `json:"x-codeSamples,omitempty" yaml:"x-codeSamples,omitempty"`
 	XInternal    bool                   `json:"x-internal,omitempty" yaml:"x-internal,omitempty"`
+
+	// Values are the OperationInfo.Values, read by middleware.
+	Values map[interface{}]interface{} `json:"-" yaml:"-"`
 }
 
 // A workaround for missing omitnil functionality.
 // Explicitely omit the Security field from marshaling when it is nil, but not when empty.
 type operationNilOmitted struct {
-	Tags         []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
-	Summary      string            `json:"summary,omitempty" yaml:"summary,omitempty"`
-	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
-	ID           string            `json:"operationId,omitempty" yaml:"operationId,omitempty"`
-	Parameters   []*ParameterOrRef `json:"parameters,omitempty" yaml:"parameters,omitempty"`
-	RequestBody  *RequestBody      `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
-	Responses    Responses         `json:"responses,omitempty" yaml:"responses,omitempty"`
-	Deprecated   bool              `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
-	Servers      []*Server         `json:"servers,omitempty" yaml:"servers,omitempty"`
-	XCodeSamples []*XCodeSample    `json:"x-codeSamples,omitempty" yaml:"x-codeSamples,omitempty"`
-	XInternal    bool              `json:"x-internal,omitempty" yaml:"x-internal,omitempty"`
+	Tags         []string            `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Summary      string              `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Description  string              `json:"description,omitempty" yaml:"description,omitempty"`
+	ID           string              `json:"operationId,omitempty" yaml:"operationId,omitempty"`
+	Parameters   []*ParameterOrRef   `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	RequestBody  *RequestBody        `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
+	Responses    Responses           `json:"responses,omitempty" yaml:"responses,omitempty"`
+	Callbacks    map[string]Callback `json:"callbacks,omitempty" yaml:"callbacks,omitempty"`
+	Deprecated   bool                `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	Servers      []*Server           `json:"servers,omitempty" yaml:"servers,omitempty"`
+	XCodeSamples []*XCodeSample      `json:"x-codeSamples,omitempty" yaml:"x-codeSamples,omitempty"`
+	XInternal    bool                `json:"x-internal,omitempty" yaml:"x-internal,omitempty"`
 }
 
 // MarshalYAML implements yaml.Marshaler for Operation.
@@ -244,6 +442,7 @@ func omitOperationNilFields(o *Operation) *operationNilOmitted {
 		Parameters:   o.Parameters,
 		RequestBody:  o.RequestBody,
 		Responses:    o.Responses,
+		Callbacks:    o.Callbacks,
 		Deprecated:   o.Deprecated,
 		Servers:      o.Servers,
 		XCodeSamples: o.XCodeSamples,
@@ -251,6 +450,11 @@ func omitOperationNilFields(o *Operation) *operationNilOmitted {
 	}
 }
 
+// Callback maps runtime expressions, such as
+// {$request.body#/callbackUrl}, to the requests
+// the API sends to the URL they evaluate to.
+type Callback map[string]*PathItem
+
 // Responses represents a container for the expected responses
 // of an opration. It maps a HTTP response code to the expected
 // response.
@@ -276,6 +480,32 @@ type Response struct {
 	Description string                     `json:"description,omitempty" yaml:"description,omitempty"`
 	Headers     map[string]*HeaderOrRef    `json:"headers,omitempty" yaml:"headers,omitempty"`
 	Content     map[string]*MediaTypeOrRef `json:"content,omitempty" yaml:"content,omitempty"`
+	Links       map[string]*LinkOrRef      `json:"links,omitempty" yaml:"links,omitempty"`
+}
+
+// LinkOrRef represents a Link that can be inlined
+// or referenced in the API description.
+type LinkOrRef struct {
+	*Link
+	*Reference
+}
+
+// MarshalYAML implements yaml.Marshaler for LinkOrRef.
+func (lor *LinkOrRef) MarshalYAML() (interface{}, error) {
+	if lor.Link != nil {
+		return lor.Link, nil
+	}
+	return lor.Reference, nil
+}
+
+// Link represents a possible design-time link for a response.
+type Link struct {
+	OperationRef string                 `json:"operationRef,omitempty" yaml:"operationRef,omitempty"`
+	OperationID  string                 `json:"operationId,omitempty" yaml:"operationId,omitempty"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	RequestBody  interface{}            `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
+	Description  string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	Server       *Server                `json:"server,omitempty" yaml:"server,omitempty"`
 }
 
 // HeaderOrRef represents a Header that can be inlined
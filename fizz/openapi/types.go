@@ -14,6 +14,7 @@ import (
 var (
 	tofDataType = reflect.TypeOf((*DataType)(nil)).Elem()
 	tofNullable = reflect.TypeOf((*Nullable)(nil)).Elem()
+	tofParamDoc = reflect.TypeOf((*ParametersDocumenter)(nil)).Elem()
 
 	// Native.
 	tofTime           = reflect.TypeOf(time.Time{})
@@ -55,6 +56,14 @@ type Nullable interface {
 	Nullable() bool
 }
 
+// ParametersDocumenter is the interface implemented by the
+// types embedded in an operation input that adjust the
+// parameters they declare, using the tag of the embedding
+// field of the input struct.
+type ParametersDocumenter interface {
+	DocumentParameters(tag reflect.StructTag, params []*Parameter)
+}
+
 // InternalDataType represents an internal type.
 type InternalDataType int
 
@@ -2,65 +2,383 @@ package openapi
 
 import (
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// setSchemaMax sets the given maximum to the appropriate
-// schema field based on the given type.
-func setSchemaMax(schema *Schema, max int, t reflect.Type) {
-	if isNumber(t) {
-		schema.Maximum = max
-	} else if isString(t) {
-		if max >= 0 {
-			schema.MaxLength = max
+// ValidationSchemaFunc returns the schema fragment documenting
+// a validator tag with the parameter param, such as "3" for
+// "min=3", on a value of type t. The fields set in the fragment
+// are set in the schema of the value; a pattern is added to the
+// schema's allOf if it already has one. It may return nil if
+// the tag has no equivalent for t.
+type ValidationSchemaFunc func(param string, t reflect.Type) *Schema
+
+// RegisterValidation registers the function documenting the
+// validator tag, such as a custom validation registered with
+// tonic.RegisterValidation. It overrides the documentation of
+// the builtin tags of the same name.
+func (g *Generator) RegisterValidation(tag string, fn ValidationSchemaFunc) {
+	if g.validators == nil {
+		g.validators = make(map[string]ValidationSchemaFunc)
+	}
+	g.validators[tag] = fn
+}
+
+// validators documents the builtin tags of
+// go-playground/validator with an equivalent in
+// the JSON Schema validation vocabulary.
+var validators = map[string]ValidationSchemaFunc{
+	"min":      minSchema(false),
+	"gte":      minSchema(false),
+	"gt":       minSchema(true),
+	"max":      maxSchema(false),
+	"lte":      maxSchema(false),
+	"lt":       maxSchema(true),
+	"len":      lenSchema,
+	"eq":       eqSchema,
+	"oneof":    oneOfSchema,
+	"unique":   uniqueSchema,
+	"datetime": dateTimeSchema,
+
+	"email":            format("email"),
+	"url":              format("uri"),
+	"uri":              format("uri"),
+	"http_url":         format("uri"),
+	"uuid":             format("uuid"),
+	"uuid3":            format("uuid"),
+	"uuid4":            format("uuid"),
+	"uuid5":            format("uuid"),
+	"uuid_rfc4122":     format("uuid"),
+	"uuid3_rfc4122":    format("uuid"),
+	"uuid4_rfc4122":    format("uuid"),
+	"uuid5_rfc4122":    format("uuid"),
+	"ipv4":             format("ipv4"),
+	"ip4_addr":         format("ipv4"),
+	"ipv6":             format("ipv6"),
+	"ip6_addr":         format("ipv6"),
+	"hostname":         format("hostname"),
+	"hostname_rfc1123": format("hostname"),
+	"fqdn":             format("hostname"),
+	"base64":           format("byte"),
+
+	"alpha":       pattern(`^[a-zA-Z]+$`),
+	"alphanum":    pattern(`^[a-zA-Z0-9]+$`),
+	"numeric":     pattern(`^[-+]?[0-9]+(?:\.[0-9]+)?$`),
+	"number":      pattern(`^[0-9]+$`),
+	"hexadecimal": pattern(`^(0[xX])?[0-9a-fA-F]+$`),
+	"hexcolor":    pattern(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`),
+	"lowercase":   pattern(`^[^A-Z]*$`),
+	"uppercase":   pattern(`^[^a-z]*$`),
+	"ascii":       pattern(`^[\x00-\x7F]*$`),
+	"printascii":  pattern(`^[\x20-\x7E]*$`),
+	"e164":        pattern(`^\+[1-9]?[0-9]{7,14}$`),
+	"ulid":        pattern(`^[A-HJKMNP-TV-Za-hjkmnp-tv-z0-9]{26}$`),
+	"semver":      pattern(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`),
+
+	"startswith":    stringPattern(func(p string) string { return "^" + regexp.QuoteMeta(p) }),
+	"endswith":      stringPattern(func(p string) string { return regexp.QuoteMeta(p) + "$" }),
+	"contains":      stringPattern(regexp.QuoteMeta),
+	"containsany":   stringPattern(func(p string) string { return "[" + charClass(p) + "]" }),
+	"excludesall":   stringPattern(func(p string) string { return "^[^" + charClass(p) + "]*$" }),
+	"excludesrune":  stringPattern(func(p string) string { return "^[^" + charClass(p) + "]*$" }),
+	"startsnotwith": stringPattern(func(p string) string { return "^" + notPrefix([]rune(p)) }),
+	"endsnotwith":   stringPattern(func(p string) string { return notSuffix([]rune(p)) + "$" }),
+	"excludes": func(param string, t reflect.Type) *Schema {
+		if len([]rune(param)) != 1 {
+			// Excluding a substring takes a lookahead, which
+			// RE2 and so the validation middleware lack.
+			return nil
+		}
+		return &Schema{Pattern: "^[^" + charClass(param) + "]*$"}
+	},
+}
+
+// updateSchemaValidation fills the fields of the schema
+// related to the JSON Schema Validation RFC based on the
+// content of the validator tag.
+// see https://pkg.go.dev/github.com/go-playground/validator/v10
+func (g *Generator) updateSchemaValidation(schema *Schema, sf reflect.StructField) *Schema {
+	ts := sf.Tag.Get(g.config.ValidatorTag)
+	if ts == "" {
+		return schema
+	}
+	g.applyValidators(schema, strings.Split(ts, ","), sf.Type)
+
+	return schema
+}
+
+// applyValidators documents the validator tags on the schema
+// of a value of type t. The tags following a dive apply to
+// the items of a slice or array, or the values of a map.
+func (g *Generator) applyValidators(schema *Schema, tags []string, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < len(tags); i++ {
+		tag := strings.TrimSpace(tags[i])
+
+		switch {
+		case tag == "dive":
+			var elem *SchemaOrRef
+			switch t.Kind() {
+			case reflect.Slice, reflect.Array:
+				elem = schema.Items
+			case reflect.Map:
+				elem = schema.AdditionalProperties
+			}
+			if s := g.resolveSchema(elem); s != nil {
+				g.applyValidators(s, tags[i+1:], t.Elem())
+			}
+			return
+		case tag == "keys":
+			// The keys of a map have no schema.
+			for i < len(tags) && tags[i] != "endkeys" {
+				i++
+			}
+			continue
+		case strings.Contains(tag, "|"):
+			// The constraints of a schema all apply,
+			// alternatives can't be documented.
+			continue
+		}
+		name, param := tag, ""
+		if idx := strings.Index(tag, "="); idx != -1 {
+			name, param = tag[:idx], unescapeParam(tag[idx+1:])
+		}
+		fn, ok := g.validators[name]
+		if !ok {
+			fn, ok = validators[name]
+		}
+		if !ok {
+			continue
+		}
+		if fragment := fn(param, t); fragment != nil {
+			mergeSchema(schema, fragment)
+		}
+	}
+}
+
+// mergeSchema sets the fields of the fragment in schema.
+func mergeSchema(schema, fragment *Schema) {
+	if fragment.Pattern != "" && schema.Pattern != "" && schema.Pattern != fragment.Pattern {
+		// A schema has a single pattern, the
+		// others must match as well.
+		schema.AllOf = append(schema.AllOf, &SchemaOrRef{Schema: &Schema{Pattern: fragment.Pattern}})
+		cpy := *fragment
+		cpy.Pattern = ""
+		fragment = &cpy
+	}
+	dst, src := reflect.ValueOf(schema).Elem(), reflect.ValueOf(fragment).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if f := src.Field(i); src.Type().Field(i).IsExported() && !f.IsZero() {
+			dst.Field(i).Set(f)
+		}
+	}
+}
+
+// unescapeParam replaces the escape sequences
+// of the separators in the parameter of a tag.
+func unescapeParam(p string) string {
+	return strings.NewReplacer("0x2C", ",", "0x7C", "|").Replace(p)
+}
+
+func minSchema(exclusive bool) ValidationSchemaFunc {
+	return func(param string, t reflect.Type) *Schema {
+		if isNumber(t) {
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return nil
+			}
+			return &Schema{Minimum: &n, ExclusiveMinimum: exclusive}
+		}
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return nil
+		}
+		if exclusive {
+			n++
+		}
+		return sizeSchema(t, &n, nil)
+	}
+}
+
+func maxSchema(exclusive bool) ValidationSchemaFunc {
+	return func(param string, t reflect.Type) *Schema {
+		if isNumber(t) {
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return nil
+			}
+			return &Schema{Maximum: &n, ExclusiveMaximum: exclusive}
 		}
-	} else if isMap(t) {
-		if max >= 0 {
-			schema.MaxProperties = max
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return nil
 		}
-	} else if t.Kind() == reflect.Slice {
-		if max >= 0 {
-			schema.MaxItems = max
+		if exclusive {
+			n--
 		}
+		return sizeSchema(t, nil, &n)
 	}
 }
 
-// setSchemaMin sets the given minimum to the appropriate
-// schema field based on the given type.
-func setSchemaMin(schema *Schema, min int, t reflect.Type) {
+func lenSchema(param string, t reflect.Type) *Schema {
 	if isNumber(t) {
-		schema.Minimum = min
-	} else if isString(t) {
-		if min >= 0 {
-			schema.MinLength = min
+		return eqSchema(param, t)
+	}
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		return nil
+	}
+	return sizeSchema(t, &n, &n)
+}
+
+// sizeSchema returns the bounds of the length of a string,
+// the number of items of a slice or the properties of a map.
+func sizeSchema(t reflect.Type, min, max *int) *Schema {
+	s := &Schema{}
+	set := func(size *int, str, items, props *int) {
+		if size == nil || *size < 0 {
+			return
 		}
-	} else if isMap(t) {
-		if min >= 0 {
-			schema.MinProperties = min
+		switch {
+		case isString(t):
+			*str = *size
+		case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+			*items = *size
+		case isMap(t):
+			*props = *size
 		}
-	} else if t.Kind() == reflect.Slice {
-		if min >= 0 {
-			schema.MinItems = min
+	}
+	set(min, &s.MinLength, &s.MinItems, &s.MinProperties)
+	set(max, &s.MaxLength, &s.MaxItems, &s.MaxProperties)
+
+	return s
+}
+
+// eqSchema documents the eq tag of a number or string as a single
+// value enum, written as const in OpenAPI 3.1, and the eq tag of a
+// slice or map as its length.
+func eqSchema(param string, t reflect.Type) *Schema {
+	if isNumber(t) || isString(t) || t.Kind() == reflect.Bool {
+		v, err := stringToType(param, t)
+		if err != nil {
+			return nil
 		}
+		return &Schema{Enum: []interface{}{v}}
+	}
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		return nil
+	}
+	return sizeSchema(t, &n, &n)
+}
+
+// oneOfSchema documents the oneof tag, whose values are separated
+// by spaces, or quoted with single quotes if they contain some.
+func oneOfSchema(param string, t reflect.Type) *Schema {
+	if !isNumber(t) && !isString(t) {
+		return nil
+	}
+	var enum []interface{}
+	for _, m := range oneOfRe.FindAllStringSubmatch(param, -1) {
+		s := m[1]
+		if s == "" {
+			s = m[2]
+		}
+		v, err := stringToType(s, t)
+		if err != nil {
+			return nil
+		}
+		enum = append(enum, v)
+	}
+	return &Schema{Enum: enum}
+}
+
+var oneOfRe = regexp.MustCompile(`'([^']*)'|(\S+)`)
+
+func uniqueSchema(param string, t reflect.Type) *Schema {
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		return &Schema{UniqueItems: true}
 	}
+	return nil
 }
 
-// setSchemaEq sets the given equals value to the appropriate
-// schema field based on the given type.
-func setSchemaEq(schema *Schema, eq int, t reflect.Type) {
-	// For numbers and strings, equals tag would translate
-	// to the `const` property of the JSON Validation spec
-	// but OpenAPI doesn't support it.
-	if isNumber(t) || isString(t) {
-		return
+// dateTimeSchema documents the layouts of the
+// datetime tag that have a format in JSON Schema.
+func dateTimeSchema(param string, t reflect.Type) *Schema {
+	switch param {
+	case time.RFC3339, time.RFC3339Nano:
+		return &Schema{Format: "date-time"}
+	case time.DateOnly:
+		return &Schema{Format: "date"}
+	case time.TimeOnly:
+		return &Schema{Format: "time"}
+	}
+	return nil
+}
+
+func format(f string) ValidationSchemaFunc {
+	return func(_ string, t reflect.Type) *Schema {
+		if !isString(t) {
+			return nil
+		}
+		return &Schema{Format: f}
+	}
+}
+
+func pattern(p string) ValidationSchemaFunc {
+	return stringPattern(func(string) string { return p })
+}
+
+// stringPattern returns the function documenting a tag of
+// strings with the pattern returned by fn for its parameter.
+func stringPattern(fn func(param string) string) ValidationSchemaFunc {
+	return func(param string, t reflect.Type) *Schema {
+		if !isString(t) {
+			return nil
+		}
+		return &Schema{Pattern: fn(param)}
+	}
+}
+
+// notPrefix returns the pattern matching the start of the strings
+// not starting with p, without lookahead: (?:$|[^a]|a(?:$|[^b])) for
+// "ab". An empty p is the prefix of every string.
+func notPrefix(p []rune) string {
+	if len(p) == 0 {
+		return "$^"
+	}
+	res := "(?:$|[^" + charClass(string(p[len(p)-1])) + "])"
+	for i := len(p) - 2; i >= 0; i-- {
+		c := string(p[i])
+		res = "(?:$|[^" + charClass(c) + "]|" + regexp.QuoteMeta(c) + res + ")"
+	}
+	return res
+}
+
+// notSuffix returns the pattern matching the end of the strings
+// not ending with p, without lookahead: (?:^|[^b]|(?:^|[^a])b) for
+// "ab". An empty p is the suffix of every string.
+func notSuffix(p []rune) string {
+	if len(p) == 0 {
+		return "$^"
+	}
+	res := "(?:^|[^" + charClass(string(p[0])) + "])"
+	for i := 1; i < len(p); i++ {
+		c := string(p[i])
+		res = "(?:^|[^" + charClass(c) + "]|" + res + regexp.QuoteMeta(c) + ")"
 	}
-	setSchemaLen(schema, eq, t)
+	return res
 }
 
-// setSchemaLen sets the given len to the appropriate
-// schema field based on the given type.
-func setSchemaLen(schema *Schema, len int, t reflect.Type) {
-	setSchemaMax(schema, len, t)
-	setSchemaMin(schema, len, t)
+// charClass escapes the characters of s
+// to be listed in a character class.
+func charClass(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `]`, `\]`, `[`, `\[`, `^`, `\^`, `-`, `\-`).Replace(s)
 }
 
 // isString returns whether the given reflect type represents a string.
@@ -0,0 +1,143 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type vaOrder struct {
+	Quantity int               `json:"quantity" validate:"required,gte=0,lt=100"`
+	Price    float64           `json:"price" validate:"gt=0.5,max=1e6"`
+	Status   string            `json:"status" validate:"oneof=open 'on hold' closed"`
+	Level    int               `json:"level" validate:"oneof=1 2 3"`
+	Email    string            `json:"email" validate:"omitempty,email"`
+	Site     string            `json:"site" validate:"url"`
+	ID       string            `json:"id" validate:"uuid4"`
+	Day      string            `json:"day" validate:"datetime=2006-01-02"`
+	Code     string            `json:"code" validate:"startswith=A.,alphanum,len=4"`
+	Name     string            `json:"name" validate:"excludesall=!0x2C"`
+	Ref      *string           `json:"ref" validate:"omitempty,min=2,max=8"`
+	Kind     string            `json:"kind" validate:"eq=order"`
+	Tags     []string          `json:"tags" validate:"unique,min=1,dive,lowercase,max=10"`
+	Matrix   [][]int           `json:"matrix" validate:"dive,dive,gte=1"`
+	Labels   map[string]string `json:"labels" validate:"max=5,dive,keys,alpha,endkeys,required,hexcolor"`
+	Either   string            `json:"either" validate:"email|uuid"`
+	Currency string            `json:"currency" validate:"currency"`
+}
+
+func TestValidationSchema(t *testing.T) {
+	g := newTestGenerator(t)
+	g.RegisterValidation("currency", func(param string, t reflect.Type) *Schema {
+		return &Schema{Pattern: "^[A-Z]{3}$", Description: "ISO 4217 code"}
+	})
+	s := g.resolveSchema(g.newSchemaFromType(reflect.TypeOf(vaOrder{})))
+	if s == nil {
+		t.Fatal("no schema")
+	}
+	prop := func(name string) *Schema { return g.resolveSchema(s.Properties[name]) }
+	items := func(s *Schema) *Schema { return g.resolveSchema(s.Items) }
+
+	for name, want := range map[string]*Schema{
+		"quantity": {Minimum: Float(0), Maximum: Float(100), ExclusiveMaximum: true},
+		"price":    {Minimum: Float(0.5), ExclusiveMinimum: true, Maximum: Float(1e6)},
+		"status":   {Enum: []interface{}{"open", "on hold", "closed"}},
+		"level":    {Enum: []interface{}{int64(1), int64(2), int64(3)}},
+		"email":    {Format: "email"},
+		"site":     {Format: "uri"},
+		"id":       {Format: "uuid"},
+		"day":      {Format: "date"},
+		"code": {Pattern: `^A\.`, MinLength: 4, MaxLength: 4, AllOf: []*SchemaOrRef{
+			{Schema: &Schema{Pattern: `^[a-zA-Z0-9]+$`}},
+		}},
+		"name":     {Pattern: `^[^!,]*$`},
+		"ref":      {MinLength: 2, MaxLength: 8},
+		"kind":     {Enum: []interface{}{"order"}},
+		"tags":     {UniqueItems: true, MinItems: 1},
+		"labels":   {MaxProperties: 5},
+		"either":   {},
+		"currency": {Pattern: "^[A-Z]{3}$", Description: "ISO 4217 code"},
+	} {
+		got := *prop(name)
+		// Compare the validation fields only.
+		got.Type, got.Format, got.Items, got.AdditionalProperties, got.Nullable = "", want.Format, nil, nil, false
+		if name == "email" || name == "site" || name == "id" || name == "day" {
+			got.Format = prop(name).Format
+		}
+		if !reflect.DeepEqual(&got, want) {
+			gb, _ := json.Marshal(&got)
+			wb, _ := json.Marshal(want)
+			t.Errorf("%s: got %s, want %s", name, gb, wb)
+		}
+	}
+	if it := items(prop("tags")); it.Pattern != `^[^A-Z]*$` || it.MaxLength != 10 {
+		t.Errorf("unexpected tags items: %+v", it)
+	}
+	if it := items(items(prop("matrix"))); it.Minimum == nil || *it.Minimum != 1 {
+		t.Errorf("unexpected matrix items: %+v", it)
+	}
+	if v := g.resolveSchema(prop("labels").AdditionalProperties); v.Pattern == "" || !strings.HasPrefix(v.Pattern, "^#") {
+		t.Errorf("unexpected labels values: %+v", v)
+	}
+}
+
+func TestExclusiveBounds31(t *testing.T) {
+	g := newTestGenerator(t)
+	if _, err := g.AddOperation("/orders", "POST", "", reflect.TypeOf(vaOrder{}), nil, &OperationInfo{ID: "createOrder", StatusCode: 201}); err != nil {
+		t.Fatal(err)
+	}
+	spec30 := marshalFlat(t, g.API())
+	if !strings.Contains(spec30, `"quantity":{"type":"integer","format":"int32","maximum":100,"exclusiveMaximum":true,"minimum":0}`) {
+		t.Errorf("unexpected 3.0 bounds: %s", spec30)
+	}
+	if err := g.SetOpenAPIVersion("3.1"); err != nil {
+		t.Fatal(err)
+	}
+	api := g.API()
+	spec31 := marshalFlat(t, api)
+	if !strings.Contains(spec31, `"quantity":{"format":"int32","minimum":0,"type":"integer","exclusiveMaximum":100}`) ||
+		!strings.Contains(spec31, `"price":{"format":"double","maximum":1000000,"type":"number","exclusiveMinimum":0.5}`) {
+		t.Errorf("unexpected 3.1 bounds: %s", spec31)
+	}
+
+	// The numeric bounds are read back as in OpenAPI 3.0.
+	var read OpenAPI
+	if err := json.Unmarshal([]byte(spec31), &read); err != nil {
+		t.Fatal(err)
+	}
+	q := read.resolveSchema(read.Components.Schemas["CreateOrderInput"]).Properties["quantity"].Schema
+	if q.Maximum == nil || *q.Maximum != 100 || !q.ExclusiveMaximum {
+		t.Errorf("unexpected bounds read: %+v", q)
+	}
+	if err := api.ValidateValue(api.Components.Schemas["CreateOrderInput"].Schema.Properties["quantity"], 100.0); err == nil {
+		t.Error("expected an error for a value out of the exclusive bound")
+	}
+}
+
+func TestNegatedStringPatterns(t *testing.T) {
+	str := reflect.TypeOf("")
+	inputs := []string{"", "a", "ab", "abc", "xab", "ba", "a.b", "a.bc", "za.b", "a]^", "]^", "aab", "abab"}
+
+	for _, param := range []string{"ab", "a.b", "]^", "a"} {
+		for tag, want := range map[string]func(s string) bool{
+			"startsnotwith": func(s string) bool { return !strings.HasPrefix(s, param) },
+			"endsnotwith":   func(s string) bool { return !strings.HasSuffix(s, param) },
+		} {
+			p := validators[tag](param, str).Pattern
+			re, err := regexp.Compile(p)
+			if err != nil {
+				t.Fatalf("%s=%s: %v", tag, param, err)
+			}
+			for _, s := range inputs {
+				if re.MatchString(s) != want(s) {
+					t.Errorf("%s=%s: pattern %s matches %q: %v", tag, param, p, s, re.MatchString(s))
+				}
+			}
+		}
+	}
+	if s := validators["excludes"]("ab", str); s != nil {
+		t.Errorf("expected no pattern excluding a substring, got %+v", s)
+	}
+}
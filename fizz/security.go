@@ -0,0 +1,80 @@
+package fizz
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+
+	"mkfst/fizz/openapi"
+)
+
+// SecurityDocumenter is implemented by the middleware enforcing
+// a security policy, to document the operations they guard.
+type SecurityDocumenter interface {
+	// SecuritySchemes returns the schemes accepted
+	// by the middleware, by name.
+	SecuritySchemes() map[string]*openapi.SecuritySchemeOrRef
+
+	// SecurityRequirements returns the alternative requirements
+	// of the guarded operations. An empty requirement makes
+	// the others optional.
+	SecurityRequirements() []*openapi.SecurityRequirement
+}
+
+// middlewareSecurity maps the name of middleware
+// functions to their SecurityDocumenter.
+var middlewareSecurity sync.Map
+
+// RegisterMiddlewareSecurity declares that the middleware function fn
+// enforces the security policy documented by d. Functions are told
+// apart by name, which the method values of a method share for every
+// receiver: the last registration for a method wins.
+func RegisterMiddlewareSecurity(fn interface{}, d SecurityDocumenter) {
+	if name := funcName(fn); name != "" {
+		middlewareSecurity.Store(name, d)
+	}
+}
+
+// MiddlewareSecurity returns the SecurityDocumenter of the middleware
+// m, either m itself or the one registered for its function.
+func MiddlewareSecurity(m interface{}) (SecurityDocumenter, bool) {
+	if d, ok := m.(SecurityDocumenter); ok {
+		return d, true
+	}
+	if d, ok := middlewareSecurity.Load(funcName(m)); ok {
+		return d.(SecurityDocumenter), true
+	}
+	return nil, false
+}
+
+// DefaultSecurity sets the security requirements of the operation
+// unless other options, such as Security or WithoutSecurity, did.
+// The requirements are made optional by WithOptionalSecurity. Use
+// it after the options of the operation.
+func DefaultSecurity(security []*openapi.SecurityRequirement) func(*openapi.OperationInfo) {
+	return func(o *openapi.OperationInfo) {
+		if o.Security == nil {
+			o.Security = security
+			return
+		}
+		for _, r := range o.Security {
+			if len(*r) != 0 {
+				return
+			}
+		}
+		if len(o.Security) != 0 {
+			o.Security = append(append([]*openapi.SecurityRequirement(nil), security...), o.Security...)
+		}
+	}
+}
+
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+	if f := runtime.FuncForPC(v.Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
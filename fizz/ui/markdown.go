@@ -0,0 +1,240 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"mkfst/fizz/markdown"
+	"mkfst/fizz/openapi"
+)
+
+var methods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "TRACE"}
+
+// Markdown returns a static reference of the operations and schemas
+// of api, in Markdown. The operations marked x-internal are skipped.
+func Markdown(api *openapi.OpenAPI) string {
+	b := &markdown.Builder{}
+
+	if api.Info != nil {
+		b.H1(api.Info.Title)
+		if api.Info.Version != "" {
+			b.P(b.Bold("Version:") + " " + api.Info.Version)
+		}
+		if api.Info.Description != "" {
+			b.P(api.Info.Description)
+		}
+	}
+	paths := make([]string, 0, len(api.Paths))
+	for p := range api.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	b.H2("Operations")
+	for _, p := range paths {
+		for _, m := range methods {
+			op := api.Paths[p].Operation(m)
+			if op == nil || op.XInternal {
+				continue
+			}
+			operation(b, m, p, op)
+		}
+	}
+	if api.Components != nil && len(api.Components.Schemas) != 0 {
+		b.H2("Schemas")
+
+		names := make([]string, 0, len(api.Components.Schemas))
+		for n := range api.Components.Schemas {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		for _, n := range names {
+			b.H3(n)
+			schema(b, api.Components.Schemas[n])
+		}
+	}
+	return b.String()
+}
+
+func operation(b *markdown.Builder, method, path string, op *openapi.Operation) {
+	b.H3(b.InlineCode(method + " " + path))
+	if op.Deprecated {
+		b.P(b.Bold("Deprecated."))
+	}
+	if op.Summary != "" {
+		b.P(op.Summary)
+	}
+	if op.Description != "" {
+		b.P(op.Description)
+	}
+	params := [][]string{{"Name", "In", "Type", "Required", "Description"}}
+	for _, p := range op.Parameters {
+		if p == nil || p.Parameter == nil {
+			continue
+		}
+		params = append(params, []string{
+			b.InlineCode(p.Name),
+			p.In,
+			typeName(p.Schema),
+			yesNo(p.Required),
+			p.Description,
+		})
+	}
+	if len(params) > 1 {
+		b.H4("Parameters")
+		b.Table(params, nil)
+	}
+	if rb := op.RequestBody; rb != nil {
+		b.H4("Request body")
+		if rb.Description != "" {
+			b.P(rb.Description)
+		}
+		body := [][]string{{"Media type", "Type", "Required"}}
+		for _, mt := range sortedKeys(rb.Content) {
+			var s *openapi.SchemaOrRef
+			if m := rb.Content[mt]; m != nil {
+				s = m.Schema
+			}
+			body = append(body, []string{mt, typeName(s), yesNo(rb.Required)})
+		}
+		b.Table(body, nil)
+	}
+	if len(op.Responses) != 0 {
+		b.H4("Responses")
+		responses := [][]string{{"Status", "Description", "Type"}}
+		for _, code := range sortedKeys(op.Responses) {
+			r := op.Responses[code]
+			if r == nil || r.Response == nil {
+				continue
+			}
+			var types []string
+			for _, mt := range sortedKeys(r.Content) {
+				if m := r.Content[mt]; m != nil && m.MediaType != nil && m.Schema != nil {
+					types = append(types, typeName(m.Schema))
+				}
+			}
+			responses = append(responses, []string{code, r.Description, strings.Join(types, ", ")})
+		}
+		b.Table(responses, nil)
+	}
+}
+
+func schema(b *markdown.Builder, sor *openapi.SchemaOrRef) {
+	if sor == nil || sor.Schema == nil {
+		b.P(typeName(sor))
+		return
+	}
+	s := sor.Schema
+	if s.Description != "" {
+		b.P(s.Description)
+	}
+	if len(s.Properties) == 0 {
+		b.P("Type: " + typeName(sor))
+		return
+	}
+	props := [][]string{{"Property", "Type", "Required", "Description"}}
+	for _, name := range sortedKeys(s.Properties) {
+		p := s.Properties[name]
+		var desc string
+		if p != nil && p.Schema != nil {
+			desc = p.Schema.Description
+		}
+		props = append(props, []string{
+			"`" + name + "`",
+			typeName(p),
+			yesNo(contains(s.Required, name)),
+			desc,
+		})
+	}
+	b.Table(props, nil)
+}
+
+// typeName returns the type of the schema sor in a table cell,
+// with the referenced schemas linked to their section.
+func typeName(sor *openapi.SchemaOrRef) string {
+	if sor == nil {
+		return ""
+	}
+	if sor.Reference != nil {
+		name := sor.Reference.Ref[strings.LastIndex(sor.Reference.Ref, "/")+1:]
+		return fmt.Sprintf("[%s](#%s)", name, strings.ToLower(name))
+	}
+	s := sor.Schema
+	if s == nil {
+		return ""
+	}
+	var variants []*openapi.SchemaOrRef
+	switch {
+	case len(s.OneOf) != 0:
+		variants = s.OneOf
+	case len(s.AnyOf) != 0:
+		variants = s.AnyOf
+	case len(s.AllOf) != 0:
+		variants = s.AllOf
+	}
+	if variants != nil {
+		names := make([]string, 0, len(variants))
+		for _, v := range variants {
+			names = append(names, typeName(v))
+		}
+		return strings.Join(names, " \\| ")
+	}
+	types := s.Types
+	if len(types) == 0 && s.Type != "" {
+		types = []string{s.Type}
+	}
+	var name string
+	switch {
+	case contains(types, "array"):
+		name = typeName(s.Items) + "[]"
+	case contains(types, "object") && s.AdditionalProperties != nil:
+		name = "map[string]" + typeName(s.AdditionalProperties)
+	case len(types) != 0:
+		name = types[0]
+		if s.Format != "" {
+			name += " (" + s.Format + ")"
+		}
+	default:
+		name = "any"
+	}
+	if len(s.Enum) != 0 {
+		values := make([]string, 0, len(s.Enum))
+		for _, v := range s.Enum {
+			j, _ := json.Marshal(v)
+			values = append(values, string(j))
+		}
+		name += ": " + strings.Join(values, ", ")
+	}
+	if s.Nullable || contains(types, "null") {
+		name += ", nullable"
+	}
+	return name
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,179 @@
+// Package ui serves the documentation of an API from its OpenAPI
+// specification: an interactive page rendered by Swagger UI, Redoc or
+// Scalar, and a Markdown reference. The pages and the renderer assets
+// are embedded in the binary, so they work from any working directory
+// and without reaching a CDN; a renderer whose bundle is not embedded
+// needs a mirror of its assets in Config.AssetsURL.
+package ui
+
+//go:generate ../../scripts/fetch-docs-ui.sh
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/fizz/openapi"
+)
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+
+// The renderer assets are vendored in the assets directory,
+// go generate updates them, see fetch-docs-ui.sh.
+//
+//go:embed assets
+var assets embed.FS
+
+// Renderer is the renderer of the interactive documentation page.
+type Renderer string
+
+// Available renderers.
+const (
+	Swagger Renderer = "swagger"
+	Redoc   Renderer = "redoc"
+	Scalar  Renderer = "scalar"
+)
+
+// renderers lists the files loaded by the documentation pages, whose
+// pinned versions are Swagger UI 5.18.2, Redoc 2.1.3 and Scalar 1.24.0.
+var renderers = map[Renderer][]string{
+	Swagger: {"swagger-ui.css", "swagger-ui-bundle.js", "swagger-ui-standalone-preset.js"},
+	Redoc:   {"redoc.standalone.js"},
+	Scalar:  {"scalar-api-reference.js"},
+}
+
+// Config represents all available options of the documentation.
+type Config struct {
+	// Renderer of the interactive page.
+	// Default value is Swagger.
+	Renderer Renderer
+
+	// Title of the page.
+	// Default value is "API documentation".
+	Title string
+
+	// SpecURL is the URL of the specification
+	// rendered by the page. Default value is
+	// "/openapi.json".
+	SpecURL string
+
+	// AssetsURL is the base URL of a mirror of the
+	// renderer assets, serving them under the names of
+	// the assets directory, used for the assets that
+	// are not embedded. Without it, a renderer whose
+	// assets are not all embedded is an error.
+	AssetsURL string
+
+	// Spec returns the specification of the Markdown
+	// reference. The reference is not served if nil.
+	Spec func() *openapi.OpenAPI
+}
+
+// Mount registers the documentation routes on r: the interactive page
+// at path, the embedded assets under path/assets/, and, if cfg.Spec is
+// set, the Markdown reference at path/reference.md.
+func Mount(r gin.IRoutes, path string, cfg Config) error {
+	page, err := Handler(path, cfg)
+	if err != nil {
+		return err
+	}
+	path = strings.TrimSuffix(path, "/")
+
+	r.GET(path, page)
+	r.GET(path+"/assets/*filepath", assetsHandler())
+	if cfg.Spec != nil {
+		r.GET(path+"/reference.md", func(c *gin.Context) {
+			c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(Markdown(cfg.Spec())))
+		})
+	}
+	return nil
+}
+
+// Handler returns the handler rendering the interactive page of
+// cfg, whose embedded assets are served under path/assets/.
+func Handler(path string, cfg Config) (gin.HandlerFunc, error) {
+	if cfg.Renderer == "" {
+		cfg.Renderer = Swagger
+	}
+	if cfg.Title == "" {
+		cfg.Title = "API documentation"
+	}
+	if cfg.SpecURL == "" {
+		cfg.SpecURL = "/openapi.json"
+	}
+	files, ok := renderers[cfg.Renderer]
+	if !ok {
+		return nil, fmt.Errorf("ui: unknown renderer %q", cfg.Renderer)
+	}
+	urls := make(map[string]string, len(files))
+	for _, name := range files {
+		u, err := assetURL(strings.TrimSuffix(path, "/"), cfg.AssetsURL, name)
+		if err != nil {
+			return nil, fmt.Errorf("ui: renderer %s: %w", cfg.Renderer, err)
+		}
+		urls[name] = u
+	}
+	tmpl, err := template.New(string(cfg.Renderer)+".tmpl").Funcs(template.FuncMap{
+		"asset": func(name string) string { return urls[name] },
+	}).ParseFS(templates, "templates/"+string(cfg.Renderer)+".tmpl")
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{
+		"Title":   cfg.Title,
+		"SpecURL": cfg.SpecURL,
+	}
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := tmpl.Execute(c.Writer, data); err != nil {
+			c.Error(err)
+		}
+	}, nil
+}
+
+// assetURL returns the URL of the asset name: embedded, else on
+// the mirror at assetsURL, an error if it has neither.
+func assetURL(base, assetsURL string, name string) (string, error) {
+	if _, err := fs.Stat(assets, "assets/"+name); err == nil {
+		return base + "/assets/" + name, nil
+	}
+	if assetsURL != "" {
+		return strings.TrimSuffix(assetsURL, "/") + "/" + name, nil
+	}
+	return "", fmt.Errorf("asset %s is not embedded, vendor it with go generate ./fizz/ui or set AssetsURL", name)
+}
+
+// assetsHandler serves the embedded assets of the renderers,
+// and not the other files of the assets directory.
+func assetsHandler() gin.HandlerFunc {
+	files, _ := fs.Sub(assets, "assets")
+	fileServer := http.FileServer(http.FS(files))
+	pinned := map[string]bool{}
+	for _, files := range renderers {
+		for _, name := range files {
+			pinned[name] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		name := path.Clean(c.Param("filepath"))
+		if _, err := fs.Stat(files, strings.TrimPrefix(name, "/")); err != nil || !pinned[strings.TrimPrefix(name, "/")] {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		// The assets are pinned, and change with the binary only.
+		c.Header("Cache-Control", "public, max-age=86400")
+
+		req := c.Request.Clone(c.Request.Context())
+		req.URL.Path = name
+		fileServer.ServeHTTP(c.Writer, req)
+	}
+}
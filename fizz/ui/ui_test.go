@@ -0,0 +1,146 @@
+package ui
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/fizz/openapi"
+)
+
+type pet struct {
+	ID   int64  `json:"id" validate:"required"`
+	Name string `json:"name" description:"Name of the pet"`
+	Kind string `json:"kind" enum:"cat,dog"`
+}
+
+func testSpec(t *testing.T) *openapi.OpenAPI {
+	g, err := openapi.NewGenerator(&openapi.SpecGenConfig{
+		ValidatorTag:      "validate",
+		PathLocationTag:   "path",
+		QueryLocationTag:  "query",
+		HeaderLocationTag: "header",
+		EnumTag:           "enum",
+		DefaultTag:        "default",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.SetInfo(&openapi.Info{Title: "Pets", Version: "1.0.0"})
+	info := &openapi.OperationInfo{ID: "getPet", Summary: "Get a pet", StatusCode: 200}
+	if _, err := g.AddOperation("/pets/:id", "GET", "", reflect.TypeOf(struct {
+		ID int64 `path:"id"`
+	}{}), reflect.TypeOf(pet{}), info); err != nil {
+		t.Fatal(err)
+	}
+	return g.API()
+}
+
+func get(r http.Handler, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	return w
+}
+
+func TestMount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for renderer, marker := range map[Renderer]string{
+		Swagger: "SwaggerUIBundle",
+		Redoc:   "<redoc",
+		Scalar:  `id="api-reference"`,
+	} {
+		r := gin.New()
+		err := Mount(r, "/docs", Config{
+			Renderer:  renderer,
+			Title:     "Pets <API>",
+			AssetsURL: "https://mirror.internal/ui/",
+			Spec:      func() *openapi.OpenAPI { return testSpec(t) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := get(r, "/docs")
+		body := w.Body.String()
+		if w.Code != http.StatusOK || !strings.Contains(body, marker) {
+			t.Errorf("%s: unexpected page: %d %s", renderer, w.Code, body)
+		}
+		if !strings.Contains(body, "<title>Pets &lt;API&gt;</title>") {
+			t.Errorf("%s: title is not escaped: %s", renderer, body)
+		}
+		for _, name := range renderers[renderer] {
+			if u, _ := assetURL("/docs", "https://mirror.internal/ui/", name); !strings.Contains(body, u) {
+				t.Errorf("%s: missing asset %s", renderer, name)
+			}
+		}
+	}
+
+	if err := Mount(gin.New(), "/docs", Config{Renderer: "rapidoc"}); err == nil {
+		t.Error("expected an error for an unknown renderer")
+	}
+}
+
+func TestAssets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	if err := Mount(r, "/docs/", Config{}); err != nil {
+		t.Fatal(err)
+	}
+	page := get(r, "/docs").Body.String()
+	for _, name := range renderers[Swagger] {
+		if !strings.Contains(page, `"/docs/assets/`+name+`"`) {
+			t.Errorf("%s is not loaded from the embedded assets: %s", name, page)
+		}
+		if w := get(r, "/docs/assets/"+name); w.Code != http.StatusOK || w.Header().Get("Cache-Control") == "" {
+			t.Errorf("unexpected asset response: %d %v", w.Code, w.Header())
+		}
+	}
+	for _, url := range []string{"/docs/assets/README.md", "/docs/assets/missing.js", "/docs/assets/../ui.go", "/docs/reference.md"} {
+		if w := get(r, url); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", url, w.Code)
+		}
+	}
+	// Assets not vendored come from the mirror, never from a CDN.
+	if u, err := assetURL("/docs", "", "missing.js"); err == nil {
+		t.Errorf("expected an error for an asset neither embedded nor mirrored, got %s", u)
+	}
+	if u, _ := assetURL("/docs", "https://mirror.internal", "missing.js"); u != "https://mirror.internal/missing.js" {
+		t.Errorf("unexpected mirror URL: %s", u)
+	}
+	for renderer, files := range renderers {
+		embedded := true
+		for _, name := range files {
+			if _, err := fs.Stat(assets, "assets/"+name); err != nil {
+				embedded = false
+			}
+		}
+		if err := Mount(gin.New(), "/docs", Config{Renderer: renderer}); (err == nil) != embedded {
+			t.Errorf("%s: embedded %v, unexpected mount error %v", renderer, embedded, err)
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	md := Markdown(testSpec(t))
+
+	for _, s := range []string{
+		"# Pets",
+		"### `GET /pets/{id}`",
+		"Get a pet",
+		"| `id` | path | integer (int64) | yes      |",
+		"| 200    | OK          | [UiPet](#uipet) |",
+		"### UiPet",
+		`string: "cat", "dog"`,
+		"Name of the pet",
+	} {
+		if !strings.Contains(md, s) {
+			t.Errorf("missing %q in:\n%s", s, md)
+		}
+	}
+}
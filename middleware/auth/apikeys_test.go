@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "modernc.org/sqlite"
+
+	"mkfst/auth/apikey"
+	"mkfst/auth/token"
+	"mkfst/config"
+	mkfstdb "mkfst/db"
+	"mkfst/mkfsttest"
+	"mkfst/providers/policy"
+)
+
+func TestAPIKeys(t *testing.T) {
+	raw, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "keys.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	store, err := apikey.NewSQLStore(&mkfstdb.Connection{Conn: raw, Config: mkfstdb.ConnectionInfo{Type: "SQLITE"}}, apikey.SQLOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := apikey.NewManager(apikey.Opts{Store: store})
+	key, _, err := keys.Issue(t.Context(), "exporter", "", []string{"reports"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mkfsttest.New(t, config.Config{})
+	mw := NewService(Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		APIKeys:      keys,
+	}).Middleware()
+	api := h.Service.Group("/api", "api", "API")
+	api.Middleware(mw.Auth)
+	api.Route("GET", "/whoami", http.StatusOK, nil, func(c *gin.Context) (string, error) {
+		s := policy.SubjectFromUser(token.MustGetUserInfo(c.Request))
+		return s.ID + " " + strings.Join(s.Roles, ",") + " " + s.Tags["service_account"], nil
+	})
+
+	req := newRequest("GET", "/api/whoami")
+	req.Header.Set("X-API-Key", key)
+	if w := h.Client().Do(req); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "sa_exporter reports true") {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body)
+	}
+
+	req.Header.Set("X-API-Key", key+"0")
+	if w := h.Client().Do(req); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong key, got %d", w.Code)
+	}
+
+	if s := h.Spec().Components.SecuritySchemes[SchemeAPIKey]; s == nil || s.In != "header" || s.Name != "X-API-Key" {
+		t.Errorf("unexpected API key scheme: %+v", s)
+	}
+}
@@ -6,17 +6,28 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"mkfst/auth/apikey"
 	"mkfst/auth/avatar"
+	"mkfst/auth/lockout"
 	"mkfst/auth/logger"
+	"mkfst/auth/mfa"
 	"mkfst/auth/provider"
+	"mkfst/auth/session"
 	"mkfst/auth/token"
+	"mkfst/auth/users"
+	"mkfst/providers/cache"
 )
 
+// JWKSPath is the conventional path of the keys verifying tokens, published by JWKSHandler
+const JWKSPath = "/.well-known/jwks.json"
+
 // Client is a type of auth client
 type Client struct {
 	Cid     string
@@ -33,11 +44,13 @@ type AuthService struct {
 	avatarProxy    *avatar.Proxy
 	issuer         string
 	useGravatar    bool
+	resetLock      sync.Mutex // serializes the use of the reset tokens with a cache without Add
 }
 
 // Opts is a full set of all parameters to initialize AuthService
 type Opts struct {
-	SecretReader   token.Secret        // reader returns secret for given site id (aud), required
+	SecretReader   token.Secret        // reader returns secret for given site id (aud), required unless KeySet is set
+	KeySet         token.KeySet        // asymmetric keys signing tokens, published by JWKSHandler
 	ClaimsUpd      token.ClaimsUpdater // updater for jwt to add/modify values stored in the token
 	SecureCookies  bool                // makes jwt cookie secure
 	TokenDuration  time.Duration       // token's TTL, refreshed automatically
@@ -66,12 +79,21 @@ type Opts struct {
 	AvatarRoutePath   string       // avatar routing prefix, i.e. "/api/v1/avatar", default `/avatar`
 	UseGravatar       bool         // for email based auth (verified provider) use gravatar service
 
-	AdminPasswd      string         // if presented, allows basic auth with user admin and given password
-	BasicAuthChecker BasicAuthFunc  // user custom checker for basic auth, if one defined then "AdminPasswd" will ignored
-	AudienceReader   token.Audience // list of allowed aud values, default (empty) allows any
-	AudSecrets       bool           // allow multiple secrets (secret per aud)
-	Logger           logger.L       // logger interface, default is no logging at all
-	RefreshCache     RefreshCache   // optional cache to keep refreshed tokens
+	AdminPasswd      string           // if presented, allows basic auth with user admin and given password
+	BasicAuthChecker BasicAuthFunc    // user custom checker for basic auth, if one defined then "AdminPasswd" will ignored
+	AudienceReader   token.Audience   // list of allowed aud values, default (empty) allows any
+	AudSecrets       bool             // allow multiple secrets (secret per aud)
+	Logger           logger.L         // logger interface, default is no logging at all
+	RefreshCache     RefreshCache     // optional cache to keep refreshed tokens
+	Sessions         *session.Manager // optional sessions with refresh tokens, revocation and listing
+	APIKeys          *apikey.Manager  // optional API keys of service accounts, accepted by the middlewares
+	MFA              *mfa.Manager     // optional second factors, TOTP and WebAuthn
+	MFAStepUpURL     string           // page verifying a second factor, RequireMFA redirects browsers to it
+	Lockout          *lockout.Guard   // optional brute-force protection of the direct, verify and MFA logins
+	Users            *users.Manager   // optional store of the users, linking the identities of the providers to accounts
+
+	AuthPath      string             // path of the auth route in the links of the emails, default "/auth"
+	PasswordReset *PasswordResetOpts // optional password reset by email for the direct providers
 }
 
 // NewService initializes everything
@@ -85,6 +107,9 @@ func NewService(opts Opts) (res *AuthService) {
 			AdminPasswd:      opts.AdminPasswd,
 			BasicAuthChecker: opts.BasicAuthChecker,
 			RefreshCache:     opts.RefreshCache,
+			Sessions:         opts.Sessions,
+			APIKeys:          opts.APIKeys,
+			MFAStepUpURL:     opts.MFAStepUpURL,
 		},
 		issuer:      opts.Issuer,
 		useGravatar: opts.UseGravatar,
@@ -98,9 +123,37 @@ func NewService(opts Opts) (res *AuthService) {
 		res.logger = logger.NoOp
 	}
 
+	if opts.AuthPath == "" {
+		res.opts.AuthPath = "/auth"
+	}
+
+	if opts.PasswordReset != nil {
+		pr := *opts.PasswordReset
+		if pr.Template == "" {
+			pr.Template = "password_reset"
+		}
+		if pr.TTL <= 0 {
+			pr.TTL = 30 * time.Minute
+		}
+		if pr.MinLength <= 0 {
+			pr.MinLength = 8
+		}
+		if pr.Cache == nil {
+			pr.Cache = cache.NewMemoryCache(cache.MemoryOpts{})
+		}
+		if pr.MinResponse <= 0 {
+			pr.MinResponse = 300 * time.Millisecond
+			if opts.Lockout != nil {
+				pr.MinResponse = opts.Lockout.MinResponse
+			}
+		}
+		res.opts.PasswordReset = &pr
+	}
+
 	jwtService := token.NewService(token.Opts{
 		SecretReader:    opts.SecretReader,
-		ClaimsUpd:       opts.ClaimsUpd,
+		KeySet:          opts.KeySet,
+		ClaimsUpd:       claimsUpdater(opts.Users, opts.ClaimsUpd),
 		SecureCookies:   opts.SecureCookies,
 		TokenDuration:   opts.TokenDuration,
 		CookieDuration:  opts.CookieDuration,
@@ -119,13 +172,17 @@ func NewService(opts Opts) (res *AuthService) {
 		SameSite:        opts.SameSiteCookie,
 	})
 
-	if opts.SecretReader == nil {
+	if opts.SecretReader == nil && opts.KeySet == nil {
 		jwtService.SecretReader = token.SecretFunc(func(string) (string, error) {
 			return "", fmt.Errorf("secrets reader not available")
 		})
 		res.logger.Logf("[WARN] no secret reader defined")
 	}
 
+	if opts.Sessions != nil {
+		jwtService.Revocations = opts.Sessions
+	}
+
 	res.jwtService = jwtService
 	res.authMiddleware.JWTService = jwtService
 	res.authMiddleware.L = res.logger
@@ -169,6 +226,22 @@ func (s *AuthService) Handlers() (authHandler, avatarHandler interface{}) {
 			}, nil
 		}
 
+		if res, ok, err := s.sessionsHandler(ctx, action); ok {
+			return res, err
+		}
+
+		if res, ok, err := s.mfaHandler(ctx, action); ok {
+			return res, err
+		}
+
+		if res, ok, err := s.usersHandler(ctx, action); ok {
+			return res, err
+		}
+
+		if res, ok, err := s.passwordHandler(ctx, action); ok {
+			return res, err
+		}
+
 		// allow logout without specifying provider
 		if action == "logout" {
 			if len(s.providers) == 0 {
@@ -181,7 +254,29 @@ func (s *AuthService) Handlers() (authHandler, avatarHandler interface{}) {
 				}, errors.New(errMsg)
 			}
 
-			s.providers[0].Handler(ctx)
+			if s.opts.Sessions != nil {
+				if err := s.revokeLogout(ctx); err != nil {
+					ctx.AbortWithStatus(http.StatusInternalServerError)
+					return gin.H{
+						"error": err.Error(),
+					}, err
+				}
+			}
+
+			// the provider of the login, for its RP-initiated logout, else any of them
+			p := s.providers[0]
+			if providerName := ctx.Query("using"); providerName != "" {
+				var err error
+				if p, err = s.Provider(providerName); err != nil {
+					ctx.AbortWithStatus(http.StatusBadRequest)
+
+					errMsg := fmt.Sprintf("provider %s not supported", providerName)
+					return gin.H{
+						"error": errMsg,
+					}, errors.New(errMsg)
+				}
+			}
+			p.Handler(ctx)
 
 			return nil, nil
 		}
@@ -241,6 +336,11 @@ func (s *AuthService) Handlers() (authHandler, avatarHandler interface{}) {
 			}, errors.New(errMsg)
 		}
 
+		if s.opts.Sessions != nil || s.opts.Users != nil { // pass the request to the sessions and users of the login
+			w := ctx.Writer
+			ctx.Writer = &requestWriter{ResponseWriter: w, request: ctx.Request}
+			defer func() { ctx.Writer = w }()
+		}
 		res, err := p.Handler(ctx)
 
 		return res, err
@@ -256,6 +356,22 @@ func (s *AuthService) Handlers() (authHandler, avatarHandler interface{}) {
 	return authorizationHandler, s.avatarProxy.Handler
 }
 
+// JWKSHandler gets the handler publishing the keys verifying tokens, mounted at JWKSPath.
+// Without KeySet there are no public keys and it responds with 404.
+func (s *AuthService) JWKSHandler() interface{} {
+	return func(ctx *gin.Context, _ *sql.DB) (*token.JWKS, error) {
+		if s.opts.KeySet == nil {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return nil, nil
+		}
+		jwks, err := token.NewJWKS(s.opts.KeySet)
+		if err != nil {
+			return nil, err
+		}
+		return &jwks, nil
+	}
+}
+
 // appendProvider stores svc in the provider list and re-points the auth
 // middleware at the updated slice. Every Add* method must funnel through here
 // — bypassing it leaves the middleware blind to the new provider.
@@ -270,15 +386,21 @@ func (s *AuthService) appendProvider(svc provider.Service) {
 func (s *AuthService) baseParams() provider.Params {
 	return provider.Params{
 		URL:         s.opts.URL,
-		JwtService:  s.jwtService,
+		JwtService:  s.tokenService(),
 		Issuer:      s.issuer,
 		AvatarSaver: s.avatarProxy,
 		L:           s.logger,
 	}
 }
 
+// tokenService returns the token service of the providers, making sessions if enabled
+func (s *AuthService) tokenService() sessionTokens {
+	return sessionTokens{Service: s.jwtService, sessions: s.opts.Sessions, users: s.opts.Users}
+}
+
 // Middleware returns auth middleware
 func (s *AuthService) Middleware() Authenticator {
+	s.authMiddleware.DocumentSecurity()
 	return s.authMiddleware
 }
 
@@ -349,6 +471,34 @@ func (s *AuthService) AddAppleProvider(appleConfig provider.AppleConfig, privKey
 	return nil
 }
 
+// AddOIDCProvider adds an OpenID Connect provider, i.e. Keycloak, Okta or Dex, configured
+// from the discovery document of its issuer
+func (s *AuthService) AddOIDCProvider(name string, client Client, cfg provider.OIDCConfig) error {
+	p := s.baseParams()
+	p.Cid = client.Cid
+	p.Csecret = client.Csecret
+	oidcProvider, err := provider.NewOIDC(name, p, cfg)
+	if err != nil {
+		return fmt.Errorf("an OIDC provider creating failed: %w", err)
+	}
+	s.appendProvider(provider.NewService(oidcProvider))
+	return nil
+}
+
+// AddSAMLProvider adds a SAML 2.0 identity provider, i.e. ADFS or Okta. Its metadata is served by
+// the metadata action of the auth route, and the auth route must accept POST for the responses.
+func (s *AuthService) AddSAMLProvider(name string, cfg provider.SAMLConfig) error {
+	if cfg.AuthPath == "" {
+		cfg.AuthPath = s.opts.AuthPath
+	}
+	samlProvider, err := provider.NewSAML(name, s.baseParams(), cfg)
+	if err != nil {
+		return fmt.Errorf("a SAML provider creating failed: %w", err)
+	}
+	s.appendProvider(provider.NewService(samlProvider))
+	return nil
+}
+
 // AddCustomProvider adds custom provider (e.g. https://gopkg.in/oauth2.v3)
 func (s *AuthService) AddCustomProvider(name string, client Client, copts provider.CustomHandlerOpt) {
 	p := s.baseParams()
@@ -364,9 +514,10 @@ func (s *AuthService) AddDirectProvider(name string, credChecker provider.CredCh
 		L:            s.logger,
 		ProviderName: name,
 		Issuer:       s.issuer,
-		TokenService: s.jwtService,
+		TokenService: s.tokenService(),
 		CredChecker:  credChecker,
 		AvatarSaver:  s.avatarProxy,
+		Lockout:      s.opts.Lockout,
 	}))
 }
 
@@ -378,10 +529,11 @@ func (s *AuthService) AddDirectProviderWithUserIDFunc(name string, credChecker p
 		L:            s.logger,
 		ProviderName: name,
 		Issuer:       s.issuer,
-		TokenService: s.jwtService,
+		TokenService: s.tokenService(),
 		CredChecker:  credChecker,
 		AvatarSaver:  s.avatarProxy,
 		UserIDFunc:   ufn,
+		Lockout:      s.opts.Lockout,
 	}))
 }
 
@@ -391,11 +543,29 @@ func (s *AuthService) AddVerifProvider(name, msgTmpl string, sender provider.Sen
 		L:            s.logger,
 		ProviderName: name,
 		Issuer:       s.issuer,
-		TokenService: s.jwtService,
+		TokenService: s.tokenService(),
 		AvatarSaver:  s.avatarProxy,
 		Sender:       sender,
 		Template:     msgTmpl,
 		UseGravatar:  s.useGravatar,
+		Lockout:      s.opts.Lockout,
+	}))
+}
+
+// AddVerifTemplateProvider adds provider user's verification sent by sender with its template,
+// i.e. sender.Mail. The data of the template has the Link logging in with the token (magic link).
+func (s *AuthService) AddVerifTemplateProvider(name, template string, sender provider.TemplateSender) {
+	s.appendProvider(provider.NewService(provider.VerifyHandler{
+		L:              s.logger,
+		ProviderName:   name,
+		Issuer:         s.issuer,
+		TokenService:   s.tokenService(),
+		AvatarSaver:    s.avatarProxy,
+		TemplateSender: sender,
+		TemplateName:   template,
+		URL:            strings.TrimSuffix(s.opts.URL, "/") + s.opts.AuthPath + "?action=login&using=" + url.QueryEscape(name),
+		UseGravatar:    s.useGravatar,
+		Lockout:        s.opts.Lockout,
 	}))
 }
 
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"mkfst/auth/avatar"
+	"mkfst/auth/lockout"
+	"mkfst/auth/provider"
+	"mkfst/auth/token"
+	"mkfst/config"
+	"mkfst/mkfsttest"
+	"mkfst/providers/cache"
+)
+
+func TestDirectLoginLockout(t *testing.T) {
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	defer c.Close()
+	var lock sync.Mutex
+	var events []lockout.Event
+	guard := lockout.New(lockout.Opts{
+		Cache:        c,
+		UserAttempts: 2,
+		MinResponse:  50 * time.Millisecond,
+		AuditFn: func(e lockout.Event) {
+			lock.Lock()
+			events = append(events, e)
+			lock.Unlock()
+		},
+	})
+	authSvc := NewService(Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		DisableXSRF:  true,
+		AvatarStore:  avatar.NewNoOp(),
+		Lockout:      guard,
+	})
+	authSvc.AddDirectProvider("local", provider.CredCheckerFunc(func(user, passwd string) (bool, error) {
+		return user == "bob" && passwd == "pw", nil
+	}))
+
+	h := mkfsttest.New(t, config.Config{})
+	authRoute, _ := authSvc.Handlers()
+	h.Service.Route("GET", "/auth", http.StatusOK, nil, authRoute)
+	login := func(user, passwd string) (int, time.Duration, string) {
+		start := time.Now()
+		w := h.Client().Do(newRequest("GET", "/auth?action=login&using=local&user="+user+"&passwd="+passwd))
+		return w.Code, time.Since(start), w.Header().Get("Retry-After")
+	}
+
+	for _, user := range []string{"bob", "nobody"} {
+		if code, took, _ := login(user, "bad"); code != http.StatusForbidden || took < 50*time.Millisecond {
+			t.Errorf("%s: expected a delayed 403, got %d in %v", user, code, took)
+		}
+	}
+	if code, _, _ := login("bob", "pw"); code != http.StatusOK {
+		t.Fatalf("expected bob logged in, got %d", code)
+	}
+
+	login("bob", "bad")
+	login("bob", "bad")
+	code, _, retry := login("bob", "pw")
+	if code != http.StatusTooManyRequests || retry != "60" {
+		t.Errorf("expected bob locked out for 60s, got %d, retry %q", code, retry)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(events) != 5 || events[0].Type != lockout.EventLoginFailed || events[0].Provider != "local" ||
+		events[3].LockedFor != time.Minute || events[4].Type != lockout.EventLockedOut {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
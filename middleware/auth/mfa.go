@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/auth/lockout"
+	"mkfst/auth/mfa"
+	"mkfst/auth/token"
+)
+
+// mfaActions are the actions of the auth route handled by mfaHandler, with the MFA they need:
+// a user with a second factor must have verified it to change the factors
+var mfaActions = map[string]bool{
+	"mfa":                      false,
+	"totp_enroll":              true,
+	"totp_confirm":             false,
+	"totp_verify":              false,
+	"totp_disable":             true,
+	"recovery_verify":          false,
+	"recovery_codes":           true,
+	"webauthn_register":        true,
+	"webauthn_register_finish": true,
+	"webauthn_login":           false,
+	"webauthn_login_finish":    false,
+	"webauthn_remove":          true,
+}
+
+// mfaLockoutProvider is the provider name of the second factor attempts in the lockout
+const mfaLockoutProvider = "mfa"
+
+// mfaVerifyActions are the actions checking a code or assertion, guarded by the lockout of the user
+var mfaVerifyActions = map[string]bool{
+	"totp_confirm":          true,
+	"totp_verify":           true,
+	"recovery_verify":       true,
+	"webauthn_login_finish": true,
+}
+
+// mfaHandler handles the second factor actions of the auth route, reporting if action is one
+func (s *AuthService) mfaHandler(ctx *gin.Context, action string) (res gin.H, ok bool, err error) {
+	needsMFA, ok := mfaActions[action]
+	if !ok {
+		return nil, false, nil
+	}
+	fail := func(status int, msg string) (gin.H, bool, error) {
+		ctx.AbortWithStatus(status)
+		return gin.H{"error": msg}, true, errors.New(msg)
+	}
+	var guard *lockout.Guard // set for the verify actions with a lockout
+	var user token.User
+	failErr := func(err error) (gin.H, bool, error) {
+		switch {
+		case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrInvalidClient), errors.Is(err, mfa.ErrNoChallenge):
+			if guard != nil {
+				ip := guard.ClientIP(ctx.Request)
+				lock, ferr := guard.Fail(ctx.Request.Context(), mfaLockoutProvider, user.ID, ip)
+				if ferr != nil {
+					s.logger.Logf("[WARN] can't record failed second factor of %s: %v", user.ID, ferr)
+				}
+				guard.Audit(lockout.Event{Type: lockout.EventLoginFailed, Provider: mfaLockoutProvider, User: user.ID, IP: ip, LockedFor: lock})
+			}
+			return fail(http.StatusUnauthorized, err.Error())
+		case errors.Is(err, mfa.ErrNotEnrolled), errors.Is(err, mfa.ErrEnrolled):
+			return fail(http.StatusBadRequest, err.Error())
+		}
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+	if s.opts.MFA == nil {
+		return fail(http.StatusBadRequest, "second factors not enabled")
+	}
+	m := s.opts.MFA
+
+	claims, _, err := s.jwtService.Get(ctx.Request)
+	if err != nil || claims.User == nil {
+		return fail(http.StatusUnauthorized, "not logged in")
+	}
+	user = *claims.User
+	reqCtx := ctx.Request.Context()
+	creds, err := m.Credentials(reqCtx, user.ID)
+	if err != nil {
+		return failErr(err)
+	}
+	if needsMFA && creds.Enrolled() && !claims.HasMFA() {
+		return fail(http.StatusForbidden, "second factor required")
+	}
+	if s.opts.Lockout != nil && mfaVerifyActions[action] {
+		guard = s.opts.Lockout
+		ip := guard.ClientIP(ctx.Request)
+		wait, err := guard.Check(reqCtx, mfaLockoutProvider, user.ID, ip)
+		if err != nil {
+			return fail(http.StatusInternalServerError, err.Error())
+		}
+		if wait > 0 {
+			guard.Audit(lockout.Event{Type: lockout.EventLockedOut, Provider: mfaLockoutProvider, User: user.ID, IP: ip, LockedFor: wait})
+			ctx.Header("Retry-After", strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10))
+			return fail(http.StatusTooManyRequests, "too many attempts")
+		}
+	}
+
+	// stepUp sets the token of the user with the verified method
+	stepUp := func(method string, res gin.H) (gin.H, bool, error) {
+		if guard != nil {
+			if err := guard.Reset(reqCtx, mfaLockoutProvider, user.ID); err != nil {
+				s.logger.Logf("[WARN] %v", err)
+			}
+		}
+		claims = mfa.StepUp(claims, method)
+		claims.ExpiresAt = 0 // this will cause now+duration for the token
+		if _, err := s.jwtService.Set(ctx.Writer, claims); err != nil {
+			return failErr(err)
+		}
+		res["amr"] = claims.AMR
+		return res, true, nil
+	}
+	code := ctx.Request.FormValue("code")
+
+	switch action {
+	case "mfa":
+		return gin.H{"methods": creds.Methods(), "amr": claims.AMR}, true, nil
+
+	case "totp_enroll":
+		secret, uri, err := m.EnrollTOTP(reqCtx, user)
+		if err != nil {
+			return failErr(err)
+		}
+		return gin.H{"secret": secret, "uri": uri}, true, nil
+
+	case "totp_confirm":
+		recovery, err := m.ConfirmTOTP(reqCtx, user.ID, code)
+		if err != nil {
+			return failErr(err)
+		}
+		return stepUp(token.AMROTP, gin.H{"recovery_codes": recovery})
+
+	case "totp_verify":
+		if err := m.VerifyTOTP(reqCtx, user.ID, code); err != nil {
+			return failErr(err)
+		}
+		return stepUp(token.AMROTP, gin.H{"status": "verified"})
+
+	case "totp_disable":
+		if err := m.DisableTOTP(reqCtx, user.ID); err != nil {
+			return failErr(err)
+		}
+		return gin.H{"status": "totp disabled"}, true, nil
+
+	case "recovery_verify":
+		if err := m.VerifyRecoveryCode(reqCtx, user.ID, code); err != nil {
+			return failErr(err)
+		}
+		return stepUp(token.AMROTP, gin.H{"status": "verified"})
+
+	case "recovery_codes":
+		recovery, err := m.RegenerateRecoveryCodes(reqCtx, user.ID)
+		if err != nil {
+			return failErr(err)
+		}
+		return gin.H{"recovery_codes": recovery}, true, nil
+
+	case "webauthn_register":
+		opts, err := m.BeginRegistration(reqCtx, user)
+		if err != nil {
+			return failErr(err)
+		}
+		return gin.H{"publicKey": opts}, true, nil
+
+	case "webauthn_register_finish":
+		var resp mfa.RegistrationResponse
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&resp); err != nil {
+			return fail(http.StatusBadRequest, fmt.Sprintf("can't decode credential: %v", err))
+		}
+		cred, err := m.FinishRegistration(reqCtx, user.ID, ctx.Query("name"), resp)
+		if err != nil {
+			return failErr(err)
+		}
+		return stepUp(token.AMRHardwareKey, gin.H{"id": base64.RawURLEncoding.EncodeToString(cred.ID), "name": cred.Name})
+
+	case "webauthn_login":
+		opts, err := m.BeginLogin(reqCtx, user.ID)
+		if err != nil {
+			return failErr(err)
+		}
+		return gin.H{"publicKey": opts}, true, nil
+
+	case "webauthn_login_finish":
+		var resp mfa.AssertionResponse
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&resp); err != nil {
+			return fail(http.StatusBadRequest, fmt.Sprintf("can't decode assertion: %v", err))
+		}
+		if err := m.FinishLogin(reqCtx, user.ID, resp); err != nil {
+			return failErr(err)
+		}
+		return stepUp(token.AMRHardwareKey, gin.H{"status": "verified"})
+
+	default: // webauthn_remove
+		id, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(ctx.Query("id"), "="))
+		if err != nil {
+			return fail(http.StatusBadRequest, "bad credential id")
+		}
+		if err = m.RemoveWebAuthn(reqCtx, user.ID, id); err != nil {
+			return failErr(err)
+		}
+		return gin.H{"status": "credential removed"}, true, nil
+	}
+}
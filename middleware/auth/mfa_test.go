@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/auth/avatar"
+	"mkfst/auth/mfa"
+	"mkfst/auth/provider"
+	"mkfst/auth/token"
+	"mkfst/config"
+	"mkfst/mkfsttest"
+	"mkfst/providers/cache"
+)
+
+func TestRequireMFA(t *testing.T) {
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	defer c.Close()
+	authSvc := NewService(Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		DisableXSRF:  true,
+		AvatarStore:  avatar.NewNoOp(),
+		MFA:          mfa.NewManager(mfa.Opts{Store: mfa.NewCacheStore(c), Issuer: "Acme"}),
+		MFAStepUpURL: "/mfa",
+	})
+	authSvc.AddDirectProvider("local", provider.CredCheckerFunc(func(user, passwd string) (bool, error) {
+		return passwd == "pw", nil
+	}))
+
+	h := mkfsttest.New(t, config.Config{})
+	authRoute, _ := authSvc.Handlers()
+	h.Service.Route("GET", "/auth", http.StatusOK, nil, authRoute)
+	admin := h.Service.Group("/admin", "admin", "Admin")
+	mw := authSvc.Middleware()
+	admin.Middleware(mw.RequireMFA())
+	admin.Route("GET", "/", http.StatusOK, nil, func(c *gin.Context) (string, error) {
+		return "secret stats", nil
+	})
+
+	var jwtCookie *http.Cookie
+	call := func(url string, header ...string) *httptest.ResponseRecorder {
+		req := newRequest("GET", url)
+		if jwtCookie != nil {
+			req.AddCookie(jwtCookie)
+		}
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		w := h.Client().Do(req)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "JWT" {
+				jwtCookie = c
+			}
+		}
+		return w
+	}
+
+	call("/auth?action=login&using=local&user=bob&passwd=pw")
+	w := call("/admin/")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "insufficient_user_authentication") ||
+		strings.Contains(w.Body.String(), "secret stats") {
+		t.Fatalf("expected a step-up challenge, got %d %s", w.Code, w.Body)
+	}
+	if w = call("/admin/?page=1", "Accept", "text/html"); w.Code != http.StatusFound || w.Header().Get("Location") != "/mfa?from=%2Fadmin%2F%3Fpage%3D1" {
+		t.Errorf("expected a redirect to the step-up page, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	w = call("/auth?action=totp_enroll")
+	var enroll struct{ Secret string }
+	if err := json.Unmarshal(w.Body.Bytes(), &enroll); err != nil || enroll.Secret == "" {
+		t.Fatalf("unexpected enrollment: %d %s", w.Code, w.Body)
+	}
+	code, _ := mfa.TOTPCode(enroll.Secret, time.Now(), 30*time.Second)
+	if w = call("/auth?action=totp_confirm&code=" + code); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "recovery_codes") {
+		t.Fatalf("unexpected confirmation: %d %s", w.Code, w.Body)
+	}
+	if w = call("/admin/"); w.Code != http.StatusOK {
+		t.Errorf("expected access after the step-up, got %d %s", w.Code, w.Body)
+	}
+
+	// a new login needs the second factor again, and changing the factors too
+	jwtCookie = nil
+	call("/auth?action=login&using=local&user=bob&passwd=pw")
+	if w = call("/auth?action=totp_disable"); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 changing the factors without MFA, got %d", w.Code)
+	}
+	if w = call("/auth?action=totp_verify&code=000000"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong code, got %d", w.Code)
+	}
+	code, _ = mfa.TOTPCode(enroll.Secret, time.Now().Add(30*time.Second), 30*time.Second)
+	if w = call("/auth?action=totp_verify&code=" + code); w.Code != http.StatusOK {
+		t.Fatalf("unexpected verification: %d %s", w.Code, w.Body)
+	}
+	if w = call("/admin/"); w.Code != http.StatusOK {
+		t.Errorf("expected access after the verification, got %d", w.Code)
+	}
+}
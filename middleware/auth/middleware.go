@@ -9,11 +9,15 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
+	"mkfst/auth/apikey"
 	"mkfst/auth/logger"
 	"mkfst/auth/provider"
+	"mkfst/auth/session"
 	"mkfst/auth/token"
+	"mkfst/auth/users"
 
 	"github.com/gin-gonic/gin"
 )
@@ -27,6 +31,10 @@ type Authenticator struct {
 	AdminPasswd      string
 	BasicAuthChecker BasicAuthFunc
 	RefreshCache     RefreshCache
+	VerifyOnly       bool             // accepts the tokens of another service's users, without refreshing them
+	Sessions         *session.Manager // refreshes the expired tokens of sessions with their refresh token
+	APIKeys          *apikey.Manager  // accepts the API keys of service accounts, sent in its header
+	MFAStepUpURL     string           // page verifying a second factor, RequireMFA redirects browsers to it
 }
 
 // RefreshCache defines interface storing and retrieving refreshed tokens
@@ -74,12 +82,28 @@ func (a *Authenticator) authorizeRequest(
 	_ *sql.DB,
 ) (any, error) {
 
-	return func(ctx *gin.Context, _ *sql.DB) (any, error) {
+	return func(ctx *gin.Context, db *sql.DB) (any, error) {
+		res, err := a.authenticate(reqAuth)(ctx, db)
+		if err == nil && !ctx.IsAborted() {
+			ctx.Next()
+		}
+		return res, err
+	}
+}
+
+// authenticate populates the user info of the request, without calling the next handlers,
+// for the middlewares checking it further before calling them
+func (a *Authenticator) authenticate(
+	reqAuth bool,
+) func(
+	ctx *gin.Context,
+	_ *sql.DB,
+) (any, error) {
 
+	return func(ctx *gin.Context, _ *sql.DB) (any, error) {
 		// use admin user basic auth if enabled but ignore when BasicAuthChecker defined
 		if a.BasicAuthChecker == nil && a.basicAdminUser(ctx.Request) {
 			ctx.Request = token.SetUserInfo(ctx.Request, adminUser)
-			ctx.Next()
 			return nil, nil
 		}
 
@@ -102,11 +126,26 @@ func (a *Authenticator) authorizeRequest(
 					)
 				}
 				ctx.Request = token.SetUserInfo(ctx.Request, userInfo) // pass user claims into context of incoming request
-				ctx.Next()
 				return nil, err
 			}
 		}
 
+		// use the API key of a service account if presented
+		if a.APIKeys != nil {
+			if key := ctx.GetHeader(a.APIKeys.HeaderKey); key != "" {
+				k, err := a.APIKeys.Verify(ctx.Request.Context(), key)
+				if err != nil {
+					return a.onError(
+						ctx,
+						fmt.Errorf("api key check failed: %w", err),
+						reqAuth,
+					)
+				}
+				ctx.Request = token.SetUserInfo(ctx.Request, a.APIKeys.User(k))
+				return nil, nil
+			}
+		}
+
 		claims, tkn, err := a.JWTService.Get(ctx.Request)
 		if err != nil {
 			return a.onError(
@@ -140,12 +179,22 @@ func (a *Authenticator) authorizeRequest(
 					fmt.Errorf("user %s/%s blocked", claims.User.Name, claims.User.ID),
 					reqAuth,
 				)
-				a.JWTService.Reset(ctx.Writer)
+				if !a.VerifyOnly { // cookies of a verified token belong to its issuer
+					a.JWTService.Reset(ctx.Writer)
+				}
 				return res, err
 			}
 
+			if a.VerifyOnly {
+				if a.JWTService.IsExpired(claims) {
+					return a.onError(ctx, fmt.Errorf("token expired"), reqAuth)
+				}
+				ctx.Request = token.SetAMR(token.SetUserInfo(ctx.Request, *claims.User), claims.AMR)
+				return nil, nil
+			}
+
 			// check if user provider is allowed
-			if !a.isProviderAllowed(claims.User.ID) {
+			if !a.isProviderAllowed(*claims.User) {
 				res, err := a.onError(
 					ctx,
 					fmt.Errorf("user %s/%s provider is not allowed", claims.User.Name, claims.User.ID),
@@ -158,10 +207,14 @@ func (a *Authenticator) authorizeRequest(
 			if a.JWTService.IsExpired(claims) {
 				if claims, err = a.refreshExpiredToken(
 					ctx.Writer,
+					ctx.Request,
 					claims,
 					tkn,
 				); err != nil {
 					a.JWTService.Reset(ctx.Writer)
+					if a.Sessions != nil {
+						a.Sessions.Reset(ctx.Writer)
+					}
 					return a.onError(
 						ctx,
 						fmt.Errorf("can't refresh token: %w", err),
@@ -170,10 +223,10 @@ func (a *Authenticator) authorizeRequest(
 				}
 			}
 
-			ctx.Request = token.SetUserInfo(ctx.Request, *claims.User) // populate user info to request context
+			// populate user info and authentication methods to request context
+			ctx.Request = token.SetAMR(token.SetUserInfo(ctx.Request, *claims.User), claims.AMR)
 		}
 
-		ctx.Next()
 		return nil, nil
 	}
 }
@@ -184,7 +237,6 @@ func (a *Authenticator) onError(
 	reqAuth bool,
 ) (any, error) {
 	if !reqAuth { // if no auth required allow to proceeded on error
-		ctx.Next()
 		return nil, nil
 	}
 
@@ -199,7 +251,11 @@ func (a *Authenticator) onError(
 // isProviderAllowed checks if user provider is allowed, user id looks like "provider_1234567890"
 // this check is needed to reject users from providers what are used to be allowed but not anymore.
 // Such users made token before the provider was disabled and should not be allowed to login anymore.
-func (a *Authenticator) isProviderAllowed(userID string) bool {
+func (a *Authenticator) isProviderAllowed(u token.User) bool {
+	userID := u.ID
+	if identity := u.StrAttr(users.IdentityAttr); identity != "" { // account of the users store
+		userID = identity
+	}
 	userProvider := strings.Split(userID, "_")[0]
 	for _, p := range a.Providers {
 		if p.Name() == userProvider {
@@ -209,8 +265,8 @@ func (a *Authenticator) isProviderAllowed(userID string) bool {
 	return false
 }
 
-// refreshExpiredToken makes a new token with passed claims
-func (a *Authenticator) refreshExpiredToken(w http.ResponseWriter, claims token.Claims, tkn string) (token.Claims, error) {
+// refreshExpiredToken makes a new token with passed claims, rotating the refresh token of their session if any
+func (a *Authenticator) refreshExpiredToken(w http.ResponseWriter, r *http.Request, claims token.Claims, tkn string) (token.Claims, error) {
 
 	// cache refreshed claims for given token in order to eliminate multiple refreshes for concurrent requests
 	if a.RefreshCache != nil {
@@ -220,8 +276,14 @@ func (a *Authenticator) refreshExpiredToken(w http.ResponseWriter, claims token.
 		}
 	}
 
-	claims.ExpiresAt = 0                  // this will cause now+duration for refreshed token
-	c, err := a.JWTService.Set(w, claims) // Set changes token
+	var c token.Claims
+	var err error
+	if a.Sessions != nil && claims.SessionID != "" {
+		c, err = a.refreshSession(w, r, claims)
+	} else {
+		claims.ExpiresAt = 0                 // this will cause now+duration for refreshed token
+		c, err = a.JWTService.Set(w, claims) // Set changes token
+	}
 	if err != nil {
 		return token.Claims{}, err
 	}
@@ -259,6 +321,43 @@ func (a *Authenticator) AdminOnly() func(ctx *gin.Context, db *sql.DB) (any, err
 	}
 }
 
+// RequireMFA middleware allows users who verified a second factor, with "mfa" in the amr claim of their token.
+// Others get 401 with a step-up challenge, or are redirected to MFAStepUpURL if set and the request is from a browser.
+// this handler internally wrapped with auth(true) to avoid situation if RequireMFA defined without prior Auth
+func (a *Authenticator) RequireMFA() func(ctx *gin.Context, db *sql.DB) (any, error) {
+
+	return func(ctx *gin.Context, db *sql.DB) (any, error) {
+		if _, err := a.authenticate(true)(ctx, db); err != nil {
+			return nil, err
+		}
+
+		if (token.Claims{AMR: token.GetAMR(ctx.Request)}).HasMFA() {
+			ctx.Next()
+			return nil, nil
+		}
+
+		user, _ := token.GetUserInfo(ctx.Request)
+		err := fmt.Errorf("user %s/%s has not verified a second factor", user.Name, user.ID)
+		ctx.Error(err)
+		if a.MFAStepUpURL != "" && ctx.Request.Method == http.MethodGet && strings.Contains(ctx.GetHeader("Accept"), "text/html") {
+			sep := "?"
+			if strings.Contains(a.MFAStepUpURL, "?") {
+				sep = "&"
+			}
+			ctx.Redirect(http.StatusFound, a.MFAStepUpURL+sep+"from="+url.QueryEscape(ctx.Request.URL.RequestURI()))
+			ctx.Abort()
+			return nil, err
+		}
+		// step-up challenge of RFC 9470
+		ctx.Header("WWW-Authenticate", `Bearer error="insufficient_user_authentication", error_description="A second factor is required", acr_values="mfa"`)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "Second factor required.",
+			"step_up": a.MFAStepUpURL,
+		})
+		return nil, err
+	}
+}
+
 // basic auth for admin user
 func (a *Authenticator) basicAdminUser(r *http.Request) bool {
 
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/auth/token"
+	"mkfst/config"
+	"mkfst/mkfsttest"
+)
+
+func TestAuthCallsTheHandler(t *testing.T) {
+	h := mkfsttest.New(t, config.Config{})
+	mw := NewService(Opts{BasicAuthChecker: func(user, passwd string) (bool, token.User, error) {
+		return passwd == "pw", token.User{ID: "basic_" + user, Name: user}, nil
+	}}).Middleware()
+
+	items := h.Service.Group("/items", "items", "Items")
+	items.Middleware(mw.Auth)
+	items.Route("POST", "/", http.StatusCreated, nil, func(c *gin.Context) (*token.User, error) {
+		u, err := token.GetUserInfo(c.Request)
+		return &u, err
+	})
+
+	req := newRequest("POST", "/items/")
+	req.SetBasicAuth("bob", "pw")
+	w := h.Client().Do(req)
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Fatalf("expected the response of the handler, got %d %s %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+
+	req = newRequest("POST", "/items/")
+	req.SetBasicAuth("bob", "wrong")
+	if w = h.Client().Do(req); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"mkfst/auth/avatar"
+	"mkfst/auth/provider"
+	"mkfst/auth/token"
+	"mkfst/config"
+	"mkfst/mkfsttest"
+	"mkfst/providers/cache"
+)
+
+func TestOIDCLogout(t *testing.T) {
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer := "http://" + r.Host
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/jwks",
+			"end_session_endpoint":   issuer + "/logout",
+		})
+	}))
+	defer idp.Close()
+
+	idTokens := cache.NewMemoryCache(cache.MemoryOpts{})
+	defer idTokens.Close()
+	if err := idTokens.Set(context.Background(), "oidc:keycloak:id_token:jwt1", []byte("id-token-1"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	authSvc := NewService(Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		URL:          "http://app.example.com",
+		DisableXSRF:  true,
+		AvatarStore:  avatar.NewNoOp(),
+	})
+	authSvc.AddDirectProvider("local", provider.CredCheckerFunc(func(user, passwd string) (bool, error) { return false, nil }))
+	if err := authSvc.AddOIDCProvider("keycloak", Client{Cid: "app", Csecret: "csecret"}, provider.OIDCConfig{Issuer: idp.URL, Cache: idTokens}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := mkfsttest.New(t, config.Config{})
+	authRoute, _ := authSvc.Handlers()
+	h.Service.Route("GET", "/auth", http.StatusOK, nil, authRoute)
+
+	w := httptest.NewRecorder()
+	if _, err := authSvc.TokenService().Set(w, token.Claims{User: &token.User{ID: "keycloak_user1", Name: "User One"}, StandardClaims: jwt.StandardClaims{Id: "jwt1"}}); err != nil {
+		t.Fatal(err)
+	}
+	req := newRequest("GET", "/auth?action=logout&using=keycloak&from=http://app.example.com/bye")
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+
+	// the logout of the second provider, with the ID token of the login
+	w = h.Client().Do(req)
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || loc.Path != "/logout" || loc.Query().Get("client_id") != "app" ||
+		loc.Query().Get("id_token_hint") != "id-token-1" || loc.Query().Get("post_logout_redirect_uri") != "http://app.example.com/bye" {
+		t.Errorf("unexpected logout response: %d %v", w.Code, loc)
+	}
+
+	if w = h.Client().Do(newRequest("GET", "/auth?action=logout&using=nope")); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown provider, got %d", w.Code)
+	}
+}
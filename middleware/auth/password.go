@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+
+	"mkfst/auth/lockout"
+	"mkfst/auth/provider"
+	"mkfst/auth/token"
+	"mkfst/providers/cache"
+)
+
+// passwordResetState marks the handshake of the password reset tokens, rejected by the verify providers
+const passwordResetState = "password_reset"
+
+// PasswordResetOpts enables the password reset actions of the auth route, for the passwords the
+// application checks in the CredChecker of a direct provider:
+//
+//	POST /auth?action=password_reset_start   email=bob@example.com
+//	POST /auth?action=password_reset         token=...&password=...
+//
+// The first mails a link to URL with a single-use token, the second sets the new password.
+type PasswordResetOpts struct {
+	Sender   provider.TemplateSender // sends the reset link, i.e. sender.Mail, required
+	Template string                  // name of the template, default "password_reset"; its data has User, Address, Token, Link and TTL
+
+	// Lookup returns the user of the email address, ok false if none. Required. With the Sessions
+	// of the Opts, the sessions of this user ID are revoked by the reset.
+	Lookup func(ctx context.Context, email string) (user string, ok bool, err error)
+	// SetPassword changes the password of the user. Required.
+	SetPassword func(ctx context.Context, user, password string) error
+
+	URL       string        // page of the application asking the new password, the link adds the token param; required
+	TTL       time.Duration // validity of the links, default 30m
+	MinLength int           // minimal length of the passwords, default 8
+	Cache     cache.Cache   // records the used tokens, default in memory; share it between the replicas
+
+	// MinResponse is the time password_reset_start takes at least, whether the address has a
+	// user or not; default the MinResponse of the Lockout, or 300ms without Lockout.
+	MinResponse time.Duration
+}
+
+// passwordHandler handles the password reset actions of the auth route, reporting if action is one
+func (s *AuthService) passwordHandler(ctx *gin.Context, action string) (res gin.H, ok bool, err error) {
+	switch action {
+	case "password_reset_start", "password_reset":
+	default:
+		return nil, false, nil
+	}
+	fail := func(status int, msg string) (gin.H, bool, error) {
+		ctx.AbortWithStatus(status)
+		return gin.H{"error": msg}, true, errors.New(msg)
+	}
+	if s.opts.PasswordReset == nil {
+		return fail(http.StatusBadRequest, "password reset not enabled")
+	}
+	opts := s.opts.PasswordReset
+	reqCtx := ctx.Request.Context()
+
+	if action == "password_reset_start" {
+		address := ctx.Request.FormValue("email")
+		if address == "" {
+			return fail(http.StatusBadRequest, "email required")
+		}
+		if g := s.opts.Lockout; g != nil {
+			ip := g.ClientIP(ctx.Request)
+			wait, err := g.Cooldown(reqCtx, passwordResetState, address, ip)
+			if err != nil {
+				return fail(http.StatusInternalServerError, err.Error())
+			}
+			if wait > 0 {
+				g.Audit(lockout.Event{Type: lockout.EventThrottled, Provider: passwordResetState, User: address, IP: ip, LockedFor: wait})
+				ctx.Header("Retry-After", strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10))
+				return fail(http.StatusTooManyRequests, "reset already sent")
+			}
+		}
+		// the response is the same whether the address has a user or not
+		start := time.Now()
+		if err := s.sendPasswordReset(ctx.Request, address); err != nil {
+			s.logger.Logf("[WARN] can't send password reset to %s: %v", address, err)
+		}
+		if wait := time.Until(start.Add(opts.MinResponse)); wait > 0 {
+			t := time.NewTimer(wait)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-reqCtx.Done():
+			}
+		}
+		return gin.H{"status": "sent"}, true, nil
+	}
+
+	password := ctx.Request.FormValue("password")
+	if len(password) < opts.MinLength {
+		return fail(http.StatusBadRequest, "password too short, minimum "+strconv.Itoa(opts.MinLength))
+	}
+	claims, err := s.jwtService.Parse(ctx.Request.FormValue("token"))
+	if err != nil || claims.Handshake == nil || claims.Handshake.State != passwordResetState || claims.Id == "" {
+		return fail(http.StatusForbidden, "invalid reset token")
+	}
+	if s.jwtService.IsExpired(claims) {
+		return fail(http.StatusForbidden, "expired reset token")
+	}
+	if ok, err := s.consumeResetToken(reqCtx, claims.Id); err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	} else if !ok {
+		return fail(http.StatusForbidden, "used reset token")
+	}
+	if err = opts.SetPassword(reqCtx, claims.Handshake.ID, password); err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+	// a reset password may be a stolen one, log the user out of all its devices
+	if s.opts.Sessions != nil {
+		if err = s.opts.Sessions.RevokeUser(reqCtx, claims.Handshake.ID); err != nil {
+			return fail(http.StatusInternalServerError, err.Error())
+		}
+	}
+	return gin.H{"status": "password changed"}, true, nil
+}
+
+// consumeResetToken marks the reset token used, reporting false if it already was. The check and
+// the mark are one atomic Add of the cache, so concurrent requests with the same token can't both
+// change the password; a cache without Add is only serialized in this process.
+func (s *AuthService) consumeResetToken(ctx context.Context, id string) (bool, error) {
+	opts := s.opts.PasswordReset
+	key := "password_reset:" + id
+	if a, ok := opts.Cache.(cache.Adder); ok {
+		return a.Add(ctx, key, []byte{1}, opts.TTL)
+	}
+	s.resetLock.Lock()
+	defer s.resetLock.Unlock()
+	if _, used, err := opts.Cache.Get(ctx, key); err != nil || used {
+		return false, err
+	}
+	return true, opts.Cache.Set(ctx, key, []byte{1}, opts.TTL)
+}
+
+// sendPasswordReset mails the reset link to the user of the address, if any
+func (s *AuthService) sendPasswordReset(r *http.Request, address string) error {
+	opts := s.opts.PasswordReset
+	user, ok, err := opts.Lookup(r.Context(), address)
+	if err != nil || !ok {
+		return err
+	}
+	id := make([]byte, 20)
+	if _, err = rand.Read(id); err != nil {
+		return fmt.Errorf("can't make claim's id: %w", err)
+	}
+	tkn, err := s.jwtService.Token(token.Claims{
+		Handshake: &token.Handshake{State: passwordResetState, ID: user},
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(id),
+			Issuer:    s.issuer,
+			ExpiresAt: time.Now().Add(opts.TTL).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	link, err := url.Parse(opts.URL)
+	if err != nil {
+		return err
+	}
+	q := link.Query()
+	q.Set("token", tkn)
+	link.RawQuery = q.Encode()
+
+	data := struct {
+		User    string
+		Address string
+		Token   string
+		Link    string
+		TTL     time.Duration
+	}{User: user, Address: address, Token: tkn, Link: link.String(), TTL: opts.TTL}
+	return opts.Sender.SendTemplate(r.Context(), address, opts.Template, provider.RequestLocale(r), data)
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"mkfst/auth/avatar"
+	"mkfst/auth/provider/sender"
+	"mkfst/auth/session"
+	"mkfst/auth/token"
+	"mkfst/config"
+	"mkfst/mkfsttest"
+	"mkfst/providers/cache"
+	"mkfst/providers/mail"
+)
+
+// testMailer returns a mailer delivering synchronously to the returned outbox
+func testMailer(t *testing.T) (*sender.Mail, func() []mail.Message) {
+	tmpls, err := mail.NewTemplates(fstest.MapFS{
+		"verify.txt":           {Data: []byte(`{{define "subject"}}Sign in{{end}}Sign in as {{.User}}: {{.Link}}`)},
+		"verify.fr.txt":        {Data: []byte(`{{define "subject"}}Connexion{{end}}Connexion de {{.User}} : {{.Link}}`)},
+		"password_reset.txt":   {Data: []byte(`{{define "subject"}}Reset your password{{end}}Reset it at {{.Link}}`)},
+		"password_reset.html":  {Data: []byte(`<a href="{{.Link}}">Reset</a>`)},
+		"layouts/base.html":    {Data: []byte(`<html>{{template "content" .}}</html>`)},
+		"layouts/base.fr.html": {Data: []byte(`<html lang="fr">{{template "content" .}}</html>`)},
+	}, mail.TemplatesOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	var outbox []mail.Message
+	m, err := mail.New(mail.Opts{
+		Transport: mail.TransportFunc(func(_ context.Context, msg mail.Message) error {
+			lock.Lock()
+			outbox = append(outbox, msg)
+			lock.Unlock()
+			return nil
+		}),
+		Templates: tmpls,
+		From:      "no-reply@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender.NewMail(m, "", nil), func() []mail.Message {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]mail.Message(nil), outbox...)
+	}
+}
+
+var reLink = regexp.MustCompile(`https?://\S+`)
+
+func TestMagicLink(t *testing.T) {
+	mailer, outbox := testMailer(t)
+	authSvc := NewService(Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		DisableXSRF:  true,
+		AvatarStore:  avatar.NewNoOp(),
+		URL:          "http://127.0.0.1:8080",
+	})
+	authSvc.AddVerifTemplateProvider("email", "", mailer)
+
+	h := mkfsttest.New(t, config.Config{})
+	authRoute, _ := authSvc.Handlers()
+	h.Service.Route("GET", "/auth", http.StatusOK, nil, authRoute)
+
+	req := newRequest("GET", "/auth?action=login&using=email&user=bob&address=bob@example.com&from=/home")
+	req.Header.Set("Accept-Language", "fr-CA,fr;q=0.9")
+	if w := h.Client().Do(req); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body)
+	}
+	msgs := outbox()
+	if len(msgs) != 1 || msgs[0].Subject != "Connexion" || msgs[0].To[0] != "bob@example.com" {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	link, err := url.Parse(reLink.FindString(msgs[0].Text))
+	if err != nil || link.Path != "/auth" || link.Query().Get("using") != "email" || link.Query().Get("token") == "" {
+		t.Fatalf("unexpected link %v, %v", link, err)
+	}
+
+	w := h.Client().Do(newRequest("GET", link.RequestURI()))
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/home" {
+		t.Fatalf("expected a redirect to /home, got %d %v", w.Code, w.Header())
+	}
+	if !strings.Contains(w.Header().Get("Set-Cookie"), "JWT=") {
+		t.Errorf("expected the token cookie, got %v", w.Header())
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	mailer, outbox := testMailer(t)
+	var lock sync.Mutex
+	passwords := map[string]string{"bob": "old password"}
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	defer c.Close()
+	sessions := session.NewManager(session.Opts{Cache: c})
+	if _, _, err := sessions.Create(context.Background(), token.Claims{User: &token.User{ID: "bob"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	authSvc := NewService(Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		DisableXSRF:  true,
+		AvatarStore:  avatar.NewNoOp(),
+		Sessions:     sessions,
+		PasswordReset: &PasswordResetOpts{
+			Sender: mailer,
+			Lookup: func(_ context.Context, email string) (string, bool, error) {
+				return "bob", email == "bob@example.com", nil
+			},
+			SetPassword: func(_ context.Context, user, password string) error {
+				lock.Lock()
+				passwords[user] = password
+				lock.Unlock()
+				return nil
+			},
+			URL:         "https://app.example.com/reset",
+			MinResponse: 50 * time.Millisecond,
+		},
+	})
+	authSvc.AddVerifTemplateProvider("email", "", mailer)
+
+	h := mkfsttest.New(t, config.Config{})
+	authRoute, _ := authSvc.Handlers()
+	h.Service.Route("POST", "/auth", http.StatusOK, nil, authRoute)
+	post := func(action string, form url.Values) int {
+		req, _ := http.NewRequest("POST", "/auth?action="+action, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return h.Client().Do(req).Code
+	}
+
+	for _, address := range []string{"alice@example.com", "bob@example.com"} {
+		start := time.Now()
+		if code := post("password_reset_start", url.Values{"email": {address}}); code != http.StatusOK {
+			t.Errorf("%s: expected 200 whether the user exists or not, got %d", address, code)
+		}
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Errorf("%s: answered in %v, before MinResponse without Lockout", address, d)
+		}
+	}
+	msgs := outbox()
+	if len(msgs) != 1 || msgs[0].Subject != "Reset your password" || !strings.Contains(msgs[0].HTML, `<html><a href="https://app.example.com/reset?token=`) {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	link, _ := url.Parse(reLink.FindString(msgs[0].Text))
+	tkn := link.Query().Get("token")
+
+	if code := post("password_reset", url.Values{"token": {tkn}, "password": {"short"}}); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a short password, got %d", code)
+	}
+	if code := post("password_reset", url.Values{"token": {"bad"}, "password": {"new password"}}); code != http.StatusForbidden {
+		t.Errorf("expected 403 for an invalid token, got %d", code)
+	}
+	if code := post("password_reset", url.Values{"token": {tkn}, "password": {"new password"}}); code != http.StatusOK {
+		t.Fatalf("expected the password reset, got %d", code)
+	}
+	if code := post("password_reset", url.Values{"token": {tkn}, "password": {"other password"}}); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a used token, got %d", code)
+	}
+	lock.Lock()
+	if passwords["bob"] != "new password" {
+		t.Errorf("unexpected password %q", passwords["bob"])
+	}
+	lock.Unlock()
+	if list, err := sessions.Sessions(context.Background(), "bob"); err != nil || len(list) != 0 {
+		t.Errorf("expected the sessions of bob revoked by the reset, got %v, %v", list, err)
+	}
+
+	// the reset token is not a confirmation of the verify provider
+	if code := post("login&using=email&token="+tkn, nil); code != http.StatusBadRequest {
+		t.Errorf("expected the reset token rejected by the verify provider, got %d", code)
+	}
+
+	// a new token used concurrently changes the password once
+	if code := post("password_reset_start", url.Values{"email": {"bob@example.com"}}); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	msgs = outbox()
+	link, _ = url.Parse(reLink.FindString(msgs[len(msgs)-1].Text))
+	tkn = link.Query().Get("token")
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = post("password_reset", url.Values{"token": {tkn}, "password": {"password " + strconv.Itoa(i)}})
+		}(i)
+	}
+	wg.Wait()
+	changed := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			changed++
+		} else if code != http.StatusForbidden {
+			t.Errorf("expected 403 for a used token, got %d", code)
+		}
+	}
+	if changed != 1 {
+		t.Errorf("expected the password changed once, got %d", changed)
+	}
+}
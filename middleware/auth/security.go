@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"mkfst/auth/token"
+	"mkfst/fizz"
+	"mkfst/fizz/openapi"
+)
+
+// Names of the security schemes documented for the Authenticator middlewares.
+const (
+	SchemeJWTHeader = "jwtHeader"
+	SchemeJWTCookie = "jwtCookie"
+	SchemeJWTQuery  = "jwtQuery"
+	SchemeXSRF      = "xsrfHeader"
+	SchemeBasicAuth = "basicAuth"
+	SchemeAPIKey    = "apiKey"
+)
+
+// securityDoc documents the security enforced by the
+// middlewares of an Authenticator, for fizz.
+type securityDoc struct {
+	a        *Authenticator
+	optional bool
+}
+
+// DocumentSecurity registers the security schemes and requirements
+// of the Auth, Trace, AdminOnly, RBAC and RequireMFA middlewares of a,
+// so the routes and groups using them are documented as secured in
+// the OpenAPI specification. AuthService.Middleware calls it.
+//
+// Middlewares are identified by function, not receiver: when several
+// authenticators are used, the last one documented describes them all.
+func (a *Authenticator) DocumentSecurity() {
+	required := &securityDoc{a: a}
+	fizz.RegisterMiddlewareSecurity(a.Auth, required)
+	fizz.RegisterMiddlewareSecurity(a.AdminOnly(), required)
+	fizz.RegisterMiddlewareSecurity(a.RBAC(), required)
+	fizz.RegisterMiddlewareSecurity(a.RequireMFA(), required)
+	fizz.RegisterMiddlewareSecurity(a.Trace, &securityDoc{a: a, optional: true})
+}
+
+// tokenOpts returns the options of the JWT service,
+// with the default names of its cookies and headers.
+func (d *securityDoc) tokenOpts() token.Opts {
+	if s, ok := d.a.JWTService.(*token.Service); ok {
+		return s.Opts
+	}
+	return token.NewService(token.Opts{}).Opts
+}
+
+func (d *securityDoc) basicAuth() bool {
+	return d.a.BasicAuthChecker != nil || d.a.AdminPasswd != ""
+}
+
+// SecuritySchemes implements fizz.SecurityDocumenter.
+func (d *securityDoc) SecuritySchemes() map[string]*openapi.SecuritySchemeOrRef {
+	opts := d.tokenOpts()
+
+	apiKey := func(in, name, desc string) *openapi.SecuritySchemeOrRef {
+		return &openapi.SecuritySchemeOrRef{SecurityScheme: &openapi.SecurityScheme{
+			Type:        "apiKey",
+			In:          in,
+			Name:        name,
+			Description: desc,
+		}}
+	}
+	schemes := map[string]*openapi.SecuritySchemeOrRef{
+		SchemeJWTHeader: apiKey("header", opts.JWTHeaderKey, "JWT sent in a header."),
+		SchemeJWTCookie: apiKey("cookie", opts.JWTCookieName, "JWT cookie set on login."),
+		SchemeJWTQuery:  apiKey("query", opts.JWTQuery, "JWT sent as a query parameter."),
+	}
+	if !opts.DisableXSRF {
+		schemes[SchemeXSRF] = apiKey("header", opts.XSRFHeaderKey, "XSRF token, the jti claim of the JWT cookie, required along with the cookie.")
+	}
+	if d.a.APIKeys != nil {
+		schemes[SchemeAPIKey] = apiKey("header", d.a.APIKeys.HeaderKey, "API key of a service account.")
+	}
+	if d.basicAuth() {
+		schemes[SchemeBasicAuth] = &openapi.SecuritySchemeOrRef{SecurityScheme: &openapi.SecurityScheme{
+			Type:   "http",
+			Scheme: "basic",
+		}}
+	}
+	return schemes
+}
+
+// SecurityRequirements implements fizz.SecurityDocumenter.
+func (d *securityDoc) SecurityRequirements() []*openapi.SecurityRequirement {
+	cookie := openapi.SecurityRequirement{SchemeJWTCookie: {}}
+	if !d.tokenOpts().DisableXSRF {
+		cookie[SchemeXSRF] = []string{}
+	}
+	reqs := []*openapi.SecurityRequirement{
+		{SchemeJWTHeader: {}},
+		&cookie,
+		{SchemeJWTQuery: {}},
+	}
+	if d.a.APIKeys != nil {
+		reqs = append(reqs, &openapi.SecurityRequirement{SchemeAPIKey: {}})
+	}
+	if d.basicAuth() {
+		reqs = append(reqs, &openapi.SecurityRequirement{SchemeBasicAuth: {}})
+	}
+	if d.optional {
+		reqs = append(reqs, &openapi.SecurityRequirement{})
+	}
+	return reqs
+}
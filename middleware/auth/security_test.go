@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/config"
+	"mkfst/fizz"
+	"mkfst/mkfsttest"
+)
+
+func TestSecurityDocumentation(t *testing.T) {
+	h := mkfsttest.New(t, config.Config{})
+	mw := NewService(Opts{AdminPasswd: "secret", JWTHeaderKey: "X-Token"}).Middleware()
+
+	hello := func(c *gin.Context) (string, error) { return "hello", nil }
+
+	h.Service.Route("GET", "/public", http.StatusOK, nil, hello)
+
+	api := h.Service.Group("/api", "api", "API")
+	api.Middleware(mw.Trace)
+	api.Route("GET", "/feed", http.StatusOK, nil, hello)
+	api.Route("GET", "/health", http.StatusOK, []fizz.OperationOption{fizz.WithoutSecurity()}, hello)
+
+	admin := h.Service.Group("/admin", "admin", "Admin")
+	admin.Middleware(mw.AdminOnly())
+	admin.Route("GET", "/stats", http.StatusOK, nil, hello)
+
+	spec := h.Spec()
+
+	schemes := spec.Components.SecuritySchemes
+	if s := schemes[SchemeJWTHeader]; s == nil || s.In != "header" || s.Name != "X-Token" {
+		t.Errorf("unexpected header scheme: %+v", s)
+	}
+	if s := schemes[SchemeJWTCookie]; s == nil || s.In != "cookie" || s.Name != "JWT" {
+		t.Errorf("unexpected cookie scheme: %+v", s)
+	}
+	if s := schemes[SchemeBasicAuth]; s == nil || s.Type != "http" || s.Scheme != "basic" {
+		t.Errorf("unexpected basic auth scheme: %+v", s)
+	}
+	if _, ok := schemes[SchemeXSRF]; !ok {
+		t.Error("missing XSRF scheme")
+	}
+
+	if op := spec.Operation("GET", "/public"); op.Security != nil {
+		t.Errorf("public operation is secured: %v", op.Security)
+	}
+	stats := spec.Operation("GET", "/admin/stats").Security
+	if len(stats) != 4 {
+		t.Fatalf("unexpected admin security: %v", stats)
+	}
+	if cookie := *stats[1]; len(cookie) != 2 || cookie[SchemeJWTCookie] == nil || cookie[SchemeXSRF] == nil {
+		t.Errorf("cookie requirement lacks the XSRF header: %v", cookie)
+	}
+	feed := spec.Operation("GET", "/api/feed").Security
+	if len(feed) != 5 || len(*feed[4]) != 0 {
+		t.Errorf("traced operation security is not optional: %v", feed)
+	}
+	if health := spec.Operation("GET", "/api/health").Security; health == nil || len(health) != 0 {
+		t.Errorf("WithoutSecurity was overridden: %v", health)
+	}
+
+	// The documented middleware still guards the routes.
+	if w := h.Client().Do(newRequest("GET", "/admin/stats")); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+	req := newRequest("GET", "/admin/stats")
+	req.SetBasicAuth("admin", "secret")
+	if w := h.Client().Do(req); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d %s", w.Code, w.Body)
+	}
+}
+
+func newRequest(method, url string) *http.Request {
+	req, _ := http.NewRequest(method, url, nil)
+	return req
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+
+	"mkfst/auth/session"
+	"mkfst/auth/token"
+	"mkfst/auth/users"
+)
+
+// sessionTokens is the token service of the providers. Set records the user of a login in
+// the users store, then starts a session for its tokens, sending its refresh token along with the JWT.
+type sessionTokens struct {
+	*token.Service
+	sessions *session.Manager
+	users    *users.Manager
+}
+
+// requestWriter passes the login request to sessionTokens.Set, describing the device of the session
+type requestWriter struct {
+	gin.ResponseWriter
+	request *http.Request
+}
+
+// Set makes a session for the claims of a login, then sets the token with its id
+func (t sessionTokens) Set(w http.ResponseWriter, claims token.Claims) (token.Claims, error) {
+	if claims.User == nil || claims.Handshake != nil || claims.SessionID != "" {
+		return t.Service.Set(w, claims)
+	}
+
+	ctx, r := context.Background(), (*http.Request)(nil)
+	if rw, ok := w.(*requestWriter); ok {
+		ctx, r = rw.request.Context(), rw.request
+	}
+	if t.users != nil {
+		u, err := t.users.Login(ctx, *claims.User)
+		if err != nil {
+			return token.Claims{}, fmt.Errorf("failed to record user: %w", err)
+		}
+		claims.User = &u
+	}
+	if t.sessions == nil {
+		return t.Service.Set(w, claims)
+	}
+	refresh, s, err := t.sessions.Create(ctx, claims, r)
+	if err != nil {
+		return token.Claims{}, fmt.Errorf("failed to make session: %w", err)
+	}
+	claims.SessionID = s.ID
+	if claims, err = t.Service.Set(w, claims); err != nil {
+		return token.Claims{}, err
+	}
+	t.sessions.SetToken(w, refresh)
+	return claims, nil
+}
+
+// sessionsHandler handles the session actions of the auth route, reporting if action is one
+func (s *AuthService) sessionsHandler(ctx *gin.Context, action string) (res gin.H, ok bool, err error) {
+	switch action {
+	case "refresh", "sessions", "revoke", "logout_all":
+	default:
+		return nil, false, nil
+	}
+	fail := func(status int, msg string) (gin.H, bool, error) {
+		ctx.AbortWithStatus(status)
+		return gin.H{"error": msg}, true, errors.New(msg)
+	}
+	if s.opts.Sessions == nil {
+		return fail(http.StatusBadRequest, "sessions not enabled")
+	}
+	sessions := s.opts.Sessions
+
+	if action == "refresh" {
+		claims, err := s.authMiddleware.refreshSession(ctx.Writer, ctx.Request, token.Claims{})
+		if err != nil {
+			s.jwtService.Reset(ctx.Writer)
+			sessions.Reset(ctx.Writer)
+			return fail(http.StatusUnauthorized, err.Error())
+		}
+		return gin.H{"user": claims.User}, true, nil
+	}
+
+	claims, _, err := s.jwtService.Get(ctx.Request)
+	if err != nil || claims.User == nil {
+		return fail(http.StatusUnauthorized, "not logged in")
+	}
+
+	switch action {
+	case "sessions":
+		list, err := sessions.Sessions(ctx.Request.Context(), claims.User.ID)
+		if err != nil {
+			return fail(http.StatusInternalServerError, err.Error())
+		}
+		return gin.H{"sessions": list, "current": claims.SessionID}, true, nil
+
+	case "revoke":
+		id := ctx.Query("session")
+		sess, err := sessions.Session(ctx.Request.Context(), id)
+		if errors.Is(err, session.ErrNotFound) || (err == nil && sess.User.ID != claims.User.ID) {
+			return fail(http.StatusNotFound, fmt.Sprintf("session %q not found", id))
+		}
+		if err == nil {
+			err = sessions.Revoke(ctx.Request.Context(), id)
+		}
+		if err != nil {
+			return fail(http.StatusInternalServerError, err.Error())
+		}
+		return gin.H{"status": "session revoked"}, true, nil
+
+	default: // logout_all
+		if err := sessions.RevokeUser(ctx.Request.Context(), claims.User.ID); err != nil {
+			return fail(http.StatusInternalServerError, err.Error())
+		}
+		s.jwtService.Reset(ctx.Writer)
+		sessions.Reset(ctx.Writer)
+		return gin.H{"status": "logged out of all devices"}, true, nil
+	}
+}
+
+// revokeLogout revokes the session, or the token without session, of a logout request
+func (s *AuthService) revokeLogout(ctx *gin.Context) error {
+	claims, _, err := s.jwtService.Get(ctx.Request)
+	if err != nil || claims.User == nil {
+		return nil // nothing to revoke
+	}
+	defer s.opts.Sessions.Reset(ctx.Writer)
+	if claims.SessionID != "" {
+		return s.opts.Sessions.Revoke(ctx.Request.Context(), claims.SessionID)
+	}
+	return s.opts.Sessions.RevokeToken(ctx.Request.Context(), claims)
+}
+
+// refreshSession rotates the refresh token of the request and sets a token for its session.
+// The claims of an expired token, if any, must be of the same session.
+func (a *Authenticator) refreshSession(w http.ResponseWriter, r *http.Request, claims token.Claims) (token.Claims, error) {
+	refresh := a.Sessions.Token(r)
+	if refresh == "" {
+		return token.Claims{}, fmt.Errorf("no refresh token")
+	}
+	next, s, err := a.Sessions.Refresh(r.Context(), refresh)
+	if err != nil {
+		return token.Claims{}, err
+	}
+	if claims.SessionID != "" && claims.SessionID != s.ID {
+		return token.Claims{}, fmt.Errorf("refresh token of another session")
+	}
+
+	if claims.User == nil {
+		id := make([]byte, 20)
+		if _, err = rand.Read(id); err != nil {
+			return token.Claims{}, fmt.Errorf("can't make claim's id: %w", err)
+		}
+		claims = token.Claims{
+			User:           &s.User,
+			SessionID:      s.ID,
+			StandardClaims: jwt.StandardClaims{Id: hex.EncodeToString(id), Audience: s.Audience},
+		}
+	}
+	claims.ExpiresAt = 0 // this will cause now+duration for refreshed token
+	c, err := a.JWTService.Set(w, claims)
+	if err != nil {
+		return token.Claims{}, err
+	}
+	if next != "" {
+		a.Sessions.SetToken(w, next)
+	}
+	return c, nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/auth/avatar"
+	"mkfst/auth/provider"
+	"mkfst/auth/session"
+	"mkfst/auth/token"
+	"mkfst/config"
+	"mkfst/mkfsttest"
+	"mkfst/providers/cache"
+)
+
+func TestSessions(t *testing.T) {
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	defer c.Close()
+	sessions := session.NewManager(session.Opts{Cache: c})
+	authSvc := NewService(Opts{
+		SecretReader:  token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		TokenDuration: -time.Second, // issued expired, refreshed by every request
+		DisableXSRF:   true,
+		Sessions:      sessions,
+		AvatarStore:   avatar.NewNoOp(),
+	})
+	authSvc.AddDirectProvider("local", provider.CredCheckerFunc(func(user, passwd string) (bool, error) {
+		return passwd == "pw", nil
+	}))
+
+	h := mkfsttest.New(t, config.Config{})
+	authRoute, _ := authSvc.Handlers()
+	h.Service.Route("GET", "/auth", http.StatusOK, nil, authRoute)
+	me := h.Service.Group("/me", "me", "Me")
+	mw := authSvc.Middleware()
+	me.Middleware(mw.Auth)
+	me.Route("GET", "/", http.StatusOK, nil, func(c *gin.Context) (string, error) {
+		return token.MustGetUserInfo(c.Request).Name, nil
+	})
+
+	cookies := map[string]*http.Cookie{}
+	call := func(url string) *httptest.ResponseRecorder {
+		req := newRequest("GET", url)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := h.Client().Do(req)
+		for _, c := range w.Result().Cookies() {
+			cookies[c.Name] = c
+		}
+		return w
+	}
+
+	if w := call("/auth?action=login&using=local&user=bob&passwd=pw"); w.Code != http.StatusOK || cookies["JWT-REFRESH"] == nil {
+		t.Fatalf("unexpected login: %d %s %v", w.Code, w.Body, w.Result().Cookies())
+	}
+	login := *cookies["JWT-REFRESH"]
+
+	// the expired token is refreshed with a rotated refresh token
+	if w := call("/me/"); w.Code != http.StatusOK || cookies["JWT-REFRESH"].Value == login.Value {
+		t.Fatalf("unexpected refresh: %d %s", w.Code, w.Body)
+	}
+	if w := call("/auth?action=refresh"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected refresh action: %d %s", w.Code, w.Body)
+	}
+
+	w := call("/auth?action=sessions")
+	var list struct {
+		Sessions []session.Session `json:"sessions"`
+		Current  string            `json:"current"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Sessions) != 1 || list.Sessions[0].ID != list.Current {
+		t.Fatalf("unexpected sessions: %s %v", w.Body, err)
+	}
+
+	jwtCookie := *cookies["JWT"]
+	if w := call("/auth?action=logout_all"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected logout: %d %s", w.Code, w.Body)
+	}
+	// the token of the revoked session is rejected, even if its cookie is sent again
+	cookies["JWT"] = &jwtCookie
+	if w := call("/me/"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after logging out of all devices, got %d", w.Code)
+	}
+	cookies["JWT-REFRESH"] = &login
+	if w := call("/auth?action=refresh"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 refreshing a revoked session, got %d", w.Code)
+	}
+}
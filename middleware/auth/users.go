@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/auth/token"
+	"mkfst/auth/users"
+)
+
+// claimsUpdater enriches the claims with the account of the user before the updater of the consumer
+func claimsUpdater(m *users.Manager, upd token.ClaimsUpdater) token.ClaimsUpdater {
+	if m == nil {
+		return upd
+	}
+	if upd == nil {
+		return m
+	}
+	return token.ClaimsUpdFunc(func(claims token.Claims) token.Claims {
+		return upd.Update(m.Update(claims))
+	})
+}
+
+// usersHandler handles the account and linking actions of the auth route, reporting if action is one
+func (s *AuthService) usersHandler(ctx *gin.Context, action string) (res gin.H, ok bool, err error) {
+	switch action {
+	case "account", "link_start", "link", "unlink":
+	default:
+		return nil, false, nil
+	}
+	fail := func(status int, msg string) (gin.H, bool, error) {
+		ctx.AbortWithStatus(status)
+		return gin.H{"error": msg}, true, errors.New(msg)
+	}
+	failErr := func(err error) (gin.H, bool, error) {
+		switch {
+		case errors.Is(err, users.ErrNotFound):
+			return fail(http.StatusNotFound, err.Error())
+		case errors.Is(err, users.ErrInvalidCode):
+			return fail(http.StatusUnauthorized, err.Error())
+		case errors.Is(err, users.ErrLinked), errors.Is(err, users.ErrLastIdentity), errors.Is(err, users.ErrNoLinking):
+			return fail(http.StatusBadRequest, err.Error())
+		}
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+	if s.opts.Users == nil {
+		return fail(http.StatusBadRequest, "users store not enabled")
+	}
+	m := s.opts.Users
+
+	claims, _, err := s.jwtService.Get(ctx.Request)
+	if err != nil || claims.User == nil {
+		return fail(http.StatusUnauthorized, "not logged in")
+	}
+	reqCtx := ctx.Request.Context()
+
+	switch action {
+	case "account":
+		acc, err := m.Account(reqCtx, claims.User.ID)
+		if err != nil {
+			return failErr(err)
+		}
+		list, err := m.Identities(reqCtx, acc.ID)
+		if err != nil {
+			return failErr(err)
+		}
+		return gin.H{"account": acc, "identities": list}, true, nil
+
+	case "link_start":
+		code, err := m.StartLink(reqCtx, claims.User.ID)
+		if err != nil {
+			return failErr(err)
+		}
+		return gin.H{"code": code, "expires_in": int(m.LinkTTL.Seconds())}, true, nil
+
+	case "link":
+		identity := claims.User.StrAttr(users.IdentityAttr)
+		if identity == "" {
+			return fail(http.StatusBadRequest, "token without identity")
+		}
+		acc, err := m.Link(reqCtx, ctx.Query("code"), identity)
+		if err != nil {
+			return failErr(err)
+		}
+		// log in the identity again to get a token of the account it is linked to
+		u := *claims.User
+		u.ID = identity
+		if u, err = m.Login(reqCtx, u); err != nil {
+			return failErr(err)
+		}
+		claims.User = &u
+		claims.ExpiresAt = 0 // this will cause now+duration for the token
+		if _, err = s.jwtService.Set(ctx.Writer, claims); err != nil {
+			return failErr(err)
+		}
+		return gin.H{"status": fmt.Sprintf("linked to %s", acc.ID), "user": claims.User}, true, nil
+
+	default: // unlink
+		if err := m.Unlink(reqCtx, claims.User.ID, ctx.Query("identity")); err != nil {
+			return failErr(err)
+		}
+		return gin.H{"status": "identity unlinked"}, true, nil
+	}
+}
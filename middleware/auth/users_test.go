@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	_ "modernc.org/sqlite"
+
+	"mkfst/auth/avatar"
+	"mkfst/auth/provider"
+	"mkfst/auth/token"
+	"mkfst/auth/users"
+	"mkfst/config"
+	mkfstdb "mkfst/db"
+	"mkfst/mkfsttest"
+	"mkfst/providers/cache"
+	"mkfst/providers/policy"
+)
+
+func TestUsersLinking(t *testing.T) {
+	raw, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "users.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	store, err := users.NewSQLStore(&mkfstdb.Connection{Conn: raw, Config: mkfstdb.ConnectionInfo{Type: "SQLITE"}}, users.SQLOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cache.NewMemoryCache(cache.MemoryOpts{})
+	defer c.Close()
+	accounts := users.NewManager(users.Opts{Store: store, Cache: c})
+
+	authSvc := NewService(Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		DisableXSRF:  true,
+		AvatarStore:  avatar.NewNoOp(),
+		Users:        accounts,
+	})
+	check := provider.CredCheckerFunc(func(user, passwd string) (bool, error) { return passwd == "pw", nil })
+	authSvc.AddDirectProvider("local", check)
+	authSvc.AddDirectProvider("ldap", check)
+
+	h := mkfsttest.New(t, config.Config{})
+	authRoute, _ := authSvc.Handlers()
+	h.Service.Route("GET", "/auth", http.StatusOK, nil, authRoute)
+	me := h.Service.Group("/me", "me", "Me")
+	mw := authSvc.Middleware()
+	me.Middleware(mw.Auth)
+	me.Route("GET", "/", http.StatusOK, nil, func(c *gin.Context) (string, error) {
+		s := policy.SubjectFromUser(token.MustGetUserInfo(c.Request))
+		return s.ID + " " + strings.Join(s.Roles, ","), nil
+	})
+
+	var jwtCookie *http.Cookie
+	call := func(url string) *httptest.ResponseRecorder {
+		req := newRequest("GET", url)
+		if jwtCookie != nil {
+			req.AddCookie(jwtCookie)
+		}
+		w := h.Client().Do(req)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "JWT" {
+				jwtCookie = c
+			}
+		}
+		return w
+	}
+	var body struct {
+		Code    string `json:"code"`
+		Account struct {
+			ID string `json:"id"`
+		} `json:"account"`
+		Identities []users.Identity `json:"identities"`
+	}
+	decode := func(w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call("/auth?action=login&using=local&user=bob&passwd=pw")
+	decode(call("/auth?action=account"))
+	bob := body.Account.ID
+	if !strings.HasPrefix(bob, "acct_") || len(body.Identities) != 1 || body.Identities[0].Provider != "local" {
+		t.Fatalf("unexpected account: %+v", body)
+	}
+	if err = accounts.UpdateAccount(t.Context(), bob, func(a *users.Account) error {
+		a.Roles = []string{"billing"}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	decode(call("/auth?action=link_start"))
+	code := body.Code
+	call("/auth?action=login&using=ldap&user=bob&passwd=pw")
+	decode(call("/auth?action=account"))
+	if body.Account.ID == bob {
+		t.Fatal("ldap login should have its own account before linking")
+	}
+	if w := call("/auth?action=link&code=" + code); w.Code != http.StatusOK {
+		t.Fatalf("unexpected link: %d %s", w.Code, w.Body)
+	}
+	decode(call("/auth?action=account"))
+	if body.Account.ID != bob || len(body.Identities) != 2 {
+		t.Errorf("expected both identities in %s, got %+v", bob, body)
+	}
+
+	// the stored roles are in the token, and read by the policies
+	if w := call("/me/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), bob+" billing") {
+		t.Errorf("unexpected user: %d %s", w.Code, w.Body)
+	}
+}
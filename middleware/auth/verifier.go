@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"mkfst/auth/logger"
+	"mkfst/auth/token"
+)
+
+// VerifierOpts is a set of parameters to initialize a verifier of the tokens issued by another service
+type VerifierOpts struct {
+	JWKSURL         string        // keys of the issuing service, i.e. https://auth.example.com/.well-known/jwks.json, required
+	RefreshInterval time.Duration // keys' TTL, default 1h. Keys are fetched early for tokens with an unknown kid
+	Client          *http.Client  // client fetching the keys, default one has a 10s timeout
+
+	Issuer         string          // accepted iss claim, default (empty) accepts any
+	AudienceReader token.Audience  // list of allowed aud values, default (empty) allows any
+	Validator      token.Validator // validator allows to reject some valid tokens with user-defined logic
+
+	DisableXSRF    bool   // disable XSRF protection, useful for testing/debugging
+	JWTCookieName  string // default "JWT"
+	JWTHeaderKey   string // default "X-JWT"
+	XSRFCookieName string // default "XSRF-TOKEN"
+	XSRFHeaderKey  string // default "X-XSRF-TOKEN"
+	JWTQuery       string // default "token"
+
+	Logger logger.L // logger interface, default is no logging at all
+}
+
+// NewVerifier makes an Authenticator trusting the tokens signed with the keys published at JWKSURL.
+// It accepts the users of any provider and rejects expired tokens, as only the issuer refreshes them.
+func NewVerifier(opts VerifierOpts) (Authenticator, error) {
+	if opts.JWKSURL == "" {
+		return Authenticator{}, fmt.Errorf("no JWKS url")
+	}
+
+	keys := token.NewRemoteKeySet(opts.JWKSURL)
+	if opts.RefreshInterval > 0 {
+		keys.RefreshInterval = opts.RefreshInterval
+	}
+	if opts.Client != nil {
+		keys.Client = opts.Client
+	}
+
+	res := Authenticator{
+		L: opts.Logger,
+		JWTService: token.NewService(token.Opts{
+			KeySet:         keys,
+			DisableXSRF:    opts.DisableXSRF,
+			JWTCookieName:  opts.JWTCookieName,
+			JWTHeaderKey:   opts.JWTHeaderKey,
+			XSRFCookieName: opts.XSRFCookieName,
+			XSRFHeaderKey:  opts.XSRFHeaderKey,
+			JWTQuery:       opts.JWTQuery,
+			AudienceReader: opts.AudienceReader,
+		}),
+		Validator:  opts.Validator,
+		VerifyOnly: true,
+	}
+	if res.L == nil {
+		res.L = logger.NoOp
+	}
+
+	if opts.Issuer != "" {
+		res.Validator = token.ValidatorFunc(func(tkn string, claims token.Claims) bool {
+			if claims.Issuer != opts.Issuer {
+				return false
+			}
+			return opts.Validator == nil || opts.Validator.Validate(tkn, claims)
+		})
+	}
+	return res, nil
+}
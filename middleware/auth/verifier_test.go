@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+
+	"mkfst/auth/token"
+	"mkfst/config"
+	"mkfst/mkfsttest"
+)
+
+func TestVerifier(t *testing.T) {
+	keys, err := token.NewKeyRing(token.ES256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSvc := NewService(Opts{KeySet: keys, Issuer: "users-api"})
+
+	issuer := mkfsttest.New(t, config.Config{})
+	issuer.Service.Route("GET", JWKSPath, http.StatusOK, nil, authSvc.JWKSHandler())
+	srv := httptest.NewServer(issuer.Handler())
+	defer srv.Close()
+
+	mw, err := NewVerifier(VerifierOpts{JWKSURL: srv.URL + JWKSPath, Issuer: "users-api", DisableXSRF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mkfsttest.New(t, config.Config{})
+	api := h.Service.Group("/api", "api", "API")
+	api.Middleware(mw.Auth)
+	api.Route("GET", "/me", http.StatusOK, nil, func(c *gin.Context) (string, error) {
+		u, err := token.GetUserInfo(c.Request)
+		return u.ID, err
+	})
+
+	sign := func(iss string, exp time.Time) string {
+		claims := token.Claims{
+			StandardClaims: jwt.StandardClaims{Issuer: iss, ExpiresAt: exp.Unix()},
+			User:           &token.User{ID: "github_1234", Name: "user"},
+		}
+		tkn, err := authSvc.TokenService().Token(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tkn
+	}
+	call := func(tkn string) *httptest.ResponseRecorder {
+		req := newRequest("GET", "/api/me")
+		req.Header.Set("X-JWT", tkn)
+		return h.Client().Do(req)
+	}
+
+	// users of any provider are accepted
+	if w := call(sign("users-api", time.Now().Add(time.Minute))); w.Code != http.StatusOK || w.Body.String() != `"github_1234"` {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if w := call(sign("other", time.Now().Add(time.Minute))); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for another issuer, got %d", w.Code)
+	}
+	if w := call(sign("users-api", time.Now().Add(-time.Minute))); w.Code != http.StatusUnauthorized || w.Header().Get("X-JWT") != "" {
+		t.Errorf("expected 401 for an expired token, got %d %v", w.Code, w.Header())
+	}
+
+	if _, err := NewVerifier(VerifierOpts{}); err == nil {
+		t.Error("expected an error without JWKS url")
+	}
+}
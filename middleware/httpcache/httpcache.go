@@ -0,0 +1,481 @@
+// Package httpcache caches the responses of GET routes in a
+// providers/cache Cache, with ETag, Last-Modified and 304 handling,
+// concurrent misses collapsed, and invalidation by tags.
+//
+//	responses, err := httpcache.New(httpcache.Opts{Cache: cache.NewMemoryCache(cache.MemoryOpts{})})
+//	svc.Middleware(responses)
+//	svc.Provide(responses) // for the handlers calling Invalidate
+//	protected.Middleware(mw.Auth, responses) // after the auth middleware of a group
+//
+//	svc.Route("GET", "/products/:id", 200, []fizz.OperationOption{
+//	    httpcache.Cached(httpcache.RouteOpts{TTL: time.Minute, Tags: []string{"product:{id}"}}),
+//	}, getProduct)
+//	svc.Route("PUT", "/products/:id", 200, []fizz.OperationOption{
+//	    httpcache.Invalidates("product:{id}"),
+//	}, updateProduct)
+package httpcache
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+
+	"mkfst/auth/token"
+	"mkfst/providers/cache"
+)
+
+// Opts configures New.
+type Opts struct {
+	// Cache stores the responses. Required. Share a Redis or SQL cache
+	// between the replicas.
+	Cache cache.Cache
+
+	// TTL of the responses of the GET routes without the Cached
+	// option. Default 0: only those routes are cached.
+	TTL time.Duration
+
+	// Vary are the request headers in the keys of all the routes,
+	// i.e. "Accept-Language".
+	Vary []string
+
+	// KeyPrefix is prepended to the keys of the cache. Default "httpcache|".
+	KeyPrefix string
+
+	// UserID returns the id of the user of the request, for the routes
+	// cached PerUser. Default is the user of the auth middleware.
+	UserID func(c *gin.Context) string
+
+	// Skip bypasses the cache for the requests it returns true for,
+	// such as streamed responses: the responses are buffered.
+	Skip func(c *gin.Context) bool
+
+	// Now overrides time.Now for tests. Production code leaves nil.
+	Now func() time.Time
+}
+
+// Cache is the response caching middleware: register it with the
+// Middleware of a service or group, after the auth middleware of the
+// routes it caches: a hit is served without calling the next ones.
+type Cache struct {
+	opts  Opts
+	group singleflight.Group
+}
+
+// New returns the Cache of opts.
+func New(opts Opts) (*Cache, error) {
+	if opts.Cache == nil {
+		return nil, errors.New("httpcache.New: Cache is required")
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = "httpcache|"
+	}
+	if opts.UserID == nil {
+		opts.UserID = func(c *gin.Context) string {
+			u, err := token.GetUserInfo(c.Request)
+			if err != nil {
+				return ""
+			}
+			return u.ID
+		}
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	opts.Vary = normalize(opts.Vary)
+	return &Cache{opts: opts}, nil
+}
+
+// entry is a stored response
+type entry struct {
+	Status int               `json:"status"`
+	Header http.Header       `json:"header"`
+	Body   []byte            `json:"body"`
+	Stored time.Time         `json:"stored"`
+	Tags   map[string]string `json:"tags,omitempty"` // versions of the tags when stored
+}
+
+// keySpec is what keys the responses of a route, stored for the lookups
+type keySpec struct {
+	Query    []string `json:"query,omitempty"`
+	AllQuery bool     `json:"all_query,omitempty"`
+	Vary     []string `json:"vary,omitempty"`
+	PerUser  bool     `json:"per_user,omitempty"`
+}
+
+// response is the response of the handlers of a request
+type response struct {
+	entry  *entry
+	spec   keySpec
+	key    string
+	stored bool
+}
+
+// handledKey marks the requests handled by a Cache, registered once per route
+const handledKey = "mkfst-httpcache"
+
+// HandlerFunc returns the middleware function, see router.MiddlewareHandler.
+func (h *Cache) HandlerFunc() interface{} {
+	return func(c *gin.Context, db *sql.DB) (any, error) {
+		if _, handled := c.Get(handledKey); handled { // by the Cache of the service, before a group one
+			c.Next()
+			return nil, nil
+		}
+		c.Set(handledKey, h)
+		if c.Request.Method != http.MethodGet {
+			errs := len(c.Errors)
+			c.Next()
+			if status := c.Writer.Status(); status >= 200 && status < 300 && len(c.Errors) == errs {
+				if err := h.Invalidate(c.Request.Context(), invalidatedTags(c)...); err != nil {
+					_ = c.Error(fmt.Errorf("httpcache: %w", err))
+				}
+			}
+			return nil, nil
+		}
+		reqCC := cacheControl(c.Request.Header)
+		if _, noStore := reqCC["no-store"]; noStore || c.FullPath() == "" || (h.opts.Skip != nil && h.opts.Skip(c)) {
+			c.Next()
+			return nil, nil
+		}
+		ctx := c.Request.Context()
+		route := h.opts.KeyPrefix + "route|" + c.Request.Method + " " + c.FullPath()
+
+		spec, known := h.spec(ctx, route)
+		key := h.key(c, spec)
+		if _, noCache := reqCC["no-cache"]; known && !noCache && c.GetHeader("Pragma") != "no-cache" {
+			if e, ok := h.lookup(ctx, key, reqCC); ok {
+				h.serve(c, e, "HIT")
+				c.Abort()
+				return nil, nil
+			}
+		}
+
+		leader := false
+		v, _, _ := h.group.Do(key, func() (interface{}, error) {
+			leader = true
+			return h.fill(c, route), nil
+		})
+		f := v.(*response)
+		if !leader {
+			// the response of another request, if it was stored under the key of this one
+			if !f.stored || h.key(c, f.spec) != f.key {
+				f = h.fill(c, route)
+			} else {
+				c.Abort()
+			}
+		}
+		xcache := ""
+		if f.stored {
+			xcache = "MISS"
+		}
+		h.serve(c, f.entry, xcache)
+		return nil, nil
+	}
+}
+
+// fill runs the handlers of the request, buffering the response, and stores it if cacheable
+func (h *Cache) fill(c *gin.Context, route string) *response {
+	before := c.Writer.Header().Clone()
+	errs := len(c.Errors)
+	user := h.opts.UserID(c)
+	w := &bufferWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	now := h.opts.Now()
+	e := &entry{Status: w.Status(), Header: http.Header{}, Body: w.buf.Bytes(), Stored: now}
+	for k, v := range c.Writer.Header() {
+		if !slices.Equal(before[k], v) {
+			e.Header[k] = slices.Clone(v)
+		}
+	}
+	f := &response{entry: e}
+	if e.Status != http.StatusOK {
+		return f
+	}
+	if e.Header.Get("ETag") == "" {
+		sum := sha256.Sum256(e.Body)
+		e.Header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	if e.Header.Get("Last-Modified") == "" {
+		e.Header.Set("Last-Modified", now.UTC().Format(http.TimeFormat))
+	}
+
+	ro := routeOpts(c)
+	ttl := h.ttl(ro, e.Header)
+	if ttl <= 0 || len(c.Errors) > errs || e.Header.Get("Set-Cookie") != "" {
+		return f
+	}
+	f.spec = keySpec{AllQuery: true, Vary: slices.Clone(h.opts.Vary)}
+	var tags []string
+	if ro != nil {
+		if ro.IgnoreQuery || ro.Query != nil {
+			f.spec.AllQuery, f.spec.Query = false, ro.Query
+		}
+		f.spec.PerUser = ro.PerUser
+		f.spec.Vary = append(f.spec.Vary, ro.Vary...)
+		tags = expandTags(c, ro.Tags)
+	}
+	if f.spec.PerUser && h.opts.UserID(c) != user {
+		// the user was authenticated after the lookup: the cache runs before the auth middleware
+		return f
+	}
+	if !f.spec.PerUser && (c.GetHeader("Authorization") != "" || h.opts.UserID(c) != "") {
+		// the response to an authenticated request is private unless public, RFC 9111 section 3.5
+		if _, public := cacheControl(e.Header)["public"]; !public {
+			return f
+		}
+	}
+	for _, v := range e.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return f
+			} else if name != "" {
+				f.spec.Vary = append(f.spec.Vary, name)
+			}
+		}
+	}
+	f.spec.Vary = normalize(f.spec.Vary)
+	f.key = h.key(c, f.spec)
+
+	ctx := c.Request.Context()
+	if err := h.store(ctx, route, f, tags, ttl); err != nil {
+		_ = c.Error(fmt.Errorf("httpcache: %w", err))
+		return f
+	}
+	f.stored = true
+	return f
+}
+
+// store sets the entry of f, the versions of its tags and the key spec of the route
+func (h *Cache) store(ctx context.Context, route string, f *response, tags []string, ttl time.Duration) error {
+	if len(tags) > 0 {
+		f.entry.Tags = make(map[string]string, len(tags))
+		for _, tag := range tags {
+			version, ok, err := h.opts.Cache.Get(ctx, h.tagKey(tag))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				if version, err = h.newTagVersion(ctx, tag); err != nil {
+					return err
+				}
+			}
+			f.entry.Tags[tag] = string(version)
+		}
+	}
+	b, err := json.Marshal(f.entry)
+	if err != nil {
+		return err
+	}
+	if err = h.opts.Cache.Set(ctx, f.key, b, ttl); err != nil {
+		return err
+	}
+	spec, err := json.Marshal(f.spec)
+	if err != nil {
+		return err
+	}
+	if old, ok, err := h.opts.Cache.Get(ctx, route); err == nil && ok && string(old) == string(spec) {
+		return nil
+	}
+	return h.opts.Cache.Set(ctx, route, spec, 0)
+}
+
+// spec returns the key spec of the route, reporting if it is known
+func (h *Cache) spec(ctx context.Context, route string) (keySpec, bool) {
+	var spec keySpec
+	b, ok, err := h.opts.Cache.Get(ctx, route)
+	if err != nil || !ok || json.Unmarshal(b, &spec) != nil {
+		return keySpec{AllQuery: true, Vary: h.opts.Vary}, false
+	}
+	return spec, true
+}
+
+// key returns the key of the response of the request under spec
+func (h *Cache) key(c *gin.Context, spec keySpec) string {
+	var b strings.Builder
+	b.WriteString(c.Request.Method + "\n" + c.Request.URL.Path + "\n")
+	q := c.Request.URL.Query()
+	if !spec.AllQuery {
+		selected := url.Values{}
+		for _, name := range spec.Query {
+			if v, ok := q[name]; ok {
+				selected[name] = v
+			}
+		}
+		q = selected
+	}
+	b.WriteString(q.Encode() + "\n")
+	for _, name := range spec.Vary {
+		b.WriteString(name + ": " + strings.Join(c.Request.Header.Values(name), ",") + "\n")
+	}
+	if spec.PerUser {
+		b.WriteString("user: " + h.opts.UserID(c))
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return h.opts.KeyPrefix + "resp|" + hex.EncodeToString(sum[:])
+}
+
+// lookup returns the fresh entry of key, acceptable under the request Cache-Control reqCC
+func (h *Cache) lookup(ctx context.Context, key string, reqCC map[string]string) (*entry, bool) {
+	b, ok, err := h.opts.Cache.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false
+	}
+	e := &entry{}
+	if err = json.Unmarshal(b, e); err != nil {
+		return nil, false
+	}
+	if v, ok := reqCC["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(v); err == nil && h.opts.Now().Sub(e.Stored) > time.Duration(maxAge)*time.Second {
+			return nil, false
+		}
+	}
+	for tag, version := range e.Tags {
+		current, ok, err := h.opts.Cache.Get(ctx, h.tagKey(tag))
+		if err != nil || !ok || string(current) != version {
+			return nil, false
+		}
+	}
+	return e, true
+}
+
+// serve writes the entry e, or a 304 if the request is conditioned on it
+func (h *Cache) serve(c *gin.Context, e *entry, xcache string) {
+	header := c.Writer.Header()
+	for k, v := range e.Header {
+		if _, ok := header[k]; !ok {
+			header[k] = v
+		}
+	}
+	if xcache != "" {
+		header.Set("X-Cache", xcache)
+	}
+	if xcache == "HIT" {
+		header.Set("Age", strconv.FormatInt(int64(h.opts.Now().Sub(e.Stored)/time.Second), 10))
+	}
+	if e.Status == http.StatusOK && notModified(c.Request, e.Header) {
+		header.Del("Content-Length")
+		c.Writer.WriteHeader(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Writer.WriteHeader(e.Status)
+	_, _ = c.Writer.Write(e.Body)
+}
+
+// Invalidate invalidates the responses stored with any of the tags.
+func (h *Cache) Invalidate(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		if _, err := h.newTagVersion(ctx, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge deletes all the stored responses.
+func (h *Cache) Purge(ctx context.Context) error {
+	_, err := h.opts.Cache.DeletePrefix(ctx, h.opts.KeyPrefix)
+	return err
+}
+
+func (h *Cache) tagKey(tag string) string { return h.opts.KeyPrefix + "tag|" + tag }
+
+// newTagVersion sets a new version of the tag, making stale the entries stored with the previous one
+func (h *Cache) newTagVersion(ctx context.Context, tag string) ([]byte, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	version := []byte(hex.EncodeToString(b))
+	return version, h.opts.Cache.Set(ctx, h.tagKey(tag), version, 0)
+}
+
+// ttl returns how long to store a response with header, 0 if it must not be
+func (h *Cache) ttl(ro *RouteOpts, header http.Header) time.Duration {
+	ttl := h.opts.TTL
+	if ro != nil && ro.TTL != 0 {
+		ttl = ro.TTL
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	cc := cacheControl(header)
+	if _, ok := cc["no-store"]; ok {
+		return 0
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	if _, ok := cc["private"]; ok && (ro == nil || !ro.PerUser) {
+		return 0
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			if seconds, err := strconv.Atoi(v); err == nil {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return ttl
+}
+
+// cacheControl returns the directives of the Cache-Control of header
+func cacheControl(header http.Header) map[string]string {
+	res := map[string]string{}
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				res[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return res
+}
+
+// notModified reports if the conditional request r matches the response with header
+func notModified(r *http.Request, header http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
+
+// normalize returns the canonical header names, sorted and deduplicated
+func normalize(names []string) []string {
+	res := make([]string, 0, len(names))
+	for _, n := range names {
+		res = append(res, http.CanonicalHeaderKey(n))
+	}
+	sort.Strings(res)
+	return slices.Compact(res)
+}
@@ -0,0 +1,209 @@
+package httpcache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/auth/token"
+	"mkfst/config"
+	"mkfst/fizz"
+	"mkfst/middleware/auth"
+	"mkfst/mkfsttest"
+	"mkfst/providers/cache"
+)
+
+type product struct {
+	ID    string `json:"id"`
+	Calls int64  `json:"calls"`
+}
+
+func newTestService(t *testing.T) (*mkfsttest.Harness, *atomic.Int64, chan struct{}) {
+	responses, err := New(Opts{Cache: cache.NewMemoryCache(cache.MemoryOpts{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int64
+	gate := make(chan struct{}, 1)
+	gate <- struct{}{}
+
+	h := mkfsttest.New(t, config.Config{})
+	h.Service.Middleware(responses)
+	h.Service.Route("GET", "/products/:id", http.StatusOK, []fizz.OperationOption{
+		Cached(RouteOpts{TTL: time.Minute, Query: []string{"fields"}, Tags: []string{"product:{id}"}}),
+	}, func(c *gin.Context, _ *sql.DB) (*product, error) {
+		n := calls.Add(1)
+		if c.Query("slow") != "" {
+			<-gate
+		}
+		if c.Query("private") != "" {
+			c.Header("Cache-Control", "private")
+		}
+		return &product{ID: c.Param("id"), Calls: n}, nil
+	})
+	h.Service.Route("PUT", "/products/:id", http.StatusOK, []fizz.OperationOption{
+		Invalidates("product:{id}"),
+	}, func(c *gin.Context, _ *sql.DB) (*product, error) {
+		return &product{ID: c.Param("id")}, nil
+	})
+	h.Service.Route("GET", "/uncached", http.StatusOK, nil, func(c *gin.Context, _ *sql.DB) (*product, error) {
+		return &product{Calls: calls.Add(1)}, nil
+	})
+	return h, &calls, gate
+}
+
+func do(h *mkfsttest.Harness, method, path string, header ...string) *http.Response {
+	req, _ := http.NewRequest(method, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return h.Client().Do(req).Result()
+}
+
+func TestResponseCache(t *testing.T) {
+	h, calls, _ := newTestService(t)
+
+	res := do(h, "GET", "/products/p1")
+	etag := res.Header.Get("ETag")
+	if res.StatusCode != http.StatusOK || res.Header.Get("X-Cache") != "MISS" || etag == "" || res.Header.Get("Last-Modified") == "" {
+		t.Fatalf("unexpected first response %d %v", res.StatusCode, res.Header)
+	}
+	res = do(h, "GET", "/products/p1?utm=x")
+	if res.StatusCode != http.StatusOK || res.Header.Get("X-Cache") != "HIT" || res.Header.Get("ETag") != etag || calls.Load() != 1 {
+		t.Fatalf("expected a hit ignoring the unselected params, got %d %v", res.StatusCode, res.Header)
+	}
+	if res = do(h, "GET", "/products/p1?fields=id"); res.Header.Get("X-Cache") != "MISS" || calls.Load() != 2 {
+		t.Errorf("expected the selected params in the key, got %v", res.Header)
+	}
+
+	res = do(h, "GET", "/products/p1", "If-None-Match", `"other", `+etag)
+	if res.StatusCode != http.StatusNotModified || res.ContentLength > 0 {
+		t.Errorf("expected 304, got %d", res.StatusCode)
+	}
+	res = do(h, "GET", "/products/p1", "If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for If-Modified-Since, got %d", res.StatusCode)
+	}
+
+	if res = do(h, "GET", "/products/p1", "Cache-Control", "no-cache"); res.Header.Get("X-Cache") != "MISS" || calls.Load() != 3 {
+		t.Errorf("expected no-cache to revalidate, got %v", res.Header)
+	}
+
+	if res = do(h, "PUT", "/products/p1"); res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected update %d", res.StatusCode)
+	}
+	if res = do(h, "GET", "/products/p1"); res.Header.Get("X-Cache") != "MISS" || calls.Load() != 4 {
+		t.Errorf("expected the tag invalidated, got %v", res.Header)
+	}
+
+	for i := 0; i < 2; i++ {
+		if res = do(h, "GET", "/products/p2?private=1"); res.Header.Get("X-Cache") != "" {
+			t.Errorf("expected a private response not stored, got %v", res.Header)
+		}
+	}
+	if calls.Load() != 6 {
+		t.Errorf("expected the private responses computed, got %d calls", calls.Load())
+	}
+	for i := 0; i < 2; i++ {
+		if res = do(h, "GET", "/uncached"); res.Header.Get("X-Cache") != "" || res.Header.Get("ETag") == "" {
+			t.Errorf("expected the route without TTL not stored, got %v", res.Header)
+		}
+	}
+
+	op := h.Spec().Paths["/products/{id}"].GET
+	if _, ok := op.Responses["304"]; !ok || op.Responses["200"].Response.Headers["ETag"] == nil {
+		t.Errorf("expected the conditional responses documented, got %+v", op.Responses)
+	}
+}
+
+func TestResponseCacheCollapsesMisses(t *testing.T) {
+	h, calls, gate := newTestService(t)
+	h.Handler() // builds the service before the concurrent requests
+	<-gate      // the first request blocks until released
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- do(h, "GET", "/products/p1?slow=1").StatusCode
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	gate <- struct{}{}
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the handler called once, got %d", n)
+	}
+}
+
+func TestResponseCacheAuth(t *testing.T) {
+	responses, err := New(Opts{Cache: cache.NewMemoryCache(cache.MemoryOpts{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := auth.NewService(auth.Opts{BasicAuthChecker: func(user, passwd string) (bool, token.User, error) {
+		return passwd == "pw", token.User{ID: "basic_" + user, Name: user}, nil
+	}}).Middleware()
+	var calls atomic.Int64
+	get := func(c *gin.Context, _ *sql.DB) (*product, error) {
+		u, _ := token.GetUserInfo(c.Request)
+		return &product{ID: u.Name, Calls: calls.Add(1)}, nil
+	}
+
+	h := mkfsttest.New(t, config.Config{})
+	before := h.Service.Group("/before", "before", "Cache before auth")
+	before.Middleware(responses, mw.Auth)
+	before.Route("GET", "/shared", http.StatusOK, []fizz.OperationOption{Cached(RouteOpts{TTL: time.Minute})}, get)
+	before.Route("GET", "/mine", http.StatusOK, []fizz.OperationOption{Cached(RouteOpts{TTL: time.Minute, PerUser: true})}, get)
+	after := h.Service.Group("/after", "after", "Cache after auth")
+	after.Middleware(mw.Auth, responses)
+	after.Route("GET", "/mine", http.StatusOK, []fizz.OperationOption{Cached(RouteOpts{TTL: time.Minute, PerUser: true})}, get)
+
+	basic := func(user string) string {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(user, "pw")
+		return req.Header.Get("Authorization")
+	}
+
+	for _, path := range []string{"/before/shared", "/before/mine"} {
+		if res := do(h, "GET", path, "Authorization", basic("bob")); res.StatusCode != http.StatusOK || res.Header.Get("X-Cache") != "" {
+			t.Errorf("expected %s not stored before the auth, got %d %v", path, res.StatusCode, res.Header)
+		}
+		if res := do(h, "GET", path); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected %s rejected without credentials, got %d %v", path, res.StatusCode, res.Header)
+		}
+	}
+
+	calls.Store(0)
+	if res := do(h, "GET", "/after/mine", "Authorization", basic("bob")); res.Header.Get("X-Cache") != "MISS" {
+		t.Errorf("expected a miss, got %d %v", res.StatusCode, res.Header)
+	}
+	if res := do(h, "GET", "/after/mine", "Authorization", basic("bob")); res.Header.Get("X-Cache") != "HIT" || calls.Load() != 1 {
+		t.Errorf("expected a hit of the user, got %d %v", res.StatusCode, res.Header)
+	}
+	res := do(h, "GET", "/after/mine", "Authorization", basic("alice"))
+	var p product
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil || res.Header.Get("X-Cache") != "MISS" || p.ID != "alice" {
+		t.Errorf("expected the response of another user, got %v %+v", res.Header, p)
+	}
+	if res = do(h, "GET", "/after/mine"); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials, got %d %v", res.StatusCode, res.Header)
+	}
+}
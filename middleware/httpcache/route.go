@@ -0,0 +1,105 @@
+package httpcache
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/fizz"
+	"mkfst/fizz/openapi"
+)
+
+type routeKey struct{}
+
+type invalidatesKey struct{}
+
+// RouteOpts configures the caching of a route, see Cached.
+type RouteOpts struct {
+	// TTL of the responses, default Opts.TTL. The max-age or s-maxage
+	// of the Cache-Control of a response overrides it. A negative TTL
+	// disables the caching of the route.
+	TTL time.Duration
+
+	// Query are the query params in the key, default all of them.
+	// IgnoreQuery keys the responses by path only.
+	Query       []string
+	IgnoreQuery bool
+
+	// Vary are the request headers in the key, in addition to
+	// Opts.Vary and the Vary header of the response.
+	Vary []string
+
+	// PerUser keys the responses by user, as returned by Opts.UserID.
+	// Required to cache the responses with Cache-Control: private, and
+	// the ones to authenticated requests unless Cache-Control: public.
+	// The Cache must run after the auth middleware.
+	PerUser bool
+
+	// Tags of the responses, invalidated together with Invalidate or
+	// the Invalidates option. A "{param}" is replaced by the path
+	// param of the request: "product:{id}".
+	Tags []string
+}
+
+// Cached declares the caching of a GET route, and documents its
+// conditional responses:
+//
+//	svc.Route("GET", "/products/:id", 200, []fizz.OperationOption{
+//	    httpcache.Cached(httpcache.RouteOpts{TTL: 5 * time.Minute, Tags: []string{"products", "product:{id}"}}),
+//	}, getProduct)
+func Cached(opts RouteOpts) fizz.OperationOption {
+	return func(o *openapi.OperationInfo) {
+		fizz.WithValue(routeKey{}, &opts)(o)
+		if opts.TTL < 0 {
+			return
+		}
+		fizz.DefaultHeader("ETag", "Entity tag of the response, for If-None-Match", "")(o)
+		fizz.DefaultHeader("Last-Modified", "Date of the response, for If-Modified-Since", "")(o)
+		fizz.DefaultHeader("X-Cache", "HIT when served from the cache, MISS when stored", "")(o)
+		fizz.DefaultResponse("304", "Not modified since the ETag of If-None-Match or the date of If-Modified-Since", nil, []*openapi.ResponseHeader{
+			{Name: "ETag", Description: "Entity tag of the response", Model: ""},
+		})(o)
+	}
+}
+
+// Invalidates declares the tags invalidated by the successful
+// responses of a route, i.e. a write handler. A "{param}" is
+// replaced by the path param of the request.
+//
+//	svc.Route("PUT", "/products/:id", 200, []fizz.OperationOption{
+//	    httpcache.Invalidates("products", "product:{id}"),
+//	}, updateProduct)
+func Invalidates(tags ...string) fizz.OperationOption {
+	return fizz.WithValue(invalidatesKey{}, tags)
+}
+
+// routeOpts returns the RouteOpts of the route of the request, nil if
+// none; its handler must have run
+func routeOpts(c *gin.Context) *RouteOpts {
+	if v, ok := fizz.OperationValue(c, routeKey{}); ok {
+		return v.(*RouteOpts)
+	}
+	return nil
+}
+
+// expandTags replaces the "{param}" of tags by the path params of the request
+func expandTags(c *gin.Context, tags []string) []string {
+	res := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		for _, p := range c.Params {
+			tag = strings.ReplaceAll(tag, "{"+p.Key+"}", p.Value)
+		}
+		res = append(res, tag)
+	}
+	return res
+}
+
+// invalidatedTags returns the tags invalidated by the route of the
+// request; its handler must have run
+func invalidatedTags(c *gin.Context) []string {
+	if v, ok := fizz.OperationValue(c, invalidatesKey{}); ok {
+		return expandTags(c, v.([]string))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bufferWriter buffers the response of the handlers, written by the
+// middleware once its ETag is known. The headers are the ones of the
+// underlying writer.
+type bufferWriter struct {
+	gin.ResponseWriter
+	buf     bytes.Buffer
+	code    int
+	written bool
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.code = code
+	}
+}
+
+func (w *bufferWriter) WriteHeaderNow() { w.written = true }
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.buf.Write(b)
+}
+
+func (w *bufferWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.buf.WriteString(s)
+}
+
+func (w *bufferWriter) Status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *bufferWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.buf.Len()
+}
+
+func (w *bufferWriter) Written() bool { return w.written }
+
+// Flush is a no-op: the response is written once complete.
+func (w *bufferWriter) Flush() {}
@@ -1 +1,280 @@
+// Package logging provides structured logging on log/slog: an access log
+// middleware, and the logger of each request, carrying its request id and
+// trace, for the handlers.
+//
+//	svc.Middleware(opentel.RequestTracing("api"), logging.AccessLog(logging.Opts{Logger: logger}))
+//	svc.ProvideFunc(logging.Logger)
+//
+//	func(c *gin.Context, log *slog.Logger, in *Input) (*Output, error) {
+//	    log.Info("order created", "order", id) // with request_id, trace_id and span_id
+//	}
 package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log/slog"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"mkfst/auth/token"
+)
+
+// Redacted replaces the values of the redacted headers and query params.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactHeaders are the headers always redacted.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key", "X-JWT", "X-XSRF-Token"}
+
+// DefaultRedactQuery are the query params always redacted.
+var DefaultRedactQuery = []string{"token", "access_token", "refresh_token", "id_token", "api_key", "password", "passwd", "secret", "code"}
+
+// Opts configures AccessLog.
+type Opts struct {
+	// Logger writes the access logs, and is the parent of the loggers
+	// of the requests. Default slog.Default().
+	Logger *slog.Logger
+
+	// Level of the access logs. The 4xx responses are logged at Warn
+	// and the 5xx at Error, unless Level is higher. Default Info.
+	Level slog.Level
+
+	// SampleRate is the fraction of the requests logged, between 0
+	// and 1; the 4xx and 5xx responses are always logged. 0 logs all.
+	SampleRate float64
+
+	// SkipPaths are not logged, i.e. "/healthz". An entry matches the
+	// path or the route template of the request; one ending with "*"
+	// matches its prefix.
+	SkipPaths []string
+
+	// Headers are the request headers logged, "*" for all.
+	Headers []string
+
+	// RedactHeaders and RedactQuery are logged as Redacted, in
+	// addition to DefaultRedactHeaders and DefaultRedactQuery.
+	RedactHeaders []string
+	RedactQuery   []string
+
+	// RequestIDHeader carries the request id. A valid id of the
+	// request is kept, else one is generated; it is set on the
+	// response. Default "X-Request-ID".
+	RequestIDHeader string
+
+	// UserID returns the id of the user of the request. Default is
+	// the user of the auth middleware.
+	UserID func(c *gin.Context) string
+}
+
+type contextKey struct{}
+
+const requestIDKey = "mkfst-request-id"
+
+// AccessLog returns the middleware logging a line for each request, with
+// its method, route, status, latency, size, request id, user and trace.
+// Register it after opentel.RequestTracing, so the lines have the span
+// of the request.
+func AccessLog(opts Opts) interface{} {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.RequestIDHeader == "" {
+		opts.RequestIDHeader = "X-Request-ID"
+	}
+	if opts.UserID == nil {
+		opts.UserID = func(c *gin.Context) string {
+			u, err := token.GetUserInfo(c.Request)
+			if err != nil {
+				return ""
+			}
+			return u.ID
+		}
+	}
+	redactHeaders := map[string]bool{}
+	for _, h := range append(append([]string(nil), DefaultRedactHeaders...), opts.RedactHeaders...) {
+		redactHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	redactQuery := map[string]bool{}
+	for _, q := range append(append([]string(nil), DefaultRedactQuery...), opts.RedactQuery...) {
+		redactQuery[strings.ToLower(q)] = true
+	}
+
+	return func(c *gin.Context, db *sql.DB) (any, error) {
+		start := time.Now()
+		id := c.GetHeader(opts.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(opts.RequestIDHeader, id)
+
+		span := oteltrace.SpanContextFromContext(c.Request.Context())
+		reqLogger := opts.Logger.With(slog.String("request_id", id))
+		if span.IsValid() {
+			reqLogger = reqLogger.With(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+		}
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), reqLogger))
+
+		c.Next()
+
+		route, status := c.FullPath(), c.Writer.Status()
+		if skip(opts.SkipPaths, c.Request.URL.Path, route) {
+			return nil, nil
+		}
+		if status < http.StatusBadRequest && opts.SampleRate > 0 && opts.SampleRate < 1 && mrand.Float64() >= opts.SampleRate { //nolint:gosec // sampling
+			return nil, nil
+		}
+		level := opts.Level
+		switch {
+		case status >= http.StatusInternalServerError && level < slog.LevelError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest && status < http.StatusInternalServerError && level < slog.LevelWarn:
+			level = slog.LevelWarn
+		}
+		ctx := c.Request.Context()
+		if !opts.Logger.Enabled(ctx, level) {
+			return nil, nil
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.String("path", c.Request.URL.Path),
+		}
+		if c.Request.URL.RawQuery != "" {
+			attrs = append(attrs, slog.String("query", redactedQuery(c.Request.URL.Query(), redactQuery)))
+		}
+		attrs = append(attrs,
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("ip", c.ClientIP()),
+			slog.String("request_id", id),
+		)
+		if user := opts.UserID(c); user != "" {
+			attrs = append(attrs, slog.String("user_id", user))
+		}
+		if !span.IsValid() { // the span may be started by an inner middleware
+			span = oteltrace.SpanContextFromContext(ctx)
+		}
+		if span.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+		}
+		if headers := loggedHeaders(c.Request.Header, opts.Headers, redactHeaders); len(headers) > 0 {
+			attrs = append(attrs, slog.Attr{Key: "headers", Value: slog.GroupValue(headers...)})
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		opts.Logger.LogAttrs(ctx, level, "http request", attrs...)
+		return nil, nil
+	}
+}
+
+// NewContext returns a copy of ctx carrying the logger l.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of ctx, or slog.Default() if none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// Logger returns the logger of the request, set by AccessLog. Register it
+// with ProvideFunc to inject the logger into the handlers asking for a
+// *slog.Logger.
+func Logger(c *gin.Context) *slog.Logger {
+	return FromContext(c.Request.Context())
+}
+
+// RequestID returns the request id set by AccessLog, or "" if none.
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// skip reports if the path or route matches an entry of paths
+func skip(paths []string, path, route string) bool {
+	for _, p := range paths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) || (route != "" && strings.HasPrefix(route, prefix)) {
+				return true
+			}
+			continue
+		}
+		if p == path || p == route {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedQuery encodes the query with the values of the redacted params replaced
+func redactedQuery(q url.Values, redact map[string]bool) string {
+	for k, values := range q {
+		if redact[strings.ToLower(k)] {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return q.Encode()
+}
+
+// loggedHeaders returns the attributes of the logged headers, redacted
+func loggedHeaders(h http.Header, names []string, redact map[string]bool) []slog.Attr {
+	if len(names) == 0 {
+		return nil
+	}
+	if len(names) == 1 && names[0] == "*" {
+		names = make([]string, 0, len(h))
+		for k := range h {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	}
+	var attrs []slog.Attr
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		value := h.Get(name)
+		if value == "" {
+			continue
+		}
+		if redact[name] {
+			value = Redacted
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return attrs
+}
+
+// validRequestID reports if id of the request is safe to keep and log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
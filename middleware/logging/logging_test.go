@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/config"
+	"mkfst/mkfsttest"
+)
+
+// syncBuffer is a buffer written by the handlers of concurrent requests
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the JSON log lines
+func (b *syncBuffer) lines(t *testing.T) []map[string]any {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var res []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("invalid log line %s: %v", line, err)
+		}
+		res = append(res, m)
+	}
+	b.buf.Reset()
+	return res
+}
+
+type order struct {
+	ID string `json:"id"`
+}
+
+func newTestService(t *testing.T, opts Opts) (*mkfsttest.Harness, *syncBuffer) {
+	out := &syncBuffer{}
+	opts.Logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h := mkfsttest.New(t, config.Config{})
+	h.Service.Middleware(AccessLog(opts))
+	h.Service.ProvideFunc(Logger)
+	h.Service.Route("GET", "/orders/:id", http.StatusOK, nil, func(c *gin.Context, log *slog.Logger) (*order, error) {
+		log.Info("loading order", "order", c.Param("id"))
+		if c.Param("id") == "broken" {
+			return nil, errors.New("boom")
+		}
+		return &order{ID: c.Param("id")}, nil
+	})
+	h.Service.Route("GET", "/healthz", http.StatusOK, nil, func(c *gin.Context, _ *sql.DB) (*order, error) {
+		return &order{}, nil
+	})
+	return h, out
+}
+
+func TestAccessLog(t *testing.T) {
+	h, out := newTestService(t, Opts{SkipPaths: []string{"/healthz"}, Headers: []string{"Authorization", "User-Agent"}})
+
+	req, _ := http.NewRequest("GET", "/orders/o1?page=2&token=secret", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("User-Agent", "test")
+	w := h.Client().Do(req)
+	if w.Code != http.StatusOK || w.Header().Get("X-Request-ID") != "req-1" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	lines := out.lines(t)
+	if len(lines) != 2 {
+		t.Fatalf("expected the handler and access lines, got %v", lines)
+	}
+	if l := lines[0]; l["msg"] != "loading order" || l["request_id"] != "req-1" || l["order"] != "o1" {
+		t.Errorf("unexpected handler line %v", l)
+	}
+	l := lines[1]
+	headers, _ := l["headers"].(map[string]any)
+	if l["msg"] != "http request" || l["level"] != "INFO" || l["method"] != "GET" || l["route"] != "/orders/:id" ||
+		l["path"] != "/orders/o1" || l["status"] != float64(200) || l["request_id"] != "req-1" || l["bytes"] != float64(w.Body.Len()) ||
+		l["query"] != "page=2&token=%5BREDACTED%5D" || headers["Authorization"] != Redacted || headers["User-Agent"] != "test" {
+		t.Errorf("unexpected access line %v", l)
+	}
+	if _, ok := l["latency"]; !ok {
+		t.Error("expected the latency")
+	}
+
+	w = h.Client().Do(get("/healthz"))
+	if lines = out.lines(t); w.Code != http.StatusOK || len(lines) != 0 {
+		t.Errorf("expected no line for a skipped path, got %v", lines)
+	}
+
+	req, _ = http.NewRequest("GET", "/orders/o2", nil)
+	req.Header.Set("X-Request-ID", "bad id\n")
+	w = h.Client().Do(req)
+	lines = out.lines(t)
+	if id := w.Header().Get("X-Request-ID"); len(id) != 32 || len(lines) != 2 || lines[1]["request_id"] != id {
+		t.Errorf("expected a generated request id, got %q, %v", id, lines)
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	h, out := newTestService(t, Opts{SampleRate: 1e-9})
+	for i := 0; i < 10; i++ {
+		h.Client().Do(get("/orders/o1"))
+	}
+	lines := out.lines(t)
+	if len(lines) != 10 {
+		t.Fatalf("expected only the handler lines, got %d", len(lines))
+	}
+
+	h.Client().Do(get("/orders/broken"))
+	lines = out.lines(t)
+	if len(lines) != 2 || lines[1]["level"] != "WARN" || lines[1]["status"] != float64(400) || lines[1]["errors"] == nil {
+		t.Errorf("expected the error logged, got %v", lines)
+	}
+}
+
+func get(path string) *http.Request {
+	req, _ := http.NewRequest("GET", path, nil)
+	return req
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Algorithm decides whether a request is within the quota of its key,
+// from the state kept in the Store.
+type Algorithm int
+
+const (
+	// TokenBucket refills Quota.Limit tokens per Quota.Window up to
+	// Quota.Burst, and takes one per request: it allows bursts after
+	// idle periods, and smooths the rate over time.
+	TokenBucket Algorithm = iota
+
+	// SlidingWindow counts the requests of the current and previous
+	// fixed windows, weighting the previous one by its overlap with
+	// the window ending now: it allows at most about Quota.Limit
+	// requests in any Quota.Window.
+	SlidingWindow
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case TokenBucket:
+		return "token bucket"
+	case SlidingWindow:
+		return "sliding window"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is the decision for a request.
+type Result struct {
+	Allowed    bool
+	Limit      int           // requests allowed per window
+	Remaining  int           // requests left in the window
+	Reset      time.Duration // until the quota is fully available again
+	RetryAfter time.Duration // until the next request is allowed, if not Allowed
+}
+
+// ttl returns how long the state of a key must be kept under q
+func (a Algorithm) ttl(q Quota) time.Duration {
+	if a == SlidingWindow {
+		return 2 * q.Window
+	}
+	return time.Duration(float64(q.burst()) / q.rate())
+}
+
+// take consumes a request at now from the encoded state, nil if none,
+// and returns the decision and the new state
+func (a Algorithm) take(state []byte, q Quota, now time.Time) (Result, []byte) {
+	if a == SlidingWindow {
+		return slidingWindow(state, q, now)
+	}
+	return tokenBucket(state, q, now)
+}
+
+// tokenBucket state: the tokens left, and the time they were counted
+func tokenBucket(state []byte, q Quota, now time.Time) (Result, []byte) {
+	rate, burst := q.rate(), float64(q.burst())
+	tokens, last := burst, now
+	if len(state) == 16 {
+		tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+		last = time.Unix(0, int64(binary.BigEndian.Uint64(state[8:])))
+		if elapsed := now.Sub(last); elapsed > 0 {
+			tokens = math.Min(burst, tokens+float64(elapsed)*rate)
+		}
+	}
+
+	res := Result{Limit: q.Limit}
+	if tokens >= 1 {
+		res.Allowed = true
+		tokens--
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - tokens) / rate))
+	}
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration(math.Ceil((burst - tokens) / rate))
+
+	state = make([]byte, 16)
+	binary.BigEndian.PutUint64(state, math.Float64bits(tokens))
+	binary.BigEndian.PutUint64(state[8:], uint64(now.UnixNano()))
+	return res, state
+}
+
+// slidingWindow state: the start of the current window, and the
+// counts of the current and previous windows
+func slidingWindow(state []byte, q Quota, now time.Time) (Result, []byte) {
+	w := q.Window
+	start := now.Truncate(w)
+	var current, previous uint64
+	if len(state) == 24 {
+		stateStart := time.Unix(0, int64(binary.BigEndian.Uint64(state)))
+		switch start.Sub(stateStart) {
+		case 0:
+			current = binary.BigEndian.Uint64(state[8:])
+			previous = binary.BigEndian.Uint64(state[16:])
+		case w:
+			previous = binary.BigEndian.Uint64(state[8:])
+		}
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(w)
+	count := float64(previous)*weight + float64(current)
+	limit := float64(q.Limit)
+
+	res := Result{Limit: q.Limit, Reset: w - elapsed}
+	if count+1 <= limit {
+		res.Allowed = true
+		current++
+		count++
+	} else {
+		// until the weighted count leaves room for a request
+		room := limit - 1 - float64(current)
+		if room >= 0 && previous > 0 {
+			res.RetryAfter = time.Duration(math.Ceil(float64(w)*(1-room/float64(previous)))) - elapsed
+		} else {
+			res.RetryAfter = w - elapsed + time.Duration(math.Ceil(float64(w)*(1-(limit-1)/float64(current))))
+		}
+		res.RetryAfter = max(res.RetryAfter, time.Millisecond)
+		res.Reset = max(res.Reset, res.RetryAfter)
+	}
+	res.Remaining = max(int(limit-math.Ceil(count)), 0)
+
+	state = make([]byte, 24)
+	binary.BigEndian.PutUint64(state, uint64(start.UnixNano()))
+	binary.BigEndian.PutUint64(state[8:], current)
+	binary.BigEndian.PutUint64(state[16:], previous)
+	return res, state
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/auth/apikey"
+	"mkfst/auth/token"
+)
+
+// KeyFunc returns the key the quota of the request is counted under,
+// i.e. its client. An empty key is not limited.
+type KeyFunc func(c *gin.Context) (string, error)
+
+// ByIP keys the requests by client address. The address is the peer
+// of the connection unless it is one of the trusted proxies, in which
+// case X-Forwarded-For is read from the right, skipping the trusted
+// proxies: clients can't spoof their address by sending the header.
+//
+//	ratelimit.ByIP(netip.MustParsePrefix("10.0.0.0/8"))
+func ByIP(trustedProxies ...netip.Prefix) KeyFunc {
+	return func(c *gin.Context) (string, error) {
+		return "ip:" + ClientIP(c.Request.RemoteAddr, c.Request.Header.Values("X-Forwarded-For"), trustedProxies), nil
+	}
+}
+
+// ClientIP returns the client address of a request from remoteAddr
+// and its X-Forwarded-For headers, trusting those only when set by
+// the trustedProxies.
+func ClientIP(remoteAddr string, forwardedFor []string, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !trusted(addr, trustedProxies) {
+		return addr.String()
+	}
+	hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !trusted(addr, trustedProxies) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ByUser keys the requests by the user of the auth middleware, and
+// doesn't limit the anonymous ones: combine it with ByIP in First.
+func ByUser() KeyFunc {
+	return func(c *gin.Context) (string, error) {
+		u, err := token.GetUserInfo(c.Request)
+		if err != nil || u.ID == "" {
+			return "", nil
+		}
+		return "user:" + u.ID, nil
+	}
+}
+
+// ByAPIKey keys the requests by the API key the auth middleware
+// verified, see auth.Authenticator.APIKeys: register the limiter after
+// it. The keys sent but not verified are not trusted, and the requests
+// without a verified key are not limited: combine it with ByIP in First.
+func ByAPIKey() KeyFunc {
+	return func(c *gin.Context) (string, error) {
+		u, err := token.GetUserInfo(c.Request)
+		if err != nil || !u.IsServiceAccount() || u.StrAttr(apikey.KeyAttr) == "" {
+			return "", nil
+		}
+		return "key:" + u.StrAttr(apikey.KeyAttr), nil
+	}
+}
+
+// First returns the first key of the requests by keys:
+//
+//	ratelimit.First(ratelimit.ByUser(), ratelimit.ByIP())
+func First(keys ...KeyFunc) KeyFunc {
+	return func(c *gin.Context) (string, error) {
+		for _, k := range keys {
+			key, err := k(c)
+			if err != nil || key != "" {
+				return key, err
+			}
+		}
+		return "", nil
+	}
+}
@@ -0,0 +1,257 @@
+// Package ratelimit limits the rate of the requests of each client, for
+// the service, a group or a route, with a token bucket or a sliding
+// window counted in memory, Redis or SQL.
+//
+//	limiter, err := ratelimit.New(ratelimit.Opts{
+//	    Quota:  ratelimit.PerMinute(100),
+//	    Routes: map[string]ratelimit.Quota{"POST /orders": ratelimit.PerMinute(10)},
+//	    Key:    ratelimit.First(ratelimit.ByUser(), ratelimit.ByIP(proxies...)),
+//	})
+//	api.Middleware(limiter)
+//
+// The responses carry the RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers; the limited requests
+// get a 429 with Retry-After. The limiter documents both on the
+// operations it guards.
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/fizz"
+	"mkfst/fizz/openapi"
+)
+
+// ErrLimited is the error of the requests over their quota.
+var ErrLimited = errors.New("rate limit exceeded")
+
+// Quota is the number of requests allowed per window.
+type Quota struct {
+	Limit  int           // requests per Window; 0 in Opts.Routes exempts the route
+	Window time.Duration // required with a Limit
+	Burst  int           // requests allowed at once by TokenBucket, default Limit
+}
+
+// PerSecond returns the quota of n requests per second.
+func PerSecond(n int) Quota { return Quota{Limit: n, Window: time.Second} }
+
+// PerMinute returns the quota of n requests per minute.
+func PerMinute(n int) Quota { return Quota{Limit: n, Window: time.Minute} }
+
+// PerHour returns the quota of n requests per hour.
+func PerHour(n int) Quota { return Quota{Limit: n, Window: time.Hour} }
+
+// rate returns the requests allowed per nanosecond
+func (q Quota) rate() float64 { return float64(q.Limit) / float64(q.Window) }
+
+func (q Quota) burst() int {
+	if q.Burst > 0 {
+		return q.Burst
+	}
+	return q.Limit
+}
+
+// policy returns the RateLimit-Policy of q, i.e. 100;w=60
+func (q Quota) policy(a Algorithm) string {
+	p := fmt.Sprintf("%d;w=%d", q.Limit, int64(math.Ceil(q.Window.Seconds())))
+	if a == TokenBucket && q.burst() != q.Limit {
+		p += ";burst=" + strconv.Itoa(q.burst())
+	}
+	return p
+}
+
+func (q Quota) validate() error {
+	switch {
+	case q.Limit < 0 || q.Burst < 0:
+		return errors.New("negative limit")
+	case q.Limit > 0 && q.Window <= 0:
+		return errors.New("window required")
+	}
+	return nil
+}
+
+// Opts configures New.
+type Opts struct {
+	// Quota of each key, required.
+	Quota Quota
+
+	// Routes overrides the quota of routes, by route template with or
+	// without its method: "POST /orders", "/orders/:id". Each route
+	// has its own counters, shared by its methods when unset.
+	Routes map[string]Quota
+
+	// Algorithm counting the requests, default TokenBucket.
+	Algorithm Algorithm
+
+	// Key returns the client of the request. Default ByIP().
+	Key KeyFunc
+
+	// Store keeps the counters. Share a Redis or SQL store between the
+	// replicas. Default NewMemoryStore.
+	Store Store
+
+	// Name separates the counters of the limiters sharing a Store.
+	// Default "default".
+	Name string
+
+	// Skip exempts the requests it returns true for.
+	Skip func(c *gin.Context) bool
+
+	// FailClosed rejects the requests with a 503 when the store fails.
+	// By default they are allowed, and the error added to the request.
+	FailClosed bool
+
+	// Now overrides time.Now for tests. Production code leaves nil.
+	Now func() time.Time
+}
+
+// Limiter is a rate limiting middleware: register it with the
+// Middleware of a service or group, or before the handler of a route.
+type Limiter struct {
+	opts Opts
+}
+
+// New returns the Limiter of opts.
+func New(opts Opts) (*Limiter, error) {
+	if opts.Quota.Limit == 0 {
+		return nil, errors.New("ratelimit.New: Quota is required")
+	}
+	if err := opts.Quota.validate(); err != nil {
+		return nil, fmt.Errorf("ratelimit.New: Quota: %w", err)
+	}
+	for route, q := range opts.Routes {
+		if err := q.validate(); err != nil {
+			return nil, fmt.Errorf("ratelimit.New: route %s: %w", route, err)
+		}
+	}
+	if opts.Algorithm != TokenBucket && opts.Algorithm != SlidingWindow {
+		return nil, fmt.Errorf("ratelimit.New: unknown algorithm %d", opts.Algorithm)
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Key == nil {
+		opts.Key = ByIP()
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryStore(MemoryOpts{Now: opts.Now})
+	}
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+	return &Limiter{opts: opts}, nil
+}
+
+// Allow consumes a request of key under the quota q, counted in scope.
+// A key updated too often for the store to count it, ErrConflict, is
+// over its quota: the request is not allowed, and there is no error.
+func (l *Limiter) Allow(ctx context.Context, scope, key string, q Quota) (Result, error) {
+	k := l.opts.Name + "|" + scope + "|" + key
+	if t, ok := l.opts.Store.(taker); ok {
+		return t.take(ctx, k, l.opts.Algorithm, q, l.opts.Now())
+	}
+	var res Result
+	err := l.opts.Store.Update(ctx, k, l.opts.Algorithm.ttl(q), func(state []byte) ([]byte, error) {
+		var next []byte
+		res, next = l.opts.Algorithm.take(state, q, l.opts.Now())
+		return next, nil
+	})
+	if errors.Is(err, ErrConflict) {
+		return Result{Limit: q.Limit, Reset: time.Second, RetryAfter: time.Second}, nil
+	}
+	return res, err
+}
+
+// quota returns the quota of the route of the request, and the scope of its counters
+func (l *Limiter) quota(c *gin.Context) (Quota, string) {
+	route := c.FullPath()
+	if route != "" {
+		if q, ok := l.opts.Routes[c.Request.Method+" "+route]; ok {
+			return q, c.Request.Method + " " + route
+		}
+		if q, ok := l.opts.Routes[route]; ok {
+			return q, route
+		}
+	}
+	return l.opts.Quota, "*"
+}
+
+// HandlerFunc returns the middleware function, see router.MiddlewareHandler.
+func (l *Limiter) HandlerFunc() interface{} {
+	return func(c *gin.Context, db *sql.DB) (any, error) {
+		if l.opts.Skip != nil && l.opts.Skip(c) {
+			c.Next()
+			return nil, nil
+		}
+		q, scope := l.quota(c)
+		key, err := l.opts.Key(c)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return nil, err
+		}
+		if q.Limit == 0 || key == "" {
+			c.Next()
+			return nil, nil
+		}
+
+		res, err := l.Allow(c.Request.Context(), scope, key, q)
+		if err != nil {
+			if l.opts.FailClosed {
+				c.AbortWithStatus(http.StatusServiceUnavailable)
+				return nil, err
+			}
+			_ = c.Error(fmt.Errorf("ratelimit: %w", err))
+			c.Next()
+			return nil, nil
+		}
+		c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", seconds(res.Reset))
+		c.Header("RateLimit-Policy", q.policy(l.opts.Algorithm))
+		if !res.Allowed {
+			c.Header("Retry-After", seconds(res.RetryAfter))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return nil, ErrLimited
+		}
+		c.Next()
+		return nil, nil
+	}
+}
+
+// seconds formats d in seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// ErrorResponse documents the body of the limited requests.
+type ErrorResponse struct {
+	Error string `json:"error" example:"rate limit exceeded"`
+}
+
+// OperationOptions documents the headers of the responses of the
+// guarded operations, and their 429 response; see fizz.OperationDocumenter.
+func (l *Limiter) OperationOptions() []fizz.OperationOption {
+	headers := []*openapi.ResponseHeader{
+		{Name: "RateLimit-Limit", Description: "Requests allowed in the window of the quota", Model: 0},
+		{Name: "RateLimit-Remaining", Description: "Requests left in the window", Model: 0},
+		{Name: "RateLimit-Reset", Description: "Seconds until the quota is fully available again", Model: 0},
+		{Name: "RateLimit-Policy", Description: fmt.Sprintf("Quota of the client, %s by default: limit;w=window in seconds", l.opts.Quota.policy(l.opts.Algorithm)), Model: ""},
+	}
+	opts := make([]fizz.OperationOption, 0, len(headers)+1)
+	for _, h := range headers {
+		opts = append(opts, fizz.DefaultHeader(h.Name, h.Description, h.Model))
+	}
+	limited := append([]*openapi.ResponseHeader{
+		{Name: "Retry-After", Description: "Seconds until the next request is allowed", Model: 0},
+	}, headers...)
+	return append(opts, fizz.DefaultResponse(strconv.Itoa(http.StatusTooManyRequests), "Too many requests, the quota of the client is exceeded", ErrorResponse{}, limited))
+}
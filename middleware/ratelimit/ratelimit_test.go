@@ -0,0 +1,290 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "modernc.org/sqlite"
+
+	"mkfst/auth/apikey"
+	"mkfst/auth/token"
+	"mkfst/config"
+	mkfstdb "mkfst/db"
+	"mkfst/fizz"
+	"mkfst/mkfsttest"
+)
+
+// clock is a settable time for the limiters
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newClock() *clock                   { return &clock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)} }
+func allowed(t *testing.T, l *Limiter, q Quota) Result {
+	t.Helper()
+	res, err := l.Allow(context.Background(), "*", "ip:1.2.3.4", q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTokenBucket(t *testing.T) {
+	clk := newClock()
+	q := Quota{Limit: 2, Window: time.Second, Burst: 3}
+	l, err := New(Opts{Quota: q, Now: clk.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i >= 0; i-- {
+		if res := allowed(t, l, q); !res.Allowed || res.Remaining != i {
+			t.Fatalf("expected allowed with %d remaining, got %+v", i, res)
+		}
+	}
+	res := allowed(t, l, q)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond || res.Reset != 1500*time.Millisecond {
+		t.Fatalf("expected limited for 500ms, got %+v", res)
+	}
+	clk.advance(500 * time.Millisecond)
+	if res = allowed(t, l, q); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected a refilled token, got %+v", res)
+	}
+	clk.advance(time.Hour)
+	if res = allowed(t, l, q); !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("expected the bucket capped at the burst, got %+v", res)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clk := newClock()
+	q := PerMinute(10)
+	l, err := New(Opts{Quota: q, Algorithm: SlidingWindow, Now: clk.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.advance(30 * time.Second)
+	for i := 0; i < 10; i++ {
+		if res := allowed(t, l, q); !res.Allowed || res.Remaining != 9-i {
+			t.Fatalf("request %d: unexpected %+v", i, res)
+		}
+	}
+	res := allowed(t, l, q)
+	if res.Allowed || res.RetryAfter != 36*time.Second {
+		t.Fatalf("expected limited until 6s into the next window, got %+v", res)
+	}
+
+	// 45s into the next window, the previous one weighs 10*0.25
+	clk.advance(75 * time.Second)
+	for i := 0; i < 7; i++ {
+		if res = allowed(t, l, q); !res.Allowed {
+			t.Fatalf("request %d: unexpected %+v", i, res)
+		}
+	}
+	if res = allowed(t, l, q); res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected limited, got %+v", res)
+	}
+}
+
+type order struct {
+	ID string `json:"id"`
+}
+
+func TestMiddleware(t *testing.T) {
+	clk := newClock()
+	l, err := New(Opts{
+		Quota:  PerMinute(3),
+		Routes: map[string]Quota{"POST /api/orders": PerMinute(1), "/api/healthz": {}},
+		Key:    First(ByAPIKey(), ByIP()),
+		Now:    clk.now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mkfsttest.New(t, config.Config{})
+	api := h.Service.Group("/api", "api", "")
+	api.Middleware(func(c *gin.Context, _ *sql.DB) (any, error) {
+		// the API keys verified by the auth middleware
+		if c.GetHeader("X-API-Key") == "secret" {
+			u := token.User{ID: "sa_ci", Name: "ci"}
+			u.SetServiceAccount(true)
+			u.SetStrAttr(apikey.KeyAttr, "k1")
+			c.Request = token.SetUserInfo(c.Request, u)
+		}
+		c.Next()
+		return nil, nil
+	}, l)
+	handler := func(c *gin.Context, _ *sql.DB) (*order, error) { return &order{ID: c.Param("id")}, nil }
+	api.Route("GET", "/orders/:id", http.StatusOK, nil, handler)
+	api.Route("POST", "/orders", http.StatusCreated, []fizz.OperationOption{
+		fizz.Response("429", "Slow down", ErrorResponse{}, nil, nil),
+	}, handler)
+	api.Route("GET", "/healthz", http.StatusOK, nil, handler)
+
+	do := func(method, path, key string) *http.Response {
+		req, _ := http.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		return h.Client().Do(req).Result()
+	}
+
+	for i := 0; i < 3; i++ {
+		res := do("GET", "/api/orders/o1", "")
+		if res.StatusCode != http.StatusOK || res.Header.Get("RateLimit-Limit") != "3" || res.Header.Get("RateLimit-Policy") != "3;w=60" {
+			t.Fatalf("request %d: unexpected response %d %v", i, res.StatusCode, res.Header)
+		}
+	}
+	res := do("GET", "/api/orders/o2", "")
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "20" || res.Header.Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("expected 429, got %d %v", res.StatusCode, res.Header)
+	}
+
+	// the API keys and the routes with a quota have their own counters
+	if res = do("GET", "/api/orders/o1", "secret"); res.StatusCode != http.StatusOK {
+		t.Errorf("expected the key allowed, got %d", res.StatusCode)
+	}
+	if res = do("GET", "/api/orders/o1", "forged"); res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected an unverified key counted by address, got %d", res.StatusCode)
+	}
+	if res = do("POST", "/api/orders", ""); res.StatusCode != http.StatusCreated || res.Header.Get("RateLimit-Limit") != "1" {
+		t.Errorf("expected the route quota, got %d %v", res.StatusCode, res.Header)
+	}
+	if res = do("POST", "/api/orders", ""); res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected the route quota exceeded, got %d", res.StatusCode)
+	}
+	for i := 0; i < 5; i++ {
+		if res = do("GET", "/api/healthz", ""); res.StatusCode != http.StatusOK || res.Header.Get("RateLimit-Limit") != "" {
+			t.Fatalf("expected an exempted route, got %d %v", res.StatusCode, res.Header)
+		}
+	}
+
+	clk.advance(20 * time.Second)
+	if res = do("GET", "/api/orders/o1", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("expected a refilled token, got %d", res.StatusCode)
+	}
+
+	spec := h.Spec()
+	get := spec.Paths["/api/orders/{id}"].GET
+	limited, ok := get.Responses["429"]
+	if !ok || limited.Response.Headers["Retry-After"] == nil || limited.Response.Content == nil {
+		t.Fatalf("expected the 429 documented, got %+v", get.Responses)
+	}
+	if ok := get.Responses["200"].Response.Headers["RateLimit-Remaining"] != nil; !ok {
+		t.Errorf("expected the RateLimit headers documented, got %+v", get.Responses["200"].Response.Headers)
+	}
+	if d := spec.Paths["/api/orders"].POST.Responses["429"].Response.Description; d != "Slow down" {
+		t.Errorf("expected the 429 of the route kept, got %q", d)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for _, tt := range []struct {
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"192.0.2.1:80", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"10.0.0.1:80", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1:80", []string{"203.0.113.9, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"10.0.0.1:80", []string{"203.0.113.9", "198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1:80", []string{"garbage"}, "10.0.0.1"},
+		{"[::ffff:192.0.2.1]:80", nil, "192.0.2.1"},
+	} {
+		if got := ClientIP(tt.remote, tt.xff, proxies); got != tt.want {
+			t.Errorf("ClientIP(%s, %v) = %s, want %s", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestSQLStore(t *testing.T) {
+	raw, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "rl.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	clk := newClock()
+	var lock sync.Mutex
+	now := func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return clk.now()
+	}
+	store, err := NewSQLStore(&mkfstdb.Connection{Conn: raw, Config: mkfstdb.ConnectionInfo{Type: "SQLITE"}}, SQLOpts{SweepInterval: -1, Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	q := Quota{Limit: 20, Window: time.Hour}
+	l, err := New(Opts{Quota: q, Store: store, Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var allowedN, limitedN int
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := l.Allow(context.Background(), "*", "user:bob", q)
+			lock.Lock()
+			defer lock.Unlock()
+			switch {
+			case err != nil:
+				t.Error(err)
+			case res.Allowed:
+				allowedN++
+			default:
+				limitedN++
+			}
+		}()
+	}
+	wg.Wait()
+	if allowedN != 20 || limitedN != 10 {
+		t.Fatalf("expected 20 allowed and 10 limited, got %d and %d", allowedN, limitedN)
+	}
+
+	lock.Lock()
+	clk.advance(2 * time.Hour)
+	lock.Unlock()
+	if res, err := l.Allow(context.Background(), "*", "user:bob", q); err != nil || !res.Allowed || res.Remaining != 19 {
+		t.Fatalf("expected the expired state reset, got %+v, %v", res, err)
+	}
+}
+
+// conflictStore is a shared store whose key is updated too often to be counted
+type conflictStore struct{}
+
+func (conflictStore) Update(context.Context, string, time.Duration, func([]byte) ([]byte, error)) error {
+	return ErrConflict
+}
+
+func (conflictStore) Close() error { return nil }
+
+func TestConflictLimits(t *testing.T) {
+	l, err := New(Opts{Quota: PerMinute(3), Store: conflictStore{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mkfsttest.New(t, config.Config{})
+	api := h.Service.Group("/api", "api", "")
+	api.Middleware(l)
+	api.Route("GET", "/orders/:id", http.StatusOK, nil, func(c *gin.Context, _ *sql.DB) (*order, error) {
+		return &order{ID: c.Param("id")}, nil
+	})
+
+	req, _ := http.NewRequest("GET", "/api/orders/o1", nil)
+	res := h.Client().Do(req).Result()
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "1" {
+		t.Errorf("expected a hot key limited, got %d %v", res.StatusCode, res.Header)
+	}
+}
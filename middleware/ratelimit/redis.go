@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	rds "github.com/redis/go-redis/v9"
+)
+
+// RedisOpts configures NewRedisStore.
+type RedisOpts struct {
+	// Client is the Redis (or Valkey) client. Required. The store
+	// does not own the client — caller manages lifecycle.
+	Client rds.UniversalClient
+
+	// KeyPrefix is prepended to every key the store reads/writes.
+	// Default "ratelimit:".
+	KeyPrefix string
+}
+
+// NewRedisStore returns a Store backed by Redis (or Valkey), shared by
+// the replicas. The Limiter runs its algorithms in Redis, as Lua
+// scripts, so a hot key never conflicts. Update is an optimistic
+// transaction: WATCH the key, then MULTI/EXEC the new state with its
+// expiry, retried on conflict.
+func NewRedisStore(opts RedisOpts) (Store, error) {
+	if opts.Client == nil {
+		return nil, errors.New("ratelimit.NewRedisStore: Client is required")
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = "ratelimit:"
+	}
+	return &redisStore{client: opts.Client, prefix: opts.KeyPrefix}, nil
+}
+
+type redisStore struct {
+	client rds.UniversalClient
+	prefix string
+	closed bool
+}
+
+func (s *redisStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	if s.closed {
+		return ErrClosed
+	}
+	k := s.prefix + key
+	txf := func(tx *rds.Tx) error {
+		state, err := tx.Get(ctx, k).Bytes()
+		if err != nil && !errors.Is(err, rds.Nil) {
+			return err
+		}
+		if state, err = fn(state); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p rds.Pipeliner) error {
+			p.Set(ctx, k, state, ttl)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < maxRetries; i++ {
+		err := s.client.Watch(ctx, txf, k)
+		if errors.Is(err, rds.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("redis update: %w", err)
+		}
+		return nil
+	}
+	return ErrConflict
+}
+
+// The scripts of the algorithms, counted in microseconds: the same
+// decisions as tokenBucket and slidingWindow, on a hash of the state.
+// They return allowed (0 or 1), remaining, reset and retry after.
+var redisScripts = map[Algorithm]*rds.Script{
+	// ARGV: limit, window in microseconds, now, ttl in milliseconds, burst
+	TokenBucket: rds.NewScript(`
+local rate, burst, now = tonumber(ARGV[1]) / tonumber(ARGV[2]), tonumber(ARGV[5]), tonumber(ARGV[3])
+local tokens = burst
+local state = redis.call('HMGET', KEYS[1], 't', 'l')
+if state[1] then
+  tokens = tonumber(state[1])
+  local elapsed = now - tonumber(state[2])
+  if elapsed > 0 then
+    tokens = math.min(burst, tokens + elapsed * rate)
+  end
+end
+local allowed, retry = 0, 0
+if tokens >= 1 then
+  allowed, tokens = 1, tokens - 1
+else
+  retry = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 't', string.format('%.17g', tokens), 'l', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, math.floor(tokens), math.ceil((burst - tokens) / rate), retry}
+`),
+	// ARGV: limit, window in microseconds, now, ttl in milliseconds, start of the window
+	SlidingWindow: rds.NewScript(`
+local limit, w, now, start = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[5])
+local current, previous = 0, 0
+local state = redis.call('HMGET', KEYS[1], 's', 'c', 'p')
+if state[1] then
+  local d = start - tonumber(state[1])
+  if d == 0 then
+    current, previous = tonumber(state[2]), tonumber(state[3])
+  elseif d == w then
+    previous = tonumber(state[2])
+  end
+end
+local elapsed = now - start
+local count = previous * (1 - elapsed / w) + current
+local allowed, retry, reset = 0, 0, w - elapsed
+if count + 1 <= limit then
+  allowed, current, count = 1, current + 1, count + 1
+else
+  local room = limit - 1 - current
+  if room >= 0 and previous > 0 then
+    retry = math.ceil(w * (1 - room / previous)) - elapsed
+  else
+    retry = w - elapsed + math.ceil(w * (1 - (limit - 1) / current))
+  end
+  retry = math.max(retry, 1000)
+  reset = math.max(reset, retry)
+end
+redis.call('HSET', KEYS[1], 's', string.format('%.17g', start), 'c', current, 'p', previous)
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, math.max(limit - math.ceil(count), 0), reset, retry}
+`),
+}
+
+// take runs the script of the algorithm on the state of key, see taker
+func (s *redisStore) take(ctx context.Context, key string, a Algorithm, q Quota, now time.Time) (Result, error) {
+	if s.closed {
+		return Result{}, ErrClosed
+	}
+	args := []interface{}{q.Limit, q.Window.Microseconds(), now.UnixMicro(), max(a.ttl(q).Milliseconds(), 1), q.burst()}
+	if a == SlidingWindow {
+		args[4] = now.Truncate(q.Window).UnixMicro() // the windows of slidingWindow
+	}
+	v, err := redisScripts[a].Run(ctx, s.client, []string{s.prefix + key}, args...).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("redis %s: %w", a, err)
+	}
+	if len(v) != 4 {
+		return Result{}, fmt.Errorf("redis %s: unexpected result %v", a, v)
+	}
+	return Result{
+		Allowed:    v[0] == 1,
+		Limit:      q.Limit,
+		Remaining:  int(v[1]),
+		Reset:      time.Duration(v[2]) * time.Microsecond,
+		RetryAfter: time.Duration(v[3]) * time.Microsecond,
+	}, nil
+}
+
+func (s *redisStore) Close() error {
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,218 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mkfst/db"
+)
+
+// SQLOpts configures NewSQLStore.
+type SQLOpts struct {
+	// TablePrefix is prepended to the table name. Default "mkfst_ratelimit_".
+	TablePrefix string
+
+	// SweepInterval is how often a background goroutine deletes the
+	// expired states, a negative one disables the sweeper. Default
+	// 5 minutes.
+	SweepInterval time.Duration
+
+	// Now overrides time.Now for tests. Production code leaves nil.
+	Now func() time.Time
+}
+
+type sqlDialect int
+
+const (
+	sqlDialectSQLite sqlDialect = iota
+	sqlDialectPostgres
+	sqlDialectMySQL
+)
+
+// NewSQLStore returns a Store backed by an SQL database accessed through
+// mkfst's db.Connection, shared by the replicas. Supports PostgreSQL,
+// MySQL 5.7+ and SQLite.
+//
+// Updates are optimistic: the state is read with its version, and
+// written only if the version is unchanged, retried on conflict. No
+// dialect-specific locking is needed.
+func NewSQLStore(conn *db.Connection, opts SQLOpts) (Store, error) {
+	if conn == nil || conn.Conn == nil {
+		return nil, errors.New("ratelimit.NewSQLStore: nil connection")
+	}
+	var d sqlDialect
+	switch strings.ToUpper(conn.Config.Type) {
+	case "", "SQLITE":
+		d = sqlDialectSQLite
+	case "POSTGRESQL", "POSTGRES":
+		d = sqlDialectPostgres
+	case "MYSQL":
+		d = sqlDialectMySQL
+	default:
+		return nil, fmt.Errorf("ratelimit.NewSQLStore: unsupported db type %q", conn.Config.Type)
+	}
+	if opts.TablePrefix == "" {
+		opts.TablePrefix = "mkfst_ratelimit_"
+	}
+	if opts.SweepInterval == 0 {
+		opts.SweepInterval = 5 * time.Minute
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	s := &sqlStore{
+		db:      conn.Conn,
+		dialect: d,
+		opts:    opts,
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	if err := s.migrate(context.Background()); err != nil {
+		return nil, fmt.Errorf("ratelimit.NewSQLStore: migrate: %w", err)
+	}
+	if opts.SweepInterval > 0 {
+		go s.sweepLoop()
+	} else {
+		close(s.doneCh)
+	}
+	return s, nil
+}
+
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+	opts    SQLOpts
+	closed  bool
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func (s *sqlStore) table() string { return s.opts.TablePrefix + "states" }
+
+func (s *sqlStore) migrate(ctx context.Context) error {
+	t := s.table()
+	var stmt string
+	switch s.dialect {
+	case sqlDialectPostgres:
+		stmt = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			rl_key     VARCHAR(512) PRIMARY KEY,
+			rl_state   BYTEA NOT NULL,
+			rl_version BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`, t)
+	case sqlDialectMySQL:
+		stmt = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			rl_key     VARCHAR(512) PRIMARY KEY,
+			rl_state   VARBINARY(64) NOT NULL,
+			rl_version BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, t)
+	default: // SQLite
+		stmt = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			rl_key     TEXT PRIMARY KEY,
+			rl_state   BLOB NOT NULL,
+			rl_version INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		)`, t)
+	}
+	if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("create table: %w", err)
+	}
+	// Speeds up the sweeper; some MySQL versions reject IF NOT EXISTS. Non-fatal.
+	_, _ = s.db.ExecContext(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_exp ON %s (expires_at)`, t, t))
+	return nil
+}
+
+func (s *sqlStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	if s.closed {
+		return ErrClosed
+	}
+	t := s.table()
+	for i := 0; i < maxRetries; i++ {
+		now := s.opts.Now()
+		var state []byte
+		var version, expires int64
+		err := s.db.QueryRowContext(ctx, s.rebind(`SELECT rl_state, rl_version, expires_at FROM `+t+` WHERE rl_key = ?`), key).
+			Scan(&state, &version, &expires)
+		found := err == nil
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("select: %w", err)
+		}
+		if found && expires <= now.UnixNano() {
+			state = nil
+		}
+		if state, err = fn(state); err != nil {
+			return err
+		}
+
+		var res sql.Result
+		if found {
+			res, err = s.db.ExecContext(ctx, s.rebind(`UPDATE `+t+` SET rl_state = ?, rl_version = ?, expires_at = ?
+				WHERE rl_key = ? AND rl_version = ?`), state, version+1, now.Add(ttl).UnixNano(), key, version)
+		} else {
+			q := `INSERT INTO ` + t + ` (rl_key, rl_state, rl_version, expires_at) VALUES (?, ?, 1, ?) ON CONFLICT (rl_key) DO NOTHING`
+			if s.dialect == sqlDialectMySQL {
+				q = `INSERT IGNORE INTO ` + t + ` (rl_key, rl_state, rl_version, expires_at) VALUES (?, ?, 1, ?)`
+			}
+			res, err = s.db.ExecContext(ctx, s.rebind(q), key, state, now.Add(ttl).UnixNano())
+		}
+		if err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+func (s *sqlStore) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stopCh)
+	<-s.doneCh
+	return nil
+}
+
+// sweepLoop periodically deletes the expired states
+func (s *sqlStore) sweepLoop() {
+	defer close(s.doneCh)
+	ticker := time.NewTicker(s.opts.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			_, _ = s.db.ExecContext(ctx, s.rebind(`DELETE FROM `+s.table()+` WHERE expires_at < ?`), s.opts.Now().UnixNano())
+			cancel()
+		}
+	}
+}
+
+// rebind converts ?-style placeholders to PG's $1, $2 form.
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != sqlDialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query))
+	idx := 1
+	for _, r := range query {
+		if r == '?' {
+			fmt.Fprintf(&b, "$%d", idx)
+			idx++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by the operations on a closed Store.
+var ErrClosed = errors.New("ratelimit: store closed")
+
+// ErrConflict is returned by Update when the state of a key kept
+// changing under it, retries included.
+var ErrConflict = errors.New("ratelimit: too many concurrent updates")
+
+// Store keeps the state of the limited keys, shared by the replicas of
+// the service for the Redis and SQL stores. The constructors mirror
+// the providers/cache ones.
+type Store interface {
+	// Update replaces atomically the state of key, nil if none or
+	// expired, with the one returned by fn, kept for ttl. fn may be
+	// called again when another update raced it.
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(state []byte) ([]byte, error)) error
+
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// maxRetries bounds the optimistic updates of the shared stores
+const maxRetries = 10
+
+// taker is implemented by the stores running the algorithms themselves,
+// atomically, like the Redis store with its scripts. Limiter uses it
+// instead of Update.
+type taker interface {
+	take(ctx context.Context, key string, a Algorithm, q Quota, now time.Time) (Result, error)
+}
+
+// MemoryOpts configures NewMemoryStore.
+type MemoryOpts struct {
+	// Now overrides time.Now for tests. Production code leaves nil.
+	Now func() time.Time
+}
+
+// NewMemoryStore returns a Store keeping the states in process, for a
+// single replica. The expired states are dropped as the store grows.
+func NewMemoryStore(opts MemoryOpts) Store {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &memoryStore{entries: make(map[string]memoryEntry), now: now}
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	sweepAt int // size of the map triggering the next sweep
+	closed  bool
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	state   []byte
+	expires time.Time
+}
+
+func (s *memoryStore) Update(_ context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	now := s.now()
+	var state []byte
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		state = e.state
+	}
+	state, err := fn(state)
+	if err != nil {
+		return err
+	}
+	s.entries[key] = memoryEntry{state: state, expires: now.Add(ttl)}
+	if len(s.entries) >= s.sweepAt {
+		s.sweep(now)
+	}
+	return nil
+}
+
+// sweep drops the expired states, and schedules the next sweep when
+// the live ones have doubled
+func (s *memoryStore) sweep(now time.Time) {
+	for k, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	s.sweepAt = max(2*len(s.entries), 1024)
+}
+
+func (s *memoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.entries = nil
+	return nil
+}
@@ -0,0 +1,265 @@
+package validation
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"mkfst/fizz/openapi"
+	"mkfst/tonic"
+)
+
+// Config represents all available options for the middleware.
+type Config struct {
+	// Spec returns the specification the requests and responses are
+	// validated against. It is called once, on the first request, when
+	// every route is registered: pass Service.OpenAPI.
+	Spec func() *openapi.OpenAPI
+
+	// AllowUnknownQuery accepts the query parameters
+	// the operations don't document.
+	AllowUnknownQuery bool
+
+	// MaxBodyBytes is the size of the request bodies read for the
+	// validation, larger ones are rejected with a 413 status code.
+	// Default value is tonic.DefaultMaxBodyBytes, the limit of the
+	// binding of the handlers.
+	MaxBodyBytes int64
+
+	// ReportOnly reports the invalid requests instead of rejecting
+	// them, to roll out the validation on an existing API.
+	ReportOnly bool
+
+	// Responses enables the validation of the responses. The body of
+	// every response is buffered, which is meant for development and
+	// tests; Default enables it unless gin runs in release mode.
+	Responses bool
+
+	// Report is called with every request or response that doesn't
+	// match the specification. Default value is LogReport.
+	Report func(c *gin.Context, err *Error)
+}
+
+// Error lists the mismatches between a request
+// or a response and the specification.
+type Error struct {
+	Method string `json:"method"`
+	// Route is the path of the operation, such as /users/:id.
+	Route string `json:"route"`
+	// Status is the status code of the response, or
+	// zero if the request doesn't match.
+	Status     int                 `json:"status,omitempty"`
+	Mismatches openapi.ValueErrors `json:"mismatches"`
+
+	tooLarge bool // the request body is over MaxBodyBytes
+}
+
+// Error implements the builtin error interface for Error.
+func (e *Error) Error() string {
+	what := "request"
+	if e.Status != 0 {
+		what = fmt.Sprintf("response %d", e.Status)
+	}
+	return fmt.Sprintf("%s of %s %s does not match the specification: %s", what, e.Method, e.Route, e.Mismatches)
+}
+
+// Default returns the middleware validating the requests and, unless
+// gin runs in release mode, the responses, against the specification
+// returned by spec.
+func Default(spec func() *openapi.OpenAPI) interface{} {
+	return Validate(Config{
+		Spec:      spec,
+		Responses: gin.Mode() != gin.ReleaseMode,
+	})
+}
+
+// Validate returns the middleware validating the requests, and
+// optionally the responses, of the operations documented by the
+// specification. An invalid request is rejected with a 400 status
+// code, 415 when the media type of its body is not documented, or
+// 413 when its body is over MaxBodyBytes, and a JSON body listing
+// the mismatches. An invalid response is
+// reported, and sent as is.
+func Validate(config Config) interface{} {
+	if config.Spec == nil {
+		panic("validation: nil Spec")
+	}
+	if config.Report == nil {
+		config.Report = LogReport
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = tonic.DefaultMaxBodyBytes
+	}
+	var (
+		once sync.Once
+		api  *openapi.OpenAPI
+	)
+	return func(c *gin.Context, _ *sql.DB) (any, error) {
+		once.Do(func() { api = config.Spec() })
+
+		route := c.FullPath()
+		if api == nil || route == "" || api.Operation(c.Request.Method, route) == nil {
+			// Unknown routes and undocumented
+			// operations are left alone.
+			return nil, nil
+		}
+		if err := validateRequest(c, api, route, config.AllowUnknownQuery, config.MaxBodyBytes); err != nil {
+			config.Report(c, err)
+
+			if !config.ReportOnly {
+				status := http.StatusBadRequest
+				for _, m := range err.Mismatches {
+					if m.Path == "header.Content-Type" {
+						status = http.StatusUnsupportedMediaType
+					}
+				}
+				if err.tooLarge {
+					status = http.StatusRequestEntityTooLarge
+				}
+				c.AbortWithStatusJSON(status, gin.H{
+					"error":      "request does not match the specification",
+					"mismatches": err.Mismatches,
+				})
+				return nil, nil
+			}
+		}
+		if !config.Responses {
+			c.Next()
+			return nil, nil
+		}
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if err := api.ValidateResponse(c.Request.Method, route, w.Status(), mt, w.body.Bytes()); err != nil {
+			config.Report(c, &Error{
+				Method:     c.Request.Method,
+				Route:      route,
+				Status:     w.Status(),
+				Mismatches: valueErrors(err, "response"),
+			})
+		}
+		return nil, nil
+	}
+}
+
+func validateRequest(c *gin.Context, api *openapi.OpenAPI, route string, allowUnknownQuery bool, maxBodyBytes int64) *Error {
+	var body []byte
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		b, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return &Error{
+					Method:     c.Request.Method,
+					Route:      route,
+					Mismatches: openapi.ValueErrors{{Path: "$", Message: fmt.Sprintf("request body larger than %d bytes", tooLarge.Limit)}},
+					tooLarge:   true,
+				}
+			}
+			return &Error{
+				Method:     c.Request.Method,
+				Route:      route,
+				Mismatches: openapi.ValueErrors{{Path: "$", Message: err.Error()}},
+			}
+		}
+		body = b
+		// Give the body back to the handler.
+		c.Request.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	err := api.ValidateRequest(c.Request.Method, route, c.Request, body)
+	if err == nil {
+		return nil
+	}
+	var mismatches openapi.ValueErrors
+	for _, m := range valueErrors(err, "$") {
+		if allowUnknownQuery && strings.HasPrefix(m.Path, "query.") && m.Message == "unknown query parameter" {
+			continue
+		}
+		mismatches = append(mismatches, m)
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	return &Error{
+		Method:     c.Request.Method,
+		Route:      route,
+		Mismatches: mismatches,
+	}
+}
+
+// valueErrors returns the violations listed by err, or
+// a single one located at path if err lists none.
+func valueErrors(err error, path string) openapi.ValueErrors {
+	var ve openapi.ValueErrors
+	if errors.As(err, &ve) {
+		return ve
+	}
+	return openapi.ValueErrors{{Path: path, Message: err.Error()}}
+}
+
+// LogReport logs the mismatches with the standard logger.
+func LogReport(_ *gin.Context, err *Error) {
+	log.Printf("validation: %s", err)
+}
+
+// MetricReport returns a report function counting the mismatches with
+// the meter of the global OpenTelemetry provider, in the counter
+// http.server.spec_mismatches, before calling next, if not nil.
+func MetricReport(next func(*gin.Context, *Error)) func(*gin.Context, *Error) {
+	meter := otel.Meter("mkfst/middleware/validation")
+	counter, _ := meter.Int64Counter(
+		"http.server.spec_mismatches",
+		metric.WithDescription("Number of requests and responses not matching the OpenAPI specification"),
+		metric.WithUnit("Count"),
+	)
+	return func(c *gin.Context, err *Error) {
+		direction := "request"
+		if err.Status != 0 {
+			direction = "response"
+		}
+		var ctx context.Context = c
+		if c.Request != nil {
+			ctx = c.Request.Context()
+		}
+		counter.Add(ctx, int64(len(err.Mismatches)), metric.WithAttributes(
+			attribute.String("http.method", err.Method),
+			attribute.String("http.route", err.Route),
+			attribute.String("direction", direction),
+		))
+		if next != nil {
+			next(c, err)
+		}
+	}
+}
+
+// recordingWriter records the body written to the response.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
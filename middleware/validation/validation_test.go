@@ -0,0 +1,148 @@
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/config"
+	"mkfst/mkfsttest"
+	"mkfst/tonic"
+)
+
+type getPetIn struct {
+	ID   int64  `path:"id"`
+	Kind string `query:"kind" enum:"cat,dog"`
+}
+
+type createPetIn struct {
+	Name string `json:"name" validate:"required"`
+	Age  int    `json:"age"`
+}
+
+type pet struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name,omitempty" validate:"required"`
+}
+
+// reports collects the reported errors.
+type reports struct {
+	mu   sync.Mutex
+	errs []*Error
+}
+
+func (r *reports) report(_ *gin.Context, err *Error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func newHarness(t *testing.T, cfg Config) (*mkfsttest.Harness, *reports) {
+	h := mkfsttest.New(t, config.Config{})
+	// The requests are checked here, not by the harness.
+	h.ValidateResponses = false
+
+	r := &reports{}
+	cfg.Spec = h.Service.OpenAPI
+	cfg.Report = r.report
+	h.Service.Middleware(Validate(cfg))
+
+	pets := h.Service.Group("/pets", "pets", "Pets")
+	pets.Route("GET", "/:id", http.StatusOK, nil,
+		func(c *gin.Context, in *getPetIn) (*pet, error) {
+			if in.ID == 0 {
+				// Drifted from the documented output.
+				return &pet{ID: in.ID}, nil
+			}
+			return &pet{ID: in.ID, Name: "rex"}, nil
+		})
+	pets.Route("POST", "", http.StatusCreated, nil,
+		func(c *gin.Context, in *createPetIn) (*pet, error) {
+			return &pet{ID: 1, Name: in.Name}, nil
+		})
+	return h, r
+}
+
+func do(h *mkfsttest.Harness, method, url, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return h.Client().Do(req)
+}
+
+func TestRequests(t *testing.T) {
+	h, r := newHarness(t, Config{})
+
+	for _, tc := range []struct {
+		method, url, contentType, body string
+		status                         int
+		path                           string
+	}{
+		{"GET", "/pets/1?kind=cat", "", "", http.StatusOK, ""},
+		{"GET", "/pets/x", "", "", http.StatusBadRequest, "path.id"},
+		{"GET", "/pets/1?kind=bird", "", "", http.StatusBadRequest, "query.kind"},
+		{"GET", "/pets/1?debug=1", "", "", http.StatusBadRequest, "query.debug"},
+		{"POST", "/pets", "application/json", `{"name":"rex","age":3}`, http.StatusCreated, ""},
+		{"POST", "/pets", "application/json", `{"age":"old"}`, http.StatusBadRequest, "$.age"},
+		{"POST", "/pets", "text/plain", `name=rex`, http.StatusUnsupportedMediaType, "header.Content-Type"},
+		{"POST", "/pets", "application/json", `{"name":"` + strings.Repeat("x", tonic.DefaultMaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, "$"},
+	} {
+		w := do(h, tc.method, tc.url, tc.contentType, tc.body)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected %d, got %d %s", tc.method, tc.url, tc.status, w.Code, w.Body)
+			continue
+		}
+		if tc.path == "" {
+			continue
+		}
+		var resp struct {
+			Mismatches []struct{ Path, Message string }
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, m := range resp.Mismatches {
+			found = found || m.Path == tc.path
+		}
+		if !found {
+			t.Errorf("%s %s: no mismatch at %s: %s", tc.method, tc.url, tc.path, w.Body)
+		}
+	}
+	if len(r.errs) != 6 {
+		t.Errorf("expected 6 reports, got %d", len(r.errs))
+	}
+}
+
+func TestReportOnly(t *testing.T) {
+	h, r := newHarness(t, Config{ReportOnly: true, AllowUnknownQuery: true})
+
+	if w := do(h, "GET", "/pets/1?debug=1", "", ""); w.Code != http.StatusOK || len(r.errs) != 0 {
+		t.Errorf("unknown query parameter: %d, %d reports", w.Code, len(r.errs))
+	}
+	if w := do(h, "POST", "/pets", "text/plain", `{"name":"rex"}`); w.Code != http.StatusCreated || len(r.errs) != 1 {
+		t.Errorf("undocumented media type: %d, %d reports", w.Code, len(r.errs))
+	}
+}
+
+func TestResponses(t *testing.T) {
+	h, r := newHarness(t, Config{Responses: true})
+
+	if w := do(h, "GET", "/pets/1", "", ""); w.Code != http.StatusOK || len(r.errs) != 0 {
+		t.Fatalf("valid response: %d %v", w.Code, r.errs)
+	}
+	// The response is sent as is, and reported.
+	w := do(h, "GET", "/pets/0", "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `{"id":0}`) {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if len(r.errs) != 1 || r.errs[0].Status != http.StatusOK || !strings.Contains(r.errs[0].Error(), `missing required property "name"`) {
+		t.Errorf("unexpected reports: %v", r.errs)
+	}
+}
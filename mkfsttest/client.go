@@ -0,0 +1,338 @@
+package mkfsttest
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"mkfst/tonic"
+)
+
+// Client sends requests to a Harness. Requests never leave the
+// process: they are served by the harness handler through an
+// httptest.ResponseRecorder.
+type Client struct {
+	// Header holds the headers sent with every request,
+	// such as authentication tokens.
+	Header http.Header
+
+	h *Harness
+}
+
+// Response is the result of a Call. Out holds the decoded body
+// when the status code is successful.
+type Response[Out any] struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	Out    Out
+
+	method, path string
+	c            *Client
+}
+
+// Do serves req and returns the recorded response. Unlike Call, it
+// doesn't encode an input nor check the response against the spec.
+func (c *Client) Do(req *http.Request) *httptest.ResponseRecorder {
+	for k, vs := range c.Header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = vs
+		}
+	}
+	w := httptest.NewRecorder()
+	c.h.Handler().ServeHTTP(w, req)
+	return w
+}
+
+// Call sends a request to the route registered for method and path,
+// and decodes a successful response into Out.
+//
+// The path uses the same syntax as the route registration (/users/:id).
+// The fields of in are encoded following the tonic binding tags: fields
+// tagged with path, query or header are sent in the according location,
+// and the others are marshalled as the JSON body of the request. A value
+// of in that isn't a struct is sent as the body as is.
+//
+// Unless Harness.ValidateResponses is false, the response is checked
+// against the OpenAPI specification and any mismatch fails the test.
+func Call[Out any](c *Client, method, path string, in interface{}) *Response[Out] {
+	t := c.h.t
+	t.Helper()
+
+	req, err := newRequest(method, path, in)
+	if err != nil {
+		t.Fatalf("mkfsttest: %s %s: %v", method, path, err)
+	}
+	w := c.Do(req)
+
+	res := &Response[Out]{
+		Status: w.Code,
+		Header: w.Header(),
+		Body:   w.Body.Bytes(),
+		method: method,
+		path:   path,
+		c:      c,
+	}
+	if res.Status >= 200 && res.Status < 300 && len(bytes.TrimSpace(res.Body)) > 0 {
+		if err := json.Unmarshal(res.Body, &res.Out); err != nil {
+			t.Errorf("mkfsttest: %s %s: decode %T: %v", method, path, res.Out, err)
+		}
+	}
+	if c.h.ValidateResponses {
+		if err := res.Validate(); err != nil {
+			t.Errorf("mkfsttest: %s %s: response does not match the spec: %v", method, path, err)
+		}
+	}
+	return res
+}
+
+// Validate checks the response against the OpenAPI specification
+// generated for the route.
+func (r *Response[Out]) Validate() error {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.c.h.Spec().ValidateResponse(r.method, r.path, r.Status, mt, r.Body)
+}
+
+// ExpectStatus fails the test if the status code of
+// the response is not code.
+func (r *Response[Out]) ExpectStatus(code int) *Response[Out] {
+	t := r.c.h.t
+	t.Helper()
+	if r.Status != code {
+		t.Errorf("mkfsttest: %s %s: expected status %d, got %d: %s", r.method, r.path, code, r.Status, r.Body)
+	}
+	return r
+}
+
+// newRequest builds the request for a Call.
+func newRequest(method, path string, in interface{}) (*http.Request, error) {
+	params := &requestParams{
+		path:   make(map[string]string),
+		query:  make(url.Values),
+		header: make(http.Header),
+		body:   make(map[string]interface{}),
+	}
+	var rawBody interface{}
+
+	if in != nil {
+		v := reflect.ValueOf(in)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Struct {
+			if err := params.collect(v); err != nil {
+				return nil, err
+			}
+		} else {
+			rawBody = in
+		}
+	}
+	target, err := expandPath(path, params.path)
+	if err != nil {
+		return nil, err
+	}
+	if len(params.query) > 0 {
+		target += "?" + params.query.Encode()
+	}
+	var body io.Reader
+	if rawBody == nil && len(params.body) > 0 {
+		rawBody = params.body
+	}
+	if rawBody != nil {
+		b, err := json.Marshal(rawBody)
+		if err != nil {
+			return nil, fmt.Errorf("encode body: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, target, body)
+	if body != nil {
+		req.Header.Set("Content-Type", tonic.MediaType())
+	}
+	for k, vs := range params.header {
+		req.Header[k] = vs
+	}
+	return req, nil
+}
+
+// requestParams accumulates the values of an input
+// struct sorted by binding location.
+type requestParams struct {
+	path   map[string]string
+	query  url.Values
+	header http.Header
+	body   map[string]interface{}
+}
+
+// collect walks the fields of the struct value v, including
+// the fields of embedded structs, mirroring tonic's binding.
+func (p *requestParams) collect(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+
+		if sf.Anonymous {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := p.collect(fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		switch {
+		case sf.Tag.Get(tonic.PathTag) != "":
+			name, err := tonic.ParseTagKey(sf.Tag.Get(tonic.PathTag))
+			if err != nil {
+				return err
+			}
+			s, err := formatValues(fv)
+			if err != nil || len(s) != 1 {
+				return fmt.Errorf("path parameter %s: unsupported value %v", name, fv)
+			}
+			p.path[name] = s[0]
+		case sf.Tag.Get(tonic.QueryTag) != "":
+			name, err := tonic.ParseTagKey(sf.Tag.Get(tonic.QueryTag))
+			if err != nil {
+				return err
+			}
+			if fv.IsZero() {
+				continue
+			}
+			s, err := formatValues(fv)
+			if err != nil {
+				return fmt.Errorf("query parameter %s: %w", name, err)
+			}
+			if explode, err := strconv.ParseBool(sf.Tag.Get(tonic.ExplodeTag)); err == nil && !explode {
+				s = []string{strings.Join(s, ",")}
+			}
+			p.query[name] = append(p.query[name], s...)
+		case sf.Tag.Get(tonic.HeaderTag) != "":
+			name, err := tonic.ParseTagKey(sf.Tag.Get(tonic.HeaderTag))
+			if err != nil {
+				return err
+			}
+			if fv.IsZero() {
+				continue
+			}
+			s, err := formatValues(fv)
+			if err != nil || len(s) != 1 {
+				return fmt.Errorf("header %s: unsupported value %v", name, fv)
+			}
+			p.header.Set(name, s[0])
+		default:
+			name, omitEmpty := jsonFieldName(sf)
+			if name == "" || (omitEmpty && fv.IsZero()) {
+				continue
+			}
+			p.body[name] = fv.Interface()
+		}
+	}
+	return nil
+}
+
+// formatValues returns the string representations of v the
+// way tonic expects them: one value per element for slices
+// and arrays, one value otherwise.
+func formatValues(v reflect.Value) ([]string, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		if _, ok := v.Interface().(encoding.TextMarshaler); !ok {
+			out := make([]string, 0, v.Len())
+			for i := 0; i < v.Len(); i++ {
+				s, err := formatValues(v.Index(i))
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, s...)
+			}
+			return out, nil
+		}
+	}
+	switch i := v.Interface().(type) {
+	case encoding.TextMarshaler:
+		b, err := i.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return []string{string(b)}, nil
+	case time.Duration:
+		return []string{i.String()}, nil
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return []string{fmt.Sprint(v.Interface())}, nil
+	}
+	return nil, fmt.Errorf("unsupported parameter type %s", v.Type())
+}
+
+// jsonFieldName returns the name of a body field as encoding/json
+// would marshal it, and whether the field is omitted when empty.
+func jsonFieldName(sf reflect.StructField) (string, bool) {
+	tag, ok := sf.Tag.Lookup("json")
+	if !ok {
+		return sf.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	if parts[0] == "-" && len(parts) == 1 {
+		return "", false
+	}
+	name := parts[0]
+	if name == "" {
+		name = sf.Name
+	}
+	for _, o := range parts[1:] {
+		if o == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// expandPath substitutes the Gin path parameters of path
+// (:name and *name segments) with the given values.
+func expandPath(path string, values map[string]string) (string, error) {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if seg == "" || (seg[0] != ':' && seg[0] != '*') {
+			continue
+		}
+		v, ok := values[seg[1:]]
+		if !ok {
+			return "", fmt.Errorf("missing value for path parameter %s", seg[1:])
+		}
+		if seg[0] == '*' {
+			segments[i] = strings.TrimPrefix(v, "/")
+		} else {
+			segments[i] = url.PathEscape(v)
+		}
+	}
+	return strings.Join(segments, "/"), nil
+}
@@ -0,0 +1,121 @@
+package mkfsttest
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/config"
+	"mkfst/fizz"
+)
+
+type item struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type getItemIn struct {
+	ID     int    `path:"id"`
+	Suffix string `query:"suffix"`
+	Caller string `header:"X-Caller"`
+}
+
+type createItemIn struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type greeter struct{ msg string }
+
+func newItemsHarness(t *testing.T) *Harness {
+	h := New(t, config.Config{})
+	if _, err := h.DB.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	items := h.Service.Group("/items", "items", "Items")
+	items.Route("GET", "/:id", http.StatusOK, []fizz.OperationOption{},
+		func(c *gin.Context, db *sql.DB, in *getItemIn) (*item, error) {
+			out := &item{ID: in.ID}
+			if err := db.QueryRow(`SELECT name FROM items WHERE id = ?`, in.ID).Scan(&out.Name); err != nil {
+				return nil, err
+			}
+			out.Name += in.Suffix + in.Caller
+			return out, nil
+		},
+	)
+	items.Route("POST", "", http.StatusCreated, []fizz.OperationOption{},
+		func(c *gin.Context, db *sql.DB, in *createItemIn) (*item, error) {
+			res, err := db.Exec(`INSERT INTO items (name) VALUES (?)`, in.Name)
+			if err != nil {
+				return nil, err
+			}
+			id, _ := res.LastInsertId()
+			return &item{ID: int(id), Name: in.Name}, nil
+		},
+	)
+	return h
+}
+
+func TestCallRoundTrip(t *testing.T) {
+	h := newItemsHarness(t)
+	c := h.Client()
+
+	created := Call[item](c, "POST", "/items", &createItemIn{Name: "widget"}).
+		ExpectStatus(http.StatusCreated)
+	if created.Out.ID == 0 || created.Out.Name != "widget" {
+		t.Fatalf("unexpected created item: %+v", created.Out)
+	}
+
+	c.Header.Set("X-Caller", "!")
+	got := Call[item](c, "GET", "/items/:id", &getItemIn{ID: created.Out.ID, Suffix: "-v2"}).
+		ExpectStatus(http.StatusOK)
+	if got.Out.Name != "widget-v2!" {
+		t.Fatalf("expected widget-v2!, got %q", got.Out.Name)
+	}
+}
+
+func TestProvideSwapsDependency(t *testing.T) {
+	h := New(t, config.Config{})
+	h.Service.Provide(&greeter{msg: "real"})
+	h.Service.Route("GET", "/greet", http.StatusOK, []fizz.OperationOption{},
+		func(c *gin.Context, g *greeter) (string, error) {
+			return g.msg, nil
+		},
+	)
+	h.Provide(&greeter{msg: "fake"})
+
+	res := Call[string](h.Client(), "GET", "/greet", nil).ExpectStatus(http.StatusOK)
+	if res.Out != "fake" {
+		t.Fatalf("expected the swapped dependency, got %q", res.Out)
+	}
+}
+
+func TestValidateDetectsDrift(t *testing.T) {
+	h := New(t, config.Config{})
+	h.ValidateResponses = false
+	h.Service.Route("GET", "/list", http.StatusOK, []fizz.OperationOption{},
+		func(c *gin.Context) ([]item, error) {
+			return nil, nil
+		},
+	)
+
+	res := Call[[]item](h.Client(), "GET", "/list", nil).ExpectStatus(http.StatusOK)
+	if err := res.Validate(); err == nil {
+		t.Fatal("expected a null array to be reported as drift from the spec")
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	got, err := expandPath("/a/:id/*rest", map[string]string{"id": "x y", "rest": "/b/c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/a/x%20y/b/c" {
+		t.Fatalf("unexpected path %q", got)
+	}
+	if _, err := expandPath("/a/:id", nil); err == nil {
+		t.Fatal("expected an error for a missing path parameter")
+	}
+}
@@ -0,0 +1,77 @@
+package pagination
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// cursor is the decoded form of a keyset cursor. It holds
+// the sort key values of the row the page starts after.
+type cursor struct {
+	Sort string        `json:"s"`
+	Prev bool          `json:"p,omitempty"`
+	Keys []interface{} `json:"k"`
+}
+
+// timeKey wraps the time.Time keys, which would decode as strings.
+type timeKey struct {
+	T time.Time `json:"t"`
+}
+
+func encodeCursor(c cursor) string {
+	keys := make([]interface{}, len(c.Keys))
+	for i, k := range c.Keys {
+		switch v := k.(type) {
+		case time.Time:
+			keys[i] = timeKey{T: v}
+		case *time.Time:
+			keys[i] = timeKey{T: *v}
+		default:
+			keys[i] = k
+		}
+	}
+	c.Keys = keys
+	b, err := json.Marshal(c)
+	if err != nil {
+		// The keys are returned by the key function of NewPage,
+		// which must return values that can be stored in a cursor.
+		panic("pagination: cannot encode cursor: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var c cursor
+	if err := dec.Decode(&c); err != nil {
+		return nil, err
+	}
+	for i, k := range c.Keys {
+		switch v := k.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				c.Keys[i] = n
+			} else if f, err := v.Float64(); err == nil {
+				c.Keys[i] = f
+			} else {
+				return nil, err
+			}
+		case map[string]interface{}:
+			ts, _ := v["t"].(string)
+			t, err := time.Parse(time.RFC3339Nano, ts)
+			if err != nil {
+				return nil, err
+			}
+			c.Keys[i] = t
+		}
+	}
+	return &c, nil
+}
@@ -0,0 +1,128 @@
+package pagination
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/fizz"
+)
+
+// Page is the output of the list endpoints.
+type Page[T any] struct {
+	Items      []T    `json:"items" validate:"required" description:"Items of the page"`
+	HasMore    bool   `json:"has_more" description:"Whether another page follows"`
+	NextCursor string `json:"next_cursor,omitempty" description:"Cursor of the next page"`
+	PrevCursor string `json:"prev_cursor,omitempty" description:"Cursor of the previous page"`
+
+	req *Request
+}
+
+// TypeName implements openapi.Typer, naming the schema
+// of a Page[Pet] PagePet.
+func (*Page[T]) TypeName() string {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return "Page" + strings.Title(t.Name())
+}
+
+// NewPage returns the page of items, the rows returned by the query
+// built from the Clauses of r. key returns the value of the sort
+// field named field of an item, which is stored in the cursors; it
+// may be nil in offset mode.
+func NewPage[T any](r *Request, items []T, key func(item T, field string) interface{}) *Page[T] {
+	p := &Page[T]{Items: items, req: r}
+	if p.Items == nil {
+		p.Items = []T{}
+	}
+	extra := len(p.Items) > r.Limit
+	if extra {
+		p.Items = p.Items[:r.Limit]
+	}
+	if r.offset {
+		p.HasMore = extra
+		return p
+	}
+	backward := r.Backward()
+	if backward {
+		// The rows were fetched in reverse order.
+		for i, j := 0, len(p.Items)-1; i < j; i, j = i+1, j-1 {
+			p.Items[i], p.Items[j] = p.Items[j], p.Items[i]
+		}
+	}
+	if len(p.Items) == 0 {
+		return p
+	}
+	keys := func(item T) []interface{} {
+		ks := make([]interface{}, len(r.Sort))
+		for i, f := range r.Sort {
+			ks[i] = key(item, f.Name)
+		}
+		return ks
+	}
+	// Going forward, a next page exists if the query returned an
+	// extra row, and a previous one if the request had a cursor.
+	// Going backward, it is the opposite.
+	if (!backward && extra) || backward {
+		p.NextCursor = encodeCursor(cursor{Sort: r.sort, Keys: keys(p.Items[len(p.Items)-1])})
+	}
+	if (backward && extra) || (!backward && r.cursor != nil) {
+		p.PrevCursor = encodeCursor(cursor{Sort: r.sort, Prev: true, Keys: keys(p.Items[0])})
+	}
+	p.HasMore = p.NextCursor != ""
+
+	return p
+}
+
+// WriteLinks sets the RFC 8288 Link header of the response,
+// with the first, next and prev links of the page relative to
+// the request URL.
+func (p *Page[T]) WriteLinks(c *gin.Context) *Page[T] {
+	u := *c.Request.URL
+	link := func(rel string, set map[string]string) string {
+		q := u.Query()
+		q.Del("cursor")
+		q.Del("offset")
+		for k, v := range set {
+			q.Set(k, v)
+		}
+		lu := url.URL{Path: u.Path, RawQuery: q.Encode()}
+		return "<" + lu.String() + `>; rel="` + rel + `"`
+	}
+	links := []string{link("first", nil)}
+
+	if p.req != nil && p.req.offset {
+		if p.HasMore {
+			links = append(links, link("next", map[string]string{"offset": strconv.Itoa(p.req.Offset + p.req.Limit)}))
+		}
+		if p.req.Offset > 0 {
+			prev := p.req.Offset - p.req.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			links = append(links, link("prev", map[string]string{"offset": strconv.Itoa(prev)}))
+		}
+	} else {
+		if p.NextCursor != "" {
+			links = append(links, link("next", map[string]string{"cursor": p.NextCursor}))
+		}
+		if p.PrevCursor != "" {
+			links = append(links, link("prev", map[string]string{"cursor": p.PrevCursor}))
+		}
+	}
+	c.Header("Link", strings.Join(links, ", "))
+
+	return p
+}
+
+// Links documents the Link header set by WriteLinks on the
+// responses of an operation. Clients generated by mkfst sdk
+// use it to iterate over the pages.
+func Links() fizz.OperationOption {
+	return fizz.Header("Link", "RFC 8288 links to the first, next and previous pages", "")
+}
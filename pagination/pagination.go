@@ -0,0 +1,466 @@
+// Package pagination provides the inputs, outputs and SQL helpers
+// of paginated list endpoints.
+//
+// A list handler embeds Cursor (keyset pagination) or Offset in its
+// input, and optionally Filter. The tags of the embedding field
+// configure the endpoint:
+//
+//	type ListPetsIn struct {
+//		pagination.Cursor `sort:"name,created=created_at" defaultsort:"-created" maxlimit:"50"`
+//		pagination.Filter `filter:"kind,owner=owner_id"`
+//	}
+//
+// sort lists the fields the client may sort by, as name or
+// name=column; key names the unique field breaking ties (id by
+// default), and maxlimit caps the page size (DefaultMaxLimit by
+// default). tonic binds and validates the parameters, and the
+// OpenAPI documentation of the operation reflects the tags.
+//
+// The handler calls Parse to obtain a Request, Request.SQL to build
+// the ORDER BY, WHERE and LIMIT clauses of its query, and NewPage to
+// build the Page returned to the client.
+package pagination
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+
+	"mkfst/fizz/openapi"
+	"mkfst/tonic"
+)
+
+const (
+	// DefaultLimit is the page size used when the client omits
+	// limit, or the max limit of the endpoint if it is lower.
+	DefaultLimit = 20
+	// DefaultMaxLimit is the largest page size accepted when the
+	// embedding field has no maxlimit tag.
+	DefaultMaxLimit = 100
+	// DefaultKey is the unique field breaking ties between rows
+	// when the embedding field has no key tag.
+	DefaultKey = "id"
+)
+
+// Cursor is embedded in the input of the list endpoints using
+// keyset pagination. The cursor is opaque to the client: it is
+// taken from the next_cursor and prev_cursor fields of a Page,
+// or from its Link header.
+type Cursor struct {
+	Limit  int    `query:"limit" description:"Maximum number of items returned"`
+	Cursor string `query:"cursor" description:"Opaque cursor of the page to return, as returned by the previous page"`
+	Sort   string `query:"sort" description:"Comma-separated sort fields, prefixed with - for descending order"`
+}
+
+// Offset is embedded in the input of the list endpoints using
+// offset pagination.
+type Offset struct {
+	Limit  int    `query:"limit" description:"Maximum number of items returned"`
+	Offset int    `query:"offset" validate:"min=0" description:"Number of items skipped"`
+	Sort   string `query:"sort" description:"Comma-separated sort fields, prefixed with - for descending order"`
+}
+
+// Filter is embedded in the input of the list endpoints accepting
+// filters, given as field:value or field:op:value where op is one
+// of eq, ne, lt, lte, gt, gte and prefix.
+type Filter struct {
+	Filters []string `query:"filter" description:"Filters, as field:value or field:op:value"`
+}
+
+// Error is the error returned by Parse for an invalid parameter.
+type Error struct {
+	Param   string // name of the query parameter
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("pagination: invalid %s: %s", e.Param, e.Message)
+}
+
+// SortField is a field of the sort order of a Request.
+type SortField struct {
+	Name   string // name used by the client
+	Column string // SQL column
+	Desc   bool
+}
+
+// Condition is a filter of a Request.
+type Condition struct {
+	Name   string // name used by the client
+	Column string // SQL column
+	Op     string
+	Value  string
+}
+
+// Request is a parsed pagination input.
+type Request struct {
+	Limit   int
+	Offset  int
+	Sort    []SortField
+	Filters []Condition
+
+	offset bool    // offset pagination
+	sort   string  // canonical sort order, stored in cursors
+	cursor *cursor // decoded cursor, nil on the first page
+}
+
+// Backward reports whether the request pages backward,
+// from a previous-page cursor.
+func (r *Request) Backward() bool {
+	return r.cursor != nil && r.cursor.Prev
+}
+
+// tagConfig is the configuration read from the tags of an embedding field.
+type tagConfig struct {
+	fields      map[string]string // sortable name -> column
+	names       []string          // sortable names, in declaration order
+	defaultSort string
+	key         string
+	maxLimit    int
+	filters     map[string]string // filterable name -> column
+	filterNames []string
+}
+
+var configs sync.Map // reflect.StructTag -> *tagConfig
+
+func configFor(tag reflect.StructTag) (*tagConfig, error) {
+	if c, ok := configs.Load(tag); ok {
+		return c.(*tagConfig), nil
+	}
+	c := &tagConfig{
+		fields:      map[string]string{},
+		filters:     map[string]string{},
+		defaultSort: tag.Get("defaultsort"),
+		key:         DefaultKey,
+		maxLimit:    DefaultMaxLimit,
+	}
+	c.names = parseColumns(tag.Get("sort"), c.fields)
+	c.filterNames = parseColumns(tag.Get("filter"), c.filters)
+
+	if k := tag.Get("key"); k != "" {
+		c.key = k
+	}
+	// The key is always sortable, since it ends every sort order.
+	keyCols := map[string]string{}
+	kn := parseColumns(c.key, keyCols)
+	if len(kn) != 1 {
+		return nil, fmt.Errorf("pagination: invalid key tag %q", c.key)
+	}
+	c.key = kn[0]
+	if _, ok := c.fields[c.key]; !ok {
+		c.fields[c.key] = keyCols[c.key]
+		c.names = append(c.names, c.key)
+	}
+	if m := tag.Get("maxlimit"); m != "" {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("pagination: invalid maxlimit tag %q", m)
+		}
+		c.maxLimit = n
+	}
+	if c.defaultSort == "" {
+		c.defaultSort = c.key
+	}
+	configs.Store(tag, c)
+
+	return c, nil
+}
+
+// parseColumns parses a comma-separated list of name or name=column
+// into m, and returns the names in order.
+func parseColumns(s string, m map[string]string) []string {
+	var names []string
+	for _, f := range strings.Split(s, ",") {
+		name, col, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if name == "" {
+			continue
+		}
+		if !ok || col == "" {
+			col = name
+		}
+		m[name] = col
+		names = append(names, name)
+	}
+	return names
+}
+
+// sortOrder parses the sort parameter s, and returns the sort
+// fields ending with the key, and their canonical representation.
+func (c *tagConfig) sortOrder(s string) ([]SortField, string, error) {
+	if strings.TrimSpace(s) == "" {
+		s = c.defaultSort
+	}
+	var (
+		fields []SortField
+		seen   = map[string]bool{}
+	)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		desc := strings.HasPrefix(f, "-")
+		f = strings.TrimLeft(f, "+-")
+		col, ok := c.fields[f]
+		if !ok {
+			return nil, "", &Error{Param: "sort", Message: fmt.Sprintf("cannot sort by %q, allowed fields are %s", f, strings.Join(c.names, ", "))}
+		}
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		fields = append(fields, SortField{Name: f, Column: col, Desc: desc})
+	}
+	if !seen[c.key] {
+		fields = append(fields, SortField{Name: c.key, Column: c.fields[c.key]})
+	}
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f.Name
+		if f.Desc {
+			parts[i] = "-" + f.Name
+		}
+	}
+	return fields, strings.Join(parts, ","), nil
+}
+
+var filterOps = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "lte": true, "gt": true, "gte": true, "prefix": true,
+}
+
+func (c *tagConfig) conditions(filters []string) ([]Condition, error) {
+	var conds []Condition
+	for _, f := range filters {
+		name, rest, ok := strings.Cut(f, ":")
+		if !ok {
+			return nil, &Error{Param: "filter", Message: fmt.Sprintf("%q is not of the form field:value", f)}
+		}
+		col, ok := c.filters[name]
+		if !ok {
+			return nil, &Error{Param: "filter", Message: fmt.Sprintf("cannot filter by %q, allowed fields are %s", name, strings.Join(c.filterNames, ", "))}
+		}
+		op, value := "eq", rest
+		if o, v, ok := strings.Cut(rest, ":"); ok && filterOps[o] {
+			op, value = o, v
+		}
+		conds = append(conds, Condition{Name: name, Column: col, Op: op, Value: value})
+	}
+	return conds, nil
+}
+
+func (c *tagConfig) limit(n int) (int, error) {
+	switch {
+	case n == 0:
+		return c.defaultLimit(), nil
+	case n < 0:
+		return 0, &Error{Param: "limit", Message: "must be positive"}
+	}
+	if n > c.maxLimit {
+		return 0, &Error{Param: "limit", Message: fmt.Sprintf("must be at most %d", c.maxLimit)}
+	}
+	return n, nil
+}
+
+// defaultLimit returns the page size used when the client omits limit.
+func (c *tagConfig) defaultLimit() int {
+	if c.maxLimit < DefaultLimit {
+		return c.maxLimit
+	}
+	return DefaultLimit
+}
+
+func (p Cursor) request(tag reflect.StructTag) (*Request, error) {
+	c, err := configFor(tag)
+	if err != nil {
+		return nil, err
+	}
+	r := &Request{}
+	if r.Limit, err = c.limit(p.Limit); err != nil {
+		return nil, err
+	}
+	if r.Sort, r.sort, err = c.sortOrder(p.Sort); err != nil {
+		return nil, err
+	}
+	if p.Cursor != "" {
+		if r.cursor, err = decodeCursor(p.Cursor); err != nil {
+			return nil, &Error{Param: "cursor", Message: "malformed cursor"}
+		}
+		if r.cursor.Sort != r.sort || len(r.cursor.Keys) != len(r.Sort) {
+			return nil, &Error{Param: "cursor", Message: "the cursor does not match the sort order"}
+		}
+	}
+	return r, nil
+}
+
+func (p Offset) request(tag reflect.StructTag) (*Request, error) {
+	c, err := configFor(tag)
+	if err != nil {
+		return nil, err
+	}
+	r := &Request{offset: true, Offset: p.Offset}
+	if r.Limit, err = c.limit(p.Limit); err != nil {
+		return nil, err
+	}
+	if p.Offset < 0 {
+		return nil, &Error{Param: "offset", Message: "must not be negative"}
+	}
+	if r.Sort, r.sort, err = c.sortOrder(p.Sort); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+var (
+	tofCursor = reflect.TypeOf(Cursor{})
+	tofOffset = reflect.TypeOf(Offset{})
+	tofFilter = reflect.TypeOf(Filter{})
+)
+
+// Parse returns the pagination request of in, a list endpoint input
+// embedding a Cursor or an Offset. The parameters were validated by
+// tonic already, so Parse only fails when in embeds neither.
+func Parse(in interface{}) (*Request, error) {
+	v := reflect.Indirect(reflect.ValueOf(in))
+	if v.Kind() != reflect.Struct {
+		return nil, errors.New("pagination: input is not a struct")
+	}
+	var (
+		r      *Request
+		err    error
+		filter *reflect.StructField
+		fv     reflect.Value
+	)
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		switch sf.Type {
+		case tofCursor:
+			r, err = v.Field(i).Interface().(Cursor).request(sf.Tag)
+		case tofOffset:
+			r, err = v.Field(i).Interface().(Offset).request(sf.Tag)
+		case tofFilter:
+			filter, fv = &sf, v.Field(i)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r == nil {
+		return nil, fmt.Errorf("pagination: %s embeds neither a Cursor nor an Offset", v.Type())
+	}
+	if filter != nil {
+		c, err := configFor(filter.Tag)
+		if err != nil {
+			return nil, err
+		}
+		if r.Filters, err = c.conditions(fv.Interface().(Filter).Filters); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func init() {
+	tonic.RegisterStructValidation(validate, Cursor{}, Offset{}, Filter{})
+}
+
+// validate is the struct-level validation of the embeds, run by tonic
+// when it binds an input. It checks the parameters against the tags of
+// the embedding field.
+func validate(sl validator.StructLevel) {
+	tag := embeddingTag(sl.Parent(), sl.Current().Type())
+
+	var err error
+	switch p := sl.Current().Interface().(type) {
+	case Cursor:
+		_, err = p.request(tag)
+	case Offset:
+		_, err = p.request(tag)
+	case Filter:
+		var c *tagConfig
+		if c, err = configFor(tag); err == nil {
+			_, err = c.conditions(p.Filters)
+		}
+	}
+	var perr *Error
+	switch {
+	case errors.As(err, &perr):
+		field := strings.ToUpper(perr.Param[:1]) + perr.Param[1:]
+		if perr.Param == "filter" {
+			field = "Filters"
+		}
+		sl.ReportError(sl.Current().FieldByName(field).Interface(), perr.Param, field, perr.Param, perr.Message)
+	case err != nil:
+		sl.ReportError(sl.Current().Interface(), sl.Current().Type().Name(), sl.Current().Type().Name(), "pagination", err.Error())
+	}
+}
+
+// embeddingTag returns the tag of the field of parent holding a value of type t.
+func embeddingTag(parent reflect.Value, t reflect.Type) reflect.StructTag {
+	for parent.Kind() == reflect.Ptr || parent.Kind() == reflect.Interface {
+		parent = parent.Elem()
+	}
+	if parent.Kind() != reflect.Struct {
+		return ""
+	}
+	for i := 0; i < parent.NumField(); i++ {
+		if sf := parent.Type().Field(i); sf.Type == t {
+			return sf.Tag
+		}
+	}
+	return ""
+}
+
+// DocumentParameters implements openapi.ParametersDocumenter.
+func (Cursor) DocumentParameters(tag reflect.StructTag, params []*openapi.Parameter) {
+	documentParams(tag, params)
+}
+
+// DocumentParameters implements openapi.ParametersDocumenter.
+func (Offset) DocumentParameters(tag reflect.StructTag, params []*openapi.Parameter) {
+	documentParams(tag, params)
+}
+
+// DocumentParameters implements openapi.ParametersDocumenter.
+func (Filter) DocumentParameters(tag reflect.StructTag, params []*openapi.Parameter) {
+	c, err := configFor(tag)
+	if err != nil {
+		return
+	}
+	for _, p := range params {
+		if p.Name == "filter" {
+			p.Description += fmt.Sprintf(". Filterable fields: %s", strings.Join(c.filterNames, ", "))
+		}
+	}
+}
+
+func documentParams(tag reflect.StructTag, params []*openapi.Parameter) {
+	c, err := configFor(tag)
+	if err != nil {
+		return
+	}
+	for _, p := range params {
+		var s *openapi.Schema
+		if p.Schema != nil {
+			s = p.Schema.Schema
+		}
+		switch p.Name {
+		case "limit":
+			if s != nil {
+				s.Minimum = openapi.Float(1)
+				s.Maximum = openapi.Float(float64(c.maxLimit))
+				s.Default = c.defaultLimit()
+			}
+		case "offset":
+			if s != nil {
+				s.Minimum = openapi.Float(0)
+			}
+		case "sort":
+			p.Description += fmt.Sprintf(". Sortable fields: %s", strings.Join(c.names, ", "))
+			if s != nil {
+				s.Default = c.defaultSort
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package pagination
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/config"
+	"mkfst/fizz"
+	"mkfst/mkfsttest"
+)
+
+type pet struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type listPetsIn struct {
+	Cursor `sort:"name,kind" defaultsort:"name" maxlimit:"10"`
+	Filter `filter:"kind,name"`
+}
+
+type listPetsOffsetIn struct {
+	Offset `sort:"name"`
+}
+
+func petKey(p pet, field string) interface{} {
+	switch field {
+	case "name":
+		return p.Name
+	case "kind":
+		return p.Kind
+	}
+	return p.ID
+}
+
+func queryPets(db *sql.DB, r *Request) ([]pet, error) {
+	cl, err := r.SQL("sqlite")
+	if err != nil {
+		return nil, err
+	}
+	q := "SELECT id, name, kind FROM pets"
+	if cl.Where != "" {
+		q += " WHERE " + cl.Where
+	}
+	rows, err := db.Query(q+" "+cl.OrderBy+" "+cl.Limit, cl.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pets []pet
+	for rows.Next() {
+		var p pet
+		if err := rows.Scan(&p.ID, &p.Name, &p.Kind); err != nil {
+			return nil, err
+		}
+		pets = append(pets, p)
+	}
+	return pets, rows.Err()
+}
+
+func newPetsHarness(t *testing.T) *mkfsttest.Harness {
+	h := mkfsttest.New(t, config.Config{})
+	if _, err := h.DB.Exec(`CREATE TABLE pets (id INTEGER PRIMARY KEY, name TEXT NOT NULL, kind TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	// Duplicate names exercise the tiebreaker.
+	for i, name := range []string{"ace", "bo", "bo", "cy", "dot", "eve", "fay"} {
+		kind := "cat"
+		if i%2 == 1 {
+			kind = "dog"
+		}
+		if _, err := h.DB.Exec(`INSERT INTO pets (name, kind) VALUES (?, ?)`, name, kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pets := h.Service.Group("/pets", "pets", "Pets")
+	pets.Route("GET", "", http.StatusOK, []fizz.OperationOption{Links()},
+		func(c *gin.Context, db *sql.DB, in *listPetsIn) (*Page[pet], error) {
+			r, err := Parse(in)
+			if err != nil {
+				return nil, err
+			}
+			items, err := queryPets(db, r)
+			if err != nil {
+				return nil, err
+			}
+			return NewPage(r, items, petKey).WriteLinks(c), nil
+		})
+	pets.Route("GET", "/offset", http.StatusOK, []fizz.OperationOption{Links()},
+		func(c *gin.Context, db *sql.DB, in *listPetsOffsetIn) (*Page[pet], error) {
+			r, err := Parse(in)
+			if err != nil {
+				return nil, err
+			}
+			items, err := queryPets(db, r)
+			if err != nil {
+				return nil, err
+			}
+			return NewPage(r, items, nil).WriteLinks(c), nil
+		})
+	return h
+}
+
+var linkRe = regexp.MustCompile(`<([^>]*)>; rel="(\w+)"`)
+
+// get fetches url, and returns the page and its links by relation.
+func get(t *testing.T, h *mkfsttest.Harness, url string) (*Page[pet], map[string]string) {
+	t.Helper()
+	w := h.Client().Do(httptest.NewRequest("GET", url, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", url, w.Code, w.Body)
+	}
+	var p Page[pet]
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{}
+	for _, m := range linkRe.FindAllStringSubmatch(w.Header().Get("Link"), -1) {
+		links[m[2]] = m[1]
+	}
+	return &p, links
+}
+
+func ids(p *Page[pet]) string {
+	var s []string
+	for _, it := range p.Items {
+		s = append(s, fmt.Sprint(it.ID))
+	}
+	return strings.Join(s, ",")
+}
+
+func TestCursorPagination(t *testing.T) {
+	h := newPetsHarness(t)
+
+	p, links := get(t, h, "/pets?limit=3")
+	if ids(p) != "1,2,3" || !p.HasMore || p.PrevCursor != "" || links["prev"] != "" {
+		t.Fatalf("first page: %s %+v %v", ids(p), p, links)
+	}
+	p, links = get(t, h, links["next"])
+	if ids(p) != "4,5,6" || !p.HasMore || links["prev"] == "" {
+		t.Fatalf("second page: %s %+v", ids(p), links)
+	}
+	p, _ = get(t, h, links["next"])
+	if ids(p) != "7" || p.HasMore || p.NextCursor != "" {
+		t.Fatalf("last page: %s %+v", ids(p), p)
+	}
+	p, links = get(t, h, "/pets?limit=3&cursor="+p.PrevCursor)
+	if ids(p) != "4,5,6" || !p.HasMore {
+		t.Fatalf("previous page: %s %+v", ids(p), p)
+	}
+	p, _ = get(t, h, links["prev"])
+	if ids(p) != "1,2,3" || p.PrevCursor != "" {
+		t.Fatalf("first page again: %s %+v", ids(p), p)
+	}
+
+	p, _ = get(t, h, "/pets?sort=-name&limit=3")
+	if ids(p) != "7,6,5" {
+		t.Fatalf("descending: %s", ids(p))
+	}
+	p, links = get(t, h, "/pets?filter=kind:dog&limit=2")
+	if ids(p) != "2,4" || !p.HasMore {
+		t.Fatalf("filtered: %s %+v", ids(p), p)
+	}
+	if p, _ = get(t, h, links["next"]); ids(p) != "6" || p.HasMore {
+		t.Fatalf("filtered next page: %s %+v", ids(p), p)
+	}
+	if p, _ = get(t, h, "/pets?filter=kind:eq:dog&filter=name:prefix:b"); ids(p) != "2" {
+		t.Fatalf("prefix filter: %s", ids(p))
+	}
+}
+
+func TestOffsetPagination(t *testing.T) {
+	h := newPetsHarness(t)
+
+	p, links := get(t, h, "/pets/offset?limit=4")
+	if ids(p) != "1,2,3,4" || !p.HasMore {
+		t.Fatalf("first page: %s", ids(p))
+	}
+	p, links = get(t, h, links["next"])
+	if ids(p) != "5,6,7" || p.HasMore || links["prev"] != "/pets/offset?limit=4&offset=0" {
+		t.Fatalf("second page: %s %v", ids(p), links)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	h := newPetsHarness(t)
+
+	for _, q := range []string{
+		"limit=11",
+		"limit=-1",
+		"sort=id,-secret",
+		"cursor=garbage",
+		"filter=id:1",
+		"filter=kind",
+	} {
+		w := h.Client().Do(httptest.NewRequest("GET", "/pets?"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", q, w.Code, w.Body)
+		}
+	}
+	// A cursor is bound to the sort order of the page that returned it.
+	p, _ := get(t, h, "/pets?limit=1")
+	w := h.Client().Do(httptest.NewRequest("GET", "/pets?sort=kind&cursor="+p.NextCursor, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("mismatched cursor: expected 400, got %d", w.Code)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	op := newPetsHarness(t).Spec().Operation("GET", "/pets")
+	if op == nil {
+		t.Fatal("missing operation")
+	}
+	for _, p := range op.Parameters {
+		switch p.Name {
+		case "limit":
+			if s := p.Schema.Schema; s.Maximum == nil || *s.Maximum != 10 || s.Minimum == nil || *s.Minimum != 1 || s.Default != 10 {
+				t.Errorf("limit: %v..%v, default %v", s.Minimum, s.Maximum, s.Default)
+			}
+		case "sort":
+			if !strings.Contains(p.Description, "name, kind, id") || p.Schema.Default != "name" {
+				t.Errorf("sort: %q %v", p.Description, p.Schema.Default)
+			}
+		}
+	}
+	if op.ResponseFor(http.StatusOK).Content["application/json"].Schema.Reference.Ref != "#/components/schemas/PagePet" {
+		t.Errorf("unexpected page schema")
+	}
+}
+
+func TestSQL(t *testing.T) {
+	r, err := Parse(&listPetsIn{Cursor: Cursor{Limit: 5, Sort: "-kind"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.cursor = &cursor{Sort: r.sort, Keys: []interface{}{"dog", int64(4)}}
+
+	cl, err := r.SQL("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `(("kind" < ?) OR ("kind" = ? AND "id" > ?))`; cl.Where != want {
+		t.Errorf("where: %s", cl.Where)
+	}
+	if cl.OrderBy != `ORDER BY "kind" DESC, "id" ASC` || cl.Limit != "LIMIT 6" {
+		t.Errorf("order/limit: %s %s", cl.OrderBy, cl.Limit)
+	}
+	if got := Rebind("postgres", "a = ? AND b = ?"); got != "a = $1 AND b = $2" {
+		t.Errorf("rebind: %s", got)
+	}
+	if cl, _ = r.SQL("mysql"); !strings.HasPrefix(cl.OrderBy, "ORDER BY `kind` DESC") {
+		t.Errorf("mysql order: %s", cl.OrderBy)
+	}
+}
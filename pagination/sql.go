@@ -0,0 +1,166 @@
+package pagination
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Clauses are the SQL fragments implementing a Request, with
+// ? placeholders (see Rebind). They are assembled by the
+// handler around its own query:
+//
+//	cl, err := req.SQL(conn.Config.Type)
+//	q := "SELECT id, name, created_at FROM pets"
+//	if cl.Where != "" {
+//		q += " WHERE " + cl.Where
+//	}
+//	q += " " + cl.OrderBy + " " + cl.Limit
+//	rows, err := conn.Conn.QueryContext(ctx, pagination.Rebind(conn.Config.Type, q), cl.Args...)
+//
+// The columns are the ones declared by the tags of the input,
+// never the names sent by the client, and every value is passed
+// as an argument. Keyset pagination requires the sort columns
+// to be NOT NULL.
+type Clauses struct {
+	// Where is the condition selecting the rows of the page, without
+	// the WHERE keyword; empty on an unfiltered first page.
+	Where string
+	// Args are the arguments of the placeholders of Where.
+	Args []interface{}
+	// OrderBy is the ORDER BY clause.
+	OrderBy string
+	// Limit is the LIMIT clause, and the OFFSET clause in offset mode.
+	// It fetches one more row than the page size, letting NewPage
+	// tell whether another page follows.
+	Limit string
+}
+
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+	dialectMySQL
+)
+
+func dialectFor(connType string) (dialect, error) {
+	switch strings.ToUpper(connType) {
+	case "", "SQLITE":
+		return dialectSQLite, nil
+	case "POSTGRESQL", "POSTGRES":
+		return dialectPostgres, nil
+	case "MYSQL":
+		return dialectMySQL, nil
+	default:
+		return 0, fmt.Errorf("pagination: unsupported db type %q", connType)
+	}
+}
+
+// quote quotes the column col, which may be qualified by a table name.
+func (d dialect) quote(col string) string {
+	q := `"`
+	if d == dialectMySQL {
+		q = "`"
+	}
+	parts := strings.Split(col, ".")
+	for i, p := range parts {
+		parts[i] = q + strings.ReplaceAll(p, q, q+q) + q
+	}
+	return strings.Join(parts, ".")
+}
+
+// SQL returns the clauses implementing the request for the db type
+// connType (the Type of a db.ConnectionInfo).
+func (r *Request) SQL(connType string) (Clauses, error) {
+	d, err := dialectFor(connType)
+	if err != nil {
+		return Clauses{}, err
+	}
+	var (
+		cl    Clauses
+		conds []string
+	)
+	for _, f := range r.Filters {
+		col := d.quote(f.Column)
+		switch f.Op {
+		case "prefix":
+			conds = append(conds, col+" LIKE ? ESCAPE '!'")
+			cl.Args = append(cl.Args, likeEscaper.Replace(f.Value)+"%")
+			continue
+		case "ne":
+			conds = append(conds, col+" <> ?")
+		case "lt":
+			conds = append(conds, col+" < ?")
+		case "lte":
+			conds = append(conds, col+" <= ?")
+		case "gt":
+			conds = append(conds, col+" > ?")
+		case "gte":
+			conds = append(conds, col+" >= ?")
+		default:
+			conds = append(conds, col+" = ?")
+		}
+		cl.Args = append(cl.Args, f.Value)
+	}
+	backward := r.Backward()
+
+	if !r.offset && r.cursor != nil {
+		// (a > ?) OR (a = ? AND b > ?) OR ...
+		var or []string
+		for i := range r.Sort {
+			var and []string
+			for j := 0; j < i; j++ {
+				and = append(and, d.quote(r.Sort[j].Column)+" = ?")
+				cl.Args = append(cl.Args, r.cursor.Keys[j])
+			}
+			op := ">"
+			if r.Sort[i].Desc != backward {
+				op = "<"
+			}
+			and = append(and, d.quote(r.Sort[i].Column)+" "+op+" ?")
+			cl.Args = append(cl.Args, r.cursor.Keys[i])
+			or = append(or, "("+strings.Join(and, " AND ")+")")
+		}
+		conds = append(conds, "("+strings.Join(or, " OR ")+")")
+	}
+	cl.Where = strings.Join(conds, " AND ")
+
+	order := make([]string, len(r.Sort))
+	for i, f := range r.Sort {
+		order[i] = d.quote(f.Column) + " ASC"
+		if f.Desc != backward {
+			order[i] = d.quote(f.Column) + " DESC"
+		}
+	}
+	cl.OrderBy = "ORDER BY " + strings.Join(order, ", ")
+
+	cl.Limit = "LIMIT " + strconv.Itoa(r.Limit+1)
+	if r.offset && r.Offset > 0 {
+		cl.Limit += " OFFSET " + strconv.Itoa(r.Offset)
+	}
+	return cl, nil
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// Rebind replaces the ? placeholders of query by the placeholders
+// of the db type connType, $1, $2... for PostgreSQL.
+func Rebind(connType, query string) string {
+	if d, _ := dialectFor(connType); d != dialectPostgres {
+		return query
+	}
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
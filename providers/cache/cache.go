@@ -69,3 +69,13 @@ type Cache interface {
 	// every method returns ErrClosed.
 	Close() error
 }
+
+// Adder is implemented by the backends storing a key only if it is
+// absent, atomically — memory, Redis and SQL all do. Used for the
+// single-use markers a Get then Set would let two concurrent callers
+// both claim, i.e. the consumed password reset tokens.
+type Adder interface {
+	// Add stores value under key like Set, unless the key is present
+	// and not expired. Reports whether the value was stored.
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+}
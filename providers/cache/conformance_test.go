@@ -181,6 +181,61 @@ func runCacheConformance(t *testing.T, name string, factory func(t *testing.T) C
 		}
 	})
 
+	t.Run(name+"/AddOnlyIfAbsent", func(t *testing.T) {
+		c := factory(t)
+		defer c.Close()
+		a, ok := c.(Adder)
+		if !ok {
+			t.Fatalf("%T is not an Adder", c)
+		}
+		ctx := context.Background()
+		if added, err := a.Add(ctx, "k", []byte("v1"), 50*time.Millisecond); err != nil || !added {
+			t.Fatalf("first add: added=%v err=%v", added, err)
+		}
+		if added, err := a.Add(ctx, "k", []byte("v2"), 0); err != nil || added {
+			t.Fatalf("second add: added=%v err=%v", added, err)
+		}
+		if got, _, _ := c.Get(ctx, "k"); string(got) != "v1" {
+			t.Fatalf("got %q want v1", got)
+		}
+		time.Sleep(80 * time.Millisecond)
+		// An expired entry is replaced.
+		if added, err := a.Add(ctx, "k", []byte("v3"), 0); err != nil || !added {
+			t.Fatalf("add after expiry: added=%v err=%v", added, err)
+		}
+		if got, _, _ := c.Get(ctx, "k"); string(got) != "v3" {
+			t.Fatalf("got %q want v3", got)
+		}
+	})
+
+	t.Run(name+"/ConcurrentAddOnce", func(t *testing.T) {
+		c := factory(t)
+		defer c.Close()
+		a := c.(Adder)
+		ctx := context.Background()
+		const N = 50
+
+		var wg sync.WaitGroup
+		var added, errs atomic.Int64
+		for i := 0; i < N; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, err := a.Add(ctx, "k", []byte("v"), time.Minute)
+				if err != nil {
+					errs.Add(1)
+				}
+				if ok {
+					added.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if errs.Load() != 0 || added.Load() != 1 {
+			t.Fatalf("added %d times, %d errors", added.Load(), errs.Load())
+		}
+	})
+
 	t.Run(name+"/ClosedReturnsErrClosed", func(t *testing.T) {
 		c := factory(t)
 		_ = c.Set(context.Background(), "k", []byte("v"), 0)
@@ -88,7 +88,24 @@ func (c *memoryCache) Set(ctx context.Context, key string, value []byte, ttl tim
 	if c.closed {
 		return ErrClosed
 	}
+	c.setLocked(key, value, ttl)
+	return nil
+}
+
+func (c *memoryCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false, ErrClosed
+	}
+	if entry, ok := c.entries[key]; ok && (entry.expiresAt.IsZero() || !entry.expiresAt.Before(c.now())) {
+		return false, nil
+	}
+	c.setLocked(key, value, ttl)
+	return true, nil
+}
 
+func (c *memoryCache) setLocked(key string, value []byte, ttl time.Duration) {
 	// Replace existing entry under the same key.
 	if existing, ok := c.entries[key]; ok {
 		c.evictLocked(existing)
@@ -106,7 +123,6 @@ func (c *memoryCache) Set(ctx context.Context, key string, value []byte, ttl tim
 	c.curBytes += int64(len(value))
 
 	c.evictToBudgetLocked()
-	return nil
 }
 
 func (c *memoryCache) Delete(ctx context.Context, key string) error {
@@ -63,6 +63,17 @@ func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time
 	return nil
 }
 
+func (c *redisCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if c.closed {
+		return false, ErrClosed
+	}
+	added, err := c.client.SetNX(ctx, c.k(key), value, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis setnx: %w", err)
+	}
+	return added, nil
+}
+
 func (c *redisCache) Delete(ctx context.Context, key string) error {
 	if c.closed {
 		return ErrClosed
@@ -199,6 +199,56 @@ func (c *sqlCache) Set(ctx context.Context, key string, value []byte, ttl time.D
 	return nil
 }
 
+// Add inserts the row, or replaces it only if it expired: the
+// conflicting row is updated under the WHERE (PG, SQLite) or the IF
+// (MySQL) of the upsert, so the check and the write are one statement.
+func (c *sqlCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if c.closed {
+		return false, ErrClosed
+	}
+	var expires interface{}
+	if ttl > 0 {
+		expires = c.encodeTime(c.now().Add(ttl))
+	}
+	now := c.encodeTime(c.now())
+	t := c.table()
+
+	var q string
+	args := []interface{}{key, value, expires, now}
+	switch c.dialect {
+	case sqlDialectPostgres:
+		q = c.rebind(`INSERT INTO ` + t + ` (cache_key, cache_value, expires_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT (cache_key) DO UPDATE
+			  SET cache_value = EXCLUDED.cache_value, expires_at = EXCLUDED.expires_at
+			  WHERE ` + t + `.expires_at IS NOT NULL AND ` + t + `.expires_at <= ?`)
+	case sqlDialectMySQL:
+		// cache_value is assigned first, so both IFs see the old expires_at
+		q = `INSERT INTO ` + t + ` (cache_key, cache_value, expires_at)
+			VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			  cache_value = IF(expires_at IS NOT NULL AND expires_at <= ?, VALUES(cache_value), cache_value),
+			  expires_at = IF(expires_at IS NOT NULL AND expires_at <= ?, VALUES(expires_at), expires_at)`
+		args = append(args, now)
+	default: // SQLite
+		q = `INSERT INTO ` + t + ` (cache_key, cache_value, expires_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT (cache_key) DO UPDATE
+			  SET cache_value = excluded.cache_value, expires_at = excluded.expires_at
+			  WHERE ` + t + `.expires_at IS NOT NULL AND ` + t + `.expires_at <= ?`
+	}
+	res, err := c.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return false, fmt.Errorf("add: %w", err)
+	}
+	// MySQL reports 0 for a row left unchanged, 1 or 2 otherwise
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("add: %w", err)
+	}
+	return n > 0, nil
+}
+
 func (c *sqlCache) Delete(ctx context.Context, key string) error {
 	if c.closed {
 		return ErrClosed
@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Maildir is a Transport writing the messages to the new directory of
+// a maildir instead of sending them, for tests and development.
+type Maildir struct {
+	dir string
+}
+
+// NewMaildir returns the transport writing to the maildir dir, made if
+// missing.
+func NewMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("mail.NewMaildir: %w", err)
+		}
+	}
+	return &Maildir{dir: dir}, nil
+}
+
+// Send writes the message to a file of the new directory. The file is
+// written to tmp first, so the readers of new never see it partial.
+func (d *Maildir) Send(_ context.Context, m Message) error {
+	if len(m.Recipients()) == 0 {
+		return ErrNoRecipient
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "." + hex.EncodeToString(b) + ".mkfst"
+	tmp := filepath.Join(d.dir, "tmp", name)
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("maildir: %w", err)
+	}
+	if err = os.Rename(tmp, filepath.Join(d.dir, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("maildir: %w", err)
+	}
+	return nil
+}
+
+// Messages returns the files of the new directory, oldest first.
+func (d *Maildir) Messages() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(d.dir, "new", "*"))
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
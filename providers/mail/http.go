@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HTTPOpts configures NewHTTP.
+type HTTPOpts struct {
+	// URL of the send endpoint of the email service. Required.
+	URL string
+
+	// Header is added to the requests, i.e. the Authorization with
+	// the API key of the service.
+	Header http.Header
+
+	// Encode returns the request body and its content type for the
+	// message, in the format of the service. Default is the JSON of
+	// the Message.
+	Encode func(m Message) (body []byte, contentType string, err error)
+
+	// Client sends the requests. Default http.DefaultClient.
+	Client *http.Client
+}
+
+// HTTP is a Transport posting the messages to the HTTP API of an email
+// service. Encode adapts it to the API of the service.
+type HTTP struct {
+	opts HTTPOpts
+}
+
+// NewHTTP returns the HTTP transport.
+func NewHTTP(opts HTTPOpts) (*HTTP, error) {
+	if opts.URL == "" {
+		return nil, errors.New("mail.NewHTTP: URL is required")
+	}
+	if opts.Encode == nil {
+		opts.Encode = func(m Message) ([]byte, string, error) {
+			data, err := json.Marshal(m)
+			return data, "application/json", err
+		}
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &HTTP{opts: opts}, nil
+}
+
+// Send posts the message. Any status but 2xx is an error.
+func (h *HTTP) Send(ctx context.Context, m Message) error {
+	if len(m.Recipients()) == 0 {
+		return ErrNoRecipient
+	}
+	body, contentType, err := h.opts.Encode(m)
+	if err != nil {
+		return fmt.Errorf("mail http: encode: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range h.opts.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := h.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mail http: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("mail http: status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Package mail sends templated transactional emails.
+//
+// Messages are rendered from HTML and text templates with layouts and
+// per-locale variants (see Templates), and handed to a Transport: SMTP
+// with STARTTLS, auth and a connection pool, a maildir for tests and
+// development, or the HTTP API of an email service.
+//
+//	tmpls, _ := mail.NewTemplates(templatesFS, mail.TemplatesOpts{})
+//	mailer, _ := mail.New(mail.Opts{
+//	    Transport: mail.NewSMTP(mail.SMTPOpts{Host: "smtp.example.com", Port: 587, StartTLS: true, ...}),
+//	    Templates: tmpls,
+//	    From:      "Example <no-reply@example.com>",
+//	    Scheduler: sched,
+//	})
+//	mailer.Register(worker)
+//
+//	mailer.SendTemplate(ctx, "bob@example.com", "welcome", "fr", data)
+//
+// With a Scheduler, sending is asynchronous: messages are rendered when
+// sent, and delivered by the tasks of providers/tasks, retrying with
+// backoff until the transport accepts them. Without one they are
+// delivered before Send returns.
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"mkfst/providers/tasks"
+)
+
+// TaskType is the type of the delivery tasks.
+const TaskType = "mail.send"
+
+// ErrNoRecipient is returned for a message without recipients.
+var ErrNoRecipient = errors.New("mail: no recipient")
+
+// Message is an email. Text and HTML are its alternative bodies, at
+// least one of them is required.
+type Message struct {
+	From    string            `json:"from"`
+	To      []string          `json:"to"`
+	Cc      []string          `json:"cc,omitempty"`
+	Bcc     []string          `json:"bcc,omitempty"`
+	ReplyTo string            `json:"replyTo,omitempty"`
+	Subject string            `json:"subject"`
+	Text    string            `json:"text,omitempty"`
+	HTML    string            `json:"html,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Recipients returns the addresses of To, Cc and Bcc.
+func (m Message) Recipients() []string {
+	res := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	res = append(res, m.To...)
+	res = append(res, m.Cc...)
+	return append(res, m.Bcc...)
+}
+
+// Transport delivers messages.
+type Transport interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// TransportFunc is an adapter to allow the use of ordinary functions as Transport.
+type TransportFunc func(ctx context.Context, m Message) error
+
+// Send calls f(ctx, m).
+func (f TransportFunc) Send(ctx context.Context, m Message) error { return f(ctx, m) }
+
+// Opts configures New.
+type Opts struct {
+	// Transport delivers the messages. Required.
+	Transport Transport
+
+	// Templates renders SendTemplate messages. Optional.
+	Templates *Templates
+
+	// From is the sender of the messages without one. Required.
+	From string
+
+	// Scheduler enqueues the deliveries. Nil delivers the messages
+	// in Send.
+	Scheduler tasks.Scheduler
+
+	// Queue of the deliveries. Empty defaults to "default".
+	Queue string
+
+	// MaxRetries is the number of retries of a delivery after the
+	// first attempt. Nil falls back to the worker's default.
+	MaxRetries *int
+
+	// Timeout caps an attempt. Default 30s.
+	Timeout time.Duration
+}
+
+// Mailer renders and sends messages.
+type Mailer struct {
+	opts Opts
+}
+
+// New returns a Mailer.
+func New(opts Opts) (*Mailer, error) {
+	if opts.Transport == nil {
+		return nil, errors.New("mail.New: Transport is required")
+	}
+	if opts.From == "" {
+		return nil, errors.New("mail.New: From is required")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	return &Mailer{opts: opts}, nil
+}
+
+// Register registers the delivery handler on the worker w.
+func (m *Mailer) Register(w tasks.Worker) error {
+	return w.Register(TaskType, m.deliver)
+}
+
+// Send sends the message, from the default sender if it has none. The
+// record is zero without a Scheduler.
+func (m *Mailer) Send(ctx context.Context, msg Message) (tasks.Record, error) {
+	if len(msg.Recipients()) == 0 {
+		return tasks.Record{}, ErrNoRecipient
+	}
+	if msg.From == "" {
+		msg.From = m.opts.From
+	}
+	if m.opts.Scheduler == nil {
+		ctx, cancel := context.WithTimeout(ctx, m.opts.Timeout)
+		defer cancel()
+		return tasks.Record{}, m.opts.Transport.Send(ctx, msg)
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return tasks.Record{}, err
+	}
+	return m.opts.Scheduler.Enqueue(ctx, tasks.Task{
+		Type:       TaskType,
+		Payload:    payload,
+		Queue:      m.opts.Queue,
+		MaxRetries: m.opts.MaxRetries,
+		Timeout:    m.opts.Timeout,
+		Tags:       map[string]string{"subject": msg.Subject},
+	})
+}
+
+// SendTemplate renders the template name in the locale with data, and
+// sends it to the address.
+func (m *Mailer) SendTemplate(ctx context.Context, to, name, locale string, data interface{}) (tasks.Record, error) {
+	if m.opts.Templates == nil {
+		return tasks.Record{}, errors.New("mail: no templates")
+	}
+	msg, err := m.opts.Templates.Render(name, locale, data)
+	if err != nil {
+		return tasks.Record{}, err
+	}
+	msg.To = []string{to}
+	return m.Send(ctx, msg)
+}
+
+// deliver sends the message of a delivery task.
+func (m *Mailer) deliver(ctx context.Context, task tasks.Task) error {
+	var msg Message
+	if err := json.Unmarshal(task.Payload, &msg); err != nil {
+		return err
+	}
+	if err := m.opts.Transport.Send(ctx, msg); err != nil {
+		return fmt.Errorf("mail: send %q: %w", msg.Subject, err)
+	}
+	return nil
+}
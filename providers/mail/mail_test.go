@@ -0,0 +1,201 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"mkfst/providers/tasks"
+)
+
+var testTemplates = fstest.MapFS{
+	"layouts/base.html":   {Data: []byte(`<html><body>{{template "content" .}}</body></html>`)},
+	"layouts/base.txt":    {Data: []byte("{{template \"content\" .}}\n-- \nExample")},
+	"welcome.txt":         {Data: []byte(`{{define "subject"}}Welcome, {{.Name}}{{end}}Hello {{.Name}}`)},
+	"welcome.html":        {Data: []byte(`<p>Hello <b>{{.Name}}</b></p>`)},
+	"welcome.fr.txt":      {Data: []byte(`{{define "subject"}}Bienvenue, {{.Name}}{{end}}Bonjour {{.Name}}`)},
+	"layouts/base.fr.txt": {Data: []byte("{{template \"content\" .}}\n-- \nExemple")},
+	"reset.html":          {Data: []byte(`{{define "subject"}}Reset{{end}}<a href="{{.Link}}">reset</a>`)},
+}
+
+func TestTemplates(t *testing.T) {
+	tmpls, err := NewTemplates(testTemplates, TemplatesOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := []struct {
+		name, locale        string
+		subject, text, html string
+	}{
+		{"welcome", "", "Welcome, <Bob>", "Hello <Bob>\n-- \nExample", "<html><body><p>Hello <b>&lt;Bob&gt;</b></p></body></html>"},
+		{"welcome", "fr-CA", "Bienvenue, <Bob>", "Bonjour <Bob>\n-- \nExemple", "<html><body><p>Hello <b>&lt;Bob&gt;</b></p></body></html>"},
+		{"welcome", "de", "Welcome, <Bob>", "Hello <Bob>\n-- \nExample", "<html><body><p>Hello <b>&lt;Bob&gt;</b></p></body></html>"},
+		{"reset", "fr", "Reset", "", `<html><body><a href="https://example.com/reset?t=a&amp;b">reset</a></body></html>`},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name+"."+tt.locale, func(t *testing.T) {
+			msg, err := tmpls.Render(tt.name, tt.locale, map[string]string{"Name": "<Bob>", "Link": "https://example.com/reset?t=a&b"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Subject != tt.subject || msg.Text != tt.text || msg.HTML != tt.html {
+				t.Errorf("unexpected message %+v", msg)
+			}
+		})
+	}
+
+	if _, err = tmpls.Render("missing", "en", nil); !errors.Is(err, ErrNoTemplate) {
+		t.Errorf("expected ErrNoTemplate, got %v", err)
+	}
+	if _, err = NewTemplates(fstest.MapFS{"bad.txt": {Data: []byte("{{.Name")}}, TemplatesOpts{}); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	data, err := Message{
+		From:    "Example <no-reply@example.com>",
+		To:      []string{"bob@example.com"},
+		Bcc:     []string{"audit@example.com"},
+		Subject: "Café\r\nBcc: evil@example.com",
+		Text:    "Hello",
+		HTML:    "<p>Hello</p>",
+		Headers: map[string]string{"x-campaign": "welcome"},
+	}.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if subject != "Café\r\nBcc: evil@example.com" || m.Header.Get("Bcc") != "" || m.Header.Get("X-Campaign") != "welcome" ||
+		m.Header.Get("From") != `"Example" <no-reply@example.com>` || !strings.HasSuffix(m.Header.Get("Message-Id"), "@example.com>") {
+		t.Errorf("unexpected headers %v", m.Header)
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	var parts []string
+	for {
+		p, err := r.NextPart() // decodes the quoted-printable
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		parts = append(parts, p.Header.Get("Content-Type")+" "+string(body))
+	}
+	if strings.Join(parts, "|") != "text/plain; charset=utf-8 Hello|text/html; charset=utf-8 <p>Hello</p>" {
+		t.Errorf("unexpected parts %q", parts)
+	}
+}
+
+func TestMailer(t *testing.T) {
+	tmpls, err := NewTemplates(testTemplates, TemplatesOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first attempt fails, and is retried.
+	var calls atomic.Int32
+	transport := TransportFunc(func(ctx context.Context, m Message) error {
+		if calls.Add(1) == 1 {
+			return errors.New("unavailable")
+		}
+		return dir.Send(ctx, m)
+	})
+
+	store := tasks.NewMemoryStore(tasks.MemoryOpts{})
+	w, err := tasks.NewWorker(tasks.WorkerOpts{
+		Store:               store,
+		PollInterval:        5 * time.Millisecond,
+		MaintenanceInterval: 10 * time.Millisecond,
+		Backoff:             func(int) time.Duration { return 5 * time.Millisecond },
+		DefaultMaxRetries:   3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(Opts{Transport: transport, Templates: tmpls, From: "no-reply@example.com", Scheduler: tasks.NewScheduler(store)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Register(w); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	if _, err = m.Send(ctx, Message{Subject: "nobody", Text: "x"}); !errors.Is(err, ErrNoRecipient) {
+		t.Errorf("expected ErrNoRecipient, got %v", err)
+	}
+	if _, err = m.SendTemplate(ctx, "bob@example.com", "welcome", "fr", map[string]string{"Name": "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, _ := dir.Messages()
+		if len(files) == 1 {
+			data, _ := os.ReadFile(files[0])
+			msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Header.Get("To") != "<bob@example.com>" || msg.Header.Get("Subject") != "Bienvenue, Bob" {
+				t.Errorf("unexpected headers %v", msg.Header)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("message not delivered after %d attempts", calls.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer key" || r.Header.Get("Content-Type") != "application/json" ||
+			!strings.Contains(string(body), `"to":["bob@example.com"]`) {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	h, err := NewHTTP(HTTPOpts{URL: srv.URL, Header: http.Header{"Authorization": {"Bearer key"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{From: "no-reply@example.com", To: []string{"bob@example.com"}, Subject: "Hi", Text: "Hello"}
+	if err = h.Send(context.Background(), msg); err != nil {
+		t.Error(err)
+	}
+	msg.To = []string{"alice@example.com"}
+	if err = h.Send(context.Background(), msg); err == nil || !strings.Contains(err.Error(), "status 400: invalid request") {
+		t.Errorf("expected a status error, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bytes returns the message in the RFC 5322 format, with the Text and
+// HTML bodies as a multipart/alternative. Bcc is left out of the headers.
+func (m Message) Bytes() ([]byte, error) {
+	if m.Text == "" && m.HTML == "" {
+		return nil, fmt.Errorf("mail: no body in %q", m.Subject)
+	}
+	var b bytes.Buffer
+	header := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+		}
+	}
+	from, err := formatAddresses(m.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := formatAddresses(m.To...)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := formatAddresses(m.Cc...)
+	if err != nil {
+		return nil, err
+	}
+	replyTo, err := formatAddresses(m.ReplyTo)
+	if err != nil {
+		return nil, err
+	}
+	header("From", from)
+	header("To", to)
+	header("Cc", cc)
+	header("Reply-To", replyTo)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	if _, ok := m.Headers["Message-ID"]; !ok {
+		header("Message-ID", messageID(m.From))
+	}
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header(textproto.CanonicalMIMEHeaderKey(name), mime.QEncoding.Encode("utf-8", stripNewlines(m.Headers[name])))
+	}
+	header("MIME-Version", "1.0")
+
+	if m.Text == "" || m.HTML == "" {
+		contentType, body := "text/plain", m.Text
+		if m.Text == "" {
+			contentType, body = "text/html", m.HTML
+		}
+		header("Content-Type", contentType+"; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		return b.Bytes(), writeQuotedPrintable(&b, body)
+	}
+
+	mw := multipart.NewWriter(&b)
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	b.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// formatAddresses parses the addresses and formats them for a header,
+// encoding the names
+func formatAddresses(list ...string) (string, error) {
+	var res []string
+	for _, s := range list {
+		if s == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			return "", fmt.Errorf("mail: invalid address %q: %w", s, err)
+		}
+		res = append(res, addr.String())
+	}
+	return strings.Join(res, ", "), nil
+}
+
+// address returns the bare address of s, i.e. bob@example.com of "Bob <bob@example.com>"
+func address(s string) (string, error) {
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", fmt.Errorf("mail: invalid address %q: %w", s, err)
+	}
+	return addr.Address, nil
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// messageID makes a unique Message-ID in the domain of the sender
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := address(from); err == nil {
+		if i := strings.LastIndex(addr, "@"); i >= 0 {
+			domain = addr[i+1:]
+		}
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
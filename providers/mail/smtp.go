@@ -0,0 +1,286 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SMTPOpts configures NewSMTP.
+type SMTPOpts struct {
+	// Host and Port of the server. Port defaults to 465 with TLS,
+	// 587 otherwise.
+	Host string
+	Port int
+
+	// Username and Password authenticate with PLAIN, or LOGIN if
+	// LoginAuth is set. Empty Username skips the authentication.
+	Username  string
+	Password  string
+	LoginAuth bool
+
+	// TLS connects with implicit TLS (port 465). StartTLS upgrades the
+	// plain connection, and fails if the server doesn't support it.
+	// Without either the connection is unencrypted, only allowed to
+	// authenticate with a server on localhost.
+	TLS      bool
+	StartTLS bool
+
+	// InsecureSkipVerify skips the verification of the certificate
+	// of the server, for development servers.
+	InsecureSkipVerify bool
+
+	// LocalName is sent in HELO/EHLO. Default "localhost".
+	LocalName string
+
+	// Timeout caps the connection to the server. Default 10s.
+	Timeout time.Duration
+
+	// PoolSize is the number of idle connections kept for reuse. 0
+	// defaults to 2, negative disables the pool.
+	PoolSize int
+
+	// IdleTimeout closes the connections idle for longer. Default 1m.
+	IdleTimeout time.Duration
+}
+
+// SMTP is a Transport sending messages to an SMTP server, reusing the
+// authenticated connections of a pool.
+type SMTP struct {
+	opts SMTPOpts
+	lock sync.Mutex
+	idle []*smtpConn
+}
+
+type smtpConn struct {
+	client *smtp.Client
+	conn   net.Conn
+	used   time.Time
+}
+
+// NewSMTP returns the SMTP transport.
+func NewSMTP(opts SMTPOpts) *SMTP {
+	if opts.Port == 0 {
+		opts.Port = 587
+		if opts.TLS {
+			opts.Port = 465
+		}
+	}
+	if opts.LocalName == "" {
+		opts.LocalName = "localhost"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.PoolSize == 0 {
+		opts.PoolSize = 2
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = time.Minute
+	}
+	return &SMTP{opts: opts}
+}
+
+// Send delivers the message to the server.
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	if len(m.Recipients()) == 0 {
+		return ErrNoRecipient
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	from, err := address(m.From)
+	if err != nil {
+		return err
+	}
+	rcpts := make([]string, 0, len(m.Recipients()))
+	for _, r := range m.Recipients() {
+		addr, err := address(r)
+		if err != nil {
+			return err
+		}
+		rcpts = append(rcpts, addr)
+	}
+
+	c, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+	} else {
+		_ = c.conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	}
+	if err = s.send(c.client, from, rcpts, data); err != nil {
+		// a rejected message leaves the connection usable after a reset
+		if c.client.Reset() == nil {
+			s.put(c)
+		} else {
+			_ = c.conn.Close()
+		}
+		return fmt.Errorf("smtp: %w", err)
+	}
+	s.put(c)
+	return nil
+}
+
+func (s *SMTP) send(client *smtp.Client, from string, rcpts []string, data []byte) error {
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, r := range rcpts {
+		if err := client.Rcpt(r); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// get returns a live idle connection of the pool, or dials a new one
+func (s *SMTP) get(ctx context.Context) (*smtpConn, error) {
+	for {
+		s.lock.Lock()
+		if len(s.idle) == 0 {
+			s.lock.Unlock()
+			break
+		}
+		c := s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+		s.lock.Unlock()
+
+		if time.Since(c.used) < s.opts.IdleTimeout {
+			_ = c.conn.SetDeadline(time.Now().Add(s.opts.Timeout))
+			if c.client.Noop() == nil {
+				return c, nil
+			}
+		}
+		_ = c.conn.Close()
+	}
+	return s.dial(ctx)
+}
+
+// put returns the connection to the pool, or quits it if the pool is full
+func (s *SMTP) put(c *smtpConn) {
+	c.used = time.Now()
+	s.lock.Lock()
+	if len(s.idle) < s.opts.PoolSize {
+		s.idle = append(s.idle, c)
+		s.lock.Unlock()
+		return
+	}
+	s.lock.Unlock()
+	_ = c.client.Quit()
+	_ = c.conn.Close()
+}
+
+func (s *SMTP) dial(ctx context.Context) (_ *smtpConn, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+	addr := net.JoinHostPort(s.opts.Host, strconv.Itoa(s.opts.Port))
+	tlsConfig := &tls.Config{ServerName: s.opts.Host, InsecureSkipVerify: s.opts.InsecureSkipVerify} //nolint:gosec // opt-in for development servers
+
+	var conn net.Conn
+	if s.opts.TLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("smtp: dial %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.opts.Host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("smtp: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = client.Close()
+		}
+	}()
+	if err = client.Hello(s.opts.LocalName); err != nil {
+		return nil, fmt.Errorf("smtp: %w", err)
+	}
+	if s.opts.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return nil, errors.New("smtp: the server doesn't support STARTTLS")
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return nil, fmt.Errorf("smtp: starttls: %w", err)
+		}
+	}
+	if s.opts.Username != "" {
+		var auth smtp.Auth
+		if s.opts.LoginAuth {
+			auth = &loginAuth{host: s.opts.Host, username: s.opts.Username, password: s.opts.Password}
+		} else {
+			auth = smtp.PlainAuth("", s.opts.Username, s.opts.Password, s.opts.Host)
+		}
+		if err = client.Auth(auth); err != nil {
+			return nil, fmt.Errorf("smtp: auth: %w", err)
+		}
+	}
+	return &smtpConn{client: client, conn: conn}, nil
+}
+
+// Close quits the idle connections.
+func (s *SMTP) Close() error {
+	s.lock.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.lock.Unlock()
+	for _, c := range idle {
+		_ = c.client.Quit()
+		_ = c.conn.Close()
+	}
+	return nil
+}
+
+// loginAuth is the LOGIN mechanism, still the only one of some servers
+type loginAuth struct {
+	host, username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:", "User Name\x00":
+		return []byte(a.username), nil
+	case "Password:", "Password\x00":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
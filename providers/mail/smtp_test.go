@@ -0,0 +1,142 @@
+package mail
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTP is an SMTP server accepting the messages of user:secret, without TLS
+type fakeSMTP struct {
+	addr  string
+	lock  sync.Mutex
+	conns int
+	auths []string
+	msgs  []string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	f := &fakeSMTP{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.lock.Lock()
+			f.conns++
+			f.lock.Unlock()
+			go f.serve(textproto.NewConn(conn))
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(c *textproto.Conn) {
+	defer c.Close()
+	reply := func(format string, args ...interface{}) { _ = c.PrintfLine(format, args...) }
+	decode := func(s string) string { b, _ := base64.StdEncoding.DecodeString(s); return string(b) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN LOGIN")
+		case "AUTH":
+			var user, pass string
+			if mech, resp, _ := strings.Cut(arg, " "); mech == "PLAIN" {
+				parts := strings.Split(decode(resp), "\x00")
+				user, pass = parts[1], parts[2]
+			} else {
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				line, _ = c.ReadLine()
+				user = decode(line)
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				line, _ = c.ReadLine()
+				pass = decode(line)
+			}
+			f.lock.Lock()
+			f.auths = append(f.auths, user)
+			f.lock.Unlock()
+			if user != "user" || pass != "secret" {
+				reply("535 authentication failed")
+				continue
+			}
+			reply("235 ok")
+		case "MAIL", "NOOP", "RSET":
+			reply("250 ok")
+		case "RCPT":
+			if strings.Contains(arg, "rejected") {
+				reply("550 no such user")
+				continue
+			}
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data, _ := c.ReadDotBytes()
+			f.lock.Lock()
+			f.msgs = append(f.msgs, string(data))
+			f.lock.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	f := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(f.addr)
+	p, _ := strconv.Atoi(port)
+	msg := Message{From: "no-reply@example.com", To: []string{"Bob <bob@example.com>"}, Subject: "Hi", Text: "Hello"}
+
+	for _, login := range []bool{false, true} {
+		s := NewSMTP(SMTPOpts{Host: host, Port: p, Username: "user", Password: "secret", LoginAuth: login})
+		for i := 0; i < 3; i++ {
+			if err := s.Send(context.Background(), msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rejected := msg
+		rejected.To = []string{"rejected@example.com"}
+		if err := s.Send(context.Background(), rejected); err == nil {
+			t.Error("expected an error for a rejected recipient")
+		}
+		if err := s.Send(context.Background(), msg); err != nil { // on the connection reset after the error
+			t.Fatal(err)
+		}
+		_ = s.Close()
+	}
+	f.lock.Lock()
+	if f.conns != 2 || len(f.auths) != 2 || len(f.msgs) != 8 || !strings.Contains(f.msgs[0], "Subject: Hi") {
+		t.Errorf("expected one pooled connection per transport, got %d connections, %d auths, %d messages", f.conns, len(f.auths), len(f.msgs))
+	}
+	f.lock.Unlock()
+
+	s := NewSMTP(SMTPOpts{Host: host, Port: p, Username: "user", Password: "wrong"})
+	if err := s.Send(context.Background(), msg); err == nil || !strings.Contains(err.Error(), "auth") {
+		t.Errorf("expected an auth error, got %v", err)
+	}
+	s = NewSMTP(SMTPOpts{Host: host, Port: p, StartTLS: true})
+	if err := s.Send(context.Background(), msg); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("expected a STARTTLS error, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// ErrNoTemplate is returned by Render for a template without a text or
+// HTML file.
+var ErrNoTemplate = errors.New("mail: template not found")
+
+// TemplatesOpts configures NewTemplates.
+type TemplatesOpts struct {
+	// DefaultLocale is the locale of the files without one, used
+	// when no file of the requested locale exists. Default "en".
+	DefaultLocale string
+
+	// Layout is the name of the layout of the messages in the
+	// layouts directory. Default "base".
+	Layout string
+
+	// Funcs are added to the functions of the templates.
+	Funcs map[string]interface{}
+}
+
+// Templates renders messages from the files of a file system:
+//
+//	layouts/base.html          layout of the HTML bodies
+//	layouts/base.txt           layout of the text bodies
+//	welcome.html               HTML body of the welcome message
+//	welcome.txt                text body
+//	welcome.fr.html            French HTML body, and so on
+//
+// A message is the text and HTML files of its name, one of them may be
+// missing. The subject is the "subject" template the text file, or else
+// the HTML file, defines. Each file is rendered in its layout, which
+// includes it with {{template "content" .}}; without a layout the file
+// is the body. Layouts can be localized the same way.
+//
+// The files of the locale are looked up with a fallback to the language,
+// then to the default locale: "pt-BR" uses welcome.pt-BR.txt, else
+// welcome.pt.txt, else welcome.txt.
+type Templates struct {
+	fsys fs.FS
+	opts TemplatesOpts
+}
+
+// NewTemplates returns the Templates of the files of fsys, checking
+// that they parse.
+func NewTemplates(fsys fs.FS, opts TemplatesOpts) (*Templates, error) {
+	if opts.DefaultLocale == "" {
+		opts.DefaultLocale = "en"
+	}
+	if opts.Layout == "" {
+		opts.Layout = "base"
+	}
+	t := &Templates{fsys: fsys, opts: opts}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		switch path.Ext(p) {
+		case ".txt":
+			_, err = t.parseText(p, "")
+		case ".html":
+			_, err = t.parseHTML(p, "")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mail.NewTemplates: %w", err)
+	}
+	return t, nil
+}
+
+// Render renders the message name in the locale with data. The message
+// has the subject and bodies, and no recipients.
+func (t *Templates) Render(name, locale string, data interface{}) (Message, error) {
+	var msg Message
+	var subject string
+	if file := t.lookup(name, locale, ".txt"); file != "" {
+		tmpl, err := t.parseText(file, t.lookup("layouts/"+t.opts.Layout, locale, ".txt"))
+		if err != nil {
+			return Message{}, err
+		}
+		if msg.Text, err = executeText(tmpl, data); err != nil {
+			return Message{}, err
+		}
+		if tmpl.Lookup("subject") != nil {
+			var b bytes.Buffer
+			if err = tmpl.ExecuteTemplate(&b, "subject", data); err != nil {
+				return Message{}, fmt.Errorf("mail: render subject of %s: %w", file, err)
+			}
+			subject = b.String()
+		}
+	}
+	if file := t.lookup(name, locale, ".html"); file != "" {
+		tmpl, err := t.parseHTML(file, t.lookup("layouts/"+t.opts.Layout, locale, ".html"))
+		if err != nil {
+			return Message{}, err
+		}
+		var b bytes.Buffer
+		root := "content"
+		if tmpl.Lookup("layout") != nil {
+			root = "layout"
+		}
+		if err = tmpl.ExecuteTemplate(&b, root, data); err != nil {
+			return Message{}, fmt.Errorf("mail: render %s: %w", file, err)
+		}
+		msg.HTML = b.String()
+		if subject == "" && tmpl.Lookup("subject") != nil {
+			b.Reset()
+			if err = tmpl.ExecuteTemplate(&b, "subject", data); err != nil {
+				return Message{}, fmt.Errorf("mail: render subject of %s: %w", file, err)
+			}
+			subject = b.String()
+		}
+	}
+	if msg.Text == "" && msg.HTML == "" {
+		return Message{}, fmt.Errorf("%w: %s", ErrNoTemplate, name)
+	}
+	msg.Subject = strings.TrimSpace(stripNewlines(subject))
+	return msg, nil
+}
+
+// lookup returns the file of name in the locale with the extension, or "" if none
+func (t *Templates) lookup(name, locale, ext string) string {
+	var candidates []string
+	if locale != "" {
+		candidates = append(candidates, name+"."+locale+ext)
+		if i := strings.IndexAny(locale, "-_"); i > 0 {
+			candidates = append(candidates, name+"."+locale[:i]+ext)
+		}
+	}
+	candidates = append(candidates, name+"."+t.opts.DefaultLocale+ext, name+ext)
+	for _, c := range candidates {
+		if _, err := fs.Stat(t.fsys, c); err == nil {
+			return c
+		}
+	}
+	return ""
+}
+
+// parseText parses the text file as the "content" template, in the layout if not empty
+func (t *Templates) parseText(file, layout string) (*texttemplate.Template, error) {
+	root := texttemplate.New("layout").Funcs(t.opts.Funcs)
+	if layout != "" {
+		src, err := fs.ReadFile(t.fsys, layout)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = root.Parse(string(src)); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", layout, err)
+		}
+	}
+	src, err := fs.ReadFile(t.fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = root.New("content").Parse(string(src)); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return root, nil
+}
+
+// parseHTML parses the HTML file as the "content" template, in the layout if not empty
+func (t *Templates) parseHTML(file, layout string) (*htmltemplate.Template, error) {
+	root := htmltemplate.New("layout").Funcs(htmltemplate.FuncMap(t.opts.Funcs))
+	if layout != "" {
+		src, err := fs.ReadFile(t.fsys, layout)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = root.Parse(string(src)); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", layout, err)
+		}
+	}
+	src, err := fs.ReadFile(t.fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = root.New("content").Parse(string(src)); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return root, nil
+}
+
+func executeText(tmpl *texttemplate.Template, data interface{}) (string, error) {
+	root := "content"
+	if tmpl.Lookup("layout") != nil && tmpl.Lookup("layout").Tree != nil {
+		root = "layout"
+	}
+	var b bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&b, root, data); err != nil {
+		return "", fmt.Errorf("mail: render: %w", err)
+	}
+	return b.String(), nil
+}
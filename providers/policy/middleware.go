@@ -26,7 +26,8 @@ import (
 
 // SubjectFromUser maps an authenticated User → Subject. The default
 // reads `roles` from the user's slice attribute; an admin user gets
-// every permission via the synthetic admin.* role.
+// every permission via the synthetic admin.* role, and the scopes of
+// a service account's API key are its roles.
 func SubjectFromUser(u token.User) Subject {
 	roles := u.SliceAttr("roles")
 	if u.IsAdmin() {
@@ -34,10 +35,17 @@ func SubjectFromUser(u token.User) Subject {
 		// enforcer treats AdminAll as a master grant.
 		roles = append([]string{"__admin"}, roles...)
 	}
+	tags := map[string]string{"name": u.Name, "email": u.Email}
+	if u.IsServiceAccount() {
+		// Grant scopes like roles: a key scoped "billing" gets
+		// the permissions of the billing role, and no more.
+		roles = append(roles[:len(roles):len(roles)], u.SliceAttr(token.ScopesAttr)...)
+		tags["service_account"] = "true"
+	}
 	return Subject{
 		ID:    u.ID,
 		Roles: roles,
-		Tags:  map[string]string{"name": u.Name, "email": u.Email},
+		Tags:  tags,
 	}
 }
 
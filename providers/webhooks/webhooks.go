@@ -0,0 +1,331 @@
+// Package webhooks sends signed webhooks to the consumers of an API.
+//
+// Each event is declared once with the Go type of its payload, which
+// both checks the payloads sent and documents the webhook in the
+// OpenAPI specification:
+//
+//	sender, _ := webhooks.New(webhooks.Opts{Secret: secret, Scheduler: sched})
+//	sender.Event("order.paid", OrderPaid{}, fizz.Summary("An order was paid"))
+//	sender.Document(svc.Fizz())
+//	sender.Register(worker)
+//
+//	sender.Send(ctx, "order.paid", sub.URL, OrderPaid{...})
+//
+// Deliveries are tasks of providers/tasks: Send enqueues them, and the
+// worker the sender is registered on posts them, retrying with backoff
+// until the consumer responds with a 2xx status code.
+//
+// Requests are signed following the Standard Webhooks specification
+// (https://www.standardwebhooks.com): the webhook-signature header holds
+// "v1," and the base64 HMAC-SHA256 of "<webhook-id>.<webhook-timestamp>.<body>",
+// which consumers check with Verify.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"mkfst/fizz"
+	"mkfst/providers/tasks"
+)
+
+// TaskType is the type of the delivery tasks.
+const TaskType = "webhooks.deliver"
+
+// Headers of the webhook requests.
+const (
+	HeaderID        = "webhook-id"
+	HeaderTimestamp = "webhook-timestamp"
+	HeaderSignature = "webhook-signature"
+	HeaderEvent     = "webhook-event"
+)
+
+var (
+	// ErrUnknownEvent is returned by Send for an event not declared.
+	ErrUnknownEvent = errors.New("webhooks: unknown event")
+	// ErrInvalidSignature is returned by Verify when no signature
+	// of the request matches.
+	ErrInvalidSignature = errors.New("webhooks: invalid signature")
+	// ErrExpired is returned by Verify when the timestamp of the
+	// request is out of the tolerance.
+	ErrExpired = errors.New("webhooks: timestamp out of tolerance")
+)
+
+// Opts configures New.
+type Opts struct {
+	// Secret is the HMAC key of the signatures. Required.
+	Secret []byte
+
+	// Scheduler enqueues the deliveries. Required.
+	Scheduler tasks.Scheduler
+
+	// Queue of the deliveries. Empty defaults to "default".
+	Queue string
+
+	// MaxRetries is the number of retries of a delivery after the
+	// first attempt. Nil falls back to the worker's default.
+	MaxRetries *int
+
+	// Timeout caps an attempt. Default 10s.
+	Timeout time.Duration
+
+	// Client posts the requests, overriding the default one. The
+	// default client refuses to connect to loopback, private,
+	// link-local (as the cloud metadata at 169.254.169.254) and
+	// unspecified addresses, so the URLs of the subscribers can't
+	// reach the internal network; doesn't follow redirects; and
+	// times out after Timeout. Set Client to deliver to internal
+	// services, or through a proxy.
+	Client *http.Client
+}
+
+// Sender sends the webhooks of the declared events.
+type Sender struct {
+	opts Opts
+
+	mu     sync.RWMutex
+	events map[string]*event
+}
+
+type event struct {
+	model reflect.Type
+	docs  []fizz.OperationOption
+}
+
+// delivery is the payload of a delivery task.
+type delivery struct {
+	ID    string          `json:"id"`
+	Event string          `json:"event"`
+	URL   string          `json:"url"`
+	Body  json.RawMessage `json:"body"`
+}
+
+// New returns a Sender.
+func New(opts Opts) (*Sender, error) {
+	if len(opts.Secret) == 0 {
+		return nil, errors.New("webhooks.New: Secret is required")
+	}
+	if opts.Scheduler == nil {
+		return nil, errors.New("webhooks.New: Scheduler is required")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = publicClient(opts.Timeout)
+	}
+	return &Sender{
+		opts:   opts,
+		events: make(map[string]*event),
+	}, nil
+}
+
+// Event declares the event name, whose payloads are values of the
+// type of model. The options describe its webhook in the specification.
+func (s *Sender) Event(name string, model interface{}, docs ...fizz.OperationOption) *Sender {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[name] = &event{model: reflect.TypeOf(model), docs: docs}
+	return s
+}
+
+// Document documents the webhooks of the declared events in the
+// specification of f, with their payload and signature headers.
+func (s *Sender) Document(f *fizz.Fizz) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for name, e := range s.events {
+		docs := append([]fizz.OperationOption{
+			fizz.Descriptionf(
+				"Sent as the %s event. The request is signed: the %s header holds \"v1,\" and the base64 HMAC-SHA256 of \"<%s>.<%s>.<body>\"; the consumer responds with a 2xx status code, or the request is retried.",
+				name, HeaderSignature, HeaderID, HeaderTimestamp,
+			),
+		}, e.docs...)
+		f.Webhook(name, http.MethodPost, reflect.New(e.model).Elem().Interface(), docs)
+	}
+}
+
+// Register registers the delivery handler on the worker w.
+func (s *Sender) Register(w tasks.Worker) error {
+	return w.Register(TaskType, s.deliver)
+}
+
+// Send enqueues the delivery of the event name to url, with the
+// payload, which must be of the type declared for the event.
+func (s *Sender) Send(ctx context.Context, name, url string, payload interface{}) (tasks.Record, error) {
+	s.mu.RLock()
+	e, ok := s.events[name]
+	s.mu.RUnlock()
+	if !ok {
+		return tasks.Record{}, fmt.Errorf("%w %s", ErrUnknownEvent, name)
+	}
+	if t := reflect.TypeOf(payload); t != e.model && !(t != nil && t.Kind() == reflect.Ptr && t.Elem() == e.model) {
+		return tasks.Record{}, fmt.Errorf("webhooks: payload of event %s is a %s, not a %s", name, t, e.model)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return tasks.Record{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return tasks.Record{}, err
+	}
+	d, err := json.Marshal(delivery{ID: id, Event: name, URL: url, Body: body})
+	if err != nil {
+		return tasks.Record{}, err
+	}
+	return s.opts.Scheduler.Enqueue(ctx, tasks.Task{
+		Type:       TaskType,
+		Payload:    d,
+		Queue:      s.opts.Queue,
+		MaxRetries: s.opts.MaxRetries,
+		Timeout:    s.opts.Timeout,
+		Tags:       map[string]string{"event": name},
+	})
+}
+
+// errForbiddenAddress is returned by the dialer of the default client
+// for an address of the internal network.
+var errForbiddenAddress = errors.New("webhooks: forbidden address")
+
+// internalNets are the ranges refused by the default client beside the
+// loopback, private, link-local and unspecified addresses.
+var internalNets = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // this network
+	netip.MustParsePrefix("100.64.0.0/10"), // shared address space, i.e. the metadata of Alibaba Cloud
+}
+
+// publicClient returns the default client of the deliveries, connecting
+// to public addresses only and not following redirects.
+func publicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// the check is on the address dialed, after the resolution, so
+		// a name resolving to an internal address is refused too
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addr.Addr()) {
+				return fmt.Errorf("%w %s", errForbiddenAddress, address)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // the dialer would check the proxy, not the subscriber
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicAddr reports if addr can be dialed by the default client.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsUnspecified() || addr.IsMulticast() {
+		return false
+	}
+	for _, p := range internalNets {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver posts a delivery, signed at the time of the attempt.
+func (s *Sender) deliver(ctx context.Context, task tasks.Task) error {
+	var d delivery
+	if err := json.Unmarshal(task.Payload, &d); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, d.ID)
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(s.opts.Secret, d.ID, now, d.Body))
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhooks: %s responded %s to %s", d.URL, resp.Status, d.Event)
+	}
+	return nil
+}
+
+// Sign returns the signature of the webhook id sent at ts with
+// the body, as the value of the webhook-signature header.
+func Sign(secret []byte, id string, ts time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s.%d.", id, ts.Unix())
+	mac.Write(body)
+
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a webhook request with the headers
+// h and the body, and that it was sent within tolerance of now,
+// if not zero. The signature header may list several signatures,
+// separated by spaces, during the rotation of the secret.
+func Verify(secret []byte, h http.Header, body []byte, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(h.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("webhooks: invalid %s header", HeaderTimestamp)
+	}
+	ts := time.Unix(unix, 0)
+	if d := time.Since(ts); tolerance != 0 && (d > tolerance || d < -tolerance) {
+		return ErrExpired
+	}
+	expected := Sign(secret, h.Get(HeaderID), ts, body)
+	for _, sig := range strings.Fields(h.Get(HeaderSignature)) {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "msg_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,188 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mkfst/fizz"
+	"mkfst/providers/tasks"
+)
+
+type orderPaid struct {
+	OrderID string `json:"orderId"`
+	Amount  int64  `json:"amount"`
+}
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("whsec")
+	body := []byte(`{"orderId":"o1"}`)
+	now := time.Now()
+
+	h := http.Header{}
+	h.Set(HeaderID, "msg_1")
+	h.Set(HeaderTimestamp, "x")
+	if err := Verify(secret, h, body, 0); err == nil {
+		t.Error("expected an error for an invalid timestamp")
+	}
+	h.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	h.Set(HeaderSignature, "v1,stale "+Sign(secret, "msg_1", now, body))
+	if err := Verify(secret, h, body, time.Minute); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := Verify([]byte("other"), h, body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature, got %v", err)
+	}
+	if err := Verify(secret, h, []byte(`{}`), time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for a modified body, got %v", err)
+	}
+	old := now.Add(-time.Hour)
+	h.Set(HeaderTimestamp, strconv.FormatInt(old.Unix(), 10))
+	h.Set(HeaderSignature, Sign(secret, "msg_1", old, body))
+	if err := Verify(secret, h, body, time.Minute); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	secret := []byte("whsec")
+
+	var calls atomic.Int32
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(secret, r.Header, body, time.Minute); err != nil {
+			t.Errorf("Verify: %v", err)
+		}
+		// The first attempt fails, and is retried.
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received <- r.Header.Get(HeaderEvent) + " " + string(body)
+	}))
+	defer srv.Close()
+
+	store := tasks.NewMemoryStore(tasks.MemoryOpts{})
+	w, err := tasks.NewWorker(tasks.WorkerOpts{
+		Store:               store,
+		PollInterval:        5 * time.Millisecond,
+		MaintenanceInterval: 10 * time.Millisecond,
+		Backoff:             func(int) time.Duration { return 5 * time.Millisecond },
+		DefaultMaxRetries:   3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default client refuses the loopback address of the test server.
+	s, err := New(Opts{Secret: secret, Scheduler: tasks.NewScheduler(store), Client: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Event("order.paid", orderPaid{})
+	if err := s.Register(w); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	if _, err := s.Send(ctx, "order.shipped", srv.URL, orderPaid{}); !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("expected ErrUnknownEvent, got %v", err)
+	}
+	if _, err := s.Send(ctx, "order.paid", srv.URL, struct{}{}); err == nil {
+		t.Error("expected an error for a payload of another type")
+	}
+	if _, err := s.Send(ctx, "order.paid", srv.URL, &orderPaid{OrderID: "o1", Amount: 42}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if want := `order.paid {"orderId":"o1","amount":42}`; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook not delivered after %d attempts", calls.Load())
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":          true,
+		"2606:2800:220:1::":      true,
+		"127.0.0.1":              false,
+		"::1":                    false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false,
+		"100.100.100.200":        false,
+		"0.0.0.0":                false,
+		"::":                     false,
+		"fe80::1":                false,
+		"fd00:ec2::254":          false,
+		"::ffff:169.254.169.254": false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	client := publicClient(time.Second)
+	if _, err := client.Get(srv.URL); !errors.Is(err, errForbiddenAddress) || calls.Load() != 0 {
+		t.Errorf("expected the loopback address refused, got %v after %d calls", err, calls.Load())
+	}
+	if client.Timeout != time.Second {
+		t.Errorf("unexpected timeout %v", client.Timeout)
+	}
+
+	// Redirects are responses, failing the delivery.
+	client.Transport = srv.Client().Transport
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || calls.Load() != 1 {
+		t.Errorf("expected the redirect not followed, got %s after %d calls", resp.Status, calls.Load())
+	}
+}
+
+func TestDocument(t *testing.T) {
+	s, err := New(Opts{Secret: []byte("whsec"), Scheduler: tasks.NewScheduler(tasks.NewMemoryStore(tasks.MemoryOpts{}))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Event("order.paid", orderPaid{}, fizz.Summary("An order was paid"))
+
+	f := fizz.New()
+	s.Document(f)
+
+	// OpenAPI 3.0 lists the webhooks under x-webhooks.
+	item := f.Generator().API().XWebhooks["order.paid"]
+	if item == nil || item.POST == nil {
+		t.Fatalf("webhook not documented: %+v", f.Generator().API().XWebhooks)
+	}
+	op := item.POST
+	if op.Summary != "An order was paid" || !strings.Contains(op.Description, HeaderSignature) {
+		t.Errorf("unexpected webhook operation: %+v", op)
+	}
+	if op.RequestBody == nil || op.RequestBody.Content["application/json"] == nil {
+		t.Errorf("webhook payload not documented: %+v", op.RequestBody)
+	}
+}
@@ -3,25 +3,26 @@ package router
 import (
 	"database/sql"
 	"fmt"
-	"log"
 	config "mkfst/config"
 	db "mkfst/db"
+	"strings"
+	"unicode"
 
 	tonic "mkfst/tonic"
 
 	fizz "mkfst/fizz"
 
 	gin "github.com/gin-gonic/gin"
-	"github.com/gofrs/uuid"
 )
 
 type Router struct {
-	Base       *fizz.Fizz
-	Db         *db.Connection
-	Container  *tonic.Container
-	groups     []Group
-	routes     []Route
-	middleware []interface{}
+	Base         *fizz.Fizz
+	Db           *db.Connection
+	Container    *tonic.Container
+	groups       []*Group
+	routes       []Route
+	middleware   []interface{}
+	operationIDs map[string]bool
 }
 
 type Group struct {
@@ -48,6 +49,14 @@ func (router *Router) Provide(deps ...interface{}) *Router {
 	return router
 }
 
+// ProvideFunc registers request-scoped dependencies: each fn is a
+// func(*gin.Context) T resolving the T of a handler for its request.
+// See tonic.Container.RegisterFunc.
+func (router *Router) ProvideFunc(fns ...interface{}) *Router {
+	router.Container.RegisterFunc(fns...)
+	return router
+}
+
 func (router *Router) Group(
 	path string,
 	name string,
@@ -68,7 +77,7 @@ func (router *Router) Group(
 		middleware:  []any{},
 	}
 
-	router.groups = append(router.groups, *group)
+	router.groups = append(router.groups, group)
 	return group
 }
 
@@ -79,7 +88,7 @@ func (router *Router) AddGroup(group Group) *Router {
 		group.description,
 	)
 
-	router.groups = append(router.groups, group)
+	router.groups = append(router.groups, &group)
 	return router
 }
 
@@ -116,18 +125,35 @@ func (router *Router) Build() *fizz.Fizz {
 		router = getGroups(group, router)
 	}
 
-	for _, middleware := range router.middleware {
-		Base.Use(tonic.Handler(middleware, router.Container, 200))
-	}
+	middleware := MapHandlers(
+		router.middleware,
+		func(handler interface{}) gin.HandlerFunc {
+			return tonic.Handler(handlerFunc(handler), router.Container, 200)
+		},
+	)
+	Base.Use(middleware...)
 
+	security := router.security(nil)
 	for _, route := range router.routes {
+		route.docs = withMiddlewareDocs(route.docs, router.middleware, route.handlers)
+		route.docs = withSecurity(route.docs, security)
 		router.addRouteToRouter(route)
 	}
 
 	for _, group := range router.groups {
 
+		// Gin groups copy the handlers of their parent when created,
+		// which happens before Build: add the service middleware.
+		group.Base.Use(middleware...)
+
+		security := router.security(group.middleware)
+		for i, route := range group.routes {
+			route.docs = withMiddlewareDocs(route.docs, router.middleware, group.middleware, route.handlers)
+			group.routes[i].docs = withSecurity(route.docs, security)
+		}
+
 		for _, middleware := range group.middleware {
-			group.Base.Use(tonic.Handler(middleware, router.Container, 200))
+			group.Base.Use(tonic.Handler(handlerFunc(middleware), router.Container, 200))
 		}
 
 		if len(group.routes) > 0 {
@@ -143,21 +169,75 @@ func (router *Router) Build() *fizz.Fizz {
 	return router.Base
 }
 
+// security returns the documenter of the security enforced by the
+// given group middleware or, if none does, by the service middleware.
+// Its schemes are added to the specification.
+func (router *Router) security(middleware []interface{}) fizz.SecurityDocumenter {
+	for _, list := range [][]interface{}{middleware, router.middleware} {
+		for _, m := range list {
+			if d, ok := fizz.MiddlewareSecurity(m); ok {
+				router.Base.Generator().AddSecuritySchemes(d.SecuritySchemes())
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// withSecurity returns the operation options docs with the
+// security requirements of d, unless the options set theirs.
+func withSecurity(docs []fizz.OperationOption, d fizz.SecurityDocumenter) []fizz.OperationOption {
+	if d == nil {
+		return docs
+	}
+	return append(append([]fizz.OperationOption(nil), docs...), fizz.DefaultSecurity(d.SecurityRequirements()))
+}
+
+// MiddlewareHandler is implemented by the middleware which are values
+// rather than handler functions, such as the ones documenting the
+// operations they guard: the router registers their HandlerFunc.
+type MiddlewareHandler interface {
+	HandlerFunc() interface{}
+}
+
+// handlerFunc returns the handler function of h
+func handlerFunc(h interface{}) interface{} {
+	if m, ok := h.(MiddlewareHandler); ok {
+		return m.HandlerFunc()
+	}
+	return h
+}
+
+// withMiddlewareDocs returns the operation options docs followed
+// by the options of the fizz.OperationDocumenter middleware of
+// the given lists, from the outermost.
+func withMiddlewareDocs(docs []fizz.OperationOption, lists ...[]interface{}) []fizz.OperationOption {
+	res := docs
+	for _, list := range lists {
+		for _, m := range list {
+			if d, ok := m.(fizz.OperationDocumenter); ok {
+				if len(res) == len(docs) {
+					res = append([]fizz.OperationOption(nil), docs...)
+				}
+				res = append(res, d.OperationOptions()...)
+			}
+		}
+	}
+	return res
+}
+
 func (router *Router) addRouteToRouter(route Route) {
 
 	mappedHandlers := MapHandlers(
 		route.handlers,
 		func(handler interface{}) gin.HandlerFunc {
-			return tonic.Handler(handler, router.Container, route.status)
+			return tonic.Handler(handlerFunc(handler), router.Container, route.status)
 		},
 	)
 
-	id, err := uuid.NewV4()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	route.docs = append(route.docs, fizz.ID(id.String()))
+	fullPath := joinPath(router.Base.GinRouterGroup().BasePath(), route.path)
+	// Prepended, so a fizz.ID of the route overrides it.
+	route.docs = append([]fizz.OperationOption{fizz.ID(router.operationID(route.method, fullPath))}, route.docs...)
 
 	router.Base.Handle(
 		route.path,
@@ -167,6 +247,49 @@ func (router *Router) addRouteToRouter(route Route) {
 	)
 }
 
+// operationID returns the ID of the operation of the route at method
+// and path, derived from both so the generated specification is the
+// same on every build: GET /users/:id becomes getUsersById.
+func (router *Router) operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			continue
+		}
+		if seg[0] == ':' || seg[0] == '*' {
+			b.WriteString("By")
+			seg = seg[1:]
+		}
+		for _, word := range strings.FieldsFunc(seg, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			r := []rune(word)
+			b.WriteString(string(unicode.ToUpper(r[0])) + string(r[1:]))
+		}
+	}
+	id := b.String()
+
+	if router.operationIDs == nil {
+		router.operationIDs = make(map[string]bool)
+	}
+	// Paths differing only by punctuation get the same ID.
+	for i := 2; router.operationIDs[id]; i++ {
+		id = fmt.Sprintf("%s%d", b.String(), i)
+	}
+	router.operationIDs[id] = true
+
+	return id
+}
+
+func joinPath(base, path string) string {
+	if path == "" {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
 func (group *Group) Middleware(handlers ...interface{}) *Group {
 	group.middleware = append(group.middleware, handlers...)
 	return group
@@ -221,16 +344,12 @@ func (group *Group) addRouteToGroup(route Route) {
 	mappedHandlers := MapHandlers(
 		route.handlers,
 		func(handler interface{}) gin.HandlerFunc {
-			return tonic.Handler(handler, group.router.Container, route.status)
+			return tonic.Handler(handlerFunc(handler), group.router.Container, route.status)
 		},
 	)
 
-	id, err := uuid.NewV4()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	route.docs = append(route.docs, fizz.ID(id.String()))
+	fullPath := joinPath(group.Base.GinRouterGroup().BasePath(), route.path)
+	route.docs = append([]fizz.OperationOption{fizz.ID(group.router.operationID(route.method, fullPath))}, route.docs...)
 
 	group.Base.Handle(
 		route.path,
@@ -260,7 +379,7 @@ func MapHandlers[T, U any](ts []T, f func(T) U) []U {
 	return res
 }
 
-func getGroups(group Group, router *Router) *Router {
+func getGroups(group *Group, router *Router) *Router {
 
 	if group.Base == nil {
 		group.Base = router.Base.Group(
@@ -274,7 +393,7 @@ func getGroups(group Group, router *Router) *Router {
 		for _, subgroup := range group.groups {
 			subgroup.path = fmt.Sprintf("%s%s", group.path, subgroup.path)
 			subgroup.middleware = append(subgroup.middleware, group.middleware...)
-			router = getGroups(*subgroup, router)
+			router = getGroups(subgroup, router)
 		}
 	}
 
@@ -286,7 +405,7 @@ func getGroups(group Group, router *Router) *Router {
 
 }
 
-func contains(groups []Group, comparator Group) bool {
+func contains(groups []*Group, comparator *Group) bool {
 	for _, group := range groups {
 		if group.path == comparator.path {
 			return true
@@ -310,7 +429,7 @@ func Create(config config.Config) Router {
 		return Router{
 			Base:       fizz.NewFromEngine(gin.New()),
 			Container:  container,
-			groups:     []Group{},
+			groups:     []*Group{},
 			routes:     []Route{},
 			middleware: []any{},
 		}
@@ -325,7 +444,7 @@ func Create(config config.Config) Router {
 		Base:       fizz.New(),
 		Db:         &connection,
 		Container:  container,
-		groups:     []Group{},
+		groups:     []*Group{},
 		routes:     []Route{},
 		middleware: []any{},
 	}
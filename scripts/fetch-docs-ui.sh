@@ -0,0 +1,21 @@
+#!/usr/bin/env bash
+# fetch-docs-ui.sh — vendor the assets of the documentation renderers
+# (Swagger UI, Redoc, Scalar) into fizz/ui/assets, where they are
+# embedded in the binary. Run through `go generate ./fizz/ui`.
+#
+# The versions are pinned, and must match the versions listed
+# in fizz/ui/ui.go.
+
+set -euo pipefail
+cd "$(dirname "$0")/../fizz/ui/assets"
+
+fetch() {
+  echo "=== $1"
+  curl -fsSL -o "$1" "$2"
+}
+
+fetch swagger-ui.css                  https://unpkg.com/swagger-ui-dist@5.18.2/swagger-ui.css
+fetch swagger-ui-bundle.js            https://unpkg.com/swagger-ui-dist@5.18.2/swagger-ui-bundle.js
+fetch swagger-ui-standalone-preset.js https://unpkg.com/swagger-ui-dist@5.18.2/swagger-ui-standalone-preset.js
+fetch redoc.standalone.js             https://cdn.redoc.ly/redoc/v2.1.3/bundles/redoc.standalone.js
+fetch scalar-api-reference.js         https://cdn.jsdelivr.net/npm/@scalar/api-reference@1.24.0/dist/browser/standalone.js
@@ -0,0 +1,566 @@
+package sdkgen
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+
+	"mkfst/fizz/openapi"
+)
+
+// Go generates the source of a Go client package for
+// the API. The source is formatted with gofmt.
+func Go(api *openapi.OpenAPI, opts Options) ([]byte, error) {
+	m, err := newModel(api, opts)
+	if err != nil {
+		return nil, err
+	}
+	pkg := opts.Package
+	if pkg == "" {
+		pkg = "client"
+	}
+	g := &goEmitter{model: m}
+
+	var body strings.Builder
+	g.w = &body
+	for _, name := range m.types {
+		g.emitType(name)
+	}
+	for _, op := range m.ops {
+		g.emitOperation(op)
+	}
+
+	var out strings.Builder
+	out.WriteString("// Code generated by mkfst sdkgen. DO NOT EDIT.\n\n")
+	title := "the API"
+	if api.Info != nil && api.Info.Title != "" {
+		title = "the " + api.Info.Title + " API"
+	}
+	fmt.Fprintf(&out, "// Package %s is a client for %s.\npackage %s\n\n", pkg, title, pkg)
+	out.WriteString("import (\n")
+	for _, imp := range []string{"bytes", "context", "encoding/json", "errors", "fmt", "io", "net/http", "net/url", "strings", "time"} {
+		fmt.Fprintf(&out, "\t%q\n", imp)
+	}
+	out.WriteString(")\n\n")
+	out.WriteString(goRuntime)
+	out.WriteString(body.String())
+
+	src, err := format.Source([]byte(out.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return src, nil
+}
+
+type goEmitter struct {
+	*model
+	w *strings.Builder
+}
+
+func (g *goEmitter) printf(format string, a ...interface{}) {
+	fmt.Fprintf(g.w, format, a...)
+}
+
+func (g *goEmitter) emitType(name string) {
+	id := g.names[name]
+	s := g.api.Components.Schemas[name]
+
+	if sc := g.resolve(s); sc != nil {
+		desc := sc.Description
+		if desc == "" {
+			desc = sc.Title
+		}
+		g.w.WriteString(comment("//", desc))
+	}
+	if s != nil && s.Reference != nil {
+		g.printf("type %s = %s\n\n", id, g.typeExpr(s, false))
+		return
+	}
+	g.printf("type %s %s\n\n", id, g.typeExpr(s, false))
+}
+
+// typeExpr returns the Go type of the schema. Nullable
+// schemas are represented by pointers when nullable is set.
+func (g *goEmitter) typeExpr(s *openapi.SchemaOrRef, nullable bool) string {
+	if s == nil {
+		return "any"
+	}
+	if name := g.refName(s); name != "" {
+		if nullable && g.isStruct(s) {
+			return "*" + name
+		}
+		return name
+	}
+	if s.Reference != nil || s.Schema == nil {
+		return "any"
+	}
+	sc := s.Schema
+	var t string
+	switch sc.Type {
+	case "string":
+		switch sc.Format {
+		case "date-time":
+			t = "time.Time"
+		case "byte", "binary":
+			return "[]byte"
+		default:
+			t = "string"
+		}
+	case "integer":
+		switch sc.Format {
+		case "int32":
+			t = "int32"
+		case "int64":
+			t = "int64"
+		default:
+			t = "int"
+		}
+	case "number":
+		if sc.Format == "float" {
+			t = "float32"
+		} else {
+			t = "float64"
+		}
+	case "boolean":
+		t = "bool"
+	case "array":
+		return "[]" + g.typeExpr(sc.Items, false)
+	case "object":
+		if len(sc.Properties) == 0 {
+			if sc.AdditionalProperties != nil {
+				return "map[string]" + g.typeExpr(sc.AdditionalProperties, false)
+			}
+			return "map[string]any"
+		}
+		t = g.structExpr(sc)
+	default:
+		if len(sc.OneOf) != 0 || len(sc.AnyOf) != 0 {
+			// Polymorphic values are left for the
+			// caller to decode, by discriminator.
+			return "json.RawMessage"
+		}
+		return "any"
+	}
+	if nullable && sc.Nullable {
+		return "*" + t
+	}
+	return t
+}
+
+func (g *goEmitter) structExpr(sc *openapi.Schema) string {
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	fields := make(map[string]int)
+	for _, name := range sortedProperties(sc) {
+		ps := sc.Properties[name]
+		if d := g.resolve(ps); d != nil && ps.Reference == nil {
+			b.WriteString(comment("//", d.Description))
+		}
+		tag := name
+		if !isRequired(sc, name) {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "%s %s `json:%q`\n", unique(fields, exportedName(name)), g.typeExpr(ps, true), tag)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// isStruct returns whether s is generated as a Go struct.
+func (g *goEmitter) isStruct(s *openapi.SchemaOrRef) bool {
+	sc := g.resolve(s)
+	return sc != nil && sc.Type == "object" && len(sc.Properties) != 0
+}
+
+// paramExpr returns the type of a request field. Optional
+// scalars are pointers, so that zero values can be sent.
+func (g *goEmitter) paramExpr(p *param) string {
+	t := g.typeExpr(p.Schema, false)
+	if p.Required || strings.HasPrefix(t, "[]") || strings.HasPrefix(t, "map[") {
+		return t
+	}
+	return "*" + t
+}
+
+// bodyExpr returns the type of the request body, and
+// whether it is a pointer because the body is optional.
+func (g *goEmitter) bodyExpr(op *operation) (string, bool) {
+	t := g.typeExpr(op.Body, false)
+	return t, !op.BodyReq && !strings.HasPrefix(t, "[]") && !strings.HasPrefix(t, "map[")
+}
+
+func (g *goEmitter) emitOperation(op *operation) {
+	req := op.Name + "Request"
+	if op.HasInput() {
+		g.printf("// %s holds the parameters of %s.\n", req, op.Name)
+		g.printf("type %s struct {\n", req)
+		for _, p := range op.Params {
+			g.w.WriteString(comment("//", p.Description))
+			g.printf("%s %s `%s:%q`\n", p.Field, g.paramExpr(p), p.In, p.Name)
+		}
+		if op.Body != nil {
+			t, ptr := g.bodyExpr(op)
+			if ptr {
+				t = "*" + t
+			}
+			g.printf("Body %s `json:\"-\"`\n", t)
+		}
+		g.printf("}\n\n")
+	}
+
+	// Request builder.
+	builder := unexportedName(op.Name) + "Request"
+	if op.HasInput() {
+		g.printf("func (c *Client) %s(ctx context.Context, in *%s) (*http.Request, error) {\n", builder, req)
+		g.printf("if in == nil {\nin = new(%s)\n}\n", req)
+	} else {
+		g.printf("func (c *Client) %s(ctx context.Context) (*http.Request, error) {\n", builder)
+	}
+	g.emitPath(op)
+	g.emitParams(op, "query", "q := make(url.Values)", "q", "Add")
+	g.emitParams(op, "header", "h := make(http.Header)", "h", "Add")
+	q, h, body := "nil", "nil", "nil"
+	if hasParam(op, "query") {
+		q = "q"
+	}
+	if hasParam(op, "header") {
+		h = "h"
+	}
+	if op.Body != nil {
+		body = "in.Body"
+		if _, ptr := g.bodyExpr(op); ptr {
+			// Don't send a null body for a nil pointer.
+			g.printf("var body any\nif in.Body != nil {\nbody = in.Body\n}\n")
+			body = "body"
+		}
+	}
+	g.printf("return c.newRequest(ctx, %q, path, %s, %s, %s)\n}\n\n", op.Method, q, h, body)
+
+	// Operation method.
+	g.printf("// %s calls %s %s.\n", op.Name, op.Method, op.Path)
+	for _, text := range []string{op.Summary, op.Description} {
+		if text != "" {
+			g.printf("//\n")
+			g.w.WriteString(comment("//", text))
+		}
+	}
+	if op.Deprecated {
+		g.printf("//\n// Deprecated: the operation is deprecated by the API.\n")
+	}
+	args, call := "ctx context.Context", "ctx"
+	if op.HasInput() {
+		args += ", in *" + req
+		call += ", in"
+	}
+	if op.Result == nil {
+		g.printf("func (c *Client) %s(%s) error {\n", op.Name, args)
+		g.printf("req, err := c.%s(%s)\nif err != nil {\nreturn err\n}\n", builder, call)
+		g.printf("_, err = c.send(req, nil)\nreturn err\n}\n\n")
+		return
+	}
+	t := g.typeExpr(op.Result, false)
+	ret, ref, zero := t, "out", "out"
+	if g.isStruct(op.Result) {
+		ret, ref, zero = "*"+t, "&out", "nil"
+	}
+	g.printf("func (c *Client) %s(%s) (%s, error) {\n", op.Name, args, ret)
+	g.printf("var out %s\n", t)
+	g.printf("req, err := c.%s(%s)\nif err != nil {\nreturn %s, err\n}\n", builder, call, zero)
+	g.printf("if _, err := c.send(req, &out); err != nil {\nreturn %s, err\n}\n", zero)
+	g.printf("return %s, nil\n}\n\n", ref)
+
+	if op.Paginated {
+		g.printf("// %sPages returns a pager over the pages of %s. The following\n", op.Name, op.Name)
+		g.printf("// pages are requested from the Link header of each response.\n")
+		g.printf("func (c *Client) %sPages(%s) *Pager[%s] {\n", op.Name, strings.TrimPrefix(strings.TrimPrefix(args, "ctx context.Context"), ", "), t)
+		g.printf("return &Pager[%s]{\nc: c,\nfirst: func(ctx context.Context) (*http.Request, error) {\nreturn c.%s(%s)\n},\n}\n}\n\n", t, builder, call)
+	}
+}
+
+func (g *goEmitter) emitPath(op *operation) {
+	var parts []string
+	rest := op.Path
+	for {
+		i := strings.Index(rest, "{")
+		j := strings.Index(rest, "}")
+		if i < 0 || j < i {
+			break
+		}
+		if i > 0 {
+			parts = append(parts, fmt.Sprintf("%q", rest[:i]))
+		}
+		name := rest[i+1 : j]
+		field := ""
+		for _, p := range op.Params {
+			if p.In == "path" && p.Name == name {
+				field = p.Field
+			}
+		}
+		if field == "" {
+			parts = append(parts, fmt.Sprintf("%q", rest[i:j+1]))
+		} else {
+			parts = append(parts, fmt.Sprintf("url.PathEscape(formatParam(in.%s))", field))
+		}
+		rest = rest[j+1:]
+	}
+	if rest != "" || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%q", rest))
+	}
+	g.printf("path := %s\n", strings.Join(parts, " + "))
+}
+
+func (g *goEmitter) emitParams(op *operation, in, decl, v, add string) {
+	if !hasParam(op, in) {
+		return
+	}
+	g.printf("%s\n", decl)
+	for _, p := range op.Params {
+		if p.In != in {
+			continue
+		}
+		t := g.paramExpr(p)
+		switch {
+		case strings.HasPrefix(t, "[]") && t != "[]byte":
+			if p.Explode {
+				g.printf("for _, v := range in.%s {\n%s.%s(%q, formatParam(v))\n}\n", p.Field, v, add, p.Name)
+			} else {
+				g.printf("if len(in.%s) != 0 {\nvs := make([]string, 0, len(in.%s))\n", p.Field, p.Field)
+				g.printf("for _, v := range in.%s {\nvs = append(vs, formatParam(v))\n}\n", p.Field)
+				g.printf("%s.%s(%q, strings.Join(vs, \",\"))\n}\n", v, add, p.Name)
+			}
+		case strings.HasPrefix(t, "*"):
+			g.printf("if in.%s != nil {\n%s.%s(%q, formatParam(*in.%s))\n}\n", p.Field, v, add, p.Name, p.Field)
+		default:
+			g.printf("%s.%s(%q, formatParam(in.%s))\n", v, add, p.Name, p.Field)
+		}
+	}
+}
+
+func hasParam(op *operation, in string) bool {
+	for _, p := range op.Params {
+		if p.In == in {
+			return true
+		}
+	}
+	return false
+}
+
+// goRuntime is the part of the Go client that
+// doesn't depend on the specification.
+const goRuntime = `// Client sends requests to the API.
+type Client struct {
+	// BaseURL is the URL the operation paths are
+	// resolved against, such as https://api.example.com.
+	BaseURL string
+
+	// HTTPClient sends the requests.
+	// Default to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Header holds the headers sent with every
+	// request, such as the Authorization header.
+	Header http.Header
+}
+
+// New returns a client for the API served at baseURL.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		Header:     make(http.Header),
+	}
+}
+
+// Error is the error returned for responses with a
+// status code outside of the 2xx range.
+type Error struct {
+	StatusCode int
+	// Message is the error message returned by
+	// the API, if the body holds one.
+	Message string
+	Header  http.Header
+	Body    []byte
+}
+
+// Error implements the error interface for Error.
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Decode decodes the body of the error response into v.
+func (e *Error) Decode(v any) error {
+	return json.Unmarshal(e.Body, v)
+}
+
+// StatusCode returns the status code of the response
+// that caused err, or 0 if err is not an *Error.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// ErrNoMorePages is returned by Pager.Next once
+// the last page has been returned.
+var ErrNoMorePages = errors.New("no more pages")
+
+// Pager iterates over the pages of a paginated operation.
+//
+//	for p.More() {
+//		page, err := p.Next(ctx)
+//		...
+//	}
+type Pager[T any] struct {
+	c       *Client
+	first   func(context.Context) (*http.Request, error)
+	next    string
+	started bool
+}
+
+// More returns whether another page is available.
+func (p *Pager[T]) More() bool {
+	return !p.started || p.next != ""
+}
+
+// Next requests and returns the next page.
+func (p *Pager[T]) Next(ctx context.Context) (T, error) {
+	var page T
+	if !p.More() {
+		return page, ErrNoMorePages
+	}
+	var (
+		req *http.Request
+		err error
+	)
+	if !p.started {
+		req, err = p.first(ctx)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, p.next, nil)
+	}
+	p.started, p.next = true, ""
+	if err != nil {
+		return page, err
+	}
+	h, err := p.c.send(req, &page)
+	if err != nil {
+		return page, err
+	}
+	if next := nextLink(h.Get("Link")); next != "" {
+		u, err := req.URL.Parse(next)
+		if err != nil {
+			return page, err
+		}
+		p.next = u.String()
+	}
+	return page, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, header http.Header, body any) (*http.Request, error) {
+	u := c.BaseURL + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// send sends req and decodes the JSON body of a
+// successful response into out, unless it is nil.
+func (c *Client) send(req *http.Request, out any) (http.Header, error) {
+	for k, vs := range c.Header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = vs
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &Error{StatusCode: resp.StatusCode, Header: resp.Header, Body: b}
+		var payload struct {
+			Error   string ` + "`json:\"error\"`" + `
+			Message string ` + "`json:\"message\"`" + `
+		}
+		if json.Unmarshal(b, &payload) == nil {
+			e.Message = payload.Error
+			if e.Message == "" {
+				e.Message = payload.Message
+			}
+		}
+		return resp.Header, e
+	}
+	if out != nil && len(bytes.TrimSpace(b)) != 0 {
+		if err := json.Unmarshal(b, out); err != nil {
+			return resp.Header, fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// nextLink returns the target of the rel="next"
+// link of a Link header, as per RFC 8288.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, attr := range parts[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(attr), "=")
+			if strings.EqualFold(k, "rel") && strings.Trim(v, "\"") == "next" {
+				return target[1 : len(target)-1]
+			}
+		}
+	}
+	return ""
+}
+
+// formatParam formats a parameter value the way
+// the server binds it from a string.
+func formatParam(v any) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return t.String()
+	}
+	return fmt.Sprint(v)
+}
+
+`
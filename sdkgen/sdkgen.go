@@ -0,0 +1,476 @@
+// Package sdkgen generates typed API clients from the OpenAPI
+// specification of a mkfst service.
+//
+// The specification carries everything a client needs: fizz documents
+// the location of every input field (path, query, header or body) and
+// the schema of the success response of each route. sdkgen turns it into
+// a Go package or a TypeScript module exposing one method per operation,
+// a request type per operation, a typed error for non-2xx responses and
+// pagers for operations that document a Link response header.
+//
+//	f := svc.Build()
+//	src, err := sdkgen.Go(f.Generator().API(), sdkgen.Options{Package: "petstore"})
+//
+// Method names are derived from the method and path of each route,
+// spelling initialisms in uppercase: GET /users/{id}/posts becomes
+// GetUsersByIDPosts.
+package sdkgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"sigs.k8s.io/yaml"
+
+	"mkfst/fizz/openapi"
+)
+
+const componentsSchemaPath = "#/components/schemas/"
+
+// Options controls the generated code.
+type Options struct {
+	// Package is the name of the generated Go package.
+	// Default to "client".
+	Package string
+
+	// IncludeInternal includes the operations marked
+	// with x-internal, which are skipped by default.
+	IncludeInternal bool
+}
+
+// Load decodes an OpenAPI specification
+// in either the JSON or YAML format.
+func Load(r io.Reader) (*openapi.OpenAPI, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err = yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("decode specification: %w", err)
+	}
+	api := new(openapi.OpenAPI)
+	if err := json.Unmarshal(b, api); err != nil {
+		return nil, fmt.Errorf("decode specification: %w", err)
+	}
+	return api, nil
+}
+
+var (
+	methodOrder = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+		http.MethodOptions,
+		http.MethodTrace,
+	}
+	reUUIDInput = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}Input$`)
+	reNonIdent  = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+	// initialisms lists the words written in uppercase
+	// in Go identifiers, per the Go code review comments.
+	initialisms = map[string]bool{
+		"ACL": true, "API": true, "ASCII": true, "CPU": true, "CSS": true,
+		"DNS": true, "EOF": true, "GUID": true, "HTML": true, "HTTP": true,
+		"HTTPS": true, "ID": true, "IP": true, "JSON": true, "JWT": true,
+		"MFA": true, "OTP": true, "QPS": true, "RAM": true, "RPC": true,
+		"SAML": true, "SLA": true, "SMTP": true, "SQL": true, "SSH": true,
+		"TCP": true, "TLS": true, "TTL": true, "UDP": true, "UI": true,
+		"UID": true, "URI": true, "URL": true, "UTF8": true, "UUID": true,
+		"VM": true, "XML": true, "XMPP": true, "XSRF": true, "XSS": true,
+	}
+)
+
+// model is the language-neutral view of the
+// specification used by the code emitters.
+type model struct {
+	api   *openapi.OpenAPI
+	ops   []*operation
+	types []string          // component names, sorted
+	names map[string]string // component name to type identifier
+}
+
+// operation describes a single client method.
+type operation struct {
+	Name        string
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Deprecated  bool
+	Params      []*param
+	Body        *openapi.SchemaOrRef
+	BodyReq     bool
+	Result      *openapi.SchemaOrRef
+	Paginated   bool
+}
+
+// HasInput returns whether the operation
+// takes a request argument.
+func (op *operation) HasInput() bool {
+	return len(op.Params) != 0 || op.Body != nil
+}
+
+// param describes an operation parameter.
+type param struct {
+	Name        string // name on the wire
+	Field       string // exported identifier
+	In          string
+	Description string
+	Required    bool
+	Explode     bool
+	Schema      *openapi.SchemaOrRef
+}
+
+func newModel(api *openapi.OpenAPI, opts Options) (*model, error) {
+	if api == nil {
+		return nil, fmt.Errorf("nil specification")
+	}
+	m := &model{
+		api:   api,
+		names: make(map[string]string),
+	}
+	paths := make([]string, 0, len(api.Paths))
+	for p := range api.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	used := make(map[string]int)
+	bodies := make(map[string]string)
+
+	for _, p := range paths {
+		item := api.Paths[p]
+		if item == nil {
+			continue
+		}
+		for _, method := range methodOrder {
+			o := item.Operation(method)
+			if o == nil || (o.XInternal && !opts.IncludeInternal) {
+				continue
+			}
+			op, err := m.newOperation(method, p, item, o)
+			if err != nil {
+				return nil, err
+			}
+			op.Name = unique(used, op.Name)
+
+			if op.Body != nil && op.Body.Reference != nil {
+				name := strings.TrimPrefix(op.Body.Reference.Ref, componentsSchemaPath)
+				// The generated names of the input bodies are
+				// based on the operation ID, older ones on a UUID.
+				if name == upperFirst(o.ID)+"Input" || reUUIDInput.MatchString(name) {
+					bodies[name] = op.Name + "Body"
+				}
+			}
+			m.ops = append(m.ops, op)
+		}
+	}
+	if api.Components != nil {
+		for name := range api.Components.Schemas {
+			m.types = append(m.types, name)
+		}
+	}
+	sort.Strings(m.types)
+
+	// Reserve the names of the request types first, then
+	// name the components. Anonymous input bodies are named
+	// after their operation, other components after themselves.
+	taken := make(map[string]int)
+	for _, op := range m.ops {
+		taken[op.Name+"Request"] = 1
+	}
+	for _, name := range m.types {
+		id, ok := bodies[name]
+		if !ok {
+			id = exportedName(name)
+		}
+		m.names[name] = unique(taken, id)
+	}
+	return m, nil
+}
+
+func (m *model) newOperation(method, path string, item *openapi.PathItem, o *openapi.Operation) (*operation, error) {
+	op := &operation{
+		Name:        operationName(method, path),
+		Method:      method,
+		Path:        path,
+		Summary:     o.Summary,
+		Description: o.Description,
+		Deprecated:  o.Deprecated,
+	}
+	fields := make(map[string]int)
+	for _, por := range append(append([]*openapi.ParameterOrRef{}, item.Parameters...), o.Parameters...) {
+		p := m.resolveParameter(por)
+		if p == nil {
+			return nil, fmt.Errorf("%s %s: unresolvable parameter", method, path)
+		}
+		if p.In == "cookie" {
+			continue
+		}
+		op.Params = append(op.Params, &param{
+			Name:        p.Name,
+			Field:       unique(fields, exportedName(p.Name)),
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required || p.In == "path",
+			Explode:     p.Explode || p.Style == "",
+			Schema:      p.Schema,
+		})
+	}
+	if rb := o.RequestBody; rb != nil {
+		if mt := jsonMediaType(rb.Content); mt != nil {
+			op.Body = mt.Schema
+			op.BodyReq = rb.Required
+		}
+	}
+	if code, resp := m.successResponse(o); resp != nil {
+		if code != strconv.Itoa(http.StatusNoContent) {
+			for mtName, mt := range resp.Content {
+				if mt != nil && mt.MediaType != nil && isJSON(mtName) {
+					op.Result = mt.Schema
+					break
+				}
+			}
+		}
+		for name := range resp.Headers {
+			if strings.EqualFold(name, "Link") {
+				op.Paginated = true
+			}
+		}
+	}
+	return op, nil
+}
+
+// successResponse returns the 2xx response of the
+// operation with the lowest status code.
+func (m *model) successResponse(o *openapi.Operation) (string, *openapi.Response) {
+	codes := make([]string, 0, len(o.Responses))
+	for code := range o.Responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		ror := o.Responses[code]
+		if ror != nil && ror.Response != nil {
+			return code, ror.Response
+		}
+	}
+	return "", nil
+}
+
+// resolve returns the schema held by s, following
+// the references to the components.
+func (m *model) resolve(s *openapi.SchemaOrRef) *openapi.Schema {
+	for i := 0; s != nil && i < 32; i++ {
+		if s.Reference == nil {
+			return s.Schema
+		}
+		if m.api.Components == nil {
+			return nil
+		}
+		s = m.api.Components.Schemas[strings.TrimPrefix(s.Reference.Ref, componentsSchemaPath)]
+	}
+	return nil
+}
+
+// refName returns the identifier of the component
+// referenced by s, or an empty string.
+func (m *model) refName(s *openapi.SchemaOrRef) string {
+	if s == nil || s.Reference == nil {
+		return ""
+	}
+	return m.names[strings.TrimPrefix(s.Reference.Ref, componentsSchemaPath)]
+}
+
+func (m *model) resolveParameter(p *openapi.ParameterOrRef) *openapi.Parameter {
+	if p == nil {
+		return nil
+	}
+	if p.Reference == nil {
+		return p.Parameter
+	}
+	if m.api.Components == nil {
+		return nil
+	}
+	ref := m.api.Components.Parameters[strings.TrimPrefix(p.Reference.Ref, "#/components/parameters/")]
+	if ref == nil || ref.Reference != nil {
+		return nil
+	}
+	return ref.Parameter
+}
+
+// sortedProperties returns the property names of
+// the schema, with the required ones first.
+func sortedProperties(s *openapi.Schema) []string {
+	required := make(map[string]bool, len(s.Required))
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		if required[names[i]] != required[names[j]] {
+			return required[names[i]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func isRequired(s *openapi.Schema, name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonMediaType(content map[string]*openapi.MediaType) *openapi.MediaType {
+	keys := make([]string, 0, len(content))
+	for k := range content {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if isJSON(k) && content[k] != nil {
+			return content[k]
+		}
+	}
+	return nil
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "*/*" || strings.Contains(mediaType, "json")
+}
+
+// operationName derives a method name from an
+// HTTP method and an OpenAPI path.
+func operationName(method, path string) string {
+	var b strings.Builder
+	b.WriteString(exportedName(strings.ToLower(method)))
+
+	segments := 0
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			continue
+		}
+		segments++
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			b.WriteString("By")
+			b.WriteString(exportedName(seg[1 : len(seg)-1]))
+			continue
+		}
+		b.WriteString(exportedName(seg))
+	}
+	if segments == 0 {
+		b.WriteString("Root")
+	}
+	return b.String()
+}
+
+// exportedName converts s to an exported Go
+// identifier, honoring the common initialisms.
+func exportedName(s string) string {
+	var b strings.Builder
+	for _, w := range splitWords(s) {
+		if u := strings.ToUpper(w); initialisms[u] {
+			b.WriteString(u)
+			continue
+		}
+		r := []rune(w)
+		b.WriteRune(unicode.ToUpper(r[0]))
+		b.WriteString(string(r[1:]))
+	}
+	id := b.String()
+	if id == "" {
+		return "X"
+	}
+	if unicode.IsDigit(rune(id[0])) {
+		id = "X" + id
+	}
+	return id
+}
+
+// upperFirst returns s with its first letter in uppercase, as
+// the generator does for the names of the input bodies.
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	return string(unicode.ToUpper(r[0])) + string(r[1:])
+}
+
+// unexportedName converts s to an unexported identifier,
+// lowering the first word: UserID becomes userID.
+func unexportedName(s string) string {
+	id := exportedName(s)
+	words := splitWords(id)
+	if len(words) == 0 {
+		return "x"
+	}
+	return strings.ToLower(words[0]) + id[len(words[0]):]
+}
+
+// splitWords splits s on non-alphanumeric characters
+// and on lowercase to uppercase transitions.
+func splitWords(s string) []string {
+	var words []string
+	for _, part := range reNonIdent.Split(s, -1) {
+		r := []rune(part)
+		start := 0
+		for i := 1; i < len(r); i++ {
+			lowerToUpper := unicode.IsLower(r[i-1]) && unicode.IsUpper(r[i])
+			acronymEnd := i+1 < len(r) && unicode.IsUpper(r[i-1]) && unicode.IsUpper(r[i]) && unicode.IsLower(r[i+1])
+			if lowerToUpper || acronymEnd {
+				words = append(words, string(r[start:i]))
+				start = i
+			}
+		}
+		if start < len(r) {
+			words = append(words, string(r[start:]))
+		}
+	}
+	return words
+}
+
+// unique returns name, or name suffixed with a number
+// if it was already returned for the same set.
+func unique(used map[string]int, name string) string {
+	n := used[name]
+	used[name] = n + 1
+	if n == 0 {
+		return name
+	}
+	return unique(used, name+strconv.Itoa(n+1))
+}
+
+// comment formats text as a comment with the given prefix.
+func comment(prefix, text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(strings.TrimRight(prefix+" "+strings.TrimSpace(line), " "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
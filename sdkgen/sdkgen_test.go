@@ -0,0 +1,146 @@
+package sdkgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mkfst/config"
+	"mkfst/fizz"
+	"mkfst/fizz/openapi"
+	"mkfst/mkfsttest"
+)
+
+type Pet struct {
+	ID      int64             `json:"id" validate:"required"`
+	Name    string            `json:"name" validate:"required"`
+	Born    time.Time         `json:"born"`
+	Kind    string            `json:"kind" enum:"cat,dog"`
+	Tags    []string          `json:"tags,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	OwnerID *int64            `json:"owner_id"`
+}
+
+type listPetsIn struct {
+	Kind   []string `query:"kind" explode:"false"`
+	Limit  int      `query:"limit"`
+	Tenant string   `header:"X-Tenant" validate:"required"`
+}
+
+type updatePetIn struct {
+	ID   int64  `path:"id"`
+	Name string `json:"name" validate:"required"`
+}
+
+func petSpec(t *testing.T) *openapi.OpenAPI {
+	h := mkfsttest.New(t, config.Config{})
+	pets := h.Service.Group("/pets", "pets", "Pets")
+	pets.Route("GET", "", http.StatusOK, []fizz.OperationOption{
+		fizz.Summary("List the pets"),
+		fizz.Header("Link", "Links to the other pages", ""),
+	}, func(c *gin.Context, in *listPetsIn) ([]Pet, error) { return nil, nil })
+	pets.Route("PUT", "/:id", http.StatusOK, []fizz.OperationOption{},
+		func(c *gin.Context, in *updatePetIn) (*Pet, error) { return nil, nil })
+	pets.Route("DELETE", "/:id", http.StatusNoContent, []fizz.OperationOption{},
+		func(c *gin.Context, in *struct {
+			ID int64 `path:"id"`
+		}) error {
+			return nil
+		})
+	return h.Spec()
+}
+
+func TestGoClientTypeChecks(t *testing.T) {
+	src, err := Go(petSpec(t), Options{Package: "petstore"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "client.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v\n%s", err, src)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("petstore", fset, []*ast.File{f}, nil); err != nil {
+		t.Fatalf("type check: %v\n%s", err, src)
+	}
+	// Ignore the alignment of struct fields by gofmt.
+	flat := strings.Join(strings.Fields(string(src)), " ")
+	for _, want := range []string{
+		"func (c *Client) GetPets(ctx context.Context, in *GetPetsRequest) ([]SdkgenPet, error)",
+		"func (c *Client) GetPetsPages(in *GetPetsRequest) *Pager[[]SdkgenPet]",
+		"func (c *Client) PutPetsByID(ctx context.Context, in *PutPetsByIDRequest) (*SdkgenPet, error)",
+		"func (c *Client) DeletePetsByID(ctx context.Context, in *DeletePetsByIDRequest) error",
+		"Body *PutPetsByIDBody `json:\"-\"`",
+		"XTenant string `header:\"X-Tenant\"`",
+		"OwnerID *int64 `json:\"owner_id,omitempty\"`",
+		`q.Add("kind", strings.Join(vs, ","))`,
+	} {
+		if !strings.Contains(flat, want) {
+			t.Errorf("generated Go client lacks %q", want)
+		}
+	}
+}
+
+func TestTypeScriptClient(t *testing.T) {
+	src, err := TypeScript(petSpec(t), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"export interface SdkgenPet {",
+		`kind?: "cat" | "dog";`,
+		"owner_id?: number | null;",
+		"async getPets(req: GetPetsRequest, init?: RequestInit): Promise<SdkgenPet[]>",
+		"async *getPetsPages(req: GetPetsRequest, init?: RequestInit): AsyncGenerator<SdkgenPet[]>",
+		"`/pets/${encodeURIComponent(String(req.id))}`",
+		`if (req.xTenant !== undefined) headers["X-Tenant"] = String(req.xTenant);`,
+		"async deletePetsByID(req: DeletePetsByIDRequest, init?: RequestInit): Promise<void>",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated TypeScript client lacks %q", want)
+		}
+	}
+}
+
+func TestLoadRoundTrip(t *testing.T) {
+	b, err := json.Marshal(petSpec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := Load(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := api.Operation("PUT", "/pets/{id}")
+	if op == nil || op.RequestBody == nil || len(op.Parameters) != 1 {
+		t.Fatalf("unexpected decoded operation: %+v", op)
+	}
+}
+
+func TestNames(t *testing.T) {
+	for in, want := range map[string]string{
+		"GET /":                 "GetRoot",
+		"GET /users/{id}/posts": "GetUsersByIDPosts",
+		"POST /api/v1/api-keys": "PostAPIV1APIKeys",
+		"PATCH /users/{userId}": "PatchUsersByUserID",
+	} {
+		method, path, _ := strings.Cut(in, " ")
+		if got := operationName(method, path); got != want {
+			t.Errorf("operationName(%s) = %s, want %s", in, got, want)
+		}
+	}
+	if got := unexportedName("IDToken"); got != "idToken" {
+		t.Errorf("unexportedName(IDToken) = %s", got)
+	}
+}
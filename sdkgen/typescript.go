@@ -0,0 +1,404 @@
+package sdkgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"mkfst/fizz/openapi"
+)
+
+var reTSIdent = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// TypeScript generates the source of a TypeScript module exposing
+// a client for the API. The module only depends on the Fetch API.
+func TypeScript(api *openapi.OpenAPI, opts Options) ([]byte, error) {
+	m, err := newModel(api, opts)
+	if err != nil {
+		return nil, err
+	}
+	g := &tsEmitter{model: m, w: new(strings.Builder)}
+
+	g.printf("// Code generated by mkfst sdkgen. DO NOT EDIT.\n\n")
+	for _, name := range m.types {
+		g.emitType(name)
+	}
+	for _, op := range m.ops {
+		if op.HasInput() {
+			g.emitRequest(op)
+		}
+	}
+	g.w.WriteString(tsRuntimeHead)
+	for _, op := range m.ops {
+		g.emitOperation(op)
+	}
+	g.w.WriteString(tsRuntimeTail)
+
+	return []byte(g.w.String()), nil
+}
+
+type tsEmitter struct {
+	*model
+	w *strings.Builder
+}
+
+func (g *tsEmitter) printf(format string, a ...interface{}) {
+	fmt.Fprintf(g.w, format, a...)
+}
+
+func (g *tsEmitter) emitType(name string) {
+	id := g.names[name]
+	s := g.api.Components.Schemas[name]
+
+	if sc := g.resolve(s); sc != nil {
+		desc := sc.Description
+		if desc == "" {
+			desc = sc.Title
+		}
+		g.w.WriteString(jsdoc("", desc))
+	}
+	if s != nil && s.Schema != nil && s.Schema.Type == "object" && len(s.Schema.Properties) != 0 {
+		g.printf("export interface %s %s\n\n", id, g.objectExpr(s.Schema, ""))
+		return
+	}
+	g.printf("export type %s = %s;\n\n", id, g.typeExpr(s, ""))
+}
+
+// typeExpr returns the TypeScript type of the schema.
+// Inline objects are indented with indent.
+func (g *tsEmitter) typeExpr(s *openapi.SchemaOrRef, indent string) string {
+	if s == nil {
+		return "unknown"
+	}
+	if name := g.refName(s); name != "" {
+		return name
+	}
+	if s.Reference != nil || s.Schema == nil {
+		return "unknown"
+	}
+	sc := s.Schema
+	var t string
+	switch sc.Type {
+	case "string", "integer", "number", "boolean":
+		t = map[string]string{"string": "string", "integer": "number", "number": "number", "boolean": "boolean"}[sc.Type]
+		enum := sc.Enum
+		if sc.Const != nil {
+			enum = []interface{}{sc.Const}
+		}
+		if len(enum) != 0 {
+			lits := make([]string, 0, len(enum))
+			for _, e := range enum {
+				if e == nil {
+					continue
+				}
+				b, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				lits = append(lits, string(b))
+			}
+			t = strings.Join(lits, " | ")
+		}
+	case "array":
+		it := g.typeExpr(sc.Items, indent)
+		if strings.Contains(it, " | ") {
+			it = "(" + it + ")"
+		}
+		t = it + "[]"
+	case "object":
+		switch {
+		case len(sc.Properties) != 0:
+			t = g.objectExpr(sc, indent)
+		case sc.AdditionalProperties != nil:
+			t = "Record<string, " + g.typeExpr(sc.AdditionalProperties, indent) + ">"
+		default:
+			t = "Record<string, unknown>"
+		}
+	default:
+		alts := sc.OneOf
+		if len(alts) == 0 {
+			alts = sc.AnyOf
+		}
+		if len(alts) == 0 {
+			return "unknown"
+		}
+		ts := make([]string, len(alts))
+		for i, a := range alts {
+			ts[i] = g.typeExpr(a, indent)
+		}
+		t = strings.Join(ts, " | ")
+	}
+	if sc.Nullable {
+		t += " | null"
+	}
+	return t
+}
+
+func (g *tsEmitter) objectExpr(sc *openapi.Schema, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range sortedProperties(sc) {
+		ps := sc.Properties[name]
+		if d := g.resolve(ps); d != nil && ps.Reference == nil {
+			b.WriteString(jsdoc(indent+"  ", d.Description))
+		}
+		opt := "?"
+		if isRequired(sc, name) {
+			opt = ""
+		}
+		fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, tsProp(name), opt, g.typeExpr(ps, indent+"  "))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func (g *tsEmitter) emitRequest(op *operation) {
+	g.printf("/** Parameters of {@link Client.%s}. */\n", unexportedName(op.Name))
+	g.printf("export interface %sRequest {\n", op.Name)
+	for _, p := range op.Params {
+		if p.Description != "" {
+			g.w.WriteString(jsdoc("  ", p.Description))
+		}
+		g.w.WriteString(jsdoc("  ", fmt.Sprintf("Sent in the %s as `%s`.", p.In, p.Name)))
+		opt := "?"
+		if p.Required {
+			opt = ""
+		}
+		g.printf("  %s%s: %s;\n", unexportedName(p.Field), opt, g.typeExpr(p.Schema, "  "))
+	}
+	if op.Body != nil {
+		opt := "?"
+		if op.BodyReq {
+			opt = ""
+		}
+		g.printf("  body%s: %s;\n", opt, g.typeExpr(op.Body, "  "))
+	}
+	g.printf("}\n\n")
+}
+
+func (g *tsEmitter) emitOperation(op *operation) {
+	name := unexportedName(op.Name)
+	result := "void"
+	if op.Result != nil {
+		result = g.typeExpr(op.Result, "  ")
+	}
+	args := "init?: RequestInit"
+	if op.HasInput() {
+		opt := "?"
+		for _, p := range op.Params {
+			if p.Required {
+				opt = ""
+			}
+		}
+		if op.BodyReq {
+			opt = ""
+		}
+		args = fmt.Sprintf("req%s: %sRequest, %s", opt, op.Name, args)
+	}
+
+	// Request builder, shared with the pager.
+	g.printf("  private %sRequest(%s): [string, RequestInit & { query: URLSearchParams }] {\n", name, args)
+	if op.HasInput() {
+		g.printf("    req = req ?? ({} as %sRequest);\n", op.Name)
+	}
+	g.printf("    const query = new URLSearchParams();\n")
+	g.printf("    const headers: Record<string, string> = {};\n")
+	for _, p := range op.Params {
+		field := "req." + unexportedName(p.Field)
+		switch p.In {
+		case "query":
+			if g.isArray(p.Schema) {
+				if p.Explode {
+					g.printf("    for (const v of %s ?? []) query.append(%q, String(v));\n", field, p.Name)
+				} else {
+					g.printf("    if (%s?.length) query.append(%q, %s.map(String).join(\",\"));\n", field, p.Name, field)
+				}
+			} else {
+				g.printf("    if (%s !== undefined) query.append(%q, String(%s));\n", field, p.Name, field)
+			}
+		case "header":
+			g.printf("    if (%s !== undefined) headers[%q] = String(%s);\n", field, p.Name, field)
+		}
+	}
+	body := "undefined"
+	if op.Body != nil {
+		body = "req.body"
+	}
+	g.printf("    return [`%s`, this.init(%q, query, headers, %s, init)];\n  }\n\n", g.pathTemplate(op), op.Method, body)
+
+	// Operation method.
+	var doc []string
+	for _, text := range []string{op.Summary, op.Description} {
+		if text != "" {
+			doc = append(doc, text)
+		}
+	}
+	doc = append(doc, fmt.Sprintf("`%s %s`", op.Method, op.Path))
+	if op.Deprecated {
+		doc = append(doc, "@deprecated The operation is deprecated by the API.")
+	}
+	g.w.WriteString(jsdoc("  ", strings.Join(doc, "\n\n")))
+	call := "init"
+	if op.HasInput() {
+		call = "req, init"
+	}
+	g.printf("  async %s(%s): Promise<%s> {\n", name, args, result)
+	g.printf("    const res = await this.send(...this.%sRequest(%s));\n", name, call)
+	if op.Result == nil {
+		g.printf("    await res.body?.cancel();\n  }\n\n")
+	} else {
+		g.printf("    return (await decode(res)) as %s;\n  }\n\n", result)
+	}
+
+	if op.Paginated && op.Result != nil {
+		g.w.WriteString(jsdoc("  ", fmt.Sprintf("Iterates over the pages of {@link Client.%s}, following\nthe Link header of each response.", name)))
+		g.printf("  async *%sPages(%s): AsyncGenerator<%s> {\n", name, args, result)
+		g.printf("    let res = await this.send(...this.%sRequest(%s));\n", name, call)
+		g.printf("    for (;;) {\n")
+		g.printf("      yield (await decode(res)) as %s;\n", result)
+		g.printf("      const next = nextLink(res.headers.get(\"Link\"));\n")
+		g.printf("      if (next === undefined) return;\n")
+		g.printf("      const url = new URL(next, res.url || this.baseUrl).toString();\n")
+		g.printf("      res = await this.send(url, this.init(\"GET\", new URLSearchParams(), {}, undefined, init));\n")
+		g.printf("    }\n  }\n\n")
+	}
+}
+
+func (g *tsEmitter) isArray(s *openapi.SchemaOrRef) bool {
+	sc := g.resolve(s)
+	return sc != nil && sc.Type == "array"
+}
+
+// pathTemplate returns the path of the operation as the
+// body of a template literal, with encoded parameters.
+func (g *tsEmitter) pathTemplate(op *operation) string {
+	path := strings.ReplaceAll(op.Path, "`", "\\`")
+	for _, p := range op.Params {
+		if p.In != "path" {
+			continue
+		}
+		path = strings.ReplaceAll(path, "{"+p.Name+"}",
+			fmt.Sprintf("${encodeURIComponent(String(req.%s))}", unexportedName(p.Field)))
+	}
+	return path
+}
+
+// tsProp returns name as a property name,
+// quoted if it isn't a valid identifier.
+func tsProp(name string) string {
+	if reTSIdent.MatchString(name) {
+		return name
+	}
+	b, _ := json.Marshal(name)
+	return string(b)
+}
+
+// jsdoc formats text as a JSDoc comment.
+func jsdoc(indent, text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "*/", "*\\/"), "\n")
+	if len(lines) == 1 {
+		return indent + "/** " + lines[0] + " */\n"
+	}
+	var b strings.Builder
+	b.WriteString(indent + "/**\n")
+	for _, line := range lines {
+		b.WriteString(strings.TrimRight(indent+" * "+strings.TrimSpace(line), " ") + "\n")
+	}
+	b.WriteString(indent + " */\n")
+	return b.String()
+}
+
+// tsRuntimeHead and tsRuntimeTail are the parts of the
+// TypeScript client that don't depend on the specification.
+const tsRuntimeHead = `/** Error thrown for responses with a status code outside of the 2xx range. */
+export class ApiError extends Error {
+  constructor(
+    readonly status: number,
+    readonly body: unknown,
+    readonly headers: Headers,
+  ) {
+    super(ApiError.message(status, body));
+    this.name = "ApiError";
+  }
+
+  private static message(status: number, body: unknown): string {
+    if (body && typeof body === "object") {
+      const b = body as { error?: unknown; message?: unknown };
+      const msg = b.error ?? b.message;
+      if (typeof msg === "string") return ` + "`${status}: ${msg}`" + `;
+    }
+    return ` + "`${status}`" + `;
+  }
+}
+
+export interface ClientOptions {
+  /** Headers sent with every request, such as the Authorization header. */
+  headers?: Record<string, string>;
+  /** Fetch implementation. Default to the global fetch. */
+  fetch?: typeof fetch;
+}
+
+export class Client {
+  readonly baseUrl: string;
+  headers: Record<string, string>;
+  private readonly fetchImpl: typeof fetch;
+
+  constructor(baseUrl: string, options: ClientOptions = {}) {
+    this.baseUrl = baseUrl.replace(/\/+$/, "");
+    this.headers = { ...options.headers };
+    this.fetchImpl = options.fetch ?? globalThis.fetch.bind(globalThis);
+  }
+
+`
+
+const tsRuntimeTail = `  private init(
+    method: string,
+    query: URLSearchParams,
+    headers: Record<string, string>,
+    body: unknown,
+    init?: RequestInit,
+  ): RequestInit & { query: URLSearchParams } {
+    const h: Record<string, string> = { Accept: "application/json", ...this.headers, ...headers };
+    if (body !== undefined) h["Content-Type"] = "application/json";
+    return {
+      ...init,
+      method,
+      query,
+      headers: { ...h, ...(init?.headers as Record<string, string> | undefined) },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    };
+  }
+
+  private async send(path: string, init: RequestInit & { query?: URLSearchParams }): Promise<Response> {
+    const { query, ...rest } = init;
+    let url = /^https?:\/\//.test(path) ? path : this.baseUrl + path;
+    const qs = query?.toString();
+    if (qs) url += "?" + qs;
+    const res = await this.fetchImpl(url, rest);
+    if (!res.ok) {
+      throw new ApiError(res.status, await decode(res).catch(() => undefined), res.headers);
+    }
+    return res;
+  }
+}
+
+async function decode(res: Response): Promise<unknown> {
+  const text = await res.text();
+  return text === "" ? undefined : JSON.parse(text);
+}
+
+/** Returns the target of the rel="next" link of a Link header (RFC 8288). */
+function nextLink(header: string | null): string | undefined {
+  for (const link of (header ?? "").split(",")) {
+    const [target, ...attrs] = link.split(";").map((s) => s.trim());
+    if (!target?.startsWith("<") || !target.endsWith(">")) continue;
+    if (attrs.some((a) => /^rel="?next"?$/i.test(a))) return target.slice(1, -1);
+  }
+  return undefined;
+}
+`
@@ -1,18 +1,28 @@
 package service
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
 	config "mkfst/config"
+	db "mkfst/db"
 	router "mkfst/router"
 	telemetry "mkfst/telemetry"
 	"mkfst/tonic"
 	http "net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"mkfst/fizz"
 	"mkfst/fizz/openapi"
+	"mkfst/fizz/ui"
 
 	"github.com/gin-gonic/gin"
+	validator "github.com/go-playground/validator/v10"
+	"sigs.k8s.io/yaml"
 )
 
 type Service struct {
@@ -20,6 +30,8 @@ type Service struct {
 	router *router.Router
 	spec   *openapi.Info
 	otel   *telemetry.Context
+	built  *fizz.Fizz
+	err    error
 }
 
 func Create(opts config.Config) Service {
@@ -29,6 +41,7 @@ func Create(opts config.Config) Service {
 		spec:   &opts.Spec,
 		otel:   &telemetry.Context{},
 	}
+	service.err = service.config.Validate()
 
 	router := router.Create(
 		service.config,
@@ -83,6 +96,19 @@ func (service *Service) GetDB() *sql.DB {
 	return service.router.Db.Conn
 }
 
+// UseDB swaps the service's database connection for conn. The previous
+// connection, if any, is closed. Handlers asking for *sql.DB receive
+// conn.Conn; call it before Build so the new value is baked into the
+// handler wrappers.
+func (service *Service) UseDB(conn *db.Connection) *Service {
+	if service.router.Db != nil && service.router.Db != conn {
+		service.router.Db.Conn.Close()
+	}
+	service.router.Db = conn
+	service.router.Provide(conn.Conn)
+	return service
+}
+
 // Provide registers additional dependencies that route handlers can ask for
 // in their argument list (in addition to *gin.Context and *sql.DB).
 func (service *Service) Provide(deps ...interface{}) *Service {
@@ -90,6 +116,35 @@ func (service *Service) Provide(deps ...interface{}) *Service {
 	return service
 }
 
+// ProvideFunc registers dependencies resolved for each request, such as
+// the logger of the request: each fn is a func(*gin.Context) T called
+// before a handler asking for T.
+func (service *Service) ProvideFunc(fns ...interface{}) *Service {
+	service.router.ProvideFunc(fns...)
+	return service
+}
+
+// Fizz returns the Fizz instance of the service, to document what the
+// routes don't, such as the webhooks. Its routes are only materialised
+// by Build.
+func (service *Service) Fizz() *fizz.Fizz {
+	return service.router.Base
+}
+
+// RegisterDiscriminator declares the concrete types of an interface
+// type used in request or response bodies; see
+// fizz.(*Fizz).RegisterDiscriminator. Call it before Build.
+func (service *Service) RegisterDiscriminator(iface interface{}, property string, mapping map[string]interface{}) error {
+	return service.router.Base.RegisterDiscriminator(iface, property, mapping)
+}
+
+// RegisterValidation registers a custom validation of the validator
+// tag, documented in the specification by schema; see
+// fizz.(*Fizz).RegisterValidation. Call it before Build.
+func (service *Service) RegisterValidation(tag string, fn validator.Func, schema openapi.ValidationSchemaFunc) error {
+	return service.router.Base.RegisterValidation(tag, fn, schema)
+}
+
 func (service *Service) ConfigureTracing(
 	config *telemetry.TracingConfig,
 ) {
@@ -98,32 +153,36 @@ func (service *Service) ConfigureTracing(
 
 }
 
-func (service *Service) Run() (err error) {
-
-	otel := telemetry.Context{}
-
-	if service.otel.UseTelemetry {
-		defer otel.Close()
+// Build materialises the route table — user routes plus the docs, spec and
+// status routes — and returns the assembled handler without binding a port.
+// It is idempotent: the first call builds, later calls return the same
+// handler. Run calls it before listening; tests and tooling can call it
+// directly.
+func (service *Service) Build() *fizz.Fizz {
+	if service.built != nil {
+		return service.built
 	}
 
-	var docsPrefix = "http"
-	if service.config.UseHTTPS {
-		docsPrefix = "https"
-	}
-
-	serviceAddress := service.config.ToAddress()
-
-	service.router.Base.Engine().LoadHTMLGlob("docs/*")
-	service.router.Base.GET("/api/docs", nil, func(ctx *gin.Context) {
-		ctx.HTML(200, "index.tmpl", gin.H{
-			"url": fmt.Sprintf(
-				"%s://%s/%s",
-				docsPrefix,
-				serviceAddress,
-				"openapi.json",
-			),
+	// An invalid config is reported by Err and Run, the
+	// service is built without the settings it couldn't apply.
+	if !service.config.DisableDocs {
+		// Registered on the engine, out of the specification.
+		err := ui.Mount(service.router.Base.Engine(), service.config.DocsPath, ui.Config{
+			Renderer:  ui.Renderer(service.config.DocsUI),
+			Title:     service.spec.Title,
+			SpecURL:   "/openapi.json",
+			AssetsURL: service.config.DocsAssetsURL,
+			Spec:      service.OpenAPI,
 		})
-	})
+		if err != nil && service.err == nil {
+			service.err = fmt.Errorf("service: %w", err)
+		}
+	}
+	if v := service.config.OpenAPIVersion; v != "" {
+		if err := service.router.Base.Generator().SetOpenAPIVersion(v); err != nil && service.err == nil {
+			service.err = fmt.Errorf("service: %w", err)
+		}
+	}
 	service.router.Base.GET("/openapi.json", nil, service.router.Base.OpenAPI(service.spec, "json"))
 	service.router.Base.GET("/openapi.yaml", nil, service.router.Base.OpenAPI(service.spec, "yaml"))
 
@@ -140,12 +199,90 @@ func (service *Service) Run() (err error) {
 		),
 	)
 
+	service.built = fizzRouter
+	return fizzRouter
+}
+
+// Err returns the error of an invalid config, such as an unsupported
+// APP_OPENAPI_VERSION, or of the settings Build couldn't apply.
+func (service *Service) Err() error {
+	return service.err
+}
+
+// OpenAPI returns the specification generated for the service,
+// building it first if needed. Middleware needing the specification,
+// such as middleware/validation, take the method value
+// svc.OpenAPI and call it once the service is built.
+func (service *Service) OpenAPI() *openapi.OpenAPI {
+	return service.Build().Generator().API()
+}
+
+// WriteOpenAPI builds the service and writes its specification to w,
+// indented, in the given format: "json" or "yaml".
+func (service *Service) WriteOpenAPI(w io.Writer, format string) error {
+	api := service.OpenAPI()
+	if service.err != nil {
+		return service.err
+	}
+	b, err := json.MarshalIndent(api, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(format) {
+	case "json":
+		b = append(b, '\n')
+	case "yaml", "yml":
+		// Going through JSON honors the custom
+		// marshaling of the OpenAPI 3.1 schemas.
+		if b, err = yaml.JSONToYAML(b); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown specification format %q", format)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ExportOpenAPI builds the service without listening and writes its
+// specification to the file at path, in the format told by its
+// extension: .json, .yaml or .yml.
+func (service *Service) ExportOpenAPI(path string) error {
+	var buf bytes.Buffer
+	if err := service.WriteOpenAPI(&buf, strings.TrimPrefix(filepath.Ext(path), ".")); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// ExportEnv is the environment variable which, when set to a file
+// path, makes Run export the specification there instead of
+// listening. It is how `mkfst openapi export` runs an application.
+const ExportEnv = "MKFST_OPENAPI_EXPORT"
+
+func (service *Service) Run() (err error) {
+
+	if path := os.Getenv(ExportEnv); path != "" {
+		return service.ExportOpenAPI(path)
+	}
+
+	otel := telemetry.Context{}
+
+	if service.otel.UseTelemetry {
+		defer otel.Close()
+	}
+
+	fizzRouter := service.Build()
+	if service.err != nil {
+		return service.err
+	}
+
 	if service.router.Db != nil {
 		defer service.router.Db.Conn.Close()
 	}
 
 	srv := &http.Server{
-		Addr:    serviceAddress,
+		Addr:    service.config.ToAddress(),
 		Handler: fizzRouter,
 	}
 	srv.ListenAndServe()
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"mkfst/config"
+)
+
+func TestInvalidConfig(t *testing.T) {
+	for env, value := range map[string]string{"APP_OPENAPI_VERSION": "2.0", "APP_DOCS_UI": "rapidoc"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			svc := Create(config.Config{SkipDB: true})
+			if svc.Build() == nil {
+				t.Fatal("expected the service built")
+			}
+			if err := svc.Err(); err == nil || !strings.Contains(err.Error(), env) {
+				t.Errorf("expected an error naming %s, got %v", env, err)
+			}
+			if err := svc.WriteOpenAPI(io.Discard, "json"); err == nil {
+				t.Error("expected the error from WriteOpenAPI")
+			}
+		})
+	}
+
+	svc := Create(config.Config{SkipDB: true, OpenAPIVersion: "3.1", DocsUI: "redoc", DocsAssetsURL: "https://assets.internal/redoc"})
+	if svc.Build(); svc.Err() != nil {
+		t.Errorf("unexpected error: %v", svc.Err())
+	}
+}
@@ -1,6 +1,11 @@
 package tonic
 
-import "reflect"
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+)
 
 // Container is the dependency-injection registry used by Handler to resolve
 // handler arguments. Deps are looked up by exact type match. Register a value
@@ -10,13 +15,17 @@ import "reflect"
 // Typed nils are allowed (e.g. (*sql.DB)(nil)) — a handler signature can list
 // the type without forcing the dep to actually exist. The handler must not
 // dereference what it didn't request to be live.
+//
+// Request-scoped deps, such as the logger of the request, are registered
+// with RegisterFunc and resolved before each call instead.
 type Container struct {
-	deps map[reflect.Type]reflect.Value
+	deps  map[reflect.Type]reflect.Value
+	funcs map[reflect.Type]reflect.Value
 }
 
 // NewContainer returns a Container pre-populated with the given deps.
 func NewContainer(deps ...interface{}) *Container {
-	c := &Container{deps: make(map[reflect.Type]reflect.Value)}
+	c := &Container{deps: make(map[reflect.Type]reflect.Value), funcs: make(map[reflect.Type]reflect.Value)}
 	c.Register(deps...)
 	return c
 }
@@ -30,6 +39,24 @@ func (c *Container) Register(deps ...interface{}) {
 			continue
 		}
 		c.deps[reflect.TypeOf(d)] = v
+		delete(c.funcs, v.Type())
+	}
+}
+
+// RegisterFunc stores each fn, a func(*gin.Context) T, as the resolver of
+// the dep T: handlers asking for T get fn's result for their request. A
+// later Register or RegisterFunc for the same type overwrites it. Panics
+// on any other signature.
+func (c *Container) RegisterFunc(fns ...interface{}) {
+	ctxType := reflect.TypeOf(&gin.Context{})
+	for _, fn := range fns {
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func || v.Type().NumIn() != 1 || v.Type().In(0) != ctxType || v.Type().NumOut() != 1 {
+			panic(fmt.Sprintf("tonic: RegisterFunc expects a func(*gin.Context) T, got %T", fn))
+		}
+		t := v.Type().Out(0)
+		c.funcs[t] = v
+		delete(c.deps, t)
 	}
 }
 
@@ -42,6 +69,7 @@ func (c *Container) RegisterAs(t reflect.Type, dep interface{}) {
 		return
 	}
 	c.deps[t] = v
+	delete(c.funcs, t)
 }
 
 // Lookup returns the registered value for t and whether it was found.
@@ -53,8 +81,21 @@ func (c *Container) Lookup(t reflect.Type) (reflect.Value, bool) {
 	return v, ok
 }
 
-// Has reports whether t is registered.
+// LookupFunc returns the resolver registered by RegisterFunc for t and
+// whether it was found.
+func (c *Container) LookupFunc(t reflect.Type) (reflect.Value, bool) {
+	if c == nil {
+		return reflect.Value{}, false
+	}
+	v, ok := c.funcs[t]
+	return v, ok
+}
+
+// Has reports whether t is registered, as a value or a resolver.
 func (c *Container) Has(t reflect.Type) bool {
 	_, ok := c.Lookup(t)
+	if !ok {
+		_, ok = c.LookupFunc(t)
+	}
 	return ok
 }
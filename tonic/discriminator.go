@@ -0,0 +1,317 @@
+package tonic
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// discriminator describes the concrete types of an interface
+// registered with RegisterDiscriminator.
+type discriminator struct {
+	property string
+	mapping  map[string]reflect.Type
+}
+
+var (
+	discriminatorsMu sync.RWMutex
+	discriminators   = make(map[reflect.Type]*discriminator)
+
+	// discriminated caches whether a type holds
+	// values of a registered interface type.
+	discriminated sync.Map
+
+	tofJSONUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	tofTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// RegisterDiscriminator registers the concrete types of the interface
+// type iface. The request bodies holding values of that type are decoded
+// into the type mapped to the value of their discriminator property.
+// The mapped types, or pointers to them, must implement iface.
+//
+//	tonic.RegisterDiscriminator(reflect.TypeOf((*Shape)(nil)).Elem(), "kind", map[string]reflect.Type{
+//		"circle": reflect.TypeOf(Circle{}),
+//		"square": reflect.TypeOf(Square{}),
+//	})
+func RegisterDiscriminator(iface reflect.Type, property string, mapping map[string]reflect.Type) error {
+	if iface == nil || iface.Kind() != reflect.Interface {
+		return errors.New("discriminated type is not an interface")
+	}
+	if property == "" {
+		return errors.New("discriminator property is empty")
+	}
+	if len(mapping) == 0 {
+		return errors.New("discriminator mapping is empty")
+	}
+	d := &discriminator{
+		property: property,
+		mapping:  make(map[string]reflect.Type, len(mapping)),
+	}
+	for value, t := range mapping {
+		if t == nil {
+			return fmt.Errorf("nil type mapped to %s", value)
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if !t.Implements(iface) && !reflect.PtrTo(t).Implements(iface) {
+			return fmt.Errorf("type %s mapped to %s does not implement %s", t, value, iface)
+		}
+		d.mapping[value] = t
+	}
+	discriminatorsMu.Lock()
+	discriminators[iface] = d
+	discriminatorsMu.Unlock()
+
+	// The registration may change the
+	// decoding of the types already seen.
+	discriminated.Range(func(k, _ interface{}) bool {
+		discriminated.Delete(k)
+		return true
+	})
+	return nil
+}
+
+func lookupDiscriminator(t reflect.Type) *discriminator {
+	discriminatorsMu.RLock()
+	defer discriminatorsMu.RUnlock()
+
+	return discriminators[t]
+}
+
+// isDiscriminated returns whether values of the type t
+// may hold values of a registered interface type.
+func isDiscriminated(t reflect.Type) bool {
+	if v, ok := discriminated.Load(t); ok {
+		return v.(bool)
+	}
+	b := hasDiscriminated(t, make(map[reflect.Type]bool))
+	discriminated.Store(t, b)
+
+	return b
+}
+
+func hasDiscriminated(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	if t.Kind() != reflect.Interface && t.Kind() != reflect.Ptr {
+		// The types decoding themselves are left alone.
+		if pt := reflect.PtrTo(t); pt.Implements(tofJSONUnmarshaler) || pt.Implements(tofTextUnmarshaler) {
+			return false
+		}
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return lookupDiscriminator(t) != nil
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return hasDiscriminated(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if (sf.PkgPath == "" || sf.Anonymous) && hasDiscriminated(sf.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeDiscriminated decodes the JSON value raw into v, resolving
+// the concrete types of the registered interface types. The values
+// that hold none are decoded by the encoding/json package.
+func decodeDiscriminated(raw json.RawMessage, v reflect.Value) error {
+	t := v.Type()
+	if !isDiscriminated(t) {
+		return json.Unmarshal(raw, v.Addr().Interface())
+	}
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return decodeInterface(raw, v, lookupDiscriminator(t))
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return decodeDiscriminated(raw, v.Elem())
+	case reflect.Slice:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := decodeDiscriminated(item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		for i := 0; i < len(items) && i < t.Len(); i++ {
+			if err := decodeDiscriminated(items[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(t, len(items))
+		for k, item := range items {
+			ev := reflect.New(t.Elem()).Elem()
+			if err := decodeDiscriminated(item, ev); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), ev)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+		return decodeStructFields(fields, v)
+	}
+	return nil
+}
+
+func decodeInterface(raw json.RawMessage, v reflect.Value, d *discriminator) error {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return fmt.Errorf("expected an object for %s: %s", v.Type(), err)
+	}
+	prop, ok := obj[d.property]
+	if !ok {
+		return fmt.Errorf("missing discriminator property %q", d.property)
+	}
+	var value string
+	if err := json.Unmarshal(prop, &value); err != nil {
+		return fmt.Errorf("discriminator property %q is not a string", d.property)
+	}
+	ct, ok := d.mapping[value]
+	if !ok {
+		return fmt.Errorf("unknown %s %q", d.property, value)
+	}
+	nv := reflect.New(ct)
+	if err := decodeDiscriminated(raw, nv.Elem()); err != nil {
+		return err
+	}
+	if ct.Implements(v.Type()) {
+		v.Set(nv.Elem())
+	} else {
+		v.Set(nv)
+	}
+	return nil
+}
+
+// decodeStructFields decodes the members of a JSON object into the
+// fields of the struct v, following the naming rules of encoding/json.
+func decodeStructFields(fields map[string]json.RawMessage, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fv := v.Field(i)
+				if !fv.CanSet() {
+					continue
+				}
+				if sf.Type.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						fv.Set(reflect.New(ft))
+					}
+					fv = fv.Elem()
+				}
+				if err := decodeStructFields(fields, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		raw, ok := fields[name]
+		if !ok {
+			for k, r := range fields {
+				if strings.EqualFold(k, name) {
+					raw, ok = r, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := decodeDiscriminated(raw, v.Field(i)); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// discriminatorBinding is an implementation of gin's binding.Binding
+// decoding JSON bodies with the registered discriminators.
+type discriminatorBinding struct{}
+
+func (discriminatorBinding) Name() string {
+	return "json"
+}
+
+func (b discriminatorBinding) Bind(req *http.Request, obj interface{}) error {
+	if req == nil || req.Body == nil {
+		return errors.New("invalid request")
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return b.BindBody(body, obj)
+}
+
+func (discriminatorBinding) BindBody(body []byte, obj interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return io.EOF
+	}
+	if err := decodeDiscriminated(body, reflect.ValueOf(obj).Elem()); err != nil {
+		return err
+	}
+	if binding.Validator == nil {
+		return nil
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
@@ -0,0 +1,87 @@
+package tonic
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type shape interface{ area() float64 }
+
+type circle struct {
+	Kind   string  `json:"kind"`
+	Radius float64 `json:"radius"`
+}
+
+func (c circle) area() float64 { return 3 * c.Radius * c.Radius }
+
+type square struct {
+	Kind string  `json:"kind"`
+	Side float64 `json:"side"`
+}
+
+func (s *square) area() float64 { return s.Side * s.Side }
+
+type drawing struct {
+	Name   string           `json:"name"`
+	Main   shape            `json:"main"`
+	Shapes []shape          `json:"shapes"`
+	Named  map[string]shape `json:"named"`
+	Extra  *shape           `json:"extra"`
+}
+
+func TestDecodeDiscriminated(t *testing.T) {
+	if err := RegisterDiscriminator(reflect.TypeOf((*shape)(nil)).Elem(), "kind", map[string]reflect.Type{
+		"circle": reflect.TypeOf(circle{}),
+		"square": reflect.TypeOf(&square{}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !isDiscriminated(reflect.TypeOf(&drawing{})) || isDiscriminated(reflect.TypeOf(circle{})) {
+		t.Fatal("unexpected discriminated types")
+	}
+	body := `{
+		"name": "d",
+		"main": {"kind": "circle", "radius": 1},
+		"shapes": [{"kind": "square", "side": 2}, {"kind": "circle", "radius": 2}],
+		"named": {"a": {"kind": "square", "side": 3}},
+		"extra": null
+	}`
+	var d drawing
+	if err := (discriminatorBinding{}).BindBody([]byte(body), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "d" || d.Main.(circle).Radius != 1 || d.Extra != nil {
+		t.Errorf("unexpected decoded value: %+v", d)
+	}
+	if len(d.Shapes) != 2 || d.Shapes[0].(*square).Side != 2 || d.Shapes[1].area() != 12 {
+		t.Errorf("unexpected shapes: %+v", d.Shapes)
+	}
+	if d.Named["a"].(*square).Side != 3 {
+		t.Errorf("unexpected named shapes: %+v", d.Named)
+	}
+
+	for body, want := range map[string]string{
+		`{"main": {"radius": 1}}`:                     `missing discriminator property "kind"`,
+		`{"main": {"kind": "hexagon"}}`:               `unknown kind "hexagon"`,
+		`{"shapes": [{"kind": 1}]}`:                   `is not a string`,
+		`{"main": {"kind": "circle", "radius": "x"}}`: "cannot unmarshal",
+	} {
+		err := (discriminatorBinding{}).BindBody([]byte(body), &drawing{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error %q, got %v", body, want, err)
+		}
+	}
+
+	// The other types are left to encoding/json.
+	var c circle
+	if err := decodeDiscriminated(json.RawMessage(`{"radius": 2}`), reflect.ValueOf(&c).Elem()); err != nil || c.Radius != 2 {
+		t.Errorf("unexpected plain decoding: %+v %v", c, err)
+	}
+	if err := RegisterDiscriminator(reflect.TypeOf((*shape)(nil)).Elem(), "kind", map[string]reflect.Type{
+		"drawing": reflect.TypeOf(drawing{}),
+	}); err == nil {
+		t.Error("expected an error for a type not implementing the interface")
+	}
+}
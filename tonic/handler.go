@@ -20,9 +20,11 @@ var (
 
 // callPlan caches the per-handler reflection work done at registration time:
 // which container values to inject as positional args, and the optional input
-// struct type to bind from the request.
+// struct type to bind from the request. funcs holds, at the index of a
+// request-scoped dep, the resolver called instead of the value of deps.
 type callPlan struct {
 	deps      []reflect.Value
+	funcs     []reflect.Value
 	inputType reflect.Type
 }
 
@@ -34,7 +36,9 @@ type callPlan struct {
 //
 // where each dep type must be registered in container. The optional last arg
 // must be a pointer to a struct and is bound from the request (body / query /
-// path / header) before the call.
+// path / header) before the call. An Output embedding Responses is rendered
+// with the status code, headers and body of the variant the handler set,
+// instead of status.
 //
 // Handler panics if the signature can't be reconciled with the container.
 func Handler(h interface{}, container *Container, status int, options ...func(*Route)) gin.HandlerFunc {
@@ -52,6 +56,14 @@ func Handler(h interface{}, container *Container, status int, options ...func(*R
 	plan := buildCallPlan(ht, container, fname)
 	out := output(ht, fname)
 
+	var variants []ResponseVariant
+	if IsResponses(out) {
+		var err error
+		if variants, err = ResponseVariants(out); err != nil {
+			panic(fmt.Sprintf("handler %s: %s", fname, err))
+		}
+	}
+
 	// Wrap Gin handler.
 	f := func(c *gin.Context, ct *Container) {
 		_, ok := c.Get(tonicWantRouteInfos)
@@ -72,7 +84,12 @@ func Handler(h interface{}, container *Container, status int, options ...func(*R
 
 		args := make([]reflect.Value, 0, 1+len(plan.deps)+1)
 		args = append(args, reflect.ValueOf(c))
-		args = append(args, plan.deps...)
+		for i, dep := range plan.deps {
+			if fn := plan.funcs[i]; fn.IsValid() {
+				dep = fn.Call([]reflect.Value{reflect.ValueOf(c)})[0]
+			}
+			args = append(args, dep)
+		}
 
 		if plan.inputType != nil {
 			input := reflect.New(plan.inputType)
@@ -112,6 +129,15 @@ func Handler(h interface{}, container *Container, status int, options ...func(*R
 			handleError(c, err.(error))
 			return
 		}
+		if variants != nil {
+			code, body, err := respond(ret[0], variants, c.Writer.Header())
+			if err != nil {
+				handleError(c, err)
+				return
+			}
+			renderHook(c, code, body)
+			return
+		}
 		renderHook(c, status, val)
 	}
 
@@ -146,6 +172,15 @@ func RegisterValidation(tagName string, validationFunc validator.Func) error {
 	return validatorObj.RegisterValidation(tagName, validationFunc)
 }
 
+// RegisterStructValidation registers a struct-level validation on the validator.Validate instance
+// of the package, run for the values of the given types wherever they appear in an input struct.
+// NOTE: calling this function may instantiate the validator itself.
+// NOTE: this function is not thread safe, since the validator validation registration isn't
+func RegisterStructValidation(fn validator.StructLevelFunc, types ...interface{}) {
+	initValidator()
+	validatorObj.RegisterStructValidation(fn, types...)
+}
+
 // RegisterTagNameFunc registers a function to get alternate names for StructFields.
 //
 // eg. to use the names which have been specified for JSON representations of structs, rather than normal Go field names:
@@ -326,6 +361,12 @@ func buildCallPlan(ht reflect.Type, container *Container, name string) callPlan
 		argType := ht.In(i)
 		if v, ok := container.Lookup(argType); ok {
 			plan.deps = append(plan.deps, v)
+			plan.funcs = append(plan.funcs, reflect.Value{})
+			continue
+		}
+		if fn, ok := container.LookupFunc(argType); ok {
+			plan.deps = append(plan.deps, reflect.Zero(argType))
+			plan.funcs = append(plan.funcs, fn)
 			continue
 		}
 		// Not registered — only allowed as the final arg, and only if it
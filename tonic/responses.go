@@ -0,0 +1,171 @@
+package tonic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Tags of the variants of a Responses output type.
+const (
+	StatusTag      = "status"
+	DescriptionTag = "description"
+	HeadersTag     = "headers"
+)
+
+// Responses is embedded in the output type of a handler responding
+// with one of several variants. Each variant is a pointer field
+// tagged with its status code, and optionally its description and
+// the headers it sets:
+//
+//	type CreateOrderResponses struct {
+//		tonic.Responses
+//		Created  *Order    `status:"201" description:"The order was created" headers:"Location"`
+//		Accepted *Job      `status:"202" description:"The order is being created"`
+//		Existing *struct{} `status:"303" headers:"Location"`
+//	}
+//
+// The handler sets the field of the variant it responds with, and
+// the headers with Header. A variant of an empty struct has no body.
+// The variants are documented as the responses of the operation.
+type Responses struct {
+	header http.Header
+}
+
+// Header returns the headers of the response, set
+// before the variant is rendered.
+func (r *Responses) Header() http.Header {
+	if r.header == nil {
+		r.header = make(http.Header)
+	}
+	return r.header
+}
+
+func (r *Responses) responses() *Responses { return r }
+
+// responder is implemented by the pointers to
+// the types embedding Responses.
+type responder interface {
+	responses() *Responses
+}
+
+var (
+	responsesType = reflect.TypeOf(Responses{})
+	responderType = reflect.TypeOf((*responder)(nil)).Elem()
+)
+
+// ResponseVariant is a variant of a Responses output type.
+type ResponseVariant struct {
+	Field       string
+	StatusCode  int
+	Description string
+	Headers     []string
+
+	// Type is the type of the body of the
+	// variant, nil if it has none.
+	Type reflect.Type
+}
+
+// IsResponses returns whether t, or the type it points
+// to, is an output type embedding Responses.
+func IsResponses(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && reflect.PtrTo(t).Implements(responderType)
+}
+
+// ResponseVariants returns the variants of the Responses
+// output type t, in the order of their fields.
+func ResponseVariants(t reflect.Type) ([]ResponseVariant, error) {
+	if !IsResponses(t) {
+		return nil, fmt.Errorf("%v does not embed tonic.Responses", t)
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var variants []ResponseVariant
+	codes := make(map[int]string)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type == responsesType {
+			continue
+		}
+		tag, ok := f.Tag.Lookup(StatusTag)
+		if !ok {
+			return nil, fmt.Errorf("%v: field %s has no %s tag", t, f.Name, StatusTag)
+		}
+		code, err := strconv.Atoi(tag)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("%v: field %s: invalid status code %q", t, f.Name, tag)
+		}
+		if other, ok := codes[code]; ok {
+			return nil, fmt.Errorf("%v: fields %s and %s have the same status code %d", t, other, f.Name, code)
+		}
+		codes[code] = f.Name
+
+		if f.Type.Kind() != reflect.Ptr {
+			return nil, fmt.Errorf("%v: field %s must be a pointer", t, f.Name)
+		}
+		v := ResponseVariant{
+			Field:       f.Name,
+			StatusCode:  code,
+			Description: f.Tag.Get(DescriptionTag),
+			Type:        f.Type.Elem(),
+		}
+		if v.Type.Kind() == reflect.Struct && v.Type.NumField() == 0 {
+			v.Type = nil
+		}
+		for _, h := range strings.Split(f.Tag.Get(HeadersTag), ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				v.Headers = append(v.Headers, h)
+			}
+		}
+		variants = append(variants, v)
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("%v has no variant", t)
+	}
+	return variants, nil
+}
+
+// respond returns the status code and the body of the variant
+// set in the Responses value v, and sets its headers on h.
+func respond(v reflect.Value, variants []ResponseVariant, h http.Header) (int, interface{}, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return 0, nil, errors.New("handler returned no response")
+		}
+	} else {
+		// Header has a pointer receiver.
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p
+	}
+	var set *ResponseVariant
+	for i := range variants {
+		if !v.Elem().FieldByName(variants[i].Field).IsNil() {
+			if set != nil {
+				return 0, nil, fmt.Errorf("handler set both the %s and %s responses", set.Field, variants[i].Field)
+			}
+			set = &variants[i]
+		}
+	}
+	if set == nil {
+		return 0, nil, errors.New("handler set no response variant")
+	}
+	for k, vs := range v.Interface().(responder).responses().header {
+		h[k] = vs
+	}
+	if set.Type == nil {
+		return set.StatusCode, nil, nil
+	}
+	return set.StatusCode, v.Elem().FieldByName(set.Field).Interface(), nil
+}
@@ -0,0 +1,127 @@
+package tonic
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type order struct {
+	ID string `json:"id"`
+}
+
+type job struct {
+	ID string `json:"id"`
+}
+
+type createOrderResponses struct {
+	Responses
+	Created  *order    `status:"201" description:"The order was created" headers:"Location"`
+	Accepted *job      `status:"202"`
+	Existing *struct{} `status:"303" headers:"Location, Retry-After"`
+}
+
+func TestResponseVariants(t *testing.T) {
+	variants, err := ResponseVariants(reflect.TypeOf(&createOrderResponses{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ResponseVariant{
+		{Field: "Created", StatusCode: 201, Description: "The order was created", Headers: []string{"Location"}, Type: reflect.TypeOf(order{})},
+		{Field: "Accepted", StatusCode: 202, Type: reflect.TypeOf(job{})},
+		{Field: "Existing", StatusCode: 303, Headers: []string{"Location", "Retry-After"}},
+	}
+	if !reflect.DeepEqual(variants, want) {
+		t.Errorf("got %+v, want %+v", variants, want)
+	}
+	if IsResponses(reflect.TypeOf(order{})) || IsResponses(nil) {
+		t.Error("unexpected Responses type")
+	}
+
+	for _, v := range []interface{}{
+		struct {
+			Responses
+			A *order
+		}{},
+		struct {
+			Responses
+			A *order `status:"600"`
+		}{},
+		struct {
+			Responses
+			A order `status:"200"`
+		}{},
+		struct {
+			Responses
+			A *order `status:"200"`
+			B *job   `status:"200"`
+		}{},
+		struct{ Responses }{},
+	} {
+		if _, err := ResponseVariants(reflect.TypeOf(v)); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+}
+
+func TestResponsesHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	SetErrorHook(func(c *gin.Context, err error) (int, interface{}) {
+		return http.StatusInternalServerError, gin.H{"error": err.Error()}
+	})
+	defer SetErrorHook(DefaultErrorHook)
+
+	r := gin.New()
+	r.POST("/orders", Handler(func(c *gin.Context) (*createOrderResponses, error) {
+		var resp createOrderResponses
+		switch c.Query("as") {
+		case "created":
+			resp.Created = &order{ID: "o1"}
+			resp.Header().Set("Location", "/orders/o1")
+		case "accepted":
+			resp.Accepted = &job{ID: "j1"}
+		case "existing":
+			resp.Existing = &struct{}{}
+			resp.Header().Set("Location", "/orders/o0")
+		case "both":
+			resp.Created, resp.Accepted = &order{}, &job{}
+		case "nil":
+			return nil, nil
+		case "error":
+			return nil, errors.New("boom")
+		}
+		return &resp, nil
+	}, nil, 200))
+
+	for _, tc := range []struct {
+		as, location, body string
+		status             int
+	}{
+		{"created", "/orders/o1", `{"id":"o1"}`, 201},
+		{"accepted", "", `{"id":"j1"}`, 202},
+		{"existing", "/orders/o0", ``, 303},
+		{"both", "", `both the Created and Accepted`, 500},
+		{"", "", `no response variant`, 500},
+		{"nil", "", `no response`, 500},
+		{"error", "", `boom`, 500},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/orders?as="+tc.as, nil))
+
+		if w.Code != tc.status || w.Header().Get("Location") != tc.location || !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("%s: unexpected response: %d %v %s", tc.as, w.Code, w.Header(), w.Body)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an invalid Responses type")
+		}
+	}()
+	Handler(func(c *gin.Context) (*struct{ Responses }, error) { return nil, nil }, nil, 200)
+}
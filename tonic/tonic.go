@@ -113,7 +113,11 @@ func DefaultBindingHookMaxBodyBytes(maxBodyBytes int64) BindHook {
 				return fmt.Errorf("error parsing request body: %s", err.Error())
 			}
 		default:
-			if err := c.ShouldBindWith(i, binding.JSON); err != nil && err != io.EOF {
+			var b binding.Binding = binding.JSON
+			if isDiscriminated(reflect.TypeOf(i)) {
+				b = discriminatorBinding{}
+			}
+			if err := c.ShouldBindWith(i, b); err != nil && err != io.EOF {
 				return fmt.Errorf("error parsing request body: %s", err.Error())
 			}
 		}
@@ -483,7 +487,14 @@ func decodeYAML(r io.Reader, obj interface{}) error {
 	if err != nil {
 		return err
 	}
-	if err := yaml.Unmarshal(btes, &obj); err != nil {
+	if isDiscriminated(reflect.TypeOf(obj)) {
+		if btes, err = yaml.YAMLToJSON(btes); err != nil {
+			return err
+		}
+		if err := decodeDiscriminated(btes, reflect.ValueOf(obj).Elem()); err != nil {
+			return err
+		}
+	} else if err := yaml.Unmarshal(btes, &obj); err != nil {
 		return err
 	}
 	if binding.Validator == nil {